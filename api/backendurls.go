@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueai2022/mc/rating"
+)
+
+// BackendSource discovers the chat backends, keyed by chat server address.
+type BackendSource interface {
+	Backends(ctx context.Context) (map[string]string, error)
+}
+
+// BackendRegistry holds the current backendURLs map and swaps it atomically on reload,
+// so in-flight syncs keep the snapshot they started with.
+type BackendRegistry struct {
+	urls atomic.Pointer[map[string]string]
+}
+
+// Backends is the registry consulted by syncAllToCurrentBackends.
+var Backends = NewBackendRegistry(nil)
+
+// NewBackendRegistry creates a registry seeded with the given backend URLs.
+func NewBackendRegistry(initial map[string]string) *BackendRegistry {
+	registry := &BackendRegistry{}
+	registry.Swap(initial)
+	return registry
+}
+
+// Snapshot returns a copy of the current backend URLs.
+func (registry *BackendRegistry) Snapshot() map[string]string {
+	return maps.Clone(*registry.urls.Load())
+}
+
+// Swap replaces the backend URLs and returns the previous set.
+func (registry *BackendRegistry) Swap(urls map[string]string) map[string]string {
+	next := maps.Clone(urls)
+	if next == nil {
+		next = map[string]string{}
+	}
+	if prev := registry.urls.Swap(&next); prev != nil {
+		return *prev
+	}
+	return nil
+}
+
+// Reload fetches the backends from source once and swaps them in.
+// An empty result is rejected so a discovery hiccup cannot drain every backend at once.
+func (registry *BackendRegistry) Reload(ctx context.Context, source BackendSource) error {
+	urls, err := source.Backends(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover backends: %w", err)
+	}
+	if len(urls) == 0 {
+		return fmt.Errorf("discovery returned no backends, keeping current set")
+	}
+
+	prev := registry.Swap(urls)
+	if !maps.Equal(prev, urls) {
+		log.Printf("Backend URLs reloaded: %d -> %d backends\n", len(prev), len(urls))
+	}
+	return nil
+}
+
+// Watch reloads the backends from source every interval until ctx is cancelled, the
+// first time an interval from now, as the backends were just loaded with Reload.
+// Failed reloads are logged and the last known good set stays in place.
+func (registry *BackendRegistry) Watch(ctx context.Context, source BackendSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := registry.Reload(ctx, source); err != nil {
+			log.Printf("Error reloading backend URLs: %v\n", err)
+		}
+	}
+}
+
+// BackendsConfig selects where the backends come from: a JSON file, DNS SRV records or
+// Consul. Discovered backends are given URLs of Scheme and Path.
+type BackendsConfig struct {
+	File string
+	// SRV is the full record name, e.g. "_chat._tcp.example.com".
+	SRV string
+	// Consul is the Consul HTTP address, whose passing instances of ConsulService are
+	// the backends.
+	Consul        string
+	ConsulService string
+	ConsulToken   string
+	Scheme        string
+	Path          string
+	// Reload is how often the backends are reloaded from the source.
+	Reload time.Duration
+}
+
+func (cfg BackendsConfig) Validate() error {
+	sources := 0
+	for _, setting := range []string{cfg.File, cfg.SRV, cfg.Consul} {
+		if setting != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of a backends file, SRV record and Consul address may be set")
+	}
+	if cfg.Consul != "" && cfg.ConsulService == "" {
+		return fmt.Errorf("a Consul service must be set with a Consul address")
+	}
+	if sources > 0 && cfg.Reload <= 0 {
+		return fmt.Errorf("backends reload interval must be positive, got %v", cfg.Reload)
+	}
+	return nil
+}
+
+// Source returns the source the backends are loaded from, or nil if none is set.
+func (cfg BackendsConfig) Source() BackendSource {
+	switch {
+	case cfg.File != "":
+		return FileBackendSource{Path: cfg.File}
+	case cfg.SRV != "":
+		// With no service and protocol, the name is looked up as the full SRV record
+		return DNSBackendSource{Name: cfg.SRV, Scheme: cfg.Scheme, Path: cfg.Path}
+	case cfg.Consul != "":
+		return ConsulBackendSource{
+			Addr:    cfg.Consul,
+			Service: cfg.ConsulService,
+			Token:   cfg.ConsulToken,
+			Scheme:  cfg.Scheme,
+			Path:    cfg.Path,
+		}
+	}
+	return nil
+}
+
+// Start loads the backends from the configured source into Backends, then reloads them
+// every Reload until ctx is done. Without a source, Backends is left as it is.
+func (cfg BackendsConfig) Start(ctx context.Context) error {
+	source := cfg.Source()
+	if source == nil {
+		return nil
+	}
+	if err := Backends.Reload(ctx, source); err != nil {
+		return err
+	}
+	go Backends.Watch(ctx, source, cfg.Reload)
+	return nil
+}
+
+// errUnknownChatServer fails syncs of chats on a chat server that isn't a current backend.
+var errUnknownChatServer = errors.New("unknown chat server")
+
+// syncAllToCurrentBackends synchronizes follower chats against the current backend
+// snapshot, in the request's priority lane.
+func (server *Server) syncAllToCurrentBackends(syncRequest SyncRequest, chatServerAddr string) ([]*rating.Rating, error) {
+	backendURLs := Backends.Snapshot()
+	if _, ok := backendURLs[chatServerAddr]; !ok {
+		return nil, fmt.Errorf("%w %s", errUnknownChatServer, chatServerAddr)
+	}
+	return server.syncRequestToDecisions(syncRequest, chatServerAddr, backendURLs)
+}
+
+// FileBackendSource reads backend URLs from a JSON object of chat server address to URL.
+type FileBackendSource struct {
+	Path string
+}
+
+func (source FileBackendSource) Backends(ctx context.Context) (map[string]string, error) {
+	data, err := os.ReadFile(source.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backend config %s: %w", source.Path, err)
+	}
+
+	var urls map[string]string
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return nil, fmt.Errorf("failed to parse backend config %s: %w", source.Path, err)
+	}
+	return urls, nil
+}
+
+// DNSBackendSource discovers backends from DNS SRV records (_service._proto.name). With
+// Service and Proto empty, Name is looked up as the full record name.
+type DNSBackendSource struct {
+	Service string
+	Proto   string
+	Name    string
+	// Scheme and Path build the chat service URL for each target, e.g. "http" and "/chat".
+	Scheme string
+	Path   string
+}
+
+func (source DNSBackendSource) Backends(ctx context.Context) (map[string]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, source.Service, source.Proto, source.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SRV records for %s: %w", source.Name, err)
+	}
+
+	urls := make(map[string]string, len(records))
+	for _, record := range records {
+		addr := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		urls[addr] = backendURL(source.Scheme, addr, source.Path)
+	}
+	return urls, nil
+}
+
+// ConsulBackendSource discovers passing instances of a service from the Consul health API.
+type ConsulBackendSource struct {
+	// Addr is the Consul HTTP address, e.g. "http://127.0.0.1:8500".
+	Addr    string
+	Service string
+	Token   string
+	Scheme  string
+	Path    string
+	Client  *http.Client
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (source ConsulBackendSource) Backends(ctx context.Context) (map[string]string, error) {
+	endpoint := fmt.Sprintf("%s/v1/health/service/%s?passing=true", strings.TrimSuffix(source.Addr, "/"), url.PathEscape(source.Service))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consul request: %w", err)
+	}
+	if source.Token != "" {
+		req.Header.Set("X-Consul-Token", source.Token)
+	}
+
+	client := source.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query consul: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned status %s", resp.Status)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+
+	urls := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+		urls[addr] = backendURL(source.Scheme, addr, source.Path)
+	}
+	return urls, nil
+}
+
+func backendURL(scheme, addr, path string) string {
+	if scheme == "" {
+		scheme = "http"
+	}
+	return (&url.URL{Scheme: scheme, Host: addr, Path: path}).String()
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// staticSource is a BackendSource returning fixed backends.
+type staticSource map[string]string
+
+func (source staticSource) Backends(ctx context.Context) (map[string]string, error) {
+	return source, nil
+}
+
+func TestBackendRegistryReload(t *testing.T) {
+	first := map[string]string{"10.0.0.1:8080": "http://10.0.0.1:8080/chat"}
+	registry := NewBackendRegistry(first)
+	snapshot := registry.Snapshot()
+
+	second := map[string]string{"10.0.0.2:8080": "http://10.0.0.2:8080/chat"}
+	if err := registry.Reload(context.Background(), staticSource(second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := registry.Snapshot(); !maps.Equal(got, second) {
+		t.Errorf("got %v after reload, want %v", got, second)
+	}
+	if !maps.Equal(snapshot, first) {
+		t.Errorf("earlier snapshot changed to %v", snapshot)
+	}
+
+	if err := registry.Reload(context.Background(), staticSource{}); err == nil {
+		t.Error("reloaded an empty set of backends")
+	}
+	if got := registry.Snapshot(); !maps.Equal(got, second) {
+		t.Errorf("got %v after an empty reload, want %v kept", got, second)
+	}
+}
+
+// countingSource is a BackendSource counting the times it is asked for the backends.
+type countingSource struct {
+	staticSource
+	calls atomic.Int64
+}
+
+func (source *countingSource) Backends(ctx context.Context) (map[string]string, error) {
+	source.calls.Add(1)
+	return source.staticSource, nil
+}
+
+// Start loads the backends before watching them, so Watch waits an interval before its
+// first reload.
+func TestBackendRegistryWatch(t *testing.T) {
+	registry := NewBackendRegistry(nil)
+	source := &countingSource{staticSource: staticSource{"10.0.0.1:8080": "http://10.0.0.1:8080/chat"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Watch(ctx, source, 20*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	if calls := source.calls.Load(); calls != 0 {
+		t.Errorf("reloaded %d times before the first interval, want 0", calls)
+	}
+	for deadline := time.Now().Add(5 * time.Second); source.calls.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("never reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := registry.Snapshot(); !maps.Equal(got, source.staticSource) {
+		t.Errorf("got %v after reloading, want %v", got, source.staticSource)
+	}
+}
+
+func TestFileBackendSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	want := map[string]string{"10.0.0.1:8080": "http://10.0.0.1:8080/chat"}
+	data, _ := json.Marshal(want)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	urls, err := FileBackendSource{Path: path}.Backends(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+}
+
+func TestBackendsConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   BackendsConfig
+		valid bool
+	}{
+		{"none", BackendsConfig{}, true},
+		{"file", BackendsConfig{File: "backends.json", Reload: 1}, true},
+		{"srv", BackendsConfig{SRV: "_chat._tcp.example.com", Reload: 1}, true},
+		{"consul", BackendsConfig{Consul: "http://127.0.0.1:8500", ConsulService: "chat", Reload: 1}, true},
+		{"consul without service", BackendsConfig{Consul: "http://127.0.0.1:8500", Reload: 1}, false},
+		{"two sources", BackendsConfig{File: "backends.json", SRV: "_chat._tcp.example.com", Reload: 1}, false},
+		{"no reload interval", BackendsConfig{SRV: "_chat._tcp.example.com"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.cfg.Validate(); (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestConsulBackendSource(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/chat" || r.Header.Get("X-Consul-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"Node": map[string]any{"Address": "10.0.0.1"}, "Service": map[string]any{"Port": 8080}},
+			{"Node": map[string]any{"Address": "10.0.0.1"}, "Service": map[string]any{"Address": "10.0.0.2", "Port": 8080}},
+		})
+	}))
+	defer consul.Close()
+
+	cfg := BackendsConfig{Consul: consul.URL, ConsulService: "chat", ConsulToken: "secret", Path: "/chat"}
+	urls, err := cfg.Source().Backends(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"10.0.0.1:8080": "http://10.0.0.1:8080/chat",
+		"10.0.0.2:8080": "http://10.0.0.2:8080/chat",
+	}
+	if !maps.Equal(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+
+	cfg.ConsulToken = "wrong"
+	if _, err := cfg.Source().Backends(context.Background()); err == nil {
+		t.Error("discovered backends with a rejected token")
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueai2022/mc/rating"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisTimeout  = 2 * time.Second
+	defaultCheckpointTTL = 24 * time.Hour
+)
+
+// chatStore is the chat state syncs read and write, as Server.chats picks it: the
+// in-process Server.chatState, RedisChatState when the sync server shares its state with
+// other replicas, or MockBackend in dry-run mode.
+type chatStore interface {
+	followerChatIds(chatID string, backends []string) ([]string, error)
+	saveFollowerChatId(chatID, backend, followerID string) error
+	getChatHistory(chatID, chatServerAddr string) ([]string, error)
+	appendChatHistory(chatID, chatServerAddr string, messages ...string) error
+}
+
+// syncCheckpointer is implemented by chat states that remember concluded chats,
+// so a sync retried on another replica does not replay the conversation.
+type syncCheckpointer interface {
+	getSyncCheckpoint(chatID string) (*rating.Rating, error)
+	saveSyncCheckpoint(chatID string, rating *rating.Rating) error
+}
+
+// RedisChatState keeps follower IDs, chat histories and sync checkpoints in Redis
+// so multiple sync-server replicas can share them.
+//
+// Keys, under the configured prefix:
+//
+//	chat:<id>:followers           hash of backend address -> follower chat ID
+//	chat:<id>:history:<address>   list of chat messages, client first
+//	chat:<id>:checkpoint          JSON rating of the concluded chat
+type RedisChatState struct {
+	client        redis.UniversalClient
+	prefix        string
+	timeout       time.Duration
+	checkpointTTL time.Duration
+}
+
+var (
+	_ chatStore        = (*RedisChatState)(nil)
+	_ syncCheckpointer = (*RedisChatState)(nil)
+)
+
+// sharedChatState, when set, is the chat state syncs use instead of Server.chatState.
+var sharedChatState atomic.Pointer[RedisChatState]
+
+// UseSharedChatState makes syncs read and write chat state in Redis, shared with the
+// other sync-server replicas. Passing nil restores the in-process chat state.
+func UseSharedChatState(state *RedisChatState) {
+	sharedChatState.Store(state)
+}
+
+// NewRedisChatState creates a Redis-backed chat state. Keys are namespaced by prefix.
+func NewRedisChatState(client redis.UniversalClient, prefix string) *RedisChatState {
+	return &RedisChatState{
+		client:        client,
+		prefix:        prefix,
+		timeout:       defaultRedisTimeout,
+		checkpointTTL: defaultCheckpointTTL,
+	}
+}
+
+func (state *RedisChatState) key(chatID string, parts ...string) string {
+	key := state.prefix + "chat:" + chatID
+	for _, part := range parts {
+		key += ":" + part
+	}
+	return key
+}
+
+func (state *RedisChatState) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), state.timeout)
+}
+
+func (state *RedisChatState) followerChatIds(chatID string, backends []string) ([]string, error) {
+	ctx, cancel := state.context()
+	defer cancel()
+
+	values, err := state.client.HMGet(ctx, state.key(chatID, "followers"), backends...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read followers of chat ID %s: %w", chatID, err)
+	}
+
+	followerIds := make([]string, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(string); ok {
+			followerIds = append(followerIds, id)
+		}
+	}
+	return followerIds, nil
+}
+
+// saveFollowerChatId records the follower chat opened on a backend for a leader chat.
+func (state *RedisChatState) saveFollowerChatId(chatID, backend, followerID string) error {
+	ctx, cancel := state.context()
+	defer cancel()
+
+	if err := state.client.HSet(ctx, state.key(chatID, "followers"), backend, followerID).Err(); err != nil {
+		return fmt.Errorf("failed to save follower of chat ID %s: %w", chatID, err)
+	}
+	return nil
+}
+
+func (state *RedisChatState) getChatHistory(chatID, chatServerAddr string) ([]string, error) {
+	ctx, cancel := state.context()
+	defer cancel()
+
+	history, err := state.client.LRange(ctx, state.key(chatID, "history", chatServerAddr), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of chat ID %s: %w", chatID, err)
+	}
+	return history, nil
+}
+
+// appendChatHistory appends messages to a chat's history on a chat server.
+func (state *RedisChatState) appendChatHistory(chatID, chatServerAddr string, messages ...string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ctx, cancel := state.context()
+	defer cancel()
+
+	values := make([]any, len(messages))
+	for i, message := range messages {
+		values[i] = message
+	}
+	if err := state.client.RPush(ctx, state.key(chatID, "history", chatServerAddr), values...).Err(); err != nil {
+		return fmt.Errorf("failed to append history of chat ID %s: %w", chatID, err)
+	}
+	return nil
+}
+
+func (state *RedisChatState) getSyncCheckpoint(chatID string) (*rating.Rating, error) {
+	ctx, cancel := state.context()
+	defer cancel()
+
+	data, err := state.client.Get(ctx, state.key(chatID, "checkpoint")).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint of chat ID %s: %w", chatID, err)
+	}
+
+	var checkpoint rating.Rating
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint of chat ID %s: %w", chatID, err)
+	}
+	return &checkpoint, nil
+}
+
+func (state *RedisChatState) saveSyncCheckpoint(chatID string, rating *rating.Rating) error {
+	data, err := json.Marshal(rating)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint of chat ID %s: %w", chatID, err)
+	}
+
+	ctx, cancel := state.context()
+	defer cancel()
+
+	if err := state.client.Set(ctx, state.key(chatID, "checkpoint"), data, state.checkpointTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save checkpoint of chat ID %s: %w", chatID, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxChatBytes = 64 * 1024
+	snippetLength       = 80
+)
+
+// ErrMalformedResponse is wrapped by every backend chat response validation error.
+var ErrMalformedResponse = errors.New("malformed backend chat response")
+
+// ResponseSchema describes what a well-formed BackendChatResponse looks like.
+type ResponseSchema struct {
+	// MaxChatBytes caps the size of a single chat message.
+	MaxChatBytes int
+	// TerminalMarkers, when set, lists the markers a decision must carry to be accepted.
+	TerminalMarkers []string
+}
+
+var responseSchema atomic.Pointer[ResponseSchema]
+
+// malformedResponses counts rejected responses per backend URL.
+var malformedResponses sync.Map
+
+func init() {
+	responseSchema.Store(&ResponseSchema{MaxChatBytes: defaultMaxChatBytes})
+}
+
+// SetResponseSchema replaces the schema backend chat responses are validated against.
+func SetResponseSchema(schema ResponseSchema) {
+	if schema.MaxChatBytes <= 0 {
+		schema.MaxChatBytes = defaultMaxChatBytes
+	}
+	responseSchema.Store(&schema)
+}
+
+// MalformedResponseCounts returns the number of rejected responses per backend URL.
+func MalformedResponseCounts() map[string]int64 {
+	counts := make(map[string]int64)
+	malformedResponses.Range(func(backend, count any) bool {
+		counts[backend.(string)] = count.(*atomic.Int64).Load()
+		return true
+	})
+	return counts
+}
+
+// validateBackendResponse checks a backend chat response against the response schema.
+// Transport errors are left to the caller; only responses that arrived are validated.
+func (server *Server) validateBackendResponse(chatSvcUrl string, resp BackendChatResponse) error {
+	if resp.Err != nil {
+		return nil
+	}
+
+	if reason := server.malformedReason(resp.Chat); reason != "" {
+		count, _ := malformedResponses.LoadOrStore(chatSvcUrl, new(atomic.Int64))
+		count.(*atomic.Int64).Add(1)
+		return fmt.Errorf("%w from %s: %s (got %q)", ErrMalformedResponse, chatSvcUrl, reason, snippet(resp.Chat))
+	}
+	return nil
+}
+
+// malformedReason describes why a chat message violates the schema, or returns "" if it is valid.
+func (server *Server) malformedReason(chat string) string {
+	schema := responseSchema.Load()
+
+	if strings.TrimSpace(chat) == "" {
+		return "missing chat message"
+	}
+	if len(chat) > schema.MaxChatBytes {
+		return fmt.Sprintf("chat message is %d bytes, limit is %d", len(chat), schema.MaxChatBytes)
+	}
+	if !utf8.ValidString(chat) {
+		return "chat message is not valid UTF-8"
+	}
+	if looksLikeHTML(chat) {
+		return "chat message is an HTML document"
+	}
+	for _, r := range chat {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return fmt.Sprintf("chat message contains control character %U", r)
+		}
+	}
+
+	if len(schema.TerminalMarkers) > 0 && server.isDecision(chat) && !containsAny(chat, schema.TerminalMarkers) {
+		return "decision does not carry an allowed terminal marker"
+	}
+	return ""
+}
+
+func looksLikeHTML(chat string) bool {
+	lower := strings.ToLower(strings.TrimSpace(chat))
+	return strings.HasPrefix(lower, "<!doctype") ||
+		strings.HasPrefix(lower, "<html") ||
+		strings.Contains(lower, "</html>") ||
+		strings.Contains(lower, "<body")
+}
+
+func containsAny(s string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(s, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func snippet(s string) string {
+	if len(s) <= snippetLength {
+		return s
+	}
+	return s[:snippetLength] + "..."
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMalformedReason(t *testing.T) {
+	SetResponseSchema(ResponseSchema{MaxChatBytes: 64})
+	t.Cleanup(func() { SetResponseSchema(ResponseSchema{}) })
+	server := &Server{}
+
+	tests := map[string]bool{
+		"Could you tell me more?":       true,
+		"line one\nline two\twith tab":  true,
+		"   ":                           false,
+		strings.Repeat("a", 65):         false,
+		"bad \xff byte":                 false,
+		"<!DOCTYPE html><p>Bad gateway": false,
+		"<html><body>oops</body>":       false,
+		"bell \a":                       false,
+	}
+	for chat, valid := range tests {
+		if reason := server.malformedReason(chat); (reason == "") != valid {
+			t.Errorf("%q: got reason %q, want valid %v", chat, reason, valid)
+		}
+	}
+}
+
+func TestMalformedResponseRejected(t *testing.T) {
+	const backendURL = "http://malformed-backend"
+	backend := dryRun(t, MockFixture{
+		ChatID:  "follower-1",
+		History: []string{"hi", "Could you tell me more?"},
+		Replies: []MockReply{{Request: "no more info", Response: "<html><body>502 Bad Gateway</body></html>"}},
+	})
+	server := &Server{}
+	request := SyncRequest{ChatRequest: ChatRequest{ChatID: "leader-1"}}
+	history, _ := backend.getChatHistory("follower-1", "127.0.0.1:8080")
+
+	_, err := server.concludeChats(currentSyncLanes.Load(), request, "follower-1", history, "127.0.0.1:8080", backendURL)
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("got error %v, want %v", err, ErrMalformedResponse)
+	}
+	if got := MalformedResponseCounts()[backendURL]; got != 1 {
+		t.Errorf("counted %d malformed responses, want 1", got)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/blueai2022/mc/rating"
+	"github.com/blueai2022/net_prg/auditlog"
+)
+
+// decisionAudit, when set, receives every decision parsed by concludeChats.
+var decisionAudit atomic.Pointer[auditlog.Log]
+
+// SetDecisionAuditLog enables auditing of parsed decisions. Passing nil disables it.
+func SetDecisionAuditLog(log *auditlog.Log) {
+	decisionAudit.Store(log)
+}
+
+// parseDecision parses a backend decision and records it in the decision audit log.
+// A decision that cannot be audited is not returned, so no automated decision goes unrecorded.
+func (server *Server) parseDecision(requestID, chatId, chatSvcUrl, decision string) (*rating.Rating, error) {
+	parsed, parseErr := rating.ParseFromDecision(decision)
+
+	audit := decisionAudit.Load()
+	if audit == nil {
+		return parsed, parseErr
+	}
+
+	entry := auditlog.Entry{
+		RequestID: requestID,
+		ChatID:    chatId,
+		Backend:   chatSvcUrl,
+		Decision:  decision,
+	}
+	if parseErr != nil {
+		entry.Error = parseErr.Error()
+	} else {
+		encoded, err := json.Marshal(parsed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode rating for audit of chat ID %s: %w", chatId, err)
+		}
+		entry.Rating = encoded
+	}
+
+	if _, err := audit.Append(entry); err != nil {
+		return nil, fmt.Errorf("failed to audit decision for chat ID %s: %w", chatId, err)
+	}
+	return parsed, parseErr
+}
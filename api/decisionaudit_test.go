@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/blueai2022/net_prg/auditlog"
+)
+
+// Two syncs of the same leader chat conclude the same follower chats, so only the
+// request ID tells their decisions apart in the audit log.
+func TestDecisionAuditRecordsRequestID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	audit, err := auditlog.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDecisionAuditLog(audit)
+	t.Cleanup(func() { SetDecisionAuditLog(nil) })
+
+	server := &Server{}
+	requests := []SyncRequest{
+		{ChatRequest: ChatRequest{ChatID: "leader-1"}, ID: newSyncRequestID()},
+		{ChatRequest: ChatRequest{ChatID: "leader-1"}, ID: newSyncRequestID()},
+	}
+	if requests[0].ID == requests[1].ID {
+		t.Fatalf("generated the same request ID %s twice", requests[0].ID)
+	}
+	for _, request := range requests {
+		server.parseDecision(request.ID, "follower-1", "http://backend", "decision")
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var recorded []string
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		var entry auditlog.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, entry.RequestID)
+	}
+	if want := []string{requests[0].ID, requests[1].ID}; !slices.Equal(recorded, want) {
+		t.Errorf("audited request IDs %v, want %v", recorded, want)
+	}
+}
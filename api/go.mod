@@ -0,0 +1,28 @@
+// The sync server's api package. These files build together with the Server and chat
+// types in the api package of github.com/blueai2022/mc, a private module, so they are
+// kept out of the root module.
+module github.com/blueai2022/net_prg/api
+
+go 1.26.0
+
+require (
+	github.com/blueai2022/mc v0.0.0
+	github.com/blueai2022/net_prg v0.0.0
+	github.com/prometheus/client_golang v1.20.5
+	github.com/redis/go-redis/v9 v9.22.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/net v0.58.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	google.golang.org/protobuf v1.36.12 // indirect
+)
+
+replace github.com/blueai2022/net_prg => ../
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// MockReply is one scripted turn of a mock chat backend.
+type MockReply struct {
+	// Request is the client message expected for this turn. Empty matches any message.
+	Request  string `json:"request,omitempty"`
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+}
+
+// MockFixture scripts a single chat: the leader chat it follows, the history already
+// recorded for it and the replies the backend gives to each subsequent client message.
+type MockFixture struct {
+	ChatID  string      `json:"chat_id"`
+	Leader  string      `json:"leader,omitempty"`
+	History []string    `json:"history"`
+	Replies []MockReply `json:"replies"`
+}
+
+// MockBackend is an in-process chat backend that serves scripted replies from fixtures.
+// It is also the chat state in dry-run mode: a sync of a leader chat concludes the
+// fixtures that follow it, starting from their scripted histories.
+type MockBackend struct {
+	mu          sync.Mutex
+	fixtures    map[string]*MockFixture
+	turns       map[string]int
+	transcripts map[string][]string
+	// recorded holds the turns syncs appended to each chat's scripted history
+	recorded map[string][]string
+}
+
+var _ chatStore = (*MockBackend)(nil)
+
+// dryRunBackend, when set, serves every sendChatRequest instead of the real chat services,
+// and the chat state instead of Server.chatState.
+var dryRunBackend atomic.Pointer[MockBackend]
+
+// EnableDryRun routes all backend chat requests and chat state reads to the given mock
+// backend. Passing nil restores the real chat services and chat state.
+func EnableDryRun(backend *MockBackend) {
+	dryRunBackend.Store(backend)
+}
+
+// NewMockBackend creates a mock backend serving the given fixtures.
+func NewMockBackend(fixtures ...MockFixture) *MockBackend {
+	backend := &MockBackend{
+		fixtures:    make(map[string]*MockFixture, len(fixtures)),
+		turns:       make(map[string]int),
+		transcripts: make(map[string][]string),
+		recorded:    make(map[string][]string),
+	}
+	for i := range fixtures {
+		backend.fixtures[fixtures[i].ChatID] = &fixtures[i]
+	}
+	return backend
+}
+
+// LoadMockBackend loads fixtures from a JSON file or from every *.json file in a directory.
+// Each file holds either a single fixture or an array of fixtures.
+func LoadMockBackend(path string) (*MockBackend, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat fixtures %s: %w", path, err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list fixtures in %s: %w", path, err)
+		}
+	}
+
+	var fixtures []MockFixture
+	for _, file := range files {
+		loaded, err := loadMockFixtures(file)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, loaded...)
+	}
+
+	return NewMockBackend(fixtures...), nil
+}
+
+func loadMockFixtures(file string) ([]MockFixture, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture %s: %w", file, err)
+	}
+
+	var fixtures []MockFixture
+	if err := json.Unmarshal(data, &fixtures); err == nil {
+		return fixtures, nil
+	}
+
+	var fixture MockFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", file, err)
+	}
+	return []MockFixture{fixture}, nil
+}
+
+// followerChatIds returns the chat IDs of the fixtures following the leader chat, sorted.
+// Every backend serves every fixture, so backends is ignored.
+func (backend *MockBackend) followerChatIds(chatID string, backends []string) ([]string, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	var followerIds []string
+	for _, fixture := range backend.fixtures {
+		if fixture.Leader == chatID {
+			followerIds = append(followerIds, fixture.ChatID)
+		}
+	}
+	slices.Sort(followerIds)
+	return followerIds, nil
+}
+
+// saveFollowerChatId makes the chat's fixture follow the leader chat, as the chat state
+// would record it. The fixture must exist, since it scripts the chat's replies.
+func (backend *MockBackend) saveFollowerChatId(chatID, serverAddr, followerID string) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	fixture, ok := backend.fixtures[followerID]
+	if !ok {
+		return fmt.Errorf("no fixture for chat ID %s", followerID)
+	}
+	fixture.Leader = chatID
+	return nil
+}
+
+// getChatHistory returns the scripted history for a chat followed by the turns recorded
+// since, as the chat state would. Every backend serves every fixture, so serverAddr is
+// ignored.
+func (backend *MockBackend) getChatHistory(chatID, serverAddr string) ([]string, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	fixture, ok := backend.fixtures[chatID]
+	if !ok {
+		return nil, fmt.Errorf("no fixture for chat ID %s", chatID)
+	}
+	return slices.Concat(fixture.History, backend.recorded[chatID]), nil
+}
+
+// appendChatHistory records messages after the chat's scripted history until Reset.
+func (backend *MockBackend) appendChatHistory(chatID, serverAddr string, messages ...string) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if _, ok := backend.fixtures[chatID]; !ok {
+		return fmt.Errorf("no fixture for chat ID %s", chatID)
+	}
+	backend.recorded[chatID] = append(backend.recorded[chatID], messages...)
+	return nil
+}
+
+// Transcript returns the client messages and backend replies exchanged so far for a chat.
+func (backend *MockBackend) Transcript(chatID string) []string {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	return append([]string(nil), backend.transcripts[chatID]...)
+}
+
+// Send plays the next scripted turn for the chat.
+func (backend *MockBackend) Send(chatID, chatMsg string) BackendChatResponse {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	fixture, ok := backend.fixtures[chatID]
+	if !ok {
+		return BackendChatResponse{Err: fmt.Errorf("no fixture for chat ID %s", chatID)}
+	}
+
+	turn := backend.turns[chatID]
+	if turn >= len(fixture.Replies) {
+		return BackendChatResponse{Err: fmt.Errorf("fixture for chat ID %s has no reply for turn %d", chatID, turn)}
+	}
+
+	reply := fixture.Replies[turn]
+	if reply.Request != "" && reply.Request != chatMsg {
+		return BackendChatResponse{Err: fmt.Errorf("fixture for chat ID %s expected %q at turn %d, got %q", chatID, reply.Request, turn, chatMsg)}
+	}
+	backend.turns[chatID] = turn + 1
+	backend.transcripts[chatID] = append(backend.transcripts[chatID], chatMsg, reply.Response)
+
+	resp := BackendChatResponse{
+		ChatResponse: ChatResponse{
+			Chat: reply.Response,
+		},
+	}
+	if reply.Error != "" {
+		resp.Err = fmt.Errorf("%s", reply.Error)
+	}
+	return resp
+}
+
+// Reset rewinds every chat to its first scripted turn and its scripted history.
+func (backend *MockBackend) Reset() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	backend.turns = make(map[string]int)
+	backend.transcripts = make(map[string][]string)
+	backend.recorded = make(map[string][]string)
+}
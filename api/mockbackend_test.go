@@ -0,0 +1,93 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMockBackendSend(t *testing.T) {
+	backend := NewMockBackend(MockFixture{
+		ChatID: "chat-1",
+		Replies: []MockReply{
+			{Request: "no more info", Response: "Anything else?"},
+			{Response: "decision", Error: "backend unavailable"},
+		},
+	})
+
+	if resp := backend.Send("chat-1", "no more info"); resp.Err != nil || resp.Chat != "Anything else?" {
+		t.Errorf("first turn: got %q, %v", resp.Chat, resp.Err)
+	}
+	if resp := backend.Send("chat-1", "anything"); resp.Err == nil || resp.Chat != "decision" {
+		t.Errorf("second turn: got %q, %v; want the scripted error", resp.Chat, resp.Err)
+	}
+	if resp := backend.Send("chat-1", "no"); resp.Err == nil {
+		t.Error("replied past the end of the script")
+	}
+	if resp := backend.Send("chat-2", "hi"); resp.Err == nil {
+		t.Error("replied for a chat without a fixture")
+	}
+	want := []string{"no more info", "Anything else?", "anything", "decision"}
+	if transcript := backend.Transcript("chat-1"); !slices.Equal(transcript, want) {
+		t.Errorf("transcript %q, want %q", transcript, want)
+	}
+
+	backend.Reset()
+	if transcript := backend.Transcript("chat-1"); len(transcript) != 0 {
+		t.Errorf("transcript %q after reset", transcript)
+	}
+	if resp := backend.Send("chat-1", "no"); resp.Err == nil {
+		t.Error("accepted an unexpected request at the first turn after reset")
+	}
+}
+
+func TestMockBackendRejectsUnexpectedRequest(t *testing.T) {
+	backend := NewMockBackend(MockFixture{ChatID: "chat-1", Replies: []MockReply{{Request: "no more info", Response: "ok"}}})
+	if resp := backend.Send("chat-1", "no"); resp.Err == nil {
+		t.Fatal("accepted an unexpected request")
+	}
+	// The turn is not used up by a rejected request
+	if resp := backend.Send("chat-1", "no more info"); resp.Err != nil {
+		t.Errorf("rejected the expected request after an unexpected one: %v", resp.Err)
+	}
+}
+
+func TestLoadMockBackend(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"single.json": `{"chat_id": "chat-1", "history": ["hi", "hello"]}`,
+		"many.json":   `[{"chat_id": "chat-2"}, {"chat_id": "chat-3"}]`,
+		"notes.txt":   `not a fixture`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend, err := LoadMockBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chatID := range []string{"chat-1", "chat-2", "chat-3"} {
+		if _, err := backend.getChatHistory(chatID, ""); err != nil {
+			t.Errorf("%s not loaded: %v", chatID, err)
+		}
+	}
+
+	backend, err = LoadMockBackend(filepath.Join(dir, "single.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := backend.getChatHistory("chat-1", ""); !slices.Equal(history, []string{"hi", "hello"}) {
+		t.Errorf("history %q", history)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMockBackend(dir); err == nil {
+		t.Error("loaded a malformed fixture")
+	}
+}
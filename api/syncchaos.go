@@ -0,0 +1,16 @@
+package api
+
+import (
+	"sync/atomic"
+
+	"github.com/blueai2022/net_prg/chaos"
+)
+
+// backendChaos, when set, fails a share of backend chat requests on purpose.
+var backendChaos atomic.Pointer[chaos.Monkey]
+
+// EnableChaos fails backend chat requests at the monkey's backend error rate.
+// Passing nil turns chaos mode off.
+func EnableChaos(monkey *chaos.Monkey) {
+	backendChaos.Store(monkey)
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/blueai2022/mc/rating"
+	"github.com/blueai2022/net_prg/pool/typed"
+)
+
+// syncAllToDecisions synchronizes all follower chats to reach a decision state in the batch lane.
+func (server *Server) syncAllToDecisions(clientRequest ChatRequest, chatServerAddr string, backendURLs map[string]string) ([]*rating.Rating, error) {
+	return server.syncRequestToDecisions(SyncRequest{ChatRequest: clientRequest, Priority: PriorityBatch}, chatServerAddr, backendURLs)
+}
+
+// syncRequestToDecisions synchronizes all follower chats to reach a decision state,
+// competing for sync workers and backend budget in the request's priority lane.
+func (server *Server) syncRequestToDecisions(syncRequest SyncRequest, chatServerAddr string, backendURLs map[string]string) ([]*rating.Rating, error) {
+	lanes := holdSyncLanes()
+	defer lanes.release()
+	if syncRequest.ID == "" {
+		syncRequest.ID = newSyncRequestID()
+	}
+
+	// Get all follower chat IDs
+	chatState := server.chats()
+	followerChatIds, err := chatState.followerChatIds(syncRequest.ChatID, slices.Collect(maps.Keys(backendURLs)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get follower chat IDs: %w", err)
+	}
+
+	// Chat states shared between replicas remember chats that were already concluded
+	checkpoints, _ := chatState.(syncCheckpointer)
+
+	// Fan the chats out to one worker each; the lane still limits how many sync at once
+	chats := typed.New[*rating.Rating](max(len(followerChatIds), 1))
+	for _, chatId := range followerChatIds {
+		chats.Submit(func(ctx context.Context) (*rating.Rating, error) {
+			// Wait for a worker slot in this request's lane
+			lanes.acquireWorker(syncRequest.Priority)
+			defer lanes.releaseWorker(syncRequest.Priority)
+
+			// Reuse the decision if another replica already concluded this chat
+			if checkpoints != nil {
+				rating, err := checkpoints.getSyncCheckpoint(chatId)
+				if err != nil {
+					log.Printf("Error reading sync checkpoint for chat ID %s: %v\n", chatId, err)
+				} else if rating != nil {
+					return rating, nil
+				}
+			}
+
+			// Get chat history
+			chatHistory, err := chatState.getChatHistory(chatId, chatServerAddr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get chat history for chat ID %s: %w", chatId, err)
+			}
+
+			// Carry out the chat to reach a decision
+			rating, err := server.concludeChats(lanes, syncRequest, chatId, chatHistory, chatServerAddr, backendURLs[chatServerAddr])
+			if err != nil {
+				return nil, fmt.Errorf("failed to carry out chat for chat ID %s: %w", chatId, err)
+			}
+
+			// Checkpoint the decision so other replicas don't replay the chat
+			if checkpoints != nil {
+				if err := checkpoints.saveSyncCheckpoint(chatId, rating); err != nil {
+					log.Printf("Error saving sync checkpoint for chat ID %s: %v\n", chatId, err)
+				}
+			}
+			return rating, nil
+		})
+	}
+	chats.Close()
+
+	// Populate the ratings slice in chat order and collect errors, if any
+	ratings := make([]*rating.Rating, len(followerChatIds))
+	var errs []error
+	for result := range chats.Results() {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+			continue
+		}
+		ratings[result.Index] = result.Value
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("encountered errors while synchronizing chats: %v", errs)
+	}
+
+	return ratings, nil
+}
+
+// chats returns the chat state syncs read and write: the mock backend's in dry-run mode,
+// so dry runs need neither the chat servers nor their state, then the state shared in
+// Redis if the sync server was given one.
+func (server *Server) chats() chatStore {
+	if backend := dryRunBackend.Load(); backend != nil {
+		return backend
+	}
+	if state := sharedChatState.Load(); state != nil {
+		return state
+	}
+	return server.chatState
+}
+
+// RecordFollowerChat records the follower chat a backend opened for a leader chat, so a
+// later sync of the leader chat, on this replica or another sharing its chat state,
+// concludes it.
+func (server *Server) RecordFollowerChat(chatID, backend, followerID string) error {
+	return server.chats().saveFollowerChatId(chatID, backend, followerID)
+}
+
+// recordTurn appends a message the sync sent and the backend's reply to the chat history,
+// so a sync retried elsewhere resumes from the last turn rather than replaying it.
+func (server *Server) recordTurn(chatId, serverAddr, chatMsg string, chatResp BackendChatResponse) {
+	if err := server.chats().appendChatHistory(chatId, serverAddr, chatMsg, chatResp.Chat); err != nil {
+		log.Printf("Error recording chat history for chat ID %s: %v\n", chatId, err)
+	}
+}
+
+// concludeChats ensures the chat reaches a decision state.
+func (server *Server) concludeChats(lanes *syncLanes, syncRequest SyncRequest, chatId string, chatHistory []string, serverAddr, chatSvcUrl string) (*rating.Rating, error) {
+	if len(chatHistory) == 0 {
+		return nil, fmt.Errorf("empty chat history for chatID %s", chatId)
+	}
+
+	// Search chatHistory backwards for a decision or error
+	// The chatHistory alternates between client and server messages (client at even indices, server at odd indices)
+	for i := len(chatHistory) - 1; i >= 0; i-- {
+		// Skip client messages (even indices, since client starts the chat)
+		if i%2 == 0 {
+			continue
+		}
+
+		response := chatHistory[i]
+
+		// If a decision is found, return it
+		if server.isDecision(response) {
+			return server.parseDecision(syncRequest.ID, chatId, chatSvcUrl, response)
+		}
+
+		// If an error response is found, return an error
+		if server.isErrorResponse(response) {
+			return nil, fmt.Errorf("error found in chat history for chatID %s: %s", chatId, response)
+		}
+	}
+
+	// If no decision was found in the history, carry out the chat to reach a decision
+	// Initialize chatResp with the last response from chatHistory
+	chatResp := BackendChatResponse{
+		ChatResponse: ChatResponse{
+			Chat: chatHistory[len(chatHistory)-1],
+		},
+	}
+
+	for !server.isLastCallResponse(chatResp.Chat) {
+		if server.isGoodbyeResponse(chatResp.Chat) {
+			return nil, fmt.Errorf("unexpected end of conversation for chatID %s", chatId)
+		}
+
+		if server.isErrorResponse(chatResp.Chat) {
+			return nil, fmt.Errorf("unexpected error in conversation for chatID %s", chatId)
+		}
+
+		// Send "no more info" to fast-forward the conversation
+		lanes.spendBudget(syncRequest.Priority)
+		chatResp = server.sendChatRequest(serverAddr, chatSvcUrl, chatId, "no more info")
+		if chatResp.Err != nil {
+			return nil, fmt.Errorf("failed to send chat for chatID %s: %w", chatId, chatResp.Err)
+		}
+		server.recordTurn(chatId, serverAddr, "no more info", chatResp)
+		if server.isDecision(chatResp.Chat) {
+			return server.parseDecision(syncRequest.ID, chatId, chatSvcUrl, chatResp.Chat)
+		}
+	}
+
+	// Send "no" to trigger the final decision
+	lanes.spendBudget(syncRequest.Priority)
+	decisionResp := server.sendChatRequest(serverAddr, chatSvcUrl, chatId, "no")
+	if decisionResp.Err != nil {
+		return nil, fmt.Errorf("failed to send chat for chatID %s: %w", chatId, decisionResp.Err)
+	}
+	server.recordTurn(chatId, serverAddr, "no", decisionResp)
+	if !server.isDecision(decisionResp.Chat) {
+		return nil, fmt.Errorf("failed to reach decision for chatID %s", chatId)
+	}
+
+	return server.parseDecision(syncRequest.ID, chatId, chatSvcUrl, decisionResp.Chat)
+}
+
+// sendChatRequest sends a chat message to the backend server and returns the response.
+func (server *Server) sendChatRequest(serverAddr, chatSvcUrl, chatID, chatMsg string) BackendChatResponse {
+	var resp BackendChatResponse
+
+	// In chaos mode some requests fail as if the backend had, without reaching it
+	if err := backendChaos.Load().BackendError(); err != nil {
+		resp = BackendChatResponse{Err: err}
+	} else if backend := dryRunBackend.Load(); backend != nil {
+		// In dry-run mode the scripted mock backend answers instead of the chat service
+		resp = backend.Send(chatID, chatMsg)
+	} else {
+		respChan := make(chan BackendChatResponse, 1)
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go server.chatWorker(&wg, serverAddr, chatSvcUrl, chatID, ChatRequest{Chat: chatMsg, ChatID: chatID}, respChan)
+
+		wg.Wait()
+		close(respChan)
+
+		resp = <-respChan
+	}
+
+	// Never hand a malformed response to the turn logic or the decision parser
+	if err := server.validateBackendResponse(chatSvcUrl, resp); err != nil {
+		resp = BackendChatResponse{Err: err}
+	}
+
+	if resp.Err != nil {
+		log.Printf("Error sending chat for chat ID %s: %v\n", chatID, resp.Err)
+	}
+
+	return resp
+}
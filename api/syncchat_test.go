@@ -0,0 +1,138 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The scripted replies below are backend questions, neither decisions nor last calls, so
+// concludeChats answers each with "no more info".
+const (
+	testQuestion     = "Could you tell me more about the account?"
+	testFollowUp     = "Anything about its recent activity?"
+	testBackendError = "backend unavailable"
+)
+
+// dryRun routes backend requests and chat state reads to fixtures until the test ends.
+func dryRun(t *testing.T, fixtures ...MockFixture) *MockBackend {
+	t.Helper()
+	backend := NewMockBackend(fixtures...)
+	EnableDryRun(backend)
+	t.Cleanup(func() { EnableDryRun(nil) })
+	return backend
+}
+
+func TestMockBackendChatState(t *testing.T) {
+	backend := dryRun(t,
+		MockFixture{ChatID: "follower-2", Leader: "leader-1", History: []string{"hi", testQuestion}},
+		MockFixture{ChatID: "follower-1", Leader: "leader-1"},
+		MockFixture{ChatID: "other", Leader: "leader-2"},
+	)
+	if chats := (&Server{}).chats(); chats != chatStore(backend) {
+		t.Fatalf("dry run reads chat state from %T, want the mock backend", chats)
+	}
+
+	followers, err := backend.followerChatIds("leader-1", []string{"127.0.0.1:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"follower-1", "follower-2"}; !slices.Equal(followers, want) {
+		t.Errorf("followers %v, want %v", followers, want)
+	}
+
+	history, err := backend.getChatHistory("follower-2", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hi", testQuestion}; !slices.Equal(history, want) {
+		t.Errorf("history %v, want %v", history, want)
+	}
+	if _, err := backend.getChatHistory("unknown", "127.0.0.1:8080"); err == nil {
+		t.Error("got the history of a chat without a fixture")
+	}
+}
+
+func TestConcludeChatsEmptyHistory(t *testing.T) {
+	backend := dryRun(t, MockFixture{ChatID: "follower-1"})
+	server := &Server{}
+	request := SyncRequest{ChatRequest: ChatRequest{ChatID: "leader-1"}}
+
+	if _, err := server.concludeChats(currentSyncLanes.Load(), request, "follower-1", nil, "127.0.0.1:8080", "http://backend"); err == nil {
+		t.Fatal("concluded a chat without history")
+	}
+	if transcript := backend.Transcript("follower-1"); len(transcript) != 0 {
+		t.Errorf("sent %q for a chat without history", transcript)
+	}
+}
+
+func TestConcludeChatsBackendError(t *testing.T) {
+	backend := dryRun(t, MockFixture{
+		ChatID:  "follower-1",
+		History: []string{"hi", testQuestion},
+		Replies: []MockReply{
+			{Request: "no more info", Response: testFollowUp},
+			{Request: "no more info", Response: testFollowUp, Error: testBackendError},
+		},
+	})
+	server := &Server{}
+	request := SyncRequest{ChatRequest: ChatRequest{ChatID: "leader-1"}}
+	history, _ := backend.getChatHistory("follower-1", "127.0.0.1:8080")
+
+	_, err := server.concludeChats(currentSyncLanes.Load(), request, "follower-1", history, "127.0.0.1:8080", "http://backend")
+	if err == nil || !strings.Contains(err.Error(), testBackendError) {
+		t.Fatalf("got error %v, want the backend's %q", err, testBackendError)
+	}
+	want := []string{"no more info", testFollowUp, "no more info", testFollowUp}
+	if transcript := backend.Transcript("follower-1"); !slices.Equal(transcript, want) {
+		t.Errorf("transcript %q, want %q", transcript, want)
+	}
+	// Only the turn that succeeded is recorded
+	history, _ = backend.getChatHistory("follower-1", "127.0.0.1:8080")
+	if want := []string{"hi", testQuestion, "no more info", testFollowUp}; !slices.Equal(history, want) {
+		t.Errorf("history %q, want %q", history, want)
+	}
+}
+
+func TestRecordFollowerChat(t *testing.T) {
+	backend := dryRun(t, MockFixture{ChatID: "follower-1"})
+	server := &Server{}
+
+	if err := server.RecordFollowerChat("leader-1", "127.0.0.1:8080", "follower-1"); err != nil {
+		t.Fatal(err)
+	}
+	followers, err := backend.followerChatIds("leader-1", []string{"127.0.0.1:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"follower-1"}; !slices.Equal(followers, want) {
+		t.Errorf("followers %v, want %v", followers, want)
+	}
+	if err := server.RecordFollowerChat("leader-1", "127.0.0.1:8080", "unknown"); err == nil {
+		t.Error("recorded a follower chat without a fixture")
+	}
+}
+
+// A dry run syncs the fixtures following the leader chat, here failing on their empty
+// histories, and leaves the others alone.
+func TestSyncReadsMockChatState(t *testing.T) {
+	dryRun(t,
+		MockFixture{ChatID: "follower-1", Leader: "leader-1"},
+		MockFixture{ChatID: "follower-2", Leader: "leader-1"},
+		MockFixture{ChatID: "other", Leader: "leader-2"},
+	)
+	server := &Server{}
+
+	_, err := server.syncAllToDecisions(ChatRequest{ChatID: "leader-1"}, "127.0.0.1:8080", map[string]string{"127.0.0.1:8080": "http://backend"})
+	if err == nil {
+		t.Fatal("concluded chats without history")
+	}
+	for _, chatID := range []string{"follower-1", "follower-2"} {
+		if !strings.Contains(err.Error(), chatID) {
+			t.Errorf("error %v does not mention %s", err, chatID)
+		}
+	}
+	if strings.Contains(err.Error(), "other") {
+		t.Errorf("synced a chat of another leader: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/blueai2022/net_prg/auditlog"
+	"github.com/blueai2022/net_prg/chaos"
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/lifecycle"
+	"github.com/blueai2022/net_prg/ping"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	precheckTimeout = 2 * time.Second
+)
+
+// SyncConfig holds the sync server settings. It is loaded with the shared config
+// package, so every setting can come from the config file, SYNCSERVER_* environment
+// variables or flags.
+type SyncConfig struct {
+	Workers      int     `config:"sync-workers" usage:"concurrent chat syncs"`
+	BackendRate  float64 `config:"backend-rate" usage:"backend requests per second shared by all lanes, 0 for unlimited"`
+	BackendBurst int     `config:"backend-burst" usage:"backend requests allowed in a burst"`
+
+	MaxChatBytes    int      `config:"max-chat-bytes" usage:"largest backend chat message accepted"`
+	TerminalMarkers []string `config:"terminal-markers" usage:"comma-separated markers a decision must carry"`
+
+	// The backends come from one of a file, DNS SRV records or Consul. Discovered
+	// backends are given URLs of BackendsScheme and BackendsPath.
+	BackendsFile          string        `config:"backends-file" usage:"JSON file of chat server address to backend URL"`
+	BackendsSRV           string        `config:"backends-srv" usage:"SRV record to discover backends from, e.g. _chat._tcp.example.com"`
+	BackendsConsul        string        `config:"backends-consul" usage:"Consul HTTP address to discover backends from, e.g. http://127.0.0.1:8500"`
+	BackendsConsulService string        `config:"backends-consul-service" usage:"Consul service whose passing instances are the backends"`
+	BackendsConsulToken   string        `config:"backends-consul-token" usage:"Consul ACL token"`
+	BackendsScheme        string        `config:"backends-scheme" usage:"URL scheme of discovered backends"`
+	BackendsPath          string        `config:"backends-path" usage:"URL path of the chat service on discovered backends"`
+	BackendsReload        time.Duration `config:"backends-reload" usage:"how often the backends are reloaded"`
+
+	PingPrecheck bool `config:"ping-precheck" usage:"ping every backend host at startup and log unreachable ones"`
+
+	ChatStateRedis  string `config:"chat-state-redis" usage:"Redis address to share chat state with other replicas, empty keeps it in process"`
+	ChatStatePrefix string `config:"chat-state-prefix" usage:"prefix of the chat state keys in Redis"`
+
+	AuditLog string `config:"audit-log" usage:"decision audit log file, empty disables auditing"`
+	DryRun   string `config:"dry-run" usage:"mock backend fixture file or directory; replaces the real chat services"`
+
+	ChaosSeed          int64   `config:"chaos-seed" usage:"seed for chaos faults, 0 picks one; the seed is logged so a run can be repeated"`
+	ChaosBackendErrors float64 `config:"chaos-backend-errors" usage:"percentage of backend chat requests failed without being sent"`
+}
+
+// DefaultSyncConfig returns the settings the sync server uses when nothing overrides them.
+func DefaultSyncConfig() SyncConfig {
+	return SyncConfig{
+		Workers:        defaultSyncWorkers,
+		BackendBurst:   1,
+		MaxChatBytes:   defaultMaxChatBytes,
+		BackendsScheme: "http",
+		BackendsReload: 30 * time.Second,
+
+		ChatStatePrefix: "syncserver:",
+	}
+}
+
+// LoadSyncConfig loads the sync server settings on top of the defaults.
+func LoadSyncConfig(args []string) (SyncConfig, error) {
+	cfg := DefaultSyncConfig()
+	if _, err := config.Load("syncserver", &cfg, args); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func (cfg *SyncConfig) Validate() error {
+	if cfg.Workers < 1 {
+		return fmt.Errorf("sync-workers must be at least 1, got %d", cfg.Workers)
+	}
+	if cfg.BackendRate < 0 {
+		return fmt.Errorf("backend-rate must not be negative, got %v", cfg.BackendRate)
+	}
+	if err := cfg.backends().Validate(); err != nil {
+		return err
+	}
+	return cfg.chaos().Validate()
+}
+
+// chaos returns the chaos fault rates; all zero leaves chaos mode off.
+func (cfg SyncConfig) chaos() chaos.Config {
+	return chaos.Config{Seed: cfg.ChaosSeed, BackendErrors: cfg.ChaosBackendErrors}
+}
+
+// backends returns the backend discovery settings.
+func (cfg SyncConfig) backends() BackendsConfig {
+	return BackendsConfig{
+		File:          cfg.BackendsFile,
+		SRV:           cfg.BackendsSRV,
+		Consul:        cfg.BackendsConsul,
+		ConsulService: cfg.BackendsConsulService,
+		ConsulToken:   cfg.BackendsConsulToken,
+		Scheme:        cfg.BackendsScheme,
+		Path:          cfg.BackendsPath,
+		Reload:        cfg.BackendsReload,
+	}
+}
+
+// Apply configures the sync lanes, response schema, backends, chat state, audit log,
+// dry-run mode and chaos mode.
+// Background reloading stops when ctx is done. The returned function closes the audit log
+// and the chat state's Redis client.
+func (cfg SyncConfig) Apply(ctx context.Context) (func() error, error) {
+	ConfigureSyncLanes(cfg.Workers, cfg.BackendRate, cfg.BackendBurst)
+	SetResponseSchema(ResponseSchema{MaxChatBytes: cfg.MaxChatBytes, TerminalMarkers: cfg.TerminalMarkers})
+
+	if err := cfg.backends().Start(ctx); err != nil {
+		return nil, err
+	}
+	if cfg.PingPrecheck {
+		go precheckBackends(ctx, Backends.Snapshot())
+	}
+
+	if cfg.DryRun != "" {
+		backend, err := LoadMockBackend(cfg.DryRun)
+		if err != nil {
+			return nil, err
+		}
+		EnableDryRun(backend)
+	}
+
+	if cfg.chaos().Enabled() {
+		monkey := chaos.New(cfg.chaos())
+		log.Println("Chaos mode enabled:", monkey)
+		EnableChaos(monkey)
+	}
+
+	closeChatState := func() error { return nil }
+	if cfg.ChatStateRedis != "" {
+		client := redis.NewClient(&redis.Options{Addr: cfg.ChatStateRedis})
+		UseSharedChatState(NewRedisChatState(client, cfg.ChatStatePrefix))
+		closeChatState = func() error {
+			UseSharedChatState(nil)
+			return client.Close()
+		}
+	}
+
+	closeAudit := func() error { return nil }
+	if cfg.AuditLog != "" {
+		audit, err := auditlog.Open(cfg.AuditLog)
+		if err != nil {
+			closeChatState()
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		SetDecisionAuditLog(audit)
+		closeAudit = func() error {
+			SetDecisionAuditLog(nil)
+			return audit.Close()
+		}
+	}
+	return func() error {
+		return errors.Join(closeAudit(), closeChatState())
+	}, nil
+}
+
+// Start applies the settings for a sync server whose shutdown lc runs: reloading stops
+// when shutdown begins and the audit log and chat state are closed after the steps
+// registered later, such as draining in-flight syncs, have run. The server is marked ready once the backends are
+// loaded.
+func (cfg SyncConfig) Start(lc *lifecycle.Lifecycle) error {
+	closeState, err := cfg.Apply(lc.Context())
+	if err != nil {
+		return err
+	}
+	lc.OnShutdown("audit log and chat state", func(ctx context.Context) error {
+		return closeState()
+	})
+	lc.SetReady(true)
+	return nil
+}
+
+// precheckBackends pings every backend host and logs the ones that don't answer.
+// ICMP may be filtered where HTTP is not, so this only warns.
+func precheckBackends(ctx context.Context, backendURLs map[string]string) {
+	for addr := range backendURLs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if err := ping.Reachable(ctx, host, precheckTimeout); err != nil {
+			log.Printf("Backend reachability pre-check failed for %s: %v\n", addr, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSyncConfigBackendSources(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   func(cfg *SyncConfig)
+		valid bool
+	}{
+		{"none", func(cfg *SyncConfig) {}, true},
+		{"file", func(cfg *SyncConfig) { cfg.BackendsFile = "backends.json" }, true},
+		{"srv", func(cfg *SyncConfig) { cfg.BackendsSRV = "_chat._tcp.example.com" }, true},
+		{"consul", func(cfg *SyncConfig) {
+			cfg.BackendsConsul, cfg.BackendsConsulService = "http://127.0.0.1:8500", "chat"
+		}, true},
+		{"consul without service", func(cfg *SyncConfig) { cfg.BackendsConsul = "http://127.0.0.1:8500" }, false},
+		{"two sources", func(cfg *SyncConfig) {
+			cfg.BackendsFile, cfg.BackendsSRV = "backends.json", "_chat._tcp.example.com"
+		}, false},
+		{"no reload interval", func(cfg *SyncConfig) { cfg.BackendsSRV, cfg.BackendsReload = "_chat._tcp.example.com", 0 }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := DefaultSyncConfig()
+			test.cfg(&cfg)
+			if err := cfg.Validate(); (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestSyncConfigConsulBackends(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/chat" || r.Header.Get("X-Consul-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"Node": map[string]any{"Address": "10.0.0.1"}, "Service": map[string]any{"Port": 8080}},
+			{"Node": map[string]any{"Address": "10.0.0.1"}, "Service": map[string]any{"Address": "10.0.0.2", "Port": 8080}},
+		})
+	}))
+	defer consul.Close()
+
+	cfg := DefaultSyncConfig()
+	cfg.BackendsConsul, cfg.BackendsConsulService, cfg.BackendsConsulToken = consul.URL, "chat", "secret"
+	cfg.BackendsPath = "/chat"
+	urls, err := cfg.backends().Source().Backends(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"10.0.0.1:8080": "http://10.0.0.1:8080/chat",
+		"10.0.0.2:8080": "http://10.0.0.2:8080/chat",
+	}
+	if !maps.Equal(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+}
+
+func TestSyncConfigSharedChatState(t *testing.T) {
+	cfg := DefaultSyncConfig()
+	cfg.ChatStateRedis = "127.0.0.1:6379"
+	closeState, err := cfg.Apply(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, ok := (&Server{}).chats().(*RedisChatState); !ok || state.prefix != "syncserver:" {
+		t.Errorf("syncs use chat state %T, want Redis under syncserver:", (&Server{}).chats())
+	}
+	if err := closeState(); err != nil {
+		t.Fatal(err)
+	}
+	if state := sharedChatState.Load(); state != nil {
+		t.Error("closing left the chat state in Redis")
+	}
+}
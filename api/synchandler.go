@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// SyncHandler serves syncs of a leader chat's follower chats against the current
+// Backends. A POST names the leader chat and the chat server it is on with the chat_id
+// and chat_server form values, and is answered with the decisions as a JSON array.
+// Interactive clients set the priority form value to "urgent" to be served ahead of
+// queued batch syncs.
+func (server *Server) SyncHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		chatID, chatServerAddr := r.FormValue("chat_id"), r.FormValue("chat_server")
+		if chatID == "" || chatServerAddr == "" {
+			http.Error(w, "chat_id and chat_server are required", http.StatusBadRequest)
+			return
+		}
+
+		priority, err := ParseSyncPriority(r.FormValue("priority"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		syncRequest := SyncRequest{ChatRequest: ChatRequest{ChatID: chatID}, Priority: priority}
+		ratings, err := server.syncAllToCurrentBackends(syncRequest, chatServerAddr)
+		if errors.Is(err, errUnknownChatServer) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ratings)
+	})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// syncBackends serves the chat server the tests sync with until the test ends.
+func syncBackends(t *testing.T) {
+	t.Helper()
+	prev := Backends.Swap(map[string]string{"127.0.0.1:8080": "http://backend"})
+	t.Cleanup(func() { Backends.Swap(prev) })
+}
+
+// postSync posts a sync request to handler.
+func postSync(handler http.Handler, method string, form url.Values) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/sync", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSyncHandler(t *testing.T) {
+	dryRun(t, MockFixture{ChatID: "follower-1", Leader: "leader-1"})
+	syncBackends(t)
+	handler := (&Server{}).SyncHandler()
+
+	tests := []struct {
+		name   string
+		method string
+		form   url.Values
+		status int
+		body   string
+	}{
+		{"get", http.MethodGet, nil, http.StatusMethodNotAllowed, ""},
+		{"no chat server", http.MethodPost, url.Values{"chat_id": {"leader-1"}}, http.StatusBadRequest, ""},
+		{"unknown priority", http.MethodPost, url.Values{"chat_id": {"leader-1"}, "chat_server": {"127.0.0.1:8080"}, "priority": {"now"}}, http.StatusBadRequest, "now"},
+		{"unknown chat server", http.MethodPost, url.Values{"chat_id": {"leader-1"}, "chat_server": {"127.0.0.1:9090"}}, http.StatusNotFound, "127.0.0.1:9090"},
+		// The fixture has no history, so the sync fails
+		{"failed sync", http.MethodPost, url.Values{"chat_id": {"leader-1"}, "chat_server": {"127.0.0.1:8080"}}, http.StatusBadGateway, "follower-1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := postSync(handler, test.method, test.form)
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+			if !strings.Contains(recorder.Body.String(), test.body) {
+				t.Errorf("body %q does not mention %q", recorder.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestSyncHandlerUrgent(t *testing.T) {
+	dryRun(t,
+		MockFixture{ChatID: "follower-1", Leader: "leader-1"},
+		MockFixture{ChatID: "follower-2", Leader: "leader-1"},
+	)
+	syncBackends(t)
+	handler := (&Server{}).SyncHandler()
+
+	before := SyncLaneStats()
+	postSync(handler, http.MethodPost, url.Values{"chat_id": {"leader-1"}, "chat_server": {"127.0.0.1:8080"}, "priority": {"urgent"}})
+	after := SyncLaneStats()
+	if got := after["urgent"].Completed - before["urgent"].Completed; got != 2 {
+		t.Errorf("%d chats synced in the urgent lane, want 2", got)
+	}
+	if got := after["batch"].Completed - before["batch"].Completed; got != 0 {
+		t.Errorf("%d chats synced in the batch lane, want 0", got)
+	}
+}
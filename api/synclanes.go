@@ -0,0 +1,291 @@
+package api
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncPriority selects the lane a sync request waits in for workers and backend budget.
+type SyncPriority int
+
+const (
+	// PriorityBatch is for background and bulk syncs.
+	PriorityBatch SyncPriority = iota
+	// PriorityUrgent is for interactive syncs; they are always served before queued batch syncs.
+	PriorityUrgent
+
+	numSyncLanes = 2
+)
+
+const (
+	defaultSyncWorkers = 16
+)
+
+func (priority SyncPriority) String() string {
+	switch priority {
+	case PriorityUrgent:
+		return "urgent"
+	default:
+		return "batch"
+	}
+}
+
+// ParseSyncPriority returns the priority named by String, or the batch one for "".
+func ParseSyncPriority(name string) (SyncPriority, error) {
+	switch name {
+	case "", "batch":
+		return PriorityBatch, nil
+	case "urgent":
+		return PriorityUrgent, nil
+	}
+	return PriorityBatch, fmt.Errorf("unknown sync priority %q", name)
+}
+
+// SyncRequest is a client sync request tagged with its priority lane.
+type SyncRequest struct {
+	ChatRequest
+	Priority SyncPriority
+	// ID tells the decisions of this request apart from those of other requests for the
+	// same leader chat in the decision audit log. syncRequestToDecisions generates one
+	// when it is empty.
+	ID string
+}
+
+// newSyncRequestID returns a random ID for a sync request that was given none.
+func newSyncRequestID() string {
+	return rand.Text()
+}
+
+// LaneStats reports queue metrics for one priority lane.
+type LaneStats struct {
+	Queued    int
+	Active    int
+	Completed int64
+	WaitTotal time.Duration
+}
+
+// laneGate hands out a limited number of permits, always serving queued urgent
+// waiters before batch ones.
+type laneGate struct {
+	mu        sync.Mutex
+	available int
+	capacity  int
+	waiters   [numSyncLanes][]chan struct{}
+}
+
+func newLaneGate(capacity int) *laneGate {
+	return &laneGate{available: capacity, capacity: capacity}
+}
+
+// acquire blocks until a permit is granted to the given lane and returns the time spent waiting.
+func (gate *laneGate) acquire(lane SyncPriority) time.Duration {
+	gate.mu.Lock()
+	if gate.available > 0 && !gate.hasWaitersFrom(lane) {
+		gate.available--
+		gate.mu.Unlock()
+		return 0
+	}
+
+	start := time.Now()
+	ready := make(chan struct{})
+	gate.waiters[lane] = append(gate.waiters[lane], ready)
+	gate.mu.Unlock()
+
+	<-ready
+	return time.Since(start)
+}
+
+// release returns a permit, handing it straight to the highest-priority waiter if there is one.
+func (gate *laneGate) release() {
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	for lane := numSyncLanes - 1; lane >= 0; lane-- {
+		if len(gate.waiters[lane]) > 0 {
+			ready := gate.waiters[lane][0]
+			gate.waiters[lane] = gate.waiters[lane][1:]
+			close(ready)
+			return
+		}
+	}
+
+	if gate.available < gate.capacity {
+		gate.available++
+	}
+}
+
+// hasWaitersFrom reports whether anyone is queued in the given lane or a more urgent one.
+func (gate *laneGate) hasWaitersFrom(lane SyncPriority) bool {
+	for l := int(lane); l < numSyncLanes; l++ {
+		if len(gate.waiters[l]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (gate *laneGate) queued(lane SyncPriority) int {
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	return len(gate.waiters[lane])
+}
+
+// syncLanes bounds concurrent chat syncs and the request budget spent on backends,
+// per priority lane.
+type syncLanes struct {
+	workers *laneGate
+	// budget is refilled at the backend rate limit; nil means unlimited.
+	budget *laneGate
+	stop   chan struct{}
+
+	// users counts the syncs holding these lanes; once retired, the budget stops being
+	// refilled when the last of them lets go.
+	mu      sync.Mutex
+	users   int
+	retired bool
+
+	active    [numSyncLanes]atomic.Int64
+	completed [numSyncLanes]atomic.Int64
+	waitNanos [numSyncLanes]atomic.Int64
+}
+
+var currentSyncLanes atomic.Pointer[syncLanes]
+
+func init() {
+	currentSyncLanes.Store(newSyncLanes(defaultSyncWorkers, 0, 0))
+}
+
+// ConfigureSyncLanes sets the number of concurrent chat syncs and the backend request
+// rate shared by all lanes. A rate of zero disables the backend budget.
+// Syncs already running finish against the previous configuration, whose budget keeps
+// being refilled until they have.
+func ConfigureSyncLanes(workers int, backendRate float64, burst int) {
+	if prev := currentSyncLanes.Swap(newSyncLanes(workers, backendRate, burst)); prev != nil {
+		prev.retire()
+	}
+}
+
+// holdSyncLanes returns the current lanes, held for a sync until it calls release.
+func holdSyncLanes() *syncLanes {
+	for {
+		// Lanes retired since they were loaded are no longer current, so load them again
+		if lanes := currentSyncLanes.Load(); lanes.hold() {
+			return lanes
+		}
+	}
+}
+
+// hold counts a sync as using the lanes, unless they were retired.
+func (lanes *syncLanes) hold() bool {
+	lanes.mu.Lock()
+	defer lanes.mu.Unlock()
+
+	if lanes.retired {
+		return false
+	}
+	lanes.users++
+	return true
+}
+
+// release lets go of lanes taken with holdSyncLanes.
+func (lanes *syncLanes) release() {
+	lanes.mu.Lock()
+	defer lanes.mu.Unlock()
+
+	lanes.users--
+	lanes.stopIfDrained()
+}
+
+// retire stops the budget refill once no sync holds the lanes.
+func (lanes *syncLanes) retire() {
+	lanes.mu.Lock()
+	defer lanes.mu.Unlock()
+
+	lanes.retired = true
+	lanes.stopIfDrained()
+}
+
+func (lanes *syncLanes) stopIfDrained() {
+	if lanes.retired && lanes.users == 0 && lanes.stop != nil {
+		close(lanes.stop)
+		lanes.stop = nil
+	}
+}
+
+func newSyncLanes(workers int, backendRate float64, burst int) *syncLanes {
+	lanes := &syncLanes{workers: newLaneGate(workers)}
+	if backendRate <= 0 {
+		return lanes
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+	lanes.budget = newLaneGate(burst)
+	lanes.stop = make(chan struct{})
+	stop := lanes.stop
+
+	// Refill the backend budget one request at a time
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / backendRate))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				lanes.budget.release()
+			}
+		}
+	}()
+
+	return lanes
+}
+
+// acquireWorker waits for a sync worker slot in the given lane.
+func (lanes *syncLanes) acquireWorker(lane SyncPriority) {
+	waited := lanes.workers.acquire(lane)
+	lanes.waitNanos[lane].Add(int64(waited))
+	lanes.active[lane].Add(1)
+}
+
+// releaseWorker frees a sync worker slot taken by acquireWorker.
+func (lanes *syncLanes) releaseWorker(lane SyncPriority) {
+	lanes.active[lane].Add(-1)
+	lanes.completed[lane].Add(1)
+	lanes.workers.release()
+}
+
+// spendBudget waits until the lane may send one more backend request.
+func (lanes *syncLanes) spendBudget(lane SyncPriority) {
+	if lanes.budget == nil {
+		return
+	}
+	waited := lanes.budget.acquire(lane)
+	lanes.waitNanos[lane].Add(int64(waited))
+}
+
+// SyncLaneStats reports queue metrics per priority lane.
+func SyncLaneStats() map[string]LaneStats {
+	lanes := currentSyncLanes.Load()
+
+	stats := make(map[string]LaneStats, numSyncLanes)
+	for lane := SyncPriority(0); lane < numSyncLanes; lane++ {
+		queued := lanes.workers.queued(lane)
+		if lanes.budget != nil {
+			queued += lanes.budget.queued(lane)
+		}
+		stats[lane.String()] = LaneStats{
+			Queued:    queued,
+			Active:    int(lanes.active[lane].Load()),
+			Completed: lanes.completed[lane].Load(),
+			WaitTotal: time.Duration(lanes.waitNanos[lane].Load()),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// waitQueued waits until n syncs are queued in the lane of gate.
+func waitQueued(t *testing.T, gate *laneGate, lane SyncPriority, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); gate.queued(lane) != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d %s syncs queued, want %d", gate.queued(lane), lane, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLaneGateServesUrgentFirst(t *testing.T) {
+	gate := newLaneGate(1)
+	gate.acquire(PriorityBatch)
+
+	served := make(chan SyncPriority, 2)
+	go func() {
+		gate.acquire(PriorityBatch)
+		served <- PriorityBatch
+	}()
+	waitQueued(t, gate, PriorityBatch, 1)
+	go func() {
+		gate.acquire(PriorityUrgent)
+		served <- PriorityUrgent
+	}()
+	waitQueued(t, gate, PriorityUrgent, 1)
+
+	gate.release()
+	if lane := <-served; lane != PriorityUrgent {
+		t.Errorf("served the %s sync first, want the urgent one that queued later", lane)
+	}
+	gate.release()
+	if lane := <-served; lane != PriorityBatch {
+		t.Errorf("served the %s sync second, want the batch one", lane)
+	}
+}
+
+func TestSyncLaneStats(t *testing.T) {
+	ConfigureSyncLanes(2, 0, 0)
+	t.Cleanup(func() { ConfigureSyncLanes(defaultSyncWorkers, 0, 0) })
+	lanes := currentSyncLanes.Load()
+
+	lanes.acquireWorker(PriorityUrgent)
+	stats := SyncLaneStats()
+	if got := stats["urgent"].Active; got != 1 {
+		t.Errorf("%d urgent syncs active, want 1", got)
+	}
+	if got := stats["batch"].Active; got != 0 {
+		t.Errorf("%d batch syncs active, want 0", got)
+	}
+
+	lanes.releaseWorker(PriorityUrgent)
+	stats = SyncLaneStats()
+	if got := stats["urgent"]; got.Active != 0 || got.Completed != 1 {
+		t.Errorf("urgent lane %+v, want none active and one completed", got)
+	}
+}
+
+// A sync holding lanes that were reconfigured away still gets budget until it is done.
+func TestRetiredLanesRefillUntilReleased(t *testing.T) {
+	ConfigureSyncLanes(1, 100, 1)
+	t.Cleanup(func() { ConfigureSyncLanes(defaultSyncWorkers, 0, 0) })
+	lanes := holdSyncLanes()
+
+	ConfigureSyncLanes(1, 100, 1)
+	if current := holdSyncLanes(); current == lanes {
+		t.Fatal("held the retired lanes after reconfiguring")
+	} else {
+		current.release()
+	}
+
+	spent := make(chan struct{})
+	go func() {
+		for range 3 {
+			lanes.spendBudget(PriorityBatch)
+		}
+		close(spent)
+	}()
+	select {
+	case <-spent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a sync on the retired lanes is still waiting for budget")
+	}
+
+	lanes.release()
+	lanes.mu.Lock()
+	defer lanes.mu.Unlock()
+	if lanes.stop != nil {
+		t.Error("the retired lanes are still refilled after the last sync released them")
+	}
+}
+
+func TestSyncLaneCollector(t *testing.T) {
+	ConfigureSyncLanes(2, 0, 0)
+	t.Cleanup(func() { ConfigureSyncLanes(defaultSyncWorkers, 0, 0) })
+	lanes := currentSyncLanes.Load()
+	lanes.acquireWorker(PriorityUrgent)
+	defer lanes.releaseWorker(PriorityUrgent)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSyncLaneCollector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "syncserver_lane_active_syncs" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			active[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+	}
+	if active["urgent"] != 1 || active["batch"] != 0 {
+		t.Errorf("active syncs by lane %v, want one urgent and no batch", active)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SyncLaneCollector reports SyncLaneStats as Prometheus metrics on every scrape, labelled
+// with the lane.
+type SyncLaneCollector struct {
+	queued    *prometheus.Desc
+	active    *prometheus.Desc
+	completed *prometheus.Desc
+	wait      *prometheus.Desc
+}
+
+// NewSyncLaneCollector creates a collector for the current sync lanes.
+func NewSyncLaneCollector() *SyncLaneCollector {
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("syncserver_lane_"+metric, help, []string{"lane"}, nil)
+	}
+	return &SyncLaneCollector{
+		queued:    desc("queued_syncs", "Chat syncs waiting for a worker or backend budget."),
+		active:    desc("active_syncs", "Chat syncs holding a worker."),
+		completed: desc("completed_syncs_total", "Chat syncs that released their worker."),
+		wait:      desc("wait_seconds_total", "Time chat syncs spent waiting for workers and backend budget."),
+	}
+}
+
+func (collector *SyncLaneCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.queued
+	ch <- collector.active
+	ch <- collector.completed
+	ch <- collector.wait
+}
+
+func (collector *SyncLaneCollector) Collect(ch chan<- prometheus.Metric) {
+	for lane, stats := range SyncLaneStats() {
+		ch <- prometheus.MustNewConstMetric(collector.queued, prometheus.GaugeValue, float64(stats.Queued), lane)
+		ch <- prometheus.MustNewConstMetric(collector.active, prometheus.GaugeValue, float64(stats.Active), lane)
+		ch <- prometheus.MustNewConstMetric(collector.completed, prometheus.CounterValue, float64(stats.Completed), lane)
+		ch <- prometheus.MustNewConstMetric(collector.wait, prometheus.CounterValue, stats.WaitTotal.Seconds(), lane)
+	}
+}
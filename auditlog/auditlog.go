@@ -0,0 +1,212 @@
+package auditlog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// genesisHash is the PrevHash of the first entry in a log.
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Entry is one audited decision. Hash covers every other field, including PrevHash,
+// so altering or removing an entry breaks the chain from that point on.
+type Entry struct {
+	Seq       int64           `json:"seq"`
+	Time      time.Time       `json:"time"`
+	RequestID string          `json:"request_id"`
+	ChatID    string          `json:"chat_id"`
+	Backend   string          `json:"backend"`
+	Decision  string          `json:"decision"`
+	Rating    json.RawMessage `json:"rating,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// Log is an append-only, hash-chained audit log stored as one JSON entry per line.
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	seq      int64
+	lastHash string
+}
+
+// Open opens (or creates) the audit log at path, verifying the existing chain
+// so new entries are never appended to a tampered log.
+func Open(path string) (*Log, error) {
+	log := &Log{lastHash: genesisHash}
+
+	existing, err := os.Open(path)
+	switch {
+	case err == nil:
+		last, verifyErr := verify(existing)
+		existing.Close()
+		if verifyErr != nil {
+			return nil, fmt.Errorf("refusing to append to audit log %s: %w", path, verifyErr)
+		}
+		if last != nil {
+			log.seq = last.Seq
+			log.lastHash = last.Hash
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+
+	log.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	return log, nil
+}
+
+// Append chains the entry onto the log and syncs it to disk.
+// Seq, PrevHash and Hash are assigned by the log; a zero Time is set to now.
+func (log *Log) Append(entry Entry) (Entry, error) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	entry.Seq = log.seq + 1
+	entry.PrevHash = log.lastHash
+
+	hash, err := entryHash(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := log.file.Write(append(line, '\n')); err != nil {
+		return Entry{}, fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := log.file.Sync(); err != nil {
+		return Entry{}, fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	log.seq = entry.Seq
+	log.lastHash = entry.Hash
+	return entry, nil
+}
+
+// Close closes the underlying file.
+func (log *Log) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return log.file.Close()
+}
+
+// Verify checks the hash chain of a log and returns the number of entries.
+func Verify(r io.Reader) (int64, error) {
+	last, err := verify(r)
+	if err != nil || last == nil {
+		return 0, err
+	}
+	return last.Seq, nil
+}
+
+func verify(r io.Reader) (*Entry, error) {
+	var last *Entry
+	prevHash := genesisHash
+
+	err := readEntries(r, func(line int, entry Entry) error {
+		if entry.Seq != int64(line) {
+			return fmt.Errorf("line %d: expected seq %d, got %d", line, line, entry.Seq)
+		}
+		if entry.PrevHash != prevHash {
+			return fmt.Errorf("line %d: chain broken, prev_hash does not match previous entry", line)
+		}
+		hash, err := entryHash(entry)
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("line %d: hash mismatch, entry was modified", line)
+		}
+
+		prevHash = entry.Hash
+		last = &entry
+		return nil
+	})
+	return last, err
+}
+
+// Export writes the entries of a log as "json" (one object per line) or "csv".
+func Export(r io.Reader, w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		return readEntries(r, func(line int, entry Entry) error {
+			return enc.Encode(entry)
+		})
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"seq", "time", "request_id", "chat_id", "backend", "decision", "rating", "error", "hash"}); err != nil {
+			return err
+		}
+		err := readEntries(r, func(line int, entry Entry) error {
+			return cw.Write([]string{
+				strconv.FormatInt(entry.Seq, 10),
+				entry.Time.Format(time.RFC3339Nano),
+				entry.RequestID,
+				entry.ChatID,
+				entry.Backend,
+				entry.Decision,
+				string(entry.Rating),
+				entry.Error,
+				entry.Hash,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+func readEntries(r io.Reader, fn func(line int, entry Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(line, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func entryHash(entry Entry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,139 @@
+package auditlog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog appends an entry for each decision to a new log and returns its path.
+func writeLog(t *testing.T, decisions ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, decision := range decisions {
+		entry, err := log.Append(Entry{RequestID: "req", ChatID: "chat", Decision: decision, Rating: json.RawMessage(`{"score":1}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Seq != int64(i+1) || entry.Hash == "" || entry.Time.IsZero() {
+			t.Fatalf("appended %+v", entry)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func verifyFile(t *testing.T, path string) (int64, error) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	return Verify(file)
+}
+
+func TestAppendAndVerify(t *testing.T) {
+	path := writeLog(t, "approve", "deny", "approve")
+	n, err := verifyFile(t, path)
+	if err != nil || n != 3 {
+		t.Errorf("verified %d entries, %v; want 3", n, err)
+	}
+}
+
+func TestReopenContinuesChain(t *testing.T) {
+	path := writeLog(t, "approve", "deny")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := log.Append(Entry{Decision: "approve"})
+	log.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Seq != 3 {
+		t.Errorf("seq %d after reopening, want 3", entry.Seq)
+	}
+	if n, err := verifyFile(t, path); err != nil || n != 3 {
+		t.Errorf("verified %d entries, %v; want 3", n, err)
+	}
+}
+
+func TestTamperingDetected(t *testing.T) {
+	tests := map[string]func(lines []string) []string{
+		"modified entry": func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"deny"`, `"approve"`, 1)
+			return lines
+		},
+		"removed entry": func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		},
+		"reordered entries": func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		},
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeLog(t, "approve", "deny", "approve")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tamper(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := verifyFile(t, path); err == nil {
+				t.Error("verified a tampered log")
+			}
+			if _, err := Open(path); err == nil {
+				t.Error("opened a tampered log for appending")
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	path := writeLog(t, "approve", "deny")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Export(bytes.NewReader(data), &out, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "seq" || records[2][5] != "deny" {
+		t.Errorf("exported %q", records)
+	}
+
+	out.Reset()
+	if err := Export(bytes.NewReader(data), &out, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 2 {
+		t.Errorf("exported %d JSON lines, want 2", lines)
+	}
+
+	if err := Export(bytes.NewReader(data), &out, "xml"); err == nil {
+		t.Error("exported an unknown format")
+	}
+}
@@ -0,0 +1,300 @@
+// Package certgen creates a development PKI: a self-signed CA and the server and client
+// certificates it signs, with DNS, IP and SPIFFE ID SANs. Keys are ECDSA P-256 and are
+// written unencrypted, so the output is meant for tests and local setups only.
+package certgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultValidity is how long certificates are valid when no validity is given.
+	DefaultValidity = 365 * 24 * time.Hour
+	// clockSkew backdates certificates so machines with slightly slow clocks accept them.
+	clockSkew = 5 * time.Minute
+)
+
+// File names Write uses, matching the defaults of deepmgr's -cert, -key and -ca flags.
+const (
+	CACertFile     = "ca-cert.pem"
+	CAKeyFile      = "ca-key.pem"
+	ServerCertFile = "server-cert.pem"
+	ServerKeyFile  = "server-key.pem"
+	ClientCertFile = "client-cert.pem"
+	ClientKeyFile  = "client-key.pem"
+)
+
+// KeyPair is a certificate and its private key.
+type KeyPair struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// CertPEM returns the certificate PEM encoded.
+func (pair *KeyPair) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Cert.Raw})
+}
+
+// KeyPEM returns the private key as PEM encoded PKCS #8.
+func (pair *KeyPair) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(pair.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TLSCertificate returns the key pair for a tls.Config.
+func (pair *KeyPair) TLSCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{pair.Cert.Raw}, PrivateKey: pair.Key, Leaf: pair.Cert}
+}
+
+// WriteFiles writes the certificate and key as PEM files. The key file is readable by
+// its owner only.
+func (pair *KeyPair) WriteFiles(certFile, keyFile string) error {
+	keyPEM, err := pair.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pair.CertPEM(), 0o644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	return nil
+}
+
+// CA is a certificate authority that issues leaf certificates.
+type CA struct {
+	KeyPair
+}
+
+// NewCA creates a self-signed CA. A zero validity uses DefaultValidity.
+func NewCA(commonName string, validity time.Duration) (*CA, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	cert, err := sign(template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{KeyPair{Cert: cert, Key: key}}, nil
+}
+
+// LoadCA loads a CA from PEM files, e.g. to issue more certificates from one written earlier.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	tlsCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	key, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA private key cannot sign")
+	}
+	return &CA{KeyPair{Cert: cert, Key: key}}, nil
+}
+
+// Pool returns a certificate pool trusting the CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// Leaf describes a leaf certificate to issue.
+type Leaf struct {
+	CommonName string
+	// Hosts are DNS names and IP addresses added as SANs.
+	Hosts []string
+	// SPIFFEID, e.g. spiffe://example.org/service, is added as a URI SAN.
+	SPIFFEID string
+	// Validity is how long the certificate is valid; zero uses DefaultValidity.
+	Validity time.Duration
+}
+
+// IssueServer issues a certificate for TLS servers.
+func (ca *CA) IssueServer(leaf Leaf) (*KeyPair, error) {
+	return ca.issue(leaf, x509.ExtKeyUsageServerAuth)
+}
+
+// IssueClient issues a certificate for TLS client authentication.
+func (ca *CA) IssueClient(leaf Leaf) (*KeyPair, error) {
+	return ca.issue(leaf, x509.ExtKeyUsageClientAuth)
+}
+
+func (ca *CA) issue(leaf Leaf, usage x509.ExtKeyUsage) (*KeyPair, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(leaf.CommonName, leaf.Validity)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+
+	for _, host := range leaf.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if leaf.SPIFFEID != "" {
+		id, err := url.Parse(leaf.SPIFFEID)
+		if err != nil || id.Scheme != "spiffe" || id.Host == "" {
+			return nil, fmt.Errorf("invalid SPIFFE ID %q", leaf.SPIFFEID)
+		}
+		template.URIs = []*url.URL{id}
+	}
+
+	// Don't let a leaf outlive its CA
+	if template.NotAfter.After(ca.Cert.NotAfter) {
+		template.NotAfter = ca.Cert.NotAfter
+	}
+
+	cert, err := sign(template, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+// PKI is a CA with a server and a client certificate.
+type PKI struct {
+	CA     *CA
+	Server *KeyPair
+	Client *KeyPair
+}
+
+// New issues a server and a client certificate from ca, or from a new CA when ca is nil.
+func New(ca *CA, server, client Leaf) (*PKI, error) {
+	if ca == nil {
+		var err error
+		if ca, err = NewCA("net_prg development CA", max(server.Validity, client.Validity)); err != nil {
+			return nil, err
+		}
+	}
+	serverPair, err := ca.IssueServer(server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue server certificate: %w", err)
+	}
+	clientPair, err := ca.IssueClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue client certificate: %w", err)
+	}
+	return &PKI{CA: ca, Server: serverPair, Client: clientPair}, nil
+}
+
+// Write writes every certificate and key into dir under the file name constants.
+func (pki *PKI) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	files := []struct {
+		pair              *KeyPair
+		certFile, keyFile string
+	}{
+		{&pki.CA.KeyPair, CACertFile, CAKeyFile},
+		{pki.Server, ServerCertFile, ServerKeyFile},
+		{pki.Client, ClientCertFile, ClientKeyFile},
+	}
+	for _, f := range files {
+		if err := f.pair.WriteFiles(filepath.Join(dir, f.certFile), filepath.Join(dir, f.keyFile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServerTLSConfig returns a config for servers presenting the server certificate. With
+// mutual set, clients must present a certificate signed by the CA.
+func (pki *PKI) ServerTLSConfig(mutual bool) *tls.Config {
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{pki.Server.TLSCertificate()}, MinVersion: tls.VersionTLS12}
+	if mutual {
+		tlsConfig.ClientCAs = pki.CA.Pool()
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig
+}
+
+// ClientTLSConfig returns a config for clients trusting the CA and presenting the client
+// certificate.
+func (pki *PKI) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{pki.Client.TLSCertificate()},
+		RootCAs:      pki.CA.Pool(),
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func newKey() (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"net_prg development"}},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func sign(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,206 @@
+// Package chaos makes servers fail on purpose at configured rates: delayed accepts,
+// dropped worker tasks, connections reset mid-stream and failed backend calls. It is
+// for checking retries, circuit breakers and drains under controlled failure and is
+// off unless a binary's chaos settings are given.
+//
+// Each kind of fault draws from its own random stream seeded from Config.Seed, so with
+// the same seed the Nth decision of a kind comes out the same in every run, however the
+// other kinds of fault fall. A nil *Monkey injects nothing.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is the error returned for injected failures.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config sets the fault rates. Percentages are between 0 and 100.
+type Config struct {
+	// Seed seeds the random streams; zero picks a random seed.
+	Seed int64
+	// AcceptDelay delays every accept by a random duration of up to this much.
+	AcceptDelay time.Duration
+	// DropTasks is the percentage of worker tasks dropped instead of run.
+	DropTasks float64
+	// Reconnects is the percentage of connection reads that reset the connection.
+	Reconnects float64
+	// BackendErrors is the percentage of backend calls that fail without being made.
+	BackendErrors float64
+}
+
+// Enabled reports whether any fault is configured.
+func (cfg Config) Enabled() bool {
+	return cfg.AcceptDelay > 0 || cfg.DropTasks > 0 || cfg.Reconnects > 0 || cfg.BackendErrors > 0
+}
+
+// Validate checks that the rates are in range.
+func (cfg Config) Validate() error {
+	if cfg.AcceptDelay < 0 {
+		return fmt.Errorf("chaos accept delay must not be negative, got %v", cfg.AcceptDelay)
+	}
+	for name, percent := range map[string]float64{"drop tasks": cfg.DropTasks, "reconnects": cfg.Reconnects, "backend errors": cfg.BackendErrors} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("chaos %s must be a percentage between 0 and 100, got %v", name, percent)
+		}
+	}
+	return nil
+}
+
+// fault is a kind of injected failure, each with its own random stream.
+type fault int
+
+const (
+	acceptFault fault = iota
+	taskFault
+	reconnectFault
+	backendFault
+	numFaults
+)
+
+// Monkey decides which operations fail.
+type Monkey struct {
+	cfg Config
+
+	mu      sync.Mutex
+	streams [numFaults]*rand.Rand
+
+	delayed       atomic.Int64
+	droppedTasks  atomic.Int64
+	reconnects    atomic.Int64
+	backendErrors atomic.Int64
+}
+
+// New creates a monkey for cfg.
+func New(cfg Config) *Monkey {
+	if cfg.Seed == 0 {
+		cfg.Seed = rand.Int64()
+	}
+	monkey := &Monkey{cfg: cfg}
+	for f := range numFaults {
+		monkey.streams[f] = rand.New(rand.NewPCG(uint64(cfg.Seed), uint64(f)))
+	}
+	return monkey
+}
+
+// Seed returns the seed in use, to repeat a run with the same faults.
+func (monkey *Monkey) Seed() int64 {
+	if monkey == nil {
+		return 0
+	}
+	return monkey.cfg.Seed
+}
+
+func (monkey *Monkey) String() string {
+	if monkey == nil {
+		return "chaos off"
+	}
+	return fmt.Sprintf("seed %d, accept delay up to %v, %v%% tasks dropped, %v%% reconnects, %v%% backend errors",
+		monkey.cfg.Seed, monkey.cfg.AcceptDelay, monkey.cfg.DropTasks, monkey.cfg.Reconnects, monkey.cfg.BackendErrors)
+}
+
+// Stats summarizes the faults injected so far.
+func (monkey *Monkey) Stats() string {
+	if monkey == nil {
+		return "chaos off"
+	}
+	return fmt.Sprintf("%d accepts delayed, %d tasks dropped, %d reconnects, %d backend errors",
+		monkey.delayed.Load(), monkey.droppedTasks.Load(), monkey.reconnects.Load(), monkey.backendErrors.Load())
+}
+
+// chance reports whether the next event of kind f happens, given its percentage.
+func (monkey *Monkey) chance(f fault, percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	monkey.mu.Lock()
+	defer monkey.mu.Unlock()
+	return monkey.streams[f].Float64()*100 < percent
+}
+
+// DelayAccept sleeps for a random part of the accept delay.
+func (monkey *Monkey) DelayAccept() {
+	if monkey == nil || monkey.cfg.AcceptDelay <= 0 {
+		return
+	}
+	monkey.mu.Lock()
+	delay := time.Duration(monkey.streams[acceptFault].Int64N(int64(monkey.cfg.AcceptDelay) + 1))
+	monkey.mu.Unlock()
+
+	monkey.delayed.Add(1)
+	time.Sleep(delay)
+}
+
+// DropTask reports whether the next worker task should be dropped.
+func (monkey *Monkey) DropTask() bool {
+	if monkey == nil || !monkey.chance(taskFault, monkey.cfg.DropTasks) {
+		return false
+	}
+	monkey.droppedTasks.Add(1)
+	return true
+}
+
+// Reconnect reports whether the connection should be reset now.
+func (monkey *Monkey) Reconnect() bool {
+	if monkey == nil || !monkey.chance(reconnectFault, monkey.cfg.Reconnects) {
+		return false
+	}
+	monkey.reconnects.Add(1)
+	return true
+}
+
+// BackendError returns ErrInjected if the next backend call should fail.
+func (monkey *Monkey) BackendError() error {
+	if monkey == nil || !monkey.chance(backendFault, monkey.cfg.BackendErrors) {
+		return nil
+	}
+	monkey.backendErrors.Add(1)
+	return ErrInjected
+}
+
+// Listener wraps listener so accepts are delayed and accepted connections are reset
+// at the reconnect rate.
+func (monkey *Monkey) Listener(listener net.Listener) net.Listener {
+	if monkey == nil {
+		return listener
+	}
+	return &chaosListener{Listener: listener, monkey: monkey}
+}
+
+type chaosListener struct {
+	net.Listener
+	monkey *Monkey
+}
+
+func (listener *chaosListener) Accept() (net.Conn, error) {
+	listener.monkey.DelayAccept()
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &chaosConn{Conn: conn, monkey: listener.monkey}, nil
+}
+
+// chaosConn resets itself on a read at the reconnect rate.
+type chaosConn struct {
+	net.Conn
+	monkey *Monkey
+}
+
+func (conn *chaosConn) Read(p []byte) (int, error) {
+	if conn.monkey.Reconnect() {
+		// Reset rather than close, as a crashed or restarted server would
+		if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		conn.Conn.Close()
+		return 0, ErrInjected
+	}
+	return conn.Conn.Read(p)
+}
@@ -0,0 +1,75 @@
+package chaos
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []Config{{}, {DropTasks: 100, Reconnects: 0.5, BackendErrors: 50, AcceptDelay: time.Second}}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%+v: %v", cfg, err)
+		}
+	}
+	invalid := []Config{{DropTasks: -1}, {Reconnects: 101}, {BackendErrors: 200}, {AcceptDelay: -time.Second}}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v: accepted", cfg)
+		}
+	}
+}
+
+// decisions returns the first n task drop decisions of a monkey, after drawing other
+// kinds of fault between them when interleave is set.
+func decisions(monkey *Monkey, n int, interleave bool) []bool {
+	var drops []bool
+	for i := range n {
+		if interleave {
+			for range i % 3 {
+				monkey.Reconnect()
+				monkey.BackendError()
+			}
+		}
+		drops = append(drops, monkey.DropTask())
+	}
+	return drops
+}
+
+func TestSeedRepeatsFaults(t *testing.T) {
+	cfg := Config{Seed: 42, DropTasks: 50, Reconnects: 50, BackendErrors: 50}
+	first := decisions(New(cfg), 100, false)
+	if again := decisions(New(cfg), 100, true); !slices.Equal(first, again) {
+		t.Error("the same seed dropped different tasks when other faults were drawn in between")
+	}
+	cfg.Seed = 43
+	if other := decisions(New(cfg), 100, false); slices.Equal(first, other) {
+		t.Error("different seeds dropped the same tasks")
+	}
+}
+
+func TestRates(t *testing.T) {
+	never := New(Config{Seed: 1})
+	always := New(Config{Seed: 1, DropTasks: 100, Reconnects: 100, BackendErrors: 100})
+	for range 100 {
+		if never.DropTask() || never.Reconnect() || never.BackendError() != nil {
+			t.Fatal("injected a fault at rate 0")
+		}
+		if !always.DropTask() || !always.Reconnect() || !errors.Is(always.BackendError(), ErrInjected) {
+			t.Fatal("skipped a fault at rate 100")
+		}
+	}
+}
+
+func TestNilMonkey(t *testing.T) {
+	var monkey *Monkey
+	if monkey.DropTask() || monkey.Reconnect() || monkey.BackendError() != nil {
+		t.Error("a nil monkey injected a fault")
+	}
+	monkey.DelayAccept()
+	if monkey.Seed() != 0 || monkey.String() != "chaos off" {
+		t.Errorf("nil monkey: seed %d, %q", monkey.Seed(), monkey)
+	}
+}
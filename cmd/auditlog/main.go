@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blueai2022/net_prg/auditlog"
+)
+
+func main() {
+	format := flag.String("format", "json", "export format: json or csv")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: auditlog [-format json|csv] verify|export <file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flag.Arg(1))
+	if err != nil {
+		log.Fatal("cannot open audit log ", err)
+	}
+	defer file.Close()
+
+	switch flag.Arg(0) {
+	case "verify":
+		n, err := auditlog.Verify(file)
+		if err != nil {
+			log.Fatal("audit log verification failed: ", err)
+		}
+		log.Printf("audit log OK: %d entries\n", n)
+	case "export":
+		if err := auditlog.Export(file, os.Stdout, *format); err != nil {
+			log.Fatal("cannot export audit log: ", err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blueai2022/net_prg/certgen"
+	"github.com/blueai2022/net_prg/config"
+)
+
+// certgenConfig holds the certgen settings.
+type certgenConfig struct {
+	Dir            string        `config:"dir" usage:"directory the certificates and keys are written to"`
+	NewCA          bool          `config:"new-ca" usage:"create a new CA even if the directory already holds one"`
+	ServerName     string        `config:"server-name" usage:"common name of the server certificate"`
+	Hosts          []string      `config:"hosts" usage:"comma-separated DNS names and IPs the server certificate is valid for"`
+	ServerSPIFFEID string        `config:"server-spiffe-id" usage:"SPIFFE ID added to the server certificate, e.g. spiffe://example.org/server"`
+	ClientName     string        `config:"client-name" usage:"common name of the client certificate"`
+	ClientSPIFFEID string        `config:"client-spiffe-id" usage:"SPIFFE ID added to the client certificate"`
+	Validity       time.Duration `config:"validity" usage:"how long the certificates are valid"`
+}
+
+func (cfg *certgenConfig) Validate() error {
+	if cfg.Dir == "" {
+		return errors.New("dir is required")
+	}
+	if len(cfg.Hosts) == 0 {
+		return errors.New("at least one host is required")
+	}
+	if cfg.Validity <= 0 {
+		return fmt.Errorf("validity must be positive, got %v", cfg.Validity)
+	}
+	return nil
+}
+
+func main() {
+	cfg := certgenConfig{
+		Dir:        ".",
+		ServerName: "localhost",
+		Hosts:      []string{"localhost", "127.0.0.1", "::1"},
+		ClientName: "client",
+		Validity:   certgen.DefaultValidity,
+	}
+	if _, err := config.Load("certgen", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	// Keep the existing CA so certificates issued earlier stay trusted
+	var ca *certgen.CA
+	caCert := filepath.Join(cfg.Dir, certgen.CACertFile)
+	if _, err := os.Stat(caCert); err == nil && !cfg.NewCA {
+		if ca, err = certgen.LoadCA(caCert, filepath.Join(cfg.Dir, certgen.CAKeyFile)); err != nil {
+			log.Fatal("cannot load existing CA: ", err)
+		}
+		log.Println("Issuing from existing CA", caCert)
+	}
+
+	pki, err := certgen.New(ca,
+		certgen.Leaf{CommonName: cfg.ServerName, Hosts: cfg.Hosts, SPIFFEID: cfg.ServerSPIFFEID, Validity: cfg.Validity},
+		certgen.Leaf{CommonName: cfg.ClientName, SPIFFEID: cfg.ClientSPIFFEID, Validity: cfg.Validity},
+	)
+	if err != nil {
+		log.Fatal("cannot create certificates: ", err)
+	}
+	if err := pki.Write(cfg.Dir); err != nil {
+		log.Fatal("cannot write certificates: ", err)
+	}
+
+	fmt.Printf("CA:     %s, %s\n", certgen.CACertFile, certgen.CAKeyFile)
+	fmt.Printf("Server: %s, %s for %v, valid until %s\n",
+		certgen.ServerCertFile, certgen.ServerKeyFile, cfg.Hosts, pki.Server.Cert.NotAfter.Format(time.DateOnly))
+	fmt.Printf("Client: %s, %s\n", certgen.ClientCertFile, certgen.ClientKeyFile)
+}
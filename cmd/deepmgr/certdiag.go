@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+)
+
+// clientCertDaysLeft is published on /debug/vars for alerting on expiring client certs.
+var clientCertDaysLeft = expvar.NewFloat("deepmgr_client_cert_days_until_expiry")
+
+// checkClientCert verifies the client certificate chain against roots and returns
+// the time left until the leaf expires.
+func checkClientCert(cert *tls.Certificate, roots *x509.CertPool) (time.Duration, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return 0, fmt.Errorf("no client certificate loaded")
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return 0, fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, der := range cert.Certificate[1:] {
+		ca, err := x509.ParseCertificate(der)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse intermediate certificate: %w", err)
+		}
+		intermediates.AddCert(ca)
+	}
+
+	left := time.Until(leaf.NotAfter)
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return left, fmt.Errorf("client certificate %q does not chain to the CA pool: %w", leaf.Subject.CommonName, err)
+	}
+	return left, nil
+}
+
+// diagnoseClientCert logs the state of the current client certificate and updates the expiry metric.
+func diagnoseClientCert(certs *certReloader, roots *x509.CertPool, warnBelow time.Duration) {
+	cert, _ := certs.GetClientCertificate(nil)
+	left, err := checkClientCert(cert, roots)
+	if err != nil {
+		log.Printf("Client certificate check failed: %v", err)
+	}
+	if cert == nil {
+		return
+	}
+
+	days := left.Hours() / 24
+	clientCertDaysLeft.Set(days)
+
+	switch {
+	case left <= 0:
+		log.Printf("WARNING: client certificate expired %.1f days ago", -days)
+	case left < warnBelow:
+		log.Printf("WARNING: client certificate expires in %.1f days", days)
+	default:
+		log.Printf("Client certificate valid for %.1f more days", days)
+	}
+}
+
+// monitorClientCert checks the client certificate now and every interval until ctx is done.
+func monitorClientCert(ctx context.Context, certs *certReloader, roots *x509.CertPool, interval, warnBelow time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		diagnoseClientCert(certs, roots, warnBelow)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
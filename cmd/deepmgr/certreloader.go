@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader serves the client certificate to TLS handshakes and reloads it from disk
+// when the cert or key file changes or on SIGHUP, so rotated certs are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// newCertReloader loads the initial key pair.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload loads the key pair from disk. On failure the previous certificate stays in use.
+func (reloader *certReloader) reload() error {
+	modTimes, err := reloader.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	reloader.mu.Lock()
+	reloader.cert = &cert
+	reloader.modTimes = modTimes
+	reloader.mu.Unlock()
+	return nil
+}
+
+func (reloader *certReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// changed reports whether either file was modified since the last reload.
+func (reloader *certReloader) changed() bool {
+	modTimes, err := reloader.stat()
+	if err != nil {
+		// Files may be mid-rotation; try again on the next tick
+		return false
+	}
+
+	reloader.mu.RLock()
+	defer reloader.mu.RUnlock()
+	return modTimes != reloader.modTimes
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (reloader *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	reloader.mu.RLock()
+	defer reloader.mu.RUnlock()
+	return reloader.cert, nil
+}
+
+// watch polls the cert files every interval and reloads on change or SIGHUP until ctx is done.
+func (reloader *certReloader) watch(ctx context.Context, interval time.Duration) {
+	chHup := make(chan os.Signal, 1)
+	signal.Notify(chHup, syscall.SIGHUP)
+	defer signal.Stop(chHup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-chHup:
+			log.Println("SIGHUP received, reloading client certificate")
+		case <-ticker.C:
+			if !reloader.changed() {
+				continue
+			}
+			log.Println("Client certificate changed on disk, reloading")
+		}
+
+		if err := reloader.reload(); err != nil {
+			log.Printf("Failed to reload client certificate: %v", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	channelzTarget       = "passthrough:///channelz"
+	channelzQueryTimeout = 5 * time.Second
+	// maxTraceEvents caps the recent events printed per channel.
+	maxTraceEvents = 10
+)
+
+// startDebugServer serves /debug/channelz and /debug/vars on addr until ctx is done.
+// Channelz is queried through an in-process channelz service, so nothing extra is
+// exposed on the network besides the debug listener.
+func startDebugServer(ctx context.Context, addr string) error {
+	// Registering the service also turns channelz data collection on
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	channelzsvc.RegisterChannelzServiceToServer(server)
+	go server.Serve(lis)
+
+	conn, err := grpc.NewClient(channelzTarget,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		server.Stop()
+		return fmt.Errorf("failed to connect to channelz service: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		conn.Close()
+		server.Stop()
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	// expvar has already registered /debug/vars on the default mux
+	http.Handle("/debug/channelz", channelzHandler{client: channelzpb.NewChannelzClient(conn)})
+	httpServer := &http.Server{Handler: http.DefaultServeMux}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Debug server failed: %v", err)
+		}
+	}()
+
+	context.AfterFunc(ctx, func() {
+		httpServer.Close()
+		conn.Close()
+		server.Stop()
+	})
+	log.Printf("Debug endpoints on http://%s/debug/channelz and /debug/vars", listener.Addr())
+	return nil
+}
+
+// channelzHandler dumps channel and subchannel state, call counts and recent trace
+// events. Add ?format=json for the raw channelz messages.
+type channelzHandler struct {
+	client channelzpb.ChannelzClient
+}
+
+func (handler channelzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), channelzQueryTimeout)
+	defer cancel()
+
+	channels, err := handler.topChannels(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		for _, channel := range channels {
+			data, _ := protojson.Marshal(channel)
+			w.Write(append(data, '\n'))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, channel := range channels {
+		if channel.GetData().GetTarget() == channelzTarget {
+			continue
+		}
+		writeChannelData(w, "", fmt.Sprintf("channel %d", channel.GetRef().GetChannelId()), channel.GetData())
+
+		for _, ref := range channel.GetSubchannelRef() {
+			resp, err := handler.client.GetSubchannel(ctx, &channelzpb.GetSubchannelRequest{SubchannelId: ref.GetSubchannelId()})
+			if err != nil {
+				fmt.Fprintf(w, "  subchannel %d: %v\n", ref.GetSubchannelId(), err)
+				continue
+			}
+			subchannel := resp.GetSubchannel()
+			writeChannelData(w, "  ", fmt.Sprintf("subchannel %d", ref.GetSubchannelId()), subchannel.GetData())
+			fmt.Fprintf(w, "    sockets: %d\n", len(subchannel.GetSocketRef()))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// topChannels pages through every top-level channel.
+func (handler channelzHandler) topChannels(ctx context.Context) ([]*channelzpb.Channel, error) {
+	var channels []*channelzpb.Channel
+	var start int64
+	for {
+		resp, err := handler.client.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{StartChannelId: start})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query channelz: %w", err)
+		}
+		channels = append(channels, resp.GetChannel()...)
+		if resp.GetEnd() || len(resp.GetChannel()) == 0 {
+			return channels, nil
+		}
+		start = resp.GetChannel()[len(resp.GetChannel())-1].GetRef().GetChannelId() + 1
+	}
+}
+
+func writeChannelData(w io.Writer, indent, name string, data *channelzpb.ChannelData) {
+	fmt.Fprintf(w, "%s%s %s: %s\n", indent, name, data.GetTarget(), data.GetState().GetState())
+	fmt.Fprintf(w, "%s  calls: started=%d succeeded=%d failed=%d", indent,
+		data.GetCallsStarted(), data.GetCallsSucceeded(), data.GetCallsFailed())
+	if last := data.GetLastCallStartedTimestamp(); last != nil {
+		fmt.Fprintf(w, " last=%s", last.AsTime().Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
+
+	events := data.GetTrace().GetEvents()
+	if len(events) > maxTraceEvents {
+		events = events[len(events)-maxTraceEvents:]
+	}
+	for _, event := range events {
+		fmt.Fprintf(w, "%s  %s %s %s\n", indent,
+			event.GetTimestamp().AsTime().Format(time.RFC3339), event.GetSeverity(), event.GetDescription())
+	}
+}
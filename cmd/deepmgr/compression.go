@@ -0,0 +1,67 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"expvar"
+	"fmt"
+
+	"google.golang.org/grpc"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/stats"
+)
+
+// Payload byte counters on /debug/vars, to judge how much compression saves.
+var (
+	payloadRawBytes        = expvar.NewMap("deepmgr_payload_raw_bytes")
+	payloadCompressedBytes = expvar.NewMap("deepmgr_payload_compressed_bytes")
+)
+
+// compressionOptions returns the dial options enabling compressor for every call.
+// An empty name leaves calls uncompressed unless they opt in with compressCall.
+func compressionOptions(compressor string, level int) ([]grpc.DialOption, error) {
+	if compressor == "" {
+		return nil, nil
+	}
+	if compressor != grpcgzip.Name {
+		return nil, fmt.Errorf("unsupported compressor %q", compressor)
+	}
+	if err := grpcgzip.SetLevel(level); err != nil {
+		return nil, fmt.Errorf("invalid gzip level %d: %w", level, err)
+	}
+	return []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.UseCompressor(compressor))}, nil
+}
+
+// compressCall is the per-call option for compressing a single large request.
+func compressCall() grpc.CallOption {
+	return grpc.UseCompressor(grpcgzip.Name)
+}
+
+// defaultCompressionLevel matches gzip's own default.
+const defaultCompressionLevel = gzip.DefaultCompression
+
+// compressionStats is a stats handler recording raw and on-the-wire payload sizes.
+type compressionStats struct{}
+
+var _ stats.Handler = compressionStats{}
+
+func (compressionStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (compressionStats) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	switch rs := rs.(type) {
+	case *stats.OutPayload:
+		payloadRawBytes.Add("out", int64(rs.Length))
+		payloadCompressedBytes.Add("out", int64(rs.CompressedLength))
+	case *stats.InPayload:
+		payloadRawBytes.Add("in", int64(rs.Length))
+		payloadCompressedBytes.Add("in", int64(rs.CompressedLength))
+	}
+}
+
+func (compressionStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (compressionStats) HandleConn(context.Context, stats.ConnStats) {}
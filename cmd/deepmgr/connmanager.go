@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// stateFunc is called when a pooled connection changes connectivity state.
+type stateFunc func(index int, from, to connectivity.State)
+
+// waitForReady blocks until conn is READY, kicking it out of IDLE if needed.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.Shutdown:
+			return fmt.Errorf("connection to %s is shut down", conn.Target())
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection to %s not ready (state %v): %w", conn.Target(), state, ctx.Err())
+		}
+	}
+}
+
+// WaitForReady blocks until at least one pooled connection is READY or ctx is done.
+func (pool *connPool) WaitForReady(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pool.mu.RLock()
+	conns := append([]*pooledConn(nil), pool.conns...)
+	pool.mu.RUnlock()
+
+	results := make(chan error, len(conns))
+	for _, pc := range conns {
+		go func() {
+			results <- waitForReady(ctx, pc.conn)
+		}()
+	}
+
+	var lastErr error
+	for range conns {
+		err := <-results
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// OnStateChange registers the callback for connectivity state changes of pooled connections.
+func (pool *connPool) OnStateChange(fn stateFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.onStateChange = fn
+}
+
+// watchState reports every state change of a pooled connection until it shuts down.
+func (pool *connPool) watchState(index int, conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for state != connectivity.Shutdown {
+		conn.WaitForStateChange(context.Background(), state)
+		next := conn.GetState()
+
+		pool.mu.RLock()
+		fn := pool.onStateChange
+		pool.mu.RUnlock()
+		if fn != nil {
+			fn(index, state, next)
+		}
+		state = next
+	}
+}
+
+// logStateChange is a stateFunc that logs connectivity transitions.
+func logStateChange(index int, from, to connectivity.State) {
+	log.Printf("Connection %d: %v -> %v", index, from, to)
+}
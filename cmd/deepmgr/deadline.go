@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// withDefaultDeadline applies timeout to ctx unless the caller already set a deadline.
+func withDefaultDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// callWithDeadline runs a call with the default deadline applied and its context
+// errors mapped to gRPC status errors, for call sites that don't go through the interceptor.
+func callWithDeadline(ctx context.Context, timeout time.Duration, method string, call func(context.Context) error) error {
+	ctx, cancel := withDefaultDeadline(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	return callError(ctx, method, start, call(ctx))
+}
+
+// callError turns context cancellation and expiry into well-formed status errors,
+// logging deadline-exceeded calls with the budget they were given.
+func callError(ctx context.Context, method string, start time.Time, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	elapsed := time.Since(start)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded:
+		budget := "no deadline"
+		if deadline, ok := ctx.Deadline(); ok {
+			budget = deadline.Sub(start).Round(time.Millisecond).String()
+		}
+		log.Printf("Call %s exceeded its deadline after %v (budget %s)", method, elapsed.Round(time.Millisecond), budget)
+		return status.Errorf(codes.DeadlineExceeded, "%s: deadline exceeded after %v (budget %s)", method, elapsed.Round(time.Millisecond), budget)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return status.Errorf(codes.Canceled, "%s: cancelled by caller after %v", method, elapsed.Round(time.Millisecond))
+	default:
+		return err
+	}
+}
+
+// deadlineUnaryInterceptor enforces the default per-RPC deadline on unary calls.
+func deadlineUnaryInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := withDefaultDeadline(ctx, timeout)
+		defer cancel()
+
+		start := time.Now()
+		return callError(ctx, method, start, invoker(ctx, method, req, reply, cc, opts...))
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"github.com/blueai2022/net_prg/lifecycle"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	certReloadInterval = time.Minute
+	poolCheckInterval  = 5 * time.Second
+	poolEvictGrace     = 30 * time.Second
+)
+
+// clientConn is a gRPC channel that can be closed: the connection pool or the gRPC-Web fallback.
+type clientConn interface {
+	grpc.ClientConnInterface
+	io.Closer
+}
+
+// clientConfig holds the deepmgr client settings taken from the command line.
+type clientConfig struct {
+	targets  []string
+	certFile string
+	keyFile  string
+	caFile   string
+	spiffe   spiffeConfig
+	tls      tlsOptions
+
+	certCheckInterval time.Duration
+	certExpiryWarning time.Duration
+
+	poolSize int
+	poolPick pickPolicy
+
+	failThreshold int
+	recoverProbes int
+
+	retry retryPolicy
+	hedge hedgePolicy
+
+	rpcTimeout time.Duration
+
+	keepalive   keepalive.ClientParameters
+	idleTimeout time.Duration
+
+	connect      grpc.ConnectParams
+	connectWait  time.Duration
+	waitForReady bool
+
+	healthService  string
+	healthInterval time.Duration
+	healthWait     time.Duration
+
+	otlpEndpoint string
+	debugAddr    string
+	grpcWebURL   string
+	drainTimeout time.Duration
+
+	compression []grpc.DialOption
+
+	maxRecvMsgSize int
+	maxSendMsgSize int
+
+	auth authConfig
+
+	// proxyDialer tunnels connections through an egress proxy; nil dials directly.
+	proxyDialer contextDialer
+
+	// udsTLS keeps mTLS on unix:// targets instead of local credentials.
+	udsTLS bool
+}
+
+// parseFlags reads the client configuration from the command line.
+func parseFlags() clientConfig {
+	var cfg clientConfig
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [command]\n%s\n\nflags:\n", os.Args[0], commandUsage)
+		flag.PrintDefaults()
+	}
+
+	targets := flag.String("target", "localhost:8080", "comma-separated gRPC targets in failover order: host:port, srv:///<srv-name>, consul://<agent>/<service>, xds:///<listener> or unix:///<socket>")
+	flag.IntVar(&cfg.failThreshold, "failover-threshold", 5, "consecutive failures before failing over to the next target")
+	flag.IntVar(&cfg.recoverProbes, "failback-probes", 3, "consecutive successful probes before returning to a preferred target")
+	flag.StringVar(&cfg.certFile, "cert", "client-cert.pem", "client certificate file")
+	flag.StringVar(&cfg.keyFile, "key", "client-key.pem", "client private key file")
+	flag.StringVar(&cfg.caFile, "ca", "ca-cert.pem", "CA certificate file used to verify the server")
+	flag.StringVar(&cfg.spiffe.socket, "spiffe-socket", "", "SPIFFE Workload API address; when set, SVIDs replace the PEM files")
+	flag.StringVar(&cfg.spiffe.serverID, "spiffe-server-id", "", "SPIFFE ID the server must present")
+	flag.StringVar(&cfg.spiffe.trustDomain, "spiffe-trust-domain", "", "accept any server in this trust domain when -spiffe-server-id is unset")
+	flag.DurationVar(&cfg.certCheckInterval, "cert-check-interval", time.Hour, "interval between client certificate chain and expiry checks")
+	flag.DurationVar(&cfg.certExpiryWarning, "cert-expiry-warning", 14*24*time.Hour, "warn when the client certificate expires within this period")
+	tlsMinVersion := flag.String("tls-min-version", "1.3", "minimum TLS version: 1.2 or 1.3")
+	tlsCiphers := flag.String("tls-ciphers", "", "comma-separated TLS 1.2 cipher suites, empty for Go defaults")
+	tlsCurves := flag.String("tls-curves", "", "comma-separated curve preferences (X25519, P256, P384, P521, X25519MLKEM768)")
+	tlsServerName := flag.String("tls-server-name", "", "override the server name used for verification and SNI")
+	flag.IntVar(&cfg.poolSize, "pool-size", 4, "number of gRPC connections to the target")
+	poolPick := flag.String("pool-pick", "least-loaded", "connection pick policy: round-robin or least-loaded")
+
+	flag.DurationVar(&cfg.rpcTimeout, "rpc-timeout", 10*time.Second, "default deadline for unary calls that don't set one, 0 disables")
+
+	flag.IntVar(&cfg.retry.maxAttempts, "retry-max-attempts", 3, "maximum attempts per unary call, 1 disables retries")
+	flag.DurationVar(&cfg.retry.perAttemptTimeout, "retry-attempt-timeout", 0, "timeout of each attempt, 0 for none")
+	retryCodes := flag.String("retry-codes", "UNAVAILABLE", "comma-separated status codes that are retried")
+	flag.DurationVar(&cfg.retry.initialBackoff, "retry-backoff", 100*time.Millisecond, "backoff before the first retry")
+	flag.DurationVar(&cfg.retry.maxBackoff, "retry-max-backoff", 2*time.Second, "maximum backoff between retries")
+	flag.Float64Var(&cfg.retry.backoffMultiplier, "retry-backoff-multiplier", 2, "backoff growth per retry")
+	flag.Float64Var(&cfg.retry.jitter, "retry-jitter", 0.2, "fraction of random jitter applied to each backoff")
+	hedgeMethods := flag.String("hedge-methods", "", "comma-separated idempotent methods to hedge, e.g. /pkg.Service/Get; a trailing / matches a whole service")
+	flag.DurationVar(&cfg.hedge.delay, "hedge-delay", 100*time.Millisecond, "latency after which a hedged attempt is sent")
+
+	flag.DurationVar(&cfg.keepalive.Time, "keepalive-time", 30*time.Second, "ping the server after this much inactivity, 0 disables keepalive")
+	flag.DurationVar(&cfg.keepalive.Timeout, "keepalive-timeout", 10*time.Second, "close the connection if a keepalive ping is not acknowledged in time")
+	flag.BoolVar(&cfg.keepalive.PermitWithoutStream, "keepalive-permit-without-stream", false, "send keepalive pings even with no active RPCs")
+	flag.DurationVar(&cfg.idleTimeout, "idle-timeout", 30*time.Minute, "move the channel to IDLE after this long without RPCs, 0 disables")
+
+	flag.DurationVar(&cfg.connect.Backoff.BaseDelay, "reconnect-base-delay", backoff.DefaultConfig.BaseDelay, "backoff after the first failed connection attempt")
+	flag.DurationVar(&cfg.connect.Backoff.MaxDelay, "reconnect-max-delay", backoff.DefaultConfig.MaxDelay, "upper bound of the reconnect backoff")
+	flag.Float64Var(&cfg.connect.Backoff.Multiplier, "reconnect-multiplier", backoff.DefaultConfig.Multiplier, "reconnect backoff growth per failed attempt")
+	flag.Float64Var(&cfg.connect.Backoff.Jitter, "reconnect-jitter", backoff.DefaultConfig.Jitter, "fraction of random jitter applied to the reconnect backoff")
+	flag.DurationVar(&cfg.connect.MinConnectTimeout, "connect-timeout", 20*time.Second, "minimum time given to each connection attempt")
+	flag.DurationVar(&cfg.connectWait, "connect-wait", 30*time.Second, "how long to wait for a READY connection at startup, 0 skips the wait")
+	flag.BoolVar(&cfg.waitForReady, "wait-for-ready", true, "queue RPCs until the channel is READY instead of failing fast")
+
+	flag.StringVar(&cfg.healthService, "health-service", "", "service name for grpc.health.v1 checks, empty for the whole server")
+	flag.DurationVar(&cfg.healthInterval, "health-interval", 10*time.Second, "interval between health checks")
+	flag.DurationVar(&cfg.healthWait, "health-wait", 30*time.Second, "how long to wait for the target to become healthy at startup, 0 skips the wait")
+
+	compressor := flag.String("compression", "", "compress every call with this compressor (gzip), empty leaves calls uncompressed")
+	compressionLevel := flag.Int("compression-level", defaultCompressionLevel, "gzip compression level")
+	flag.IntVar(&cfg.maxRecvMsgSize, "max-recv-msg-size", 4<<20, "largest response message accepted, in bytes")
+	flag.IntVar(&cfg.maxSendMsgSize, "max-send-msg-size", 4<<20, "largest request message sent, in bytes")
+	flag.DurationVar(&cfg.drainTimeout, "drain-timeout", 30*time.Second, "how long to wait for in-flight calls on shutdown")
+	flag.StringVar(&cfg.otlpEndpoint, "otlp-endpoint", "", "OTLP/gRPC collector address for traces, empty disables export")
+
+	flag.StringVar(&cfg.auth.mode, "auth", "none", "per-RPC credentials: none, bearer, oauth2 or jwt")
+	flag.StringVar(&cfg.auth.token, "auth-token", "", "static bearer token")
+	flag.StringVar(&cfg.auth.tokenFile, "auth-token-file", "", "file holding the static bearer token")
+	flag.StringVar(&cfg.auth.oauth2TokenURL, "oauth2-token-url", "", "OAuth2 token endpoint for the client-credentials flow")
+	flag.StringVar(&cfg.auth.oauth2ClientID, "oauth2-client-id", "", "OAuth2 client ID")
+	flag.StringVar(&cfg.auth.oauth2ClientSecret, "oauth2-client-secret", os.Getenv("OAUTH2_CLIENT_SECRET"), "OAuth2 client secret (defaults to $OAUTH2_CLIENT_SECRET)")
+	flag.StringVar(&cfg.auth.oauth2Scopes, "oauth2-scopes", "", "comma-separated OAuth2 scopes")
+	flag.StringVar(&cfg.auth.jwtKeyFile, "jwt-key", "", "PKCS#8 PEM private key used to sign JWTs")
+	flag.StringVar(&cfg.auth.jwtIssuer, "jwt-issuer", "deepmgr", "JWT issuer claim")
+	flag.StringVar(&cfg.auth.jwtSubject, "jwt-subject", "", "JWT subject claim")
+	flag.StringVar(&cfg.auth.jwtAudience, "jwt-audience", "", "JWT audience claim")
+	flag.DurationVar(&cfg.auth.jwtTTL, "jwt-ttl", 10*time.Minute, "lifetime of each signed JWT")
+
+	flag.StringVar(&cfg.grpcWebURL, "grpc-web-url", "", "gRPC-Web endpoint to fall back to when the channel is not ready within -connect-wait, e.g. https://envoy.example.com")
+	flag.StringVar(&cfg.debugAddr, "debug-addr", "", "serve /debug/channelz and /debug/vars on this address, e.g. localhost:6060")
+	flag.BoolVar(&cfg.udsTLS, "uds-tls", false, "use mTLS on unix:// targets instead of local credentials")
+	proxyURL := flag.String("proxy", "", "egress proxy URL: http://[user:pass@]host:port or socks5://[user:pass@]host:port")
+	flag.Parse()
+
+	cfg.targets = splitList(*targets)
+	if len(cfg.targets) == 0 {
+		log.Fatal("At least one -target is required")
+	}
+
+	var err error
+	if cfg.poolPick, err = parsePickPolicy(*poolPick); err != nil {
+		log.Fatalf("Invalid -pool-pick: %v", err)
+	}
+	if cfg.tls, err = parseTLSOptions(*tlsMinVersion, *tlsCiphers, *tlsCurves, *tlsServerName); err != nil {
+		log.Fatalf("Invalid TLS options: %v", err)
+	}
+	cfg.hedge.methods = splitList(*hedgeMethods)
+	if cfg.retry.retryableCodes, err = parseCodes(*retryCodes); err != nil {
+		log.Fatalf("Invalid -retry-codes: %v", err)
+	}
+	if cfg.compression, err = compressionOptions(*compressor, *compressionLevel); err != nil {
+		log.Fatalf("Invalid compression options: %v", err)
+	}
+	if *proxyURL != "" {
+		if cfg.proxyDialer, err = newProxyDialer(*proxyURL); err != nil {
+			log.Fatalf("Invalid -proxy: %v", err)
+		}
+	}
+
+	return cfg
+}
+
+// dialOptions builds the dial options shared by every connection in the pool.
+func (cfg clientConfig) dialOptions(creds credentials.TransportCredentials, perRPC credentials.PerRPCCredentials, drain *drainer) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// The deadline covers every retry attempt, so it wraps the retry interceptor
+		grpc.WithChainUnaryInterceptor(drain.unaryInterceptor(), deadlineUnaryInterceptor(cfg.rpcTimeout), cfg.retry.unaryInterceptor()),
+		grpc.WithChainStreamInterceptor(drain.streamInterceptor()),
+		grpc.WithIdleTimeout(cfg.idleTimeout),
+		grpc.WithConnectParams(cfg.connect),
+		grpc.WithDefaultCallOptions(
+			grpc.WaitForReady(cfg.waitForReady),
+			grpc.MaxCallRecvMsgSize(cfg.maxRecvMsgSize),
+			grpc.MaxCallSendMsgSize(cfg.maxSendMsgSize),
+		),
+		// Only used when the resolver supplies no config; xDS pushes its own policy
+		grpc.WithDefaultServiceConfig(roundRobinServiceConfig),
+		grpc.WithStatsHandler(newTracingHandler()),
+		grpc.WithStatsHandler(compressionStats{}),
+	}
+	opts = append(opts, cfg.compression...)
+	if cfg.keepalive.Time > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(cfg.keepalive))
+	}
+	if perRPC != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(perRPC))
+	}
+	if cfg.proxyDialer != nil {
+		opts = append(opts, grpc.WithContextDialer(cfg.proxyDialer))
+	}
+	return opts
+}
+
+// clientTLSConfig builds the mTLS config shared by the gRPC and gRPC-Web transports.
+// Background rotation stops when ctx is done.
+func clientTLSConfig(ctx context.Context, cfg clientConfig) (*tls.Config, error) {
+	if cfg.spiffe.socket != "" {
+		tlsConfig, source, err := newSPIFFETLSConfig(ctx, cfg.spiffe, cfg.tls)
+		if err != nil {
+			return nil, err
+		}
+		context.AfterFunc(ctx, func() { source.Close() })
+		return tlsConfig, nil
+	}
+
+	// Load client certificate and private key, and keep them fresh as they rotate
+	certs, err := newCertReloader(cfg.certFile, cfg.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	go certs.watch(ctx, certReloadInterval)
+
+	// Load CA certificate
+	caCert, err := ioutil.ReadFile(cfg.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+
+	// Catch broken chains and expiring certs before handshakes start failing
+	go monitorClientCert(ctx, certs, caCertPool, cfg.certCheckInterval, cfg.certExpiryWarning)
+
+	tlsConfig := &tls.Config{
+		GetClientCertificate: certs.GetClientCertificate,
+		RootCAs:              caCertPool,
+	}
+	cfg.tls.apply(tlsConfig)
+	return tlsConfig, nil
+}
+
+func main() {
+	cfg := parseFlags()
+
+	// Shut down on a signal, giving in-flight calls the drain timeout. Background work keeps
+	// its own context so certificates and the pool stay maintained while calls drain.
+	lc := lifecycle.New(cfg.drainTimeout)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Export client spans for every RPC, flushing them once the calls are done
+	if cfg.otlpEndpoint != "" {
+		flushTracing, err := setupTracing(ctx, cfg.otlpEndpoint)
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+		lc.OnShutdown("tracing", flushTracing)
+	}
+
+	// Channelz only tracks channels created after it is turned on, so start this before dialing
+	if cfg.debugAddr != "" {
+		http.Handle("/readyz", lc.ReadyHandler())
+		if err := startDebugServer(ctx, cfg.debugAddr); err != nil {
+			log.Fatalf("Failed to start debug server: %v", err)
+		}
+	}
+
+	// Create TLS credentials from the Workload API or the PEM files
+	tlsConfig, err := clientTLSConfig(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create TLS credentials: %v", err)
+	}
+	creds := credentials.NewTLS(tlsConfig)
+
+	// Let the Envoy control plane push TLS requirements to xDS targets
+	if usesXDS(cfg.targets) {
+		if creds, err = xdsCredentials(creds); err != nil {
+			log.Fatalf("Failed to create xDS credentials: %v", err)
+		}
+	}
+
+	// Envoy requires an Authorization header on some routes as well
+	perRPC, err := cfg.auth.perRPCCredentials(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create per-RPC credentials: %v", err)
+	}
+
+	// Create a pool of gRPC connections with TLS credentials for each target
+	drain := newDrainer()
+	opts := cfg.dialOptions(creds, perRPC, drain)
+	conn, err := newFailoverConn(
+		cfg.targets,
+		cfg.poolSize,
+		cfg.poolPick,
+		cfg.failThreshold,
+		cfg.recoverProbes,
+		func(target string) []grpc.DialOption {
+			return cfg.targetDialOptions(target, opts)
+		},
+	)
+	if err != nil {
+		log.Fatalf("Failed to dial: %v", err)
+	}
+
+	// Block until the channel is connected so the first calls don't race the dial,
+	// falling back to gRPC-Web when HTTP/2 cannot get through
+	var channel clientConn = conn
+	if cfg.connectWait > 0 {
+		readyCtx, readyCancel := context.WithTimeout(ctx, cfg.connectWait)
+		err := conn.WaitForReady(readyCtx)
+		readyCancel()
+		if err != nil {
+			if cfg.grpcWebURL == "" {
+				log.Fatalf("Connection did not become ready: %v", err)
+			}
+			log.Printf("Connection did not become ready (%v), falling back to gRPC-Web at %s", err, cfg.grpcWebURL)
+			conn.Close()
+			if channel, err = newGRPCWebConn(cfg.grpcWebURL, tlsConfig, perRPC, cfg); err != nil {
+				log.Fatalf("Failed to create gRPC-Web transport: %v", err)
+			}
+		}
+	}
+	if channel == conn {
+		go conn.maintain(ctx, poolCheckInterval, poolEvictGrace)
+	}
+
+	// Hedge slow idempotent calls onto another pooled connection
+	if len(cfg.hedge.methods) > 0 {
+		channel = hedgedConn{clientConn: channel, policy: cfg.hedge}
+	}
+
+	// Watch the target's health and wait until it is serving before issuing calls
+	health := newHealthChecker(channel, cfg.healthService, cfg.healthInterval)
+	health.OnChange(logHealthChange)
+	go health.run(ctx)
+
+	if cfg.healthWait > 0 {
+		waitCtx, waitCancel := context.WithTimeout(ctx, cfg.healthWait)
+		err := health.WaitForHealthy(waitCtx)
+		waitCancel()
+		if err != nil {
+			log.Fatalf("Target did not become healthy: %v", err)
+		}
+	}
+
+	// Run a one-shot debugging command if one was given
+	if flag.NArg() > 0 {
+		err := runCommand(ctx, channel, cfg.rpcTimeout, flag.Args())
+		channel.Close()
+		lc.Shutdown()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Use the connection pool to make gRPC calls.
+	// client := pb.NewYourServiceClient(channel)
+
+	// Wait for a shutdown signal, then let in-flight calls finish before closing the connections
+	lc.OnShutdown("connections", func(ctx context.Context) error {
+		log.Println("Shutdown signal received, draining in-flight calls...")
+		return drain.Shutdown(ctx, channel)
+	})
+	lc.SetReady(true)
+	if err := lc.Wait(); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	resolveInterval = 30 * time.Second
+	resolveTimeout  = 5 * time.Second
+
+	// roundRobinServiceConfig spreads calls over every resolved backend.
+	roundRobinServiceConfig = `{"loadBalancingConfig":[{"round_robin":{}}]}`
+)
+
+func init() {
+	// srv:///_grpc._tcp.deepmgr.example.com
+	resolver.Register(&discoveryBuilder{scheme: "srv", lookup: lookupSRV})
+	// consul://127.0.0.1:8500/deepmgr
+	resolver.Register(&discoveryBuilder{scheme: "consul", lookup: lookupConsul})
+}
+
+// lookupFunc returns the current backend addresses for a target.
+type lookupFunc func(ctx context.Context, target resolver.Target) ([]string, error)
+
+// discoveryBuilder builds resolvers that poll a discovery source for backend addresses.
+type discoveryBuilder struct {
+	scheme string
+	lookup lookupFunc
+}
+
+func (builder *discoveryBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &discoveryResolver{
+		target:     target,
+		cc:         cc,
+		lookup:     builder.lookup,
+		cancel:     cancel,
+		resolveNow: make(chan struct{}, 1),
+	}
+
+	r.wg.Add(1)
+	go r.watch(ctx)
+	return r, nil
+}
+
+func (builder *discoveryBuilder) Scheme() string {
+	return builder.scheme
+}
+
+// discoveryResolver pushes the discovered addresses to gRPC as instances come and go.
+type discoveryResolver struct {
+	target     resolver.Target
+	cc         resolver.ClientConn
+	lookup     lookupFunc
+	cancel     context.CancelFunc
+	resolveNow chan struct{}
+	wg         sync.WaitGroup
+}
+
+func (r *discoveryResolver) watch(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(resolveInterval)
+	defer ticker.Stop()
+
+	for {
+		r.resolve(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.resolveNow:
+		}
+	}
+}
+
+func (r *discoveryResolver) resolve(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := r.lookup(ctx, r.target)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no backends found for %s", r.target.URL.String())
+	}
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+
+	state := resolver.State{}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	if err := r.cc.UpdateState(state); err != nil {
+		r.cc.ReportError(err)
+	}
+}
+
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *discoveryResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// lookupSRV resolves the SRV record named by the target endpoint.
+func lookupSRV(ctx context.Context, target resolver.Target) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", target.Endpoint())
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SRV records for %s: %w", target.Endpoint(), err)
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return addrs, nil
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// lookupConsul lists the passing instances of the service named by the target endpoint,
+// using the Consul agent in the target authority.
+func lookupConsul(ctx context.Context, target resolver.Target) ([]string, error) {
+	agent := target.URL.Host
+	if agent == "" {
+		agent = "127.0.0.1:8500"
+	}
+	endpoint := fmt.Sprintf("http://%s/v1/health/service/%s?passing=true", agent, url.PathEscape(target.Endpoint()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consul request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query consul: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned status %s", resp.Status)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+
+	addrs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+	return addrs, nil
+}
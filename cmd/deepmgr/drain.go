@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// drainer tracks in-flight RPCs so shutdown can stop new calls and let running ones finish.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inflight int
+	idle     chan struct{}
+}
+
+func newDrainer() *drainer {
+	return &drainer{}
+}
+
+// begin registers a new RPC, refusing it once draining has started.
+func (d *drainer) begin(method string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return status.Errorf(codes.Unavailable, "%s: client is shutting down", method)
+	}
+	d.inflight++
+	return nil
+}
+
+// end marks an RPC registered by begin as finished.
+func (d *drainer) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inflight--
+	if d.inflight == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// unaryInterceptor counts unary calls as in flight until they return.
+func (d *drainer) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := d.begin(method); err != nil {
+			return err
+		}
+		defer d.end()
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// streamInterceptor counts streams as in flight until they finish.
+func (d *drainer) streamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := d.begin(method); err != nil {
+			return nil, err
+		}
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			d.end()
+			return nil, err
+		}
+
+		// The stream's context is cancelled once the stream finishes
+		context.AfterFunc(stream.Context(), d.end)
+		return stream, nil
+	}
+}
+
+// Shutdown stops new RPCs, waits for in-flight ones until ctx is done, and then closes conn.
+// It returns an error if calls were still running when the wait was cut short.
+func (d *drainer) Shutdown(ctx context.Context, conn io.Closer) error {
+	d.mu.Lock()
+	d.draining = true
+	remaining := d.inflight
+	var idle chan struct{}
+	if remaining > 0 {
+		if d.idle == nil {
+			d.idle = make(chan struct{})
+		}
+		idle = d.idle
+	}
+	d.mu.Unlock()
+
+	var err error
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			d.mu.Lock()
+			remaining = d.inflight
+			d.mu.Unlock()
+			err = fmt.Errorf("closing with %d calls still in flight: %w", remaining, ctx.Err())
+		}
+	}
+
+	if closeErr := conn.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	failbackProbeTimeout = 5 * time.Second
+)
+
+// failoverConn sends calls to the first usable target of an ordered list. It fails over
+// to the next target after consecutive failures and returns to a preferred target once
+// it has stayed reachable for a while.
+type failoverConn struct {
+	targets []string
+	pools   []*connPool
+
+	// failThreshold consecutive target failures trigger a failover.
+	failThreshold int
+	// recoverProbes consecutive successful probes of a preferred target trigger a failback.
+	recoverProbes int
+
+	mu       sync.RWMutex
+	active   int
+	failures int
+}
+
+var _ grpc.ClientConnInterface = (*failoverConn)(nil)
+
+// newFailoverConn dials a connection pool per target, in preference order, with the
+// dial options optsFor returns for that target.
+func newFailoverConn(targets []string, size int, policy pickPolicy, failThreshold, recoverProbes int, optsFor func(target string) []grpc.DialOption) (*failoverConn, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets given")
+	}
+
+	conn := &failoverConn{targets: targets, failThreshold: failThreshold, recoverProbes: recoverProbes}
+	for _, target := range targets {
+		pool, err := newConnPool(target, size, policy, optsFor(target)...)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.pools = append(conn.pools, pool)
+	}
+	return conn, nil
+}
+
+func (conn *failoverConn) current() (int, *connPool) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.active, conn.pools[conn.active]
+}
+
+// targetFailure reports whether an error means the target itself is unreachable or failing.
+func targetFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// record counts the outcome of a call made on target index and fails over when needed.
+func (conn *failoverConn) record(index int, err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	// Ignore results from calls started before an earlier switch
+	if index != conn.active {
+		return
+	}
+	if !targetFailure(err) {
+		conn.failures = 0
+		return
+	}
+
+	conn.failures++
+	if conn.failures >= conn.failThreshold && len(conn.pools) > 1 {
+		next := (conn.active + 1) % len(conn.pools)
+		log.Printf("Failing over from %s to %s after %d consecutive failures", conn.targets[conn.active], conn.targets[next], conn.failures)
+		conn.switchTo(next)
+	}
+}
+
+// switchTo makes index the active target. The caller holds conn.mu.
+func (conn *failoverConn) switchTo(index int) {
+	conn.active = index
+	conn.failures = 0
+}
+
+func (conn *failoverConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	index, pool := conn.current()
+	err := pool.Invoke(ctx, method, args, reply, opts...)
+	conn.record(index, err)
+	return err
+}
+
+func (conn *failoverConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	index, pool := conn.current()
+	stream, err := pool.NewStream(ctx, desc, method, opts...)
+	conn.record(index, err)
+	return stream, err
+}
+
+// WaitForReady blocks until a target is READY, failing over past targets that
+// cannot connect within their share of the wait.
+func (conn *failoverConn) WaitForReady(ctx context.Context) error {
+	deadline, hasDeadline := ctx.Deadline()
+
+	var lastErr error
+	for attempt := 0; attempt < len(conn.pools); attempt++ {
+		index, pool := conn.current()
+
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if hasDeadline {
+			share := time.Until(deadline) / time.Duration(len(conn.pools)-attempt)
+			waitCtx, cancel = context.WithTimeout(ctx, share)
+		}
+		err := pool.WaitForReady(waitCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		conn.mu.Lock()
+		if conn.active == index && len(conn.pools) > 1 {
+			next := (index + 1) % len(conn.pools)
+			log.Printf("Target %s not ready, failing over to %s", conn.targets[index], conn.targets[next])
+			conn.switchTo(next)
+		}
+		conn.mu.Unlock()
+	}
+	return lastErr
+}
+
+// maintain keeps every pool healthy and probes preferred targets for failback until ctx is done.
+func (conn *failoverConn) maintain(ctx context.Context, interval, grace time.Duration) {
+	for _, pool := range conn.pools {
+		go pool.maintain(ctx, interval, grace)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := make([]int, len(conn.pools))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		active, _ := conn.current()
+		for i := 0; i < active; i++ {
+			probeCtx, cancel := context.WithTimeout(ctx, failbackProbeTimeout)
+			err := conn.pools[i].WaitForReady(probeCtx)
+			cancel()
+
+			if err != nil {
+				healthy[i] = 0
+				continue
+			}
+			healthy[i]++
+			if healthy[i] < conn.recoverProbes {
+				continue
+			}
+
+			conn.mu.Lock()
+			if conn.active == active {
+				log.Printf("Target %s recovered, failing back from %s", conn.targets[i], conn.targets[active])
+				conn.switchTo(i)
+			}
+			conn.mu.Unlock()
+			healthy[i] = 0
+			break
+		}
+	}
+}
+
+// Close closes the pools of every target.
+func (conn *failoverConn) Close() error {
+	var firstErr error
+	for _, pool := range conn.pools {
+		if err := pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// pickPolicy selects which pooled connection serves the next call.
+type pickPolicy int
+
+const (
+	pickRoundRobin pickPolicy = iota
+	pickLeastLoaded
+)
+
+func parsePickPolicy(name string) (pickPolicy, error) {
+	switch name {
+	case "round-robin":
+		return pickRoundRobin, nil
+	case "least-loaded":
+		return pickLeastLoaded, nil
+	default:
+		return 0, fmt.Errorf("unknown pick policy %q", name)
+	}
+}
+
+type pooledConn struct {
+	conn     *grpc.ClientConn
+	inflight atomic.Int64
+	// failingSince is when the connection was first seen unhealthy, zero while healthy.
+	failingSince time.Time
+}
+
+func (pc *pooledConn) healthy() bool {
+	state := pc.conn.GetState()
+	return state != connectivity.TransientFailure && state != connectivity.Shutdown
+}
+
+// connPool spreads calls over several ClientConns to the same target, since a single
+// HTTP/2 connection saturates under heavy streaming load. It implements
+// grpc.ClientConnInterface so generated clients can use it directly.
+type connPool struct {
+	target string
+	opts   []grpc.DialOption
+	policy pickPolicy
+
+	mu            sync.RWMutex
+	conns         []*pooledConn
+	next          atomic.Uint64
+	onStateChange stateFunc
+}
+
+var _ grpc.ClientConnInterface = (*connPool)(nil)
+
+// newConnPool dials size connections to target.
+func newConnPool(target string, size int, policy pickPolicy, opts ...grpc.DialOption) (*connPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("pool size must be at least 1, got %d", size)
+	}
+
+	pool := &connPool{target: target, opts: opts, policy: policy, onStateChange: logStateChange}
+	for i := 0; i < size; i++ {
+		pc, err := pool.dial(i)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, pc)
+	}
+	return pool, nil
+}
+
+// dial creates the connection for slot index and starts watching its state.
+func (pool *connPool) dial(index int) (*pooledConn, error) {
+	conn, err := grpc.Dial(pool.target, pool.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", pool.target, err)
+	}
+	go pool.watchState(index, conn)
+	return &pooledConn{conn: conn}, nil
+}
+
+// pick returns the connection for the next call, preferring healthy ones.
+func (pool *connPool) pick() *pooledConn {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	n := len(pool.conns)
+	switch pool.policy {
+	case pickLeastLoaded:
+		var best *pooledConn
+		for _, pc := range pool.conns {
+			if !pc.healthy() {
+				continue
+			}
+			if best == nil || pc.inflight.Load() < best.inflight.Load() {
+				best = pc
+			}
+		}
+		if best != nil {
+			return best
+		}
+	default:
+		start := pool.next.Add(1)
+		for i := 0; i < n; i++ {
+			pc := pool.conns[(start+uint64(i))%uint64(n)]
+			if pc.healthy() {
+				return pc
+			}
+		}
+	}
+
+	// Nothing is healthy; let gRPC's own reconnect logic handle the call
+	return pool.conns[pool.next.Add(1)%uint64(n)]
+}
+
+func (pool *connPool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	pc := pool.pick()
+	pc.inflight.Add(1)
+	defer pc.inflight.Add(-1)
+
+	return pc.conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (pool *connPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	pc := pool.pick()
+	pc.inflight.Add(1)
+
+	stream, err := pc.conn.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		pc.inflight.Add(-1)
+		return nil, err
+	}
+
+	// The stream's context is cancelled once the stream finishes
+	context.AfterFunc(stream.Context(), func() {
+		pc.inflight.Add(-1)
+	})
+	return stream, nil
+}
+
+// maintain evicts connections that stay unhealthy longer than grace and replaces them
+// with freshly dialed ones, checking every interval until ctx is done.
+func (pool *connPool) maintain(ctx context.Context, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pool.evictUnhealthy(grace)
+		}
+	}
+}
+
+func (pool *connPool) evictUnhealthy(grace time.Duration) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	now := time.Now()
+	for i, pc := range pool.conns {
+		if pc.healthy() {
+			pc.failingSince = time.Time{}
+			continue
+		}
+		if pc.failingSince.IsZero() {
+			pc.failingSince = now
+			continue
+		}
+		if now.Sub(pc.failingSince) < grace {
+			continue
+		}
+
+		replacement, err := pool.dial(i)
+		if err != nil {
+			log.Printf("Failed to replace unhealthy connection to %s: %v", pool.target, err)
+			continue
+		}
+		log.Printf("Evicting connection %d to %s, unhealthy for %v", i, pool.target, now.Sub(pc.failingSince))
+		pool.conns[i] = replacement
+
+		// In-flight calls on the old connection keep it alive until they finish
+		old := pc.conn
+		go func() {
+			for pc.inflight.Load() > 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			old.Close()
+		}()
+	}
+}
+
+// Close closes every pooled connection.
+func (pool *connPool) Close() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var firstErr error
+	for _, pc := range pool.conns {
+		if err := pc.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
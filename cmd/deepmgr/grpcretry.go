@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryPolicy describes how unary calls are retried on transient failures.
+type retryPolicy struct {
+	maxAttempts       int
+	perAttemptTimeout time.Duration
+	retryableCodes    []codes.Code
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+	backoffMultiplier float64
+	// jitter randomizes each backoff by up to this fraction in either direction.
+	jitter float64
+}
+
+// parseCodes parses a comma-separated list of status code names such as "UNAVAILABLE,ABORTED".
+func parseCodes(names string) ([]codes.Code, error) {
+	var parsed []codes.Code
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(`"` + strings.ToUpper(name) + `"`)); err != nil {
+			return nil, fmt.Errorf("unknown status code %q", name)
+		}
+		parsed = append(parsed, code)
+	}
+	return parsed, nil
+}
+
+// retryable reports whether a failed attempt may be retried.
+func (policy retryPolicy) retryable(ctx, attemptCtx context.Context, err error) bool {
+	// The caller gave up; never retry past its deadline or cancellation
+	if ctx.Err() != nil {
+		return false
+	}
+	// The attempt ran out of its own budget while the call still has time left
+	if attemptCtx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	return slices.Contains(policy.retryableCodes, status.Code(err))
+}
+
+// backoff returns the delay before the given retry (1 for the first retry).
+func (policy retryPolicy) backoff(retry int) time.Duration {
+	delay := float64(policy.initialBackoff)
+	for i := 1; i < retry; i++ {
+		delay *= policy.backoffMultiplier
+	}
+	delay = min(delay, float64(policy.maxBackoff))
+	delay *= 1 + policy.jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// unaryInterceptor retries unary calls according to the policy.
+func (policy retryPolicy) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		for attempt := 1; ; attempt++ {
+			attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+			if policy.perAttemptTimeout > 0 {
+				attemptCtx, cancel = context.WithTimeout(ctx, policy.perAttemptTimeout)
+			}
+			err := invoker(attemptCtx, method, req, reply, cc, opts...)
+			retry := err != nil && attempt < policy.maxAttempts && policy.retryable(ctx, attemptCtx, err)
+			cancel()
+
+			if !retry {
+				return err
+			}
+
+			delay := policy.backoff(attempt)
+			log.Printf("Retrying %s after %v (attempt %d/%d): %v", method, delay, attempt+1, policy.maxAttempts, err)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	grpcWebContentType = "application/grpc-web+proto"
+
+	// gRPC-Web frame flags; trailers arrive as a final frame in the body.
+	grpcWebDataFrame    = 0x00
+	grpcWebTrailerFrame = 0x80
+	grpcWebFrameHeader  = 5
+)
+
+// grpcWebConn carries unary and server-streaming calls as gRPC-Web over HTTP/1.1, for
+// networks where the path to Envoy strips HTTP/2 or trailers. Envoy's grpc_web filter
+// translates the calls back to gRPC. It implements grpc.ClientConnInterface.
+type grpcWebConn struct {
+	baseURL        string
+	client         *http.Client
+	perRPC         credentials.PerRPCCredentials
+	timeout        time.Duration
+	maxRecvMsgSize int
+}
+
+var _ grpc.ClientConnInterface = (*grpcWebConn)(nil)
+
+// newGRPCWebConn sends gRPC-Web calls to baseURL, e.g. https://envoy.example.com:8443.
+func newGRPCWebConn(baseURL string, tlsConfig *tls.Config, perRPC credentials.PerRPCCredentials, cfg clientConfig) (*grpcWebConn, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid gRPC-Web URL %q", baseURL)
+	}
+	if parsed.Scheme == "http" && perRPC != nil && perRPC.RequireTransportSecurity() {
+		return nil, fmt.Errorf("per-RPC credentials require TLS, but %s is plain HTTP", baseURL)
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig.Clone(),
+		// Stay on HTTP/1.1; HTTP/2 is what this transport is avoiding
+		TLSNextProto:        map[string]func(string, *tls.Conn) http.RoundTripper{},
+		MaxIdleConnsPerHost: cfg.poolSize,
+		IdleConnTimeout:     cfg.idleTimeout,
+	}
+	transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	if cfg.proxyDialer != nil {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return cfg.proxyDialer(ctx, addr)
+		}
+	}
+
+	return &grpcWebConn{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		client:         &http.Client{Transport: transport},
+		perRPC:         perRPC,
+		timeout:        cfg.rpcTimeout,
+		maxRecvMsgSize: cfg.maxRecvMsgSize,
+	}, nil
+}
+
+func (web *grpcWebConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	ctx, cancel := withDefaultDeadline(ctx, web.timeout)
+	defer cancel()
+
+	start := time.Now()
+	stream := &grpcWebStream{ctx: ctx, web: web, method: method}
+	err := stream.SendMsg(args)
+	if err == nil {
+		if err = stream.RecvMsg(reply); err == io.EOF {
+			err = status.Errorf(codes.Internal, "%s: no response message", method)
+		}
+	}
+	if err == nil {
+		// Drain to the trailers so a non-OK status after the message is not lost
+		if err = stream.RecvMsg(reply); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = status.Errorf(codes.Internal, "%s: unary call returned more than one message", method)
+		}
+	}
+	stream.close()
+	return callError(ctx, method, start, err)
+}
+
+func (web *grpcWebConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if desc.ClientStreams {
+		return nil, status.Errorf(codes.Unimplemented, "%s: gRPC-Web supports only unary and server-streaming calls", method)
+	}
+	return &grpcWebStream{ctx: ctx, web: web, method: method}, nil
+}
+
+// Close releases idle HTTP connections.
+func (web *grpcWebConn) Close() error {
+	web.client.CloseIdleConnections()
+	return nil
+}
+
+// post sends a single framed request message and returns the HTTP response.
+func (web *grpcWebConn) post(ctx context.Context, method string, msg proto.Message) (*http.Response, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode request: %v", err)
+	}
+	body := make([]byte, grpcWebFrameHeader, grpcWebFrameHeader+len(data))
+	body[0] = grpcWebDataFrame
+	binary.BigEndian.PutUint32(body[1:], uint32(len(data)))
+	body = append(body, data...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, web.baseURL+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", grpcWebContentType)
+	req.Header.Set("Accept", grpcWebContentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", fmt.Sprintf("%dm", max(time.Until(deadline).Milliseconds(), 1)))
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if web.perRPC != nil {
+		service := web.baseURL + method[:strings.LastIndex(method, "/")]
+		extra, err := web.perRPC.GetRequestMetadata(ctx, service)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "failed to get per-RPC credentials: %v", err)
+		}
+		for key, value := range extra {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := web.client.Do(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "gRPC-Web request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, status.Errorf(httpStatusCode(resp.StatusCode), "gRPC-Web request failed: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// httpStatusCode maps an HTTP error status to a gRPC code, as gRPC clients do.
+func httpStatusCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// grpcWebStream is a unary or server-streaming call. The request is held by SendMsg
+// and posted on the first RecvMsg.
+type grpcWebStream struct {
+	ctx    context.Context
+	web    *grpcWebConn
+	method string
+
+	request proto.Message
+	resp    *http.Response
+	body    *bufio.Reader
+	header  metadata.MD
+	trailer metadata.MD
+	// done holds the call status once trailers were read.
+	done error
+}
+
+func (stream *grpcWebStream) Header() (metadata.MD, error) {
+	if err := stream.open(); err != nil {
+		return nil, err
+	}
+	return stream.header, nil
+}
+
+func (stream *grpcWebStream) Trailer() metadata.MD {
+	return stream.trailer
+}
+
+func (stream *grpcWebStream) CloseSend() error {
+	return nil
+}
+
+func (stream *grpcWebStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *grpcWebStream) SendMsg(m any) error {
+	if stream.request != nil {
+		return status.Errorf(codes.Internal, "%s: gRPC-Web streams accept a single request message", stream.method)
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "%s: request %T is not a proto message", stream.method, m)
+	}
+	stream.request = msg
+	return nil
+}
+
+func (stream *grpcWebStream) RecvMsg(m any) error {
+	if err := stream.open(); err != nil {
+		return err
+	}
+	if stream.done != nil {
+		return stream.done
+	}
+
+	for {
+		flag, payload, err := stream.readFrame()
+		if err == io.EOF {
+			stream.finish(status.Errorf(codes.Internal, "%s: response ended without trailers", stream.method))
+			return stream.done
+		}
+		if err != nil {
+			stream.finish(err)
+			return err
+		}
+
+		if flag&grpcWebTrailerFrame != 0 {
+			stream.trailer = parseGRPCWebTrailers(payload)
+			stream.finish(statusFromMetadata(stream.trailer))
+			return stream.done
+		}
+
+		msg, ok := m.(proto.Message)
+		if !ok {
+			return status.Errorf(codes.Internal, "%s: response %T is not a proto message", stream.method, m)
+		}
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			return status.Errorf(codes.Internal, "failed to decode response: %v", err)
+		}
+		return nil
+	}
+}
+
+// open posts the request once and handles trailers-only responses.
+func (stream *grpcWebStream) open() error {
+	if stream.resp != nil || stream.done != nil {
+		return nil
+	}
+	if stream.request == nil {
+		return status.Errorf(codes.Internal, "%s: no request message sent", stream.method)
+	}
+
+	resp, err := stream.web.post(stream.ctx, stream.method, stream.request)
+	if err != nil {
+		stream.done = err
+		return err
+	}
+	stream.resp = resp
+	stream.body = bufio.NewReader(resp.Body)
+	stream.header = headerMetadata(resp.Header)
+
+	// Errors before any message come back in the headers with an empty body
+	if resp.Header.Get("Grpc-Status") != "" {
+		stream.trailer = stream.header
+		stream.finish(statusFromMetadata(stream.header))
+	}
+	return nil
+}
+
+func (stream *grpcWebStream) readFrame() (byte, []byte, error) {
+	var header [grpcWebFrameHeader]byte
+	if _, err := io.ReadFull(stream.body, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, status.Errorf(codes.Unavailable, "failed to read gRPC-Web frame: %v", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if stream.web.maxRecvMsgSize > 0 && int64(length) > int64(stream.web.maxRecvMsgSize) {
+		return 0, nil, status.Errorf(codes.ResourceExhausted, "received message larger than max (%d vs. %d)", length, stream.web.maxRecvMsgSize)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(stream.body, payload); err != nil {
+		return 0, nil, status.Errorf(codes.Unavailable, "failed to read gRPC-Web frame: %v", err)
+	}
+	return header[0], payload, nil
+}
+
+// finish records the call status and releases the response body. An OK status ends the stream with io.EOF.
+func (stream *grpcWebStream) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	stream.done = err
+	stream.close()
+}
+
+func (stream *grpcWebStream) close() {
+	if stream.resp != nil {
+		stream.resp.Body.Close()
+	}
+}
+
+// parseGRPCWebTrailers decodes the HTTP/1-style header block carried in a trailer frame.
+func parseGRPCWebTrailers(payload []byte) metadata.MD {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(payload, "\r\n"...))))
+	header, _ := reader.ReadMIMEHeader()
+	return headerMetadata(http.Header(header))
+}
+
+func headerMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range header {
+		md.Append(strings.ToLower(key), values...)
+	}
+	return md
+}
+
+// statusFromMetadata returns the call error described by grpc-status and grpc-message, or nil for OK.
+func statusFromMetadata(md metadata.MD) error {
+	values := md.Get("grpc-status")
+	if len(values) == 0 {
+		return status.Error(codes.Internal, "gRPC-Web response carried no grpc-status")
+	}
+	code, err := strconv.Atoi(values[0])
+	if err != nil {
+		return status.Errorf(codes.Internal, "invalid grpc-status %q", values[0])
+	}
+	if codes.Code(code) == codes.OK {
+		return nil
+	}
+
+	var message string
+	if messages := md.Get("grpc-message"); len(messages) > 0 {
+		if message, err = url.PathUnescape(messages[0]); err != nil {
+			message = messages[0]
+		}
+	}
+	return status.Error(codes.Code(code), message)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+)
+
+// healthChecker polls the target with the standard grpc.health.v1 protocol and
+// notifies callbacks whenever the serving status changes.
+type healthChecker struct {
+	client   healthpb.HealthClient
+	service  string
+	interval time.Duration
+
+	mu        sync.Mutex
+	status    healthpb.HealthCheckResponse_ServingStatus
+	lastErr   error
+	changed   chan struct{}
+	callbacks []func(from, to healthpb.HealthCheckResponse_ServingStatus)
+}
+
+// newHealthChecker creates a checker for service ("" checks the server as a whole).
+func newHealthChecker(cc grpc.ClientConnInterface, service string, interval time.Duration) *healthChecker {
+	return &healthChecker{
+		client:   healthpb.NewHealthClient(cc),
+		service:  service,
+		interval: interval,
+		status:   healthpb.HealthCheckResponse_UNKNOWN,
+		changed:  make(chan struct{}),
+	}
+}
+
+// OnChange registers a callback invoked on every serving status change.
+func (checker *healthChecker) OnChange(fn func(from, to healthpb.HealthCheckResponse_ServingStatus)) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	checker.callbacks = append(checker.callbacks, fn)
+}
+
+// Status returns the last observed serving status.
+func (checker *healthChecker) Status() healthpb.HealthCheckResponse_ServingStatus {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	return checker.status
+}
+
+// run checks health every interval until ctx is done.
+func (checker *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(checker.interval)
+	defer ticker.Stop()
+
+	for {
+		checker.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (checker *healthChecker) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	status := healthpb.HealthCheckResponse_UNKNOWN
+	resp, err := checker.client.Check(ctx, &healthpb.HealthCheckRequest{Service: checker.service})
+	if err == nil {
+		status = resp.GetStatus()
+	}
+	checker.update(status, err)
+}
+
+func (checker *healthChecker) update(status healthpb.HealthCheckResponse_ServingStatus, err error) {
+	checker.mu.Lock()
+	old := checker.status
+	checker.status = status
+	checker.lastErr = err
+	if old == status {
+		checker.mu.Unlock()
+		return
+	}
+
+	close(checker.changed)
+	checker.changed = make(chan struct{})
+	callbacks := append([]func(from, to healthpb.HealthCheckResponse_ServingStatus){}, checker.callbacks...)
+	checker.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(old, status)
+	}
+}
+
+// WaitForHealthy blocks until the target reports SERVING or ctx is done.
+// The checker must be running for the status to change.
+func (checker *healthChecker) WaitForHealthy(ctx context.Context) error {
+	for {
+		checker.mu.Lock()
+		status, lastErr, changed := checker.status, checker.lastErr, checker.changed
+		checker.mu.Unlock()
+
+		if status == healthpb.HealthCheckResponse_SERVING {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("target not healthy (status %v): %w", status, lastErr)
+			}
+			return fmt.Errorf("target not healthy (status %v): %w", status, ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// logHealthChange is an OnChange callback that logs status transitions.
+func logHealthChange(from, to healthpb.HealthCheckResponse_ServingStatus) {
+	log.Printf("Target health changed: %v -> %v", from, to)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Hedge counters on /debug/vars, to tune -hedge-delay against the extra load.
+var (
+	hedgesSent = expvar.NewInt("deepmgr_hedges_sent")
+	hedgesWon  = expvar.NewInt("deepmgr_hedges_won")
+)
+
+// hedgePolicy describes which unary calls get a second, hedged attempt.
+type hedgePolicy struct {
+	// delay is how long the first attempt may run before the hedge is sent.
+	delay time.Duration
+	// methods lists idempotent full method names; entries ending in "/" match a whole service.
+	methods []string
+}
+
+// idempotent reports whether method may safely be sent twice.
+func (policy hedgePolicy) idempotent(method string) bool {
+	for _, pattern := range policy.methods {
+		if method == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(method, pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hedgedConn sends a second attempt of slow idempotent unary calls and keeps whichever
+// answers first, cancelling the other. Both attempts go through the wrapped channel's
+// picker, so with a pool of more than one connection the hedge lands on another one.
+type hedgedConn struct {
+	clientConn
+	policy hedgePolicy
+}
+
+type hedgeResult struct {
+	reply  proto.Message
+	err    error
+	hedged bool
+}
+
+func (conn hedgedConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	msg, ok := reply.(proto.Message)
+	if !ok || conn.policy.delay <= 0 || !conn.policy.idempotent(method) {
+		return conn.clientConn.Invoke(ctx, method, args, reply, opts...)
+	}
+
+	// Cancelling on return stops whichever attempt lost
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	attempt := func(hedged bool) {
+		// Each attempt decodes into its own message so the loser can't clobber the reply
+		attemptReply := msg.ProtoReflect().New().Interface()
+		err := conn.clientConn.Invoke(ctx, method, args, attemptReply, opts...)
+		results <- hedgeResult{reply: attemptReply, err: err, hedged: hedged}
+	}
+	go attempt(false)
+
+	timer := time.NewTimer(conn.policy.delay)
+	defer timer.Stop()
+
+	// hedge is cleared once the hedge has been sent
+	hedge := timer.C
+	pending := 1
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-hedge:
+			hedge = nil
+			pending++
+			hedgesSent.Add(1)
+			go attempt(true)
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				// Fast failures are left to the retry interceptor rather than hedged
+				if hedge != nil {
+					return result.err
+				}
+				lastErr = result.err
+				continue
+			}
+
+			if result.hedged {
+				hedgesWon.Add(1)
+			}
+			proto.Reset(msg)
+			proto.Merge(msg, result.reply)
+			return nil
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const commandUsage = `commands:
+  list                         list the services exposed through server reflection
+  describe <symbol>            describe a service, method or message
+  call <service/method> <json> invoke a unary or server-streaming method ("-" reads JSON from stdin)`
+
+// runCommand runs a grpcurl-like debugging command against the target.
+func runCommand(ctx context.Context, conn grpc.ClientConnInterface, timeout time.Duration, args []string) error {
+	client, err := newReflectionClient(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer client.close()
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		services, err := client.listServices()
+		if err != nil {
+			return err
+		}
+		for _, service := range services {
+			fmt.Println(service)
+		}
+		return nil
+	case args[0] == "describe" && len(args) == 2:
+		descriptor, err := client.resolveSymbol(args[1])
+		if err != nil {
+			return err
+		}
+		describe(os.Stdout, descriptor)
+		return nil
+	case args[0] == "call" && len(args) == 3:
+		return invoke(ctx, conn, client, timeout, args[1], args[2])
+	default:
+		return fmt.Errorf("invalid command %q\n%s", strings.Join(args, " "), commandUsage)
+	}
+}
+
+// reflectionClient resolves descriptors through the grpc.reflection.v1 service.
+type reflectionClient struct {
+	stream  reflectionpb.ServerReflection_ServerReflectionInfoClient
+	cancel  context.CancelFunc
+	fetched map[string]*descriptorpb.FileDescriptorProto
+	files   *protoregistry.Files
+}
+
+func newReflectionClient(ctx context.Context, conn grpc.ClientConnInterface) (*reflectionClient, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open reflection stream: %w", err)
+	}
+	return &reflectionClient{
+		stream:  stream,
+		cancel:  cancel,
+		fetched: make(map[string]*descriptorpb.FileDescriptorProto),
+		files:   new(protoregistry.Files),
+	}, nil
+}
+
+func (client *reflectionClient) close() {
+	client.stream.CloseSend()
+	client.cancel()
+}
+
+func (client *reflectionClient) request(req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := client.stream.Send(req); err != nil {
+		return nil, fmt.Errorf("failed to send reflection request: %w", err)
+	}
+	resp, err := client.stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive reflection response: %w", err)
+	}
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", errResp.GetErrorCode(), errResp.GetErrorMessage())
+	}
+	return resp, nil
+}
+
+func (client *reflectionClient) listServices() ([]string, error) {
+	resp, err := client.request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// resolveSymbol returns the descriptor of a fully-qualified symbol, fetching and
+// registering the files that define it on first use.
+func (client *reflectionClient) resolveSymbol(symbol string) (protoreflect.Descriptor, error) {
+	name := protoreflect.FullName(strings.TrimPrefix(symbol, "."))
+	if descriptor, err := client.files.FindDescriptorByName(name); err == nil {
+		return descriptor, nil
+	}
+
+	resp, err := client.request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := client.storeFiles(resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := client.register(file); err != nil {
+			return nil, err
+		}
+	}
+
+	descriptor, err := client.files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("symbol %s not found: %w", name, err)
+	}
+	return descriptor, nil
+}
+
+// storeFiles keeps the file descriptors of a response and returns their names.
+func (client *reflectionClient) storeFiles(resp *reflectionpb.ServerReflectionResponse) ([]string, error) {
+	var names []string
+	for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		file := new(descriptorpb.FileDescriptorProto)
+		if err := proto.Unmarshal(raw, file); err != nil {
+			return nil, fmt.Errorf("failed to decode file descriptor: %w", err)
+		}
+		client.fetched[file.GetName()] = file
+		names = append(names, file.GetName())
+	}
+	return names, nil
+}
+
+// register adds a file and its dependencies to the local registry.
+func (client *reflectionClient) register(name string) error {
+	if _, err := client.files.FindFileByPath(name); err == nil {
+		return nil
+	}
+
+	// Well-known types linked into the binary need no round trip
+	if file, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+		return client.files.RegisterFile(file)
+	}
+
+	fileProto, ok := client.fetched[name]
+	if !ok {
+		resp, err := client.request(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := client.storeFiles(resp); err != nil {
+			return err
+		}
+		if fileProto, ok = client.fetched[name]; !ok {
+			return fmt.Errorf("server did not return file %s", name)
+		}
+	}
+
+	for _, dependency := range fileProto.GetDependency() {
+		if err := client.register(dependency); err != nil {
+			return err
+		}
+	}
+
+	file, err := protodesc.NewFile(fileProto, client.files)
+	if err != nil {
+		return fmt.Errorf("failed to build descriptor for %s: %w", name, err)
+	}
+	return client.files.RegisterFile(file)
+}
+
+// describe prints a short, proto-like summary of a descriptor.
+func describe(w io.Writer, descriptor protoreflect.Descriptor) {
+	switch d := descriptor.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(w, "service %s {\n", d.FullName())
+		for i := 0; i < d.Methods().Len(); i++ {
+			fmt.Fprintf(w, "  %s\n", methodSignature(d.Methods().Get(i)))
+		}
+		fmt.Fprintln(w, "}")
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintln(w, methodSignature(d))
+	case protoreflect.MessageDescriptor:
+		fmt.Fprintf(w, "message %s {\n", d.FullName())
+		for i := 0; i < d.Fields().Len(); i++ {
+			field := d.Fields().Get(i)
+			typeName := field.Kind().String()
+			if field.Message() != nil {
+				typeName = string(field.Message().FullName())
+			} else if field.Enum() != nil {
+				typeName = string(field.Enum().FullName())
+			}
+			fmt.Fprintf(w, "  %s %s %s = %d;\n", field.Cardinality(), typeName, field.Name(), field.Number())
+		}
+		fmt.Fprintln(w, "}")
+	default:
+		fmt.Fprintf(w, "%s (%T)\n", descriptor.FullName(), descriptor)
+	}
+}
+
+func methodSignature(method protoreflect.MethodDescriptor) string {
+	stream := func(streaming bool) string {
+		if streaming {
+			return "stream "
+		}
+		return ""
+	}
+	return fmt.Sprintf("rpc %s(%s%s) returns (%s%s);",
+		method.Name(),
+		stream(method.IsStreamingClient()), method.Input().FullName(),
+		stream(method.IsStreamingServer()), method.Output().FullName())
+}
+
+// invoke calls a method with a JSON request body and prints the JSON responses.
+func invoke(ctx context.Context, conn grpc.ClientConnInterface, client *reflectionClient, timeout time.Duration, fullMethod, body string) error {
+	serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return fmt.Errorf("method must be <service>/<method>, got %q", fullMethod)
+	}
+
+	descriptor, err := client.resolveSymbol(serviceName)
+	if err != nil {
+		return err
+	}
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", serviceName)
+	}
+	method := service.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return fmt.Errorf("service %s has no method %s", serviceName, methodName)
+	}
+	if method.IsStreamingClient() {
+		return fmt.Errorf("client-streaming method %s is not supported", fullMethod)
+	}
+
+	if body == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read request from stdin: %w", err)
+		}
+		body = string(data)
+	}
+	req := dynamicpb.NewMessage(method.Input())
+	if err := protojson.Unmarshal([]byte(body), req); err != nil {
+		return fmt.Errorf("invalid request JSON for %s: %w", method.Input().FullName(), err)
+	}
+
+	path := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+	printer := protojson.MarshalOptions{Multiline: true, Resolver: dynamicTypes{client.files}}
+
+	if !method.IsStreamingServer() {
+		resp := dynamicpb.NewMessage(method.Output())
+		err := callWithDeadline(ctx, timeout, path, func(ctx context.Context) error {
+			return conn.Invoke(ctx, path, req, resp)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(printer.Format(resp))
+		return nil
+	}
+
+	// Server streams may legitimately run for a long time, so no default deadline here
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, path)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		resp := dynamicpb.NewMessage(method.Output())
+		if err := stream.RecvMsg(resp); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		fmt.Println(printer.Format(resp))
+	}
+}
+
+// dynamicTypes resolves Any payloads against the reflected files.
+type dynamicTypes struct {
+	files *protoregistry.Files
+}
+
+func (types dynamicTypes) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	descriptor, err := types.files.FindDescriptorByName(name)
+	if err != nil {
+		return protoregistry.GlobalTypes.FindMessageByName(name)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewMessageType(message), nil
+}
+
+func (types dynamicTypes) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	name := url
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		name = url[i+1:]
+	}
+	return types.FindMessageByName(protoreflect.FullName(name))
+}
+
+func (types dynamicTypes) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByName(name)
+}
+
+func (types dynamicTypes) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+)
+
+const (
+	// jwtRefreshMargin re-signs tokens this long before they expire.
+	jwtRefreshMargin = time.Minute
+)
+
+// authConfig selects the per-RPC credentials sent on top of mTLS.
+type authConfig struct {
+	// mode is one of none, bearer, oauth2 or jwt.
+	mode string
+
+	token     string
+	tokenFile string
+
+	oauth2TokenURL     string
+	oauth2ClientID     string
+	oauth2ClientSecret string
+	oauth2Scopes       string
+
+	jwtKeyFile  string
+	jwtIssuer   string
+	jwtSubject  string
+	jwtAudience string
+	jwtTTL      time.Duration
+}
+
+// perRPCCredentials builds the credentials for the configured mode, or nil for none.
+func (cfg authConfig) perRPCCredentials(ctx context.Context) (credentials.PerRPCCredentials, error) {
+	switch cfg.mode {
+	case "", "none":
+		return nil, nil
+	case "bearer":
+		token := cfg.token
+		if cfg.tokenFile != "" {
+			data, err := os.ReadFile(cfg.tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token file: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("bearer auth requires a token")
+		}
+		return bearerToken(token), nil
+	case "oauth2":
+		oauthConfig := clientcredentials.Config{
+			ClientID:     cfg.oauth2ClientID,
+			ClientSecret: cfg.oauth2ClientSecret,
+			TokenURL:     cfg.oauth2TokenURL,
+			Scopes:       splitList(cfg.oauth2Scopes),
+		}
+		// The token source caches the token and refreshes it when it expires
+		return oauth.TokenSource{TokenSource: oauthConfig.TokenSource(ctx)}, nil
+	case "jwt":
+		return newJWTCredentials(cfg)
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.mode)
+	}
+}
+
+// bearerToken sends a static token in the Authorization header.
+type bearerToken string
+
+func (token bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(token)}, nil
+}
+
+func (token bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+// jwtCredentials signs short-lived JWTs with a private key and reuses each one until
+// shortly before it expires.
+type jwtCredentials struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	cfg    authConfig
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newJWTCredentials(cfg authConfig) (*jwtCredentials, error) {
+	data, err := os.ReadFile(cfg.jwtKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", cfg.jwtKeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT signing key: %w", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT signing key type %T", parsed)
+	}
+
+	var method jwt.SigningMethod
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch public.Curve.Params().BitSize {
+		case 256:
+			method = jwt.SigningMethodES256
+		case 384:
+			method = jwt.SigningMethodES384
+		default:
+			method = jwt.SigningMethodES512
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing key type %T", public)
+	}
+
+	return &jwtCredentials{key: key, method: method, cfg: cfg}, nil
+}
+
+func (creds *jwtCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	creds.mu.Lock()
+	defer creds.mu.Unlock()
+
+	if creds.token == "" || time.Until(creds.expires) < jwtRefreshMargin {
+		now := time.Now()
+		expires := now.Add(creds.cfg.jwtTTL)
+		claims := jwt.RegisteredClaims{
+			Issuer:    creds.cfg.jwtIssuer,
+			Subject:   creds.cfg.jwtSubject,
+			Audience:  jwt.ClaimStrings{creds.cfg.jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		}
+		token, err := jwt.NewWithClaims(creds.method, claims).SignedString(creds.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign JWT: %w", err)
+		}
+		creds.token, creds.expires = token, expires
+	}
+
+	return map[string]string{"authorization": "Bearer " + creds.token}, nil
+}
+
+func (creds *jwtCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// spiffeConfig selects X.509-SVIDs from the SPIFFE Workload API instead of static PEM files.
+type spiffeConfig struct {
+	// socket is the Workload API address, e.g. unix:///run/spire/agent.sock.
+	socket string
+	// serverID, when set, is the only SPIFFE ID accepted from the server.
+	serverID string
+	// trustDomain, when serverID is empty, accepts any server in this trust domain.
+	trustDomain string
+}
+
+// authorizer returns the server authorization policy for the configuration.
+func (cfg spiffeConfig) authorizer() (tlsconfig.Authorizer, error) {
+	if cfg.serverID != "" {
+		id, err := spiffeid.FromString(cfg.serverID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server SPIFFE ID: %w", err)
+		}
+		return tlsconfig.AuthorizeID(id), nil
+	}
+	if cfg.trustDomain != "" {
+		td, err := spiffeid.TrustDomainFromString(cfg.trustDomain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust domain: %w", err)
+		}
+		return tlsconfig.AuthorizeMemberOf(td), nil
+	}
+	return nil, fmt.Errorf("a server SPIFFE ID or trust domain is required")
+}
+
+// newSPIFFETLSConfig builds an mTLS client config from the Workload API. The X509Source keeps
+// the SVID and trust bundle rotated in the background until it is closed.
+func newSPIFFETLSConfig(ctx context.Context, cfg spiffeConfig, opts tlsOptions) (*tls.Config, *workloadapi.X509Source, error) {
+	authorizer, err := cfg.authorizer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(workloadapi.WithAddr(cfg.socket)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create X.509 source from %s: %w", cfg.socket, err)
+	}
+
+	tlsConfig := tlsconfig.MTLSClientConfig(source, source, authorizer)
+	opts.apply(tlsConfig)
+	return tlsConfig, source, nil
+}
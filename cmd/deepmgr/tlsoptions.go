@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsOptions hardens the TLS config built for the client beyond library defaults.
+type tlsOptions struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+	serverName   string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+}
+
+// parseTLSOptions parses the TLS flags. ciphers and curves are comma-separated names;
+// cipher suites only apply to TLS 1.2, as TLS 1.3 suites are not configurable.
+func parseTLSOptions(minVersion, ciphers, curves, serverName string) (tlsOptions, error) {
+	opts := tlsOptions{serverName: serverName}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return opts, fmt.Errorf("unsupported TLS version %q, want 1.2 or 1.3", minVersion)
+	}
+	opts.minVersion = version
+
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, name := range splitList(ciphers) {
+		id, ok := suites[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		opts.cipherSuites = append(opts.cipherSuites, id)
+	}
+
+	for _, name := range splitList(curves) {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown curve %q", name)
+		}
+		opts.curves = append(opts.curves, id)
+	}
+
+	return opts, nil
+}
+
+// apply sets the options on config, leaving unset options at their defaults.
+func (opts tlsOptions) apply(config *tls.Config) {
+	config.MinVersion = opts.minVersion
+	if len(opts.cipherSuites) > 0 {
+		config.CipherSuites = opts.cipherSuites
+	}
+	if len(opts.curves) > 0 {
+		config.CurvePreferences = opts.curves
+	}
+	if opts.serverName != "" {
+		config.ServerName = opts.serverName
+	}
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
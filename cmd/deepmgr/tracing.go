@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tracerName = "deepmgr"
+)
+
+// setupTracing installs a global tracer provider exporting spans over OTLP/gRPC to endpoint,
+// along with W3C trace-context propagation. The returned function flushes and stops it.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// tracingHandler is a client stats handler that records a span per RPC and
+// propagates the trace context to the server in the request metadata.
+type tracingHandler struct {
+	tracer trace.Tracer
+}
+
+var _ stats.Handler = (*tracingHandler)(nil)
+
+func newTracingHandler() *tracingHandler {
+	return &tracingHandler{tracer: otel.Tracer(tracerName)}
+}
+
+func (handler *tracingHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	name := strings.TrimPrefix(info.FullMethodName, "/")
+	service, method, _ := strings.Cut(name, "/")
+
+	ctx, _ = handler.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", method),
+		),
+	)
+
+	// Inject the trace headers into the outgoing metadata
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func (handler *tracingHandler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	span := trace.SpanFromContext(ctx)
+
+	switch rs := rs.(type) {
+	case *stats.OutHeader:
+		if rs.RemoteAddr == nil {
+			return
+		}
+		host, port, err := net.SplitHostPort(rs.RemoteAddr.String())
+		if err != nil {
+			return
+		}
+		span.SetAttributes(attribute.String("server.address", host))
+		if p, err := strconv.Atoi(port); err == nil {
+			span.SetAttributes(attribute.Int("server.port", p))
+		}
+	case *stats.End:
+		st := status.Convert(rs.Error)
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(st.Code())))
+		if rs.Error != nil {
+			span.SetStatus(otelcodes.Error, st.Message())
+		}
+		span.End()
+	}
+}
+
+func (handler *tracingHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (handler *tracingHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// metadataCarrier adapts gRPC metadata to an OpenTelemetry TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (carrier metadataCarrier) Get(key string) string {
+	values := metadata.MD(carrier).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (carrier metadataCarrier) Set(key, value string) {
+	metadata.MD(carrier).Set(key, value)
+}
+
+func (carrier metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/local"
+)
+
+// isUnixTarget reports whether target is a unix domain socket, e.g. unix:///var/run/envoy.sock.
+// grpc-go resolves these natively; only the dial options need adjusting.
+func isUnixTarget(target string) bool {
+	return strings.HasPrefix(target, "unix:") || strings.HasPrefix(target, "unix-abstract:")
+}
+
+// targetDialOptions returns opts adjusted for target. A sidecar listening on a unix
+// socket terminates mTLS itself, so by default the socket is spoken to with local
+// credentials, which still count as secure for per-RPC credentials. -uds-tls keeps mTLS
+// for sidecars that expect it; set -tls-server-name since there is no host to verify.
+func (cfg clientConfig) targetDialOptions(target string, opts []grpc.DialOption) []grpc.DialOption {
+	if !isUnixTarget(target) {
+		return opts
+	}
+
+	// Later options override earlier ones
+	opts = slices.Clip(opts)
+	if !cfg.udsTLS {
+		opts = append(opts, grpc.WithTransportCredentials(local.NewCredentials()))
+	}
+	if cfg.proxyDialer != nil {
+		// The socket is local; never tunnel it through the egress proxy
+		opts = append(opts, grpc.WithContextDialer(dialUnix))
+	}
+	return opts
+}
+
+func dialUnix(ctx context.Context, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", addr)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	xdscreds "google.golang.org/grpc/credentials/xds"
+
+	// Register the xds:/// resolver and the xDS load-balancing policies
+	_ "google.golang.org/grpc/xds"
+)
+
+const (
+	xdsScheme = "xds"
+	// xdsBootstrapEnv names the bootstrap file that points grpc-go at the control plane.
+	xdsBootstrapEnv = "GRPC_XDS_BOOTSTRAP"
+	// xdsBootstrapConfigEnv holds the bootstrap JSON inline instead.
+	xdsBootstrapConfigEnv = "GRPC_XDS_BOOTSTRAP_CONFIG"
+)
+
+// usesXDS reports whether any target is resolved through the xDS control plane.
+func usesXDS(targets []string) bool {
+	for _, target := range targets {
+		if strings.HasPrefix(target, xdsScheme+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// xdsCredentials lets the control plane push TLS requirements for xds:/// targets.
+// When the control plane sends no security config, fallback is used as is.
+func xdsCredentials(fallback credentials.TransportCredentials) (credentials.TransportCredentials, error) {
+	if os.Getenv(xdsBootstrapEnv) == "" && os.Getenv(xdsBootstrapConfigEnv) == "" {
+		return nil, fmt.Errorf("xds targets need %s or %s to locate the control plane", xdsBootstrapEnv, xdsBootstrapConfigEnv)
+	}
+
+	creds, err := xdscreds.NewClientCredentials(xdscreds.ClientOptions{FallbackCreds: fallback})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create xDS credentials: %w", err)
+	}
+	return creds, nil
+}
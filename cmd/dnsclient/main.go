@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/dnsclient"
+)
+
+// dnsConfig holds the dnsclient settings.
+type dnsConfig struct {
+	Type      string        `config:"type" usage:"record type: A, AAAA, SRV or NAPTR"`
+	Resolvers []string      `config:"resolvers" usage:"comma-separated DNS servers, empty uses /etc/resolv.conf"`
+	Timeout   time.Duration `config:"timeout" usage:"time to wait for each resolver"`
+}
+
+func (cfg *dnsConfig) Validate() error {
+	cfg.Type = strings.ToUpper(cfg.Type)
+	switch cfg.Type {
+	case "A", "AAAA", "SRV", "NAPTR":
+		return nil
+	default:
+		return fmt.Errorf("unsupported record type %q", cfg.Type)
+	}
+}
+
+func main() {
+	cfg := dnsConfig{Type: "A", Timeout: 2 * time.Second}
+	names, err := config.Load("dnsclient", &cfg, os.Args[1:])
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	if len(names) == 0 {
+		log.Fatal("please provide at least one name")
+	}
+
+	client, err := dnsclient.New(dnsclient.Config{Resolvers: cfg.Resolvers, Timeout: cfg.Timeout})
+	if err != nil {
+		log.Fatal("cannot create DNS client: ", err)
+	}
+
+	failed := false
+	for _, name := range names {
+		if err := lookup(context.Background(), client, cfg.Type, name); err != nil {
+			log.Printf("Error looking up %s: %v\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// lookup prints the records of one name, one per line.
+func lookup(ctx context.Context, client *dnsclient.Client, recordType, name string) error {
+	switch recordType {
+	case "A", "AAAA":
+		lookupIP := client.LookupA
+		if recordType == "AAAA" {
+			lookupIP = client.LookupAAAA
+		}
+		ips, err := lookupIP(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			fmt.Printf("%s\t%s\t%s\n", name, recordType, ip)
+		}
+	case "SRV":
+		srvs, err := client.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return err
+		}
+		for _, srv := range srvs {
+			fmt.Printf("%s\tSRV\t%d %d %d %s\n", name, srv.Priority, srv.Weight, srv.Port, srv.Target)
+		}
+	case "NAPTR":
+		naptrs, err := client.LookupNAPTR(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, naptr := range naptrs {
+			fmt.Printf("%s\tNAPTR\t%d %d %q %q %q %s\n", name, naptr.Order, naptr.Preference, naptr.Flags, naptr.Service, naptr.Regexp, naptr.Replacement)
+		}
+	}
+	return nil
+}
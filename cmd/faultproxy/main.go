@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/faultproxy"
+)
+
+// faultproxyConfig holds the fault-injection proxy settings.
+type faultproxyConfig struct {
+	Protocol      string        `config:"protocol" usage:"tcp or udp"`
+	Listen        string        `config:"listen" usage:"local host:port to accept clients on" required:"true"`
+	Remote        string        `config:"remote" usage:"server host:port to relay to" required:"true"`
+	Latency       time.Duration `config:"latency" usage:"delay added to every packet in each direction"`
+	Jitter        time.Duration `config:"jitter" usage:"random extra delay of up to this much"`
+	Loss          float64       `config:"loss" usage:"percentage of packets lost; TCP chunks are delayed by a retransmission instead"`
+	Reorder       float64       `config:"reorder" usage:"percentage of UDP datagrams sent without delay, overtaking others"`
+	Bandwidth     int64         `config:"bandwidth" usage:"bits per second in each direction, 0 for unlimited"`
+	Reset         float64       `config:"reset" usage:"percentage of TCP chunks after which the connection is reset"`
+	StatsInterval time.Duration `config:"stats-interval" usage:"how often fault counts are logged, 0 to never"`
+}
+
+func (cfg *faultproxyConfig) Validate() error {
+	switch cfg.Protocol {
+	case "tcp":
+	case "udp":
+		if cfg.Reset > 0 {
+			return errors.New("reset applies to tcp only")
+		}
+	default:
+		return fmt.Errorf("protocol must be tcp or udp, got %q", cfg.Protocol)
+	}
+	return cfg.faults().Validate()
+}
+
+func (cfg *faultproxyConfig) faults() faultproxy.Faults {
+	return faultproxy.Faults{
+		Latency:   cfg.Latency,
+		Jitter:    cfg.Jitter,
+		Loss:      cfg.Loss,
+		Reorder:   cfg.Reorder,
+		Bandwidth: cfg.Bandwidth,
+		Reset:     cfg.Reset,
+	}
+}
+
+// proxy is what the TCP and UDP proxies have in common.
+type proxy interface {
+	Addr() net.Addr
+	Serve(ctx context.Context) error
+}
+
+func main() {
+	cfg := faultproxyConfig{Protocol: "tcp", StatsInterval: time.Minute}
+	if _, err := config.Load("faultproxy", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	var p proxy
+	var stats *faultproxy.Stats
+	if cfg.Protocol == "udp" {
+		udpProxy, err := faultproxy.ListenUDP(cfg.Listen, cfg.Remote, cfg.faults())
+		if err != nil {
+			log.Fatal("cannot start proxy: ", err)
+		}
+		p, stats = udpProxy, &udpProxy.Stats
+	} else {
+		tcpProxy, err := faultproxy.ListenTCP(cfg.Listen, cfg.Remote, cfg.faults())
+		if err != nil {
+			log.Fatal("cannot start proxy: ", err)
+		}
+		p, stats = tcpProxy, &tcpProxy.Stats
+	}
+	log.Printf("Fault proxy listening on %s/%s, relaying to %s with %s\n", p.Addr(), cfg.Protocol, cfg.Remote, cfg.faults())
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.StatsInterval > 0 {
+		go logStats(ctx, cfg.StatsInterval, stats)
+	}
+
+	if err := p.Serve(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Fault proxy stopped:", stats)
+}
+
+// logStats logs the fault counts every interval until ctx is done.
+func logStats(ctx context.Context, interval time.Duration, stats *faultproxy.Stats) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Println(stats)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/fuzzer"
+)
+
+// fuzzerConfig holds the fuzzer settings.
+type fuzzerConfig struct {
+	Protocol   string        `config:"protocol" usage:"line, length, sip or sdp" required:"true"`
+	Addr       string        `config:"addr" usage:"host:port of a listener to fuzz; the parsers are fuzzed in-process by go test -fuzz" required:"true"`
+	Network    string        `config:"network" usage:"tcp or udp, for addr"`
+	Corpus     string        `config:"corpus" usage:"directory keeping the corpus, crashers and hangs; empty keeps them in memory"`
+	Iterations int           `config:"iterations" usage:"inputs to run, 0 to run until interrupted or duration passes"`
+	Duration   time.Duration `config:"duration" usage:"how long to fuzz, 0 for no limit"`
+	Timeout    time.Duration `config:"timeout" usage:"time after which an input counts as a hang"`
+	Seed       uint          `config:"seed" usage:"random seed for a reproducible run, 0 picks one"`
+	MaxSize    int           `config:"max-size" usage:"largest input generated, in bytes"`
+}
+
+func (cfg *fuzzerConfig) Validate() error {
+	if !slices.Contains(fuzzer.Protocols(), cfg.Protocol) {
+		return fmt.Errorf("protocol must be one of %s, got %q", strings.Join(fuzzer.Protocols(), ", "), cfg.Protocol)
+	}
+	if cfg.Network != "tcp" && cfg.Network != "udp" {
+		return fmt.Errorf("network must be tcp or udp, got %q", cfg.Network)
+	}
+	if cfg.Iterations < 0 || cfg.Duration < 0 {
+		return fmt.Errorf("iterations and duration cannot be negative")
+	}
+	return nil
+}
+
+func main() {
+	cfg := fuzzerConfig{Network: "tcp", Timeout: 2 * time.Second, MaxSize: 4096}
+	if _, err := config.Load("fuzzer", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	corpus, err := fuzzer.OpenCorpus(cfg.Corpus)
+	if err != nil {
+		log.Fatal("cannot open corpus: ", err)
+	}
+
+	opts := fuzzer.Options{Protocol: cfg.Protocol, Corpus: corpus, Iterations: cfg.Iterations, Seed: uint64(cfg.Seed), MaxSize: cfg.MaxSize, StopOnCrash: true}
+	if cfg.Network == "udp" {
+		seeds, err := fuzzer.Seeds(cfg.Protocol)
+		if err != nil {
+			log.Fatal("invalid configuration: ", err)
+		}
+		opts.Target = &fuzzer.UDPTarget{Addr: cfg.Addr, Probe: seeds[0], Timeout: cfg.Timeout}
+	} else {
+		opts.Target = &fuzzer.TCPTarget{Addr: cfg.Addr, Timeout: cfg.Timeout}
+	}
+
+	// Stop on an interrupt signal or when the duration is up
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if cfg.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	log.Printf("Fuzzing %s as %s with %d corpus inputs\n", cfg.Addr, cfg.Protocol, corpus.Len())
+
+	stats, err := fuzzer.Run(ctx, opts, func(finding fuzzer.Finding) {
+		log.Printf("Found %s (%d bytes) saved to %q: %v\n", finding.Outcome, len(finding.Input), finding.Path, finding.Err)
+	})
+	if err != nil {
+		log.Fatal("fuzzing failed: ", err)
+	}
+	log.Println("Done:", stats)
+	if stats.Crashes > 0 || stats.Hangs > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/holepunch"
+)
+
+// punchConfig holds the holepunch settings.
+type punchConfig struct {
+	Server     bool          `config:"server" usage:"run the rendezvous server instead of a peer"`
+	Addr       string        `config:"addr" usage:"UDP address the rendezvous server listens on"`
+	Rendezvous string        `config:"rendezvous" usage:"rendezvous server host:port"`
+	ID         string        `config:"id" usage:"this peer's ID"`
+	Peer       string        `config:"peer" usage:"ID of the peer to connect to"`
+	LocalAddr  string        `config:"local-addr" usage:"local UDP address to bind"`
+	Timeout    time.Duration `config:"timeout" usage:"how long to wait for the peer"`
+	Keepalive  time.Duration `config:"keepalive" usage:"time between keepalives once connected"`
+}
+
+func (cfg *punchConfig) Validate() error {
+	if cfg.Server {
+		return nil
+	}
+	if cfg.Rendezvous == "" || cfg.ID == "" || cfg.Peer == "" {
+		return errors.New("rendezvous, id and peer are required in peer mode")
+	}
+	return nil
+}
+
+func main() {
+	cfg := punchConfig{Addr: ":3479", Timeout: 30 * time.Second, Keepalive: 15 * time.Second}
+	if _, err := config.Load("holepunch", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.Server {
+		server, err := holepunch.Listen(cfg.Addr)
+		if err != nil {
+			log.Fatal("cannot start rendezvous server: ", err)
+		}
+		log.Println("Rendezvous server listening on", server.Addr())
+		if err := server.Serve(ctx); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	connectCtx, cancelConnect := context.WithTimeout(ctx, cfg.Timeout)
+	conn, err := holepunch.Connect(connectCtx, holepunch.Options{
+		ID:                cfg.ID,
+		Peer:              cfg.Peer,
+		Rendezvous:        cfg.Rendezvous,
+		LocalAddr:         cfg.LocalAddr,
+		KeepaliveInterval: cfg.Keepalive,
+	})
+	cancelConnect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	log.Printf("Connected to %s at %s (our public endpoint %s)\n", cfg.Peer, conn.PeerAddr(), conn.PublicAddr())
+
+	// Print what the peer sends and send it every line from stdin
+	go func() {
+		for {
+			data, err := conn.Receive(ctx)
+			if err != nil {
+				return
+			}
+			fmt.Printf("%s: %s\n", cfg.Peer, data)
+		}
+	}()
+
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() && ctx.Err() == nil {
+		if err := conn.Send(lines.Bytes()); err != nil {
+			log.Printf("Error sending to peer: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/iperf"
+)
+
+// iperfConfig holds the iperf settings.
+type iperfConfig struct {
+	Server     bool          `config:"server" usage:"run as a server instead of a client"`
+	Addr       string        `config:"addr" usage:"address to listen on, or the server to test against"`
+	Mode       string        `config:"mode" usage:"upload (client sends) or download (server sends)"`
+	Protocol   string        `config:"protocol" usage:"tcp or udp"`
+	Duration   time.Duration `config:"duration" usage:"how long to send data"`
+	Bitrate    int64         `config:"bitrate" usage:"UDP send rate in bits per second"`
+	PacketSize int           `config:"packet-size" usage:"UDP datagram size in bytes"`
+}
+
+func (cfg *iperfConfig) Validate() error {
+	if cfg.Addr == "" {
+		return errors.New("addr is required")
+	}
+	return nil
+}
+
+func main() {
+	cfg := iperfConfig{
+		Addr:       ":5201",
+		Mode:       iperf.ModeUpload,
+		Protocol:   iperf.ProtocolTCP,
+		Duration:   10 * time.Second,
+		Bitrate:    10_000_000,
+		PacketSize: 1200,
+	}
+	if _, err := config.Load("iperf", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.Server {
+		server, err := iperf.Listen(cfg.Addr)
+		if err != nil {
+			log.Fatal("cannot start server: ", err)
+		}
+		log.Println("iperf server listening on", server.Addr())
+		if err := server.Serve(ctx); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	req := iperf.Request{
+		Mode:       cfg.Mode,
+		Protocol:   cfg.Protocol,
+		Duration:   cfg.Duration,
+		Bitrate:    cfg.Bitrate,
+		PacketSize: cfg.PacketSize,
+	}
+	report, err := iperf.Run(ctx, cfg.Addr, req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s %s to %s: %s\n", cfg.Protocol, cfg.Mode, cfg.Addr, report)
+}
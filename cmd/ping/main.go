@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/ping"
+)
+
+// pingConfig holds the ping settings.
+type pingConfig struct {
+	Count    int           `config:"count" usage:"number of echo requests"`
+	Interval time.Duration `config:"interval" usage:"time between requests"`
+	Timeout  time.Duration `config:"timeout" usage:"time to wait for each reply"`
+	Size     int           `config:"size" usage:"payload size in bytes"`
+}
+
+func main() {
+	cfg := pingConfig{Count: 4, Interval: time.Second, Timeout: time.Second, Size: 56}
+	args, err := config.Load("ping", &cfg, os.Args[1:])
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	if len(args) != 1 {
+		log.Fatal("please provide a host")
+	}
+
+	// Stop early on an interrupt signal and print what we have
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	opts := ping.Options{Count: cfg.Count, Interval: cfg.Interval, Timeout: cfg.Timeout, Size: cfg.Size}
+	stats, err := ping.PingWithReplies(ctx, args[0], opts, func(seq int, rtt time.Duration) {
+		fmt.Printf("reply from %s: seq=%d time=%v\n", args[0], seq, rtt)
+	})
+	if stats != nil {
+		fmt.Println(stats)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+	if stats == nil || stats.Received == 0 {
+		os.Exit(1)
+	}
+}
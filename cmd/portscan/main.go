@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/pool"
+)
+
+const (
+	maxBannerBytes = 256
+)
+
+// scanConfig holds the portscan settings.
+type scanConfig struct {
+	Ports       string        `config:"ports" usage:"ports and ranges to scan, e.g. 22,80,8000-8100"`
+	Concurrency int           `config:"concurrency" usage:"number of ports probed at once"`
+	Timeout     time.Duration `config:"timeout" usage:"connect timeout per port"`
+	Banner      bool          `config:"banner" usage:"read what each open port sends first"`
+	BannerWait  time.Duration `config:"banner-wait" usage:"how long to wait for a banner"`
+	ShowClosed  bool          `config:"show-closed" usage:"also list closed and filtered ports"`
+}
+
+func (cfg *scanConfig) Validate() error {
+	if cfg.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1, got %d", cfg.Concurrency)
+	}
+	_, err := parsePorts(cfg.Ports)
+	return err
+}
+
+// scanResult is the outcome of probing one port.
+type scanResult struct {
+	host   string
+	port   int
+	open   bool
+	err    error
+	banner string
+}
+
+// Task implementation for probing a single port
+type ScanTask struct {
+	host    string
+	port    int
+	cfg     *scanConfig
+	results chan<- scanResult
+}
+
+func (task *ScanTask) Run(ctx context.Context) error {
+	result := scanResult{host: task.host, port: task.port}
+	defer func() { task.results <- result }()
+
+	addr := net.JoinHostPort(task.host, strconv.Itoa(task.port))
+	conn, err := net.DialTimeout("tcp", addr, task.cfg.Timeout)
+	if err != nil {
+		result.err = err
+		return nil
+	}
+	defer conn.Close()
+	result.open = true
+
+	if !task.cfg.Banner {
+		return nil
+	}
+
+	// Many services (SSH, SMTP, FTP) announce themselves without being asked
+	conn.SetReadDeadline(time.Now().Add(task.cfg.BannerWait))
+	buf := make([]byte, maxBannerBytes)
+	n, _ := conn.Read(buf)
+	result.banner = printable(buf[:n])
+	return nil
+}
+
+func main() {
+	cfg := scanConfig{
+		Ports:       "1-1024",
+		Concurrency: 100,
+		Timeout:     time.Second,
+		BannerWait:  2 * time.Second,
+	}
+	hosts, err := config.Load("portscan", &cfg, os.Args[1:])
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	if len(hosts) == 0 {
+		log.Fatal("please provide at least one host")
+	}
+	ports, _ := parsePorts(cfg.Ports)
+
+	// Collect results while the pool is still busy
+	results := make(chan scanResult, cfg.Concurrency)
+	var collected []scanResult
+	done := make(chan struct{})
+	go func() {
+		for result := range results {
+			collected = append(collected, result)
+		}
+		close(done)
+	}()
+
+	start := time.Now()
+	workers := pool.New(cfg.Concurrency)
+	workers.Run()
+	for _, host := range hosts {
+		for _, port := range ports {
+			workers.Submit(&ScanTask{host: host, port: port, cfg: &cfg, results: results})
+		}
+	}
+	workers.Close()
+	workers.Wait()
+	close(results)
+	<-done
+
+	sort.Slice(collected, func(i, j int) bool {
+		if collected[i].host != collected[j].host {
+			return collected[i].host < collected[j].host
+		}
+		return collected[i].port < collected[j].port
+	})
+
+	open := 0
+	for _, result := range collected {
+		switch {
+		case result.open:
+			open++
+			fmt.Printf("%s:%d open", result.host, result.port)
+			if result.banner != "" {
+				fmt.Printf("  %q", result.banner)
+			}
+			fmt.Println()
+		case cfg.ShowClosed:
+			fmt.Printf("%s:%d %s\n", result.host, result.port, portState(result.err))
+		}
+	}
+	log.Printf("scanned %d ports on %d hosts in %v, %d open\n", len(collected), len(hosts), time.Since(start).Round(time.Millisecond), open)
+}
+
+// parsePorts parses a comma-separated list of ports and inclusive ranges.
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(high); err != nil {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		if first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		for port := first; port <= last; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports to scan")
+	}
+	return ports, nil
+}
+
+// portState tells closed ports (refused) apart from filtered ones (no answer).
+func portState(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "filtered"
+	}
+	return "closed"
+}
+
+// printable trims a banner and replaces non-printable bytes.
+func printable(banner []byte) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return '.'
+	}, strings.TrimSpace(string(banner)))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/pool"
+	"github.com/blueai2022/net_prg/quicdemo"
+)
+
+// demoConfig holds the quicdemo settings.
+type demoConfig struct {
+	Server   bool   `config:"server" usage:"run the QUIC echo server instead of the client"`
+	Addr     string `config:"addr" usage:"UDP host:port to listen on, or the server to connect to"`
+	Workers  int    `config:"workers" usage:"worker goroutines handling streams on the server"`
+	CertFile string `config:"cert" usage:"server certificate file, empty generates a self-signed one"`
+	KeyFile  string `config:"key" usage:"server key file"`
+
+	Requests    int           `config:"requests" usage:"echo requests to send"`
+	Concurrency int           `config:"concurrency" usage:"requests in flight at once"`
+	Message     string        `config:"message" usage:"line sent with every request"`
+	Timeout     time.Duration `config:"timeout" usage:"timeout for each request"`
+	CAFile      string        `config:"ca" usage:"CA file verifying the server certificate"`
+	Insecure    bool          `config:"insecure" usage:"skip server certificate verification"`
+	TCPAddr     string        `config:"tcp-addr" usage:"concurtcp server to run the same requests against for comparison"`
+}
+
+func (cfg *demoConfig) Validate() error {
+	if cfg.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	return nil
+}
+
+func main() {
+	cfg := demoConfig{Addr: "localhost:4242", Workers: 5, Requests: 100, Concurrency: 1, Timeout: 5 * time.Second}
+	if _, err := config.Load("quicdemo", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.Server {
+		serve(ctx, cfg)
+		return
+	}
+
+	opts := quicdemo.Options{
+		Requests:    cfg.Requests,
+		Concurrency: cfg.Concurrency,
+		Message:     cfg.Message,
+		Timeout:     cfg.Timeout,
+		CAFile:      cfg.CAFile,
+		Insecure:    cfg.Insecure,
+	}
+	result, err := quicdemo.Run(ctx, cfg.Addr, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(result)
+
+	if cfg.TCPAddr != "" {
+		result, err := quicdemo.RunTCP(ctx, cfg.TCPAddr, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(result)
+	}
+}
+
+func serve(ctx context.Context, cfg demoConfig) {
+	// Create a worker pool with a fixed number of workers
+	workers := pool.New(cfg.Workers)
+	workers.Run()
+
+	server, err := quicdemo.Listen(cfg.Addr, cfg.CertFile, cfg.KeyFile, workers)
+	if err != nil {
+		log.Fatal("cannot start server: ", err)
+	}
+	log.Println("QUIC server started listening on", server.Addr())
+
+	if err := server.Serve(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Shutting down server...")
+	workers.Close()
+	workers.Wait()
+	log.Println("Server shutdown complete.")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/pool"
+	"github.com/blueai2022/net_prg/revproxy"
+)
+
+const (
+	modeTCP  = "tcp"
+	modeHTTP = "http"
+)
+
+// proxyConfig holds the revproxy settings.
+type proxyConfig struct {
+	Addr           string        `config:"addr" usage:"host:port to listen on" required:"true"`
+	Backends       []string      `config:"backends" usage:"comma-separated backend host:port addresses" required:"true"`
+	Mode           string        `config:"mode" usage:"tcp to forward connections, http to forward requests"`
+	Policy         string        `config:"policy" usage:"round-robin or least-connections"`
+	Workers        int           `config:"workers" usage:"connections proxied at once in tcp mode"`
+	DialTimeout    time.Duration `config:"dial-timeout" usage:"timeout for connecting to a backend"`
+	HealthInterval time.Duration `config:"health-interval" usage:"time between backend health checks"`
+	HealthTimeout  time.Duration `config:"health-timeout" usage:"timeout for each health check"`
+	HealthPath     string        `config:"health-path" usage:"path probed with GET in http mode, empty checks TCP connects"`
+	MetricsAddr    string        `config:"metrics-addr" usage:"host:port serving per-backend metrics at /debug/vars, empty disables"`
+}
+
+func (cfg *proxyConfig) Validate() error {
+	if cfg.Mode != modeTCP && cfg.Mode != modeHTTP {
+		return fmt.Errorf("mode must be %s or %s, got %q", modeTCP, modeHTTP, cfg.Mode)
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	if cfg.HealthInterval <= 0 {
+		return fmt.Errorf("health-interval must be positive, got %v", cfg.HealthInterval)
+	}
+	return nil
+}
+
+// Task implementation for proxying a connection
+type ProxyTask struct {
+	conn     net.Conn
+	balancer *revproxy.Balancer
+	cfg      *proxyConfig
+}
+
+func (task *ProxyTask) Run(ctx context.Context) error {
+	if err := task.balancer.ProxyTCP(ctx, task.conn, task.cfg.DialTimeout); err != nil {
+		return fmt.Errorf("failed to proxy %s: %w", task.conn.RemoteAddr(), err)
+	}
+	return nil
+}
+
+func main() {
+	cfg := proxyConfig{
+		Mode:           modeTCP,
+		Policy:         revproxy.RoundRobin,
+		Workers:        100,
+		DialTimeout:    5 * time.Second,
+		HealthInterval: 10 * time.Second,
+		HealthTimeout:  2 * time.Second,
+	}
+	if _, err := config.Load("revproxy", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	balancer, err := revproxy.NewBalancer(cfg.Backends, cfg.Policy)
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	balancer.Publish("revproxy_backends")
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	check := revproxy.TCPCheck
+	if cfg.Mode == modeHTTP && cfg.HealthPath != "" {
+		check = revproxy.HTTPCheck(cfg.HealthPath)
+	}
+	go balancer.HealthCheck(ctx, cfg.HealthInterval, cfg.HealthTimeout, check)
+
+	// Serve metrics from the default mux, where expvar registers /debug/vars
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
+				log.Printf("Error serving metrics: %v\n", err)
+			}
+		}()
+	}
+
+	if cfg.Mode == modeHTTP {
+		serveHTTP(ctx, cfg, balancer)
+		return
+	}
+	serveTCP(ctx, cfg, balancer)
+}
+
+// serveTCP accepts connections and proxies each one on the worker pool.
+func serveTCP(ctx context.Context, cfg proxyConfig, balancer *revproxy.Balancer) {
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatal("cannot listen on address ", cfg.Addr)
+	}
+	log.Println("TCP proxy started listening on", listener.Addr())
+
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	// Create a worker pool with one worker per concurrently proxied connection
+	workers := pool.New(cfg.Workers)
+	workers.RunContext(ctx)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Println("cannot accept connection on listener", err)
+			continue
+		}
+
+		// Create a new task for each connection and add it to the pool
+		workers.Submit(&ProxyTask{conn: conn, balancer: balancer, cfg: &cfg})
+	}
+
+	log.Println("Shutting down proxy...")
+	workers.Close()
+	workers.Wait()
+	log.Println("Proxy shutdown complete.")
+}
+
+// serveHTTP forwards requests until ctx is done, then lets in-flight requests finish.
+func serveHTTP(ctx context.Context, cfg proxyConfig, balancer *revproxy.Balancer) {
+	server := &http.Server{Addr: cfg.Addr, Handler: balancer.HTTPHandler()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("HTTP proxy started listening on", cfg.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("cannot serve HTTP: ", err)
+	}
+	<-done
+	log.Println("Proxy shutdown complete.")
+}
@@ -0,0 +1,67 @@
+//go:build portaudio
+
+package main
+
+import (
+	"errors"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// initAudio initializes PortAudio, returning the function that terminates it.
+func initAudio() (func() error, error) {
+	if err := portaudio.Initialize(); err != nil {
+		return nil, err
+	}
+	return portaudio.Terminate, nil
+}
+
+// portaudioDevice captures and plays a call's audio on the default sound devices, a
+// packet's worth of samples at a time.
+type portaudioDevice struct {
+	capture, playback *portaudio.Stream
+	in, out           []int16
+}
+
+// openAudio opens and starts blocking capture and playback streams.
+func openAudio() (audioDevice, error) {
+	device := &portaudioDevice{in: make([]int16, rtpFrameSamples), out: make([]int16, rtpFrameSamples)}
+	var err error
+	if device.capture, err = portaudio.OpenDefaultStream(1, 0, rtpClockRate, rtpFrameSamples, device.in); err != nil {
+		return nil, err
+	}
+	if device.playback, err = portaudio.OpenDefaultStream(0, 1, rtpClockRate, rtpFrameSamples, device.out); err != nil {
+		device.capture.Close()
+		return nil, err
+	}
+	if err := errors.Join(device.capture.Start(), device.playback.Start()); err != nil {
+		device.Close()
+		return nil, err
+	}
+	return device, nil
+}
+
+func (device *portaudioDevice) Read(frame []int16) error {
+	if err := device.capture.Read(); err != nil {
+		return err
+	}
+	copy(frame, device.in)
+	return nil
+}
+
+// Write plays samples a stream buffer at a time, padding the last with silence.
+func (device *portaudioDevice) Write(samples []int16) error {
+	for len(samples) > 0 {
+		n := copy(device.out, samples)
+		clear(device.out[n:])
+		samples = samples[n:]
+		if err := device.playback.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (device *portaudioDevice) Close() error {
+	return errors.Join(device.capture.Close(), device.playback.Close())
+}
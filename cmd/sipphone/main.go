@@ -0,0 +1,1010 @@
+// Command sipphone registers with a SIP registrar, places a call to a callee and answers
+// incoming calls, sending and playing 8 kHz audio over RTP.
+//
+// Audio goes through the PortAudio C library when built with the portaudio build tag, as
+// in go build -tags portaudio ./cmd/sipphone; without it calls send silence and play
+// nothing, so the phone builds where PortAudio is not installed.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/dnsclient"
+	"github.com/blueai2022/net_prg/lifecycle"
+	"github.com/blueai2022/net_prg/mdns"
+	"github.com/blueai2022/net_prg/ping"
+	"github.com/blueai2022/net_prg/telemetry"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/opus"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+)
+
+// phoneConfig holds the SIP phone settings.
+type phoneConfig struct {
+	RegisterURI  string `config:"register-uri" usage:"SIP registrar URI"`
+	Username     string `config:"username" usage:"SIP username" required:"true"`
+	Password     string `config:"password" usage:"SIP password" required:"true"`
+	Callee       string `config:"callee" usage:"SIP URI to call"`
+	STUNServer   string `config:"stun-server" usage:"STUN server host:port, e.g. a local stunserver in CI"`
+	TURNServer   string `config:"turn-server" usage:"TURN server host:port used when STUN fails"`
+	TURNUsername string `config:"turn-username" usage:"TURN username"`
+	TURNPassword string `config:"turn-password" usage:"TURN password"`
+	PingPrecheck bool   `config:"ping-precheck" usage:"ping the registrar before registering"`
+
+	DNSResolvers []string `config:"dns-resolvers" usage:"comma-separated DNS servers for NAPTR/SRV lookups, empty uses /etc/resolv.conf"`
+
+	MQTTBroker   string        `config:"mqtt-broker" usage:"MQTT broker URL for call events, e.g. tcp://broker:1883; empty disables"`
+	MQTTTopic    string        `config:"mqtt-topic" usage:"telemetry topic template with {host}, {service} and {kind} placeholders"`
+	MQTTInterval time.Duration `config:"mqtt-interval" usage:"how often call quality is published during a call"`
+
+	MDNSInstance string `config:"mdns-instance" usage:"instance name advertised as _sip._udp over mDNS; empty disables"`
+	SIPPort      int    `config:"sip-port" usage:"local UDP port incoming calls are answered on and advertised over mDNS"`
+
+	DrainTimeout time.Duration `config:"drain-timeout" usage:"how long hanging up and flushing call events may take on shutdown"`
+}
+
+// settings is loaded once in main and read by the NAT traversal helpers.
+var settings = phoneConfig{
+	RegisterURI: "sip:example.com",
+	Callee:      "sip:bob@example.com",
+	STUNServer:  "stun.l.google.com:19302",
+	TURNServer:  "turn.example.com:3478",
+
+	MQTTInterval: 10 * time.Second,
+	SIPPort:      5060,
+	DrainTimeout: 5 * time.Second,
+}
+
+// publisher sends call events and call quality over MQTT; nil when no broker is configured.
+var publisher *telemetry.Publisher
+
+// resolver answers the NAPTR, SRV and address lookups; it caches them across calls.
+var resolver *dnsclient.Client
+
+// naptrTransports maps SIP NAPTR services to transports (RFC 3263 section 4.1).
+var naptrTransports = map[string]string{
+	"SIP+D2U":  "udp",
+	"SIP+D2T":  "tcp",
+	"SIPS+D2T": "tls",
+}
+
+// sipTarget is the server SIP requests for a URI are sent to.
+type sipTarget struct {
+	Host      string
+	Port      int
+	Transport string
+}
+
+func (target sipTarget) String() string {
+	return fmt.Sprintf("%s:%d over %s", target.Host, target.Port, target.Transport)
+}
+
+// route sends req to the target, as the registrar is sent every request the phone makes.
+func (target sipTarget) route(req *sip.Request) {
+	req.SetDestination(net.JoinHostPort(target.Host, strconv.Itoa(target.Port)))
+	req.SetTransport(strings.ToUpper(target.Transport))
+}
+
+func main() {
+	if _, err := config.Load("sip", &settings, os.Args[1:]); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var registerURI, callee sip.Uri
+	if err := sip.ParseUri(settings.RegisterURI, &registerURI); err != nil {
+		log.Fatalf("Invalid register URI %s: %v", settings.RegisterURI, err)
+	}
+	if err := sip.ParseUri(settings.Callee, &callee); err != nil {
+		log.Fatalf("Invalid callee %s: %v", settings.Callee, err)
+	}
+
+	// Hang up and release everything below in reverse on an interrupt signal
+	lc := lifecycle.New(settings.DrainTimeout)
+
+	var err error
+	resolver, err = dnsclient.New(dnsclient.Config{Resolvers: settings.DNSResolvers})
+	if err != nil {
+		log.Fatalf("Failed to create DNS client: %v", err)
+	}
+
+	// Connect to the telemetry broker if one is configured
+	if settings.MQTTBroker != "" {
+		publisher, err = telemetry.Connect(telemetry.Config{Broker: settings.MQTTBroker, Service: "sip", Topic: settings.MQTTTopic})
+		if err != nil {
+			log.Fatalf("Failed to connect to MQTT broker: %v", err)
+		}
+		lc.OnShutdown("telemetry", func(ctx context.Context) error {
+			publisher.Close()
+			return nil
+		})
+	}
+
+	// Initialize the sound devices
+	terminateAudio, err := initAudio()
+	if err != nil {
+		log.Fatalf("Failed to initialize audio: %v", err)
+	}
+	lc.OnShutdown("audio", func(ctx context.Context) error {
+		return terminateAudio()
+	})
+
+	// Locate the registrar through NAPTR and SRV records
+	registrar, err := resolveSIPTarget(context.Background(), settings.RegisterURI)
+	if err != nil {
+		log.Fatalf("Failed to resolve registrar: %v", err)
+	}
+	fmt.Println("Registrar server:", registrar)
+
+	// Warn early when the registrar does not answer ICMP; it may still accept SIP
+	if settings.PingPrecheck {
+		if err := ping.Reachable(context.Background(), registrar.Host, 2*time.Second); err != nil {
+			log.Printf("Registrar reachability pre-check failed: %v", err)
+		}
+	}
+
+	// Create a SIP user agent that sends through the registrar and answers on the SIP port
+	localIP, err := outboundIP(registrar)
+	if err != nil {
+		log.Fatalf("Failed to find the local address of the registrar's route: %v", err)
+	}
+	agent, err := sipgo.NewUA(sipgo.WithUserAgent("GoIPPhone/1.0"))
+	if err != nil {
+		log.Fatalf("Failed to create SIP user agent: %v", err)
+	}
+	lc.OnShutdown("SIP user agent", func(ctx context.Context) error {
+		return agent.Close()
+	})
+	client, err := sipgo.NewClient(agent, sipgo.WithClientHostname(localIP.String()))
+	if err != nil {
+		log.Fatalf("Failed to create SIP client: %v", err)
+	}
+	server, err := sipgo.NewServer(agent)
+	if err != nil {
+		log.Fatalf("Failed to create SIP server: %v", err)
+	}
+	go func() {
+		if err := server.ListenAndServe(lc.Context(), "udp", net.JoinHostPort("", strconv.Itoa(settings.SIPPort))); err != nil && lc.Context().Err() == nil {
+			log.Printf("SIP server stopped: %v", err)
+		}
+	}()
+	aor := sip.Uri{Scheme: "sip", User: settings.Username, Host: registerURI.Host}
+	contact := sip.ContactHeader{Address: sip.Uri{Scheme: "sip", User: settings.Username, Host: localIP.String(), Port: settings.SIPPort}}
+
+	// Register with the SIP server
+	if err := register(lc.Context(), client, registrar, aor, registerURI, contact); err != nil {
+		log.Fatalf("Failed to register: %v", err)
+	}
+	fmt.Println("Registered successfully")
+
+	// Advertise the phone on the LAN so peers can call it without a registrar
+	if settings.MDNSInstance != "" {
+		ad, err := mdns.Advertise(settings.MDNSInstance, mdns.ServiceSIP, settings.SIPPort, []string{"user=" + settings.Username})
+		if err != nil {
+			log.Fatalf("Failed to advertise over mDNS: %v", err)
+		}
+		lc.OnShutdown("mDNS advertisement", func(ctx context.Context) error {
+			ad.Close()
+			return nil
+		})
+	}
+
+	// Handle incoming calls
+	incoming := sipgo.NewDialogServerCache(client, contact)
+	outgoing := sipgo.NewDialogClientCache(client, contact)
+	server.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		remote := req.From().Address.String()
+		fmt.Println("Incoming call from:", remote)
+		dialog, err := incoming.ReadInvite(req, tx)
+		if err != nil {
+			log.Printf("Failed to accept call from %s: %v", remote, err)
+			return
+		}
+		defer dialog.Close()
+
+		// Extract SDP from the INVITE request
+		sdpOffer := req.Body()
+		fmt.Println("Received SDP Offer:", string(sdpOffer))
+		peer, err := parseRemoteMedia(sdpOffer)
+		if err != nil {
+			log.Printf("Rejecting call from %s: %v", remote, err)
+			dialog.Respond(sip.StatusNotAcceptableHere, "Not Acceptable Here", nil)
+			return
+		}
+
+		// Perform NAT traversal (STUN with TURN fallback)
+		path, err := performNATTraversal()
+		if err != nil {
+			log.Printf("Failed to perform NAT traversal: %v", err)
+			dialog.Respond(sip.StatusInternalServerError, "NAT Traversal Failed", nil)
+			return
+		}
+		defer path.Close()
+		fmt.Println("Media address:", path.addr)
+
+		// Answer with an SDP giving the discovered address
+		sdpAnswer := generateSDP(path.addr)
+		if err := dialog.RespondSDP(sdpAnswer); err != nil {
+			log.Printf("Failed to answer call from %s: %v", remote, err)
+			return
+		}
+		fmt.Println("Call answered with SDP:", string(sdpAnswer))
+		publishCallEvent(remote, "connected", nil)
+		handleRTPCommunication(dialog.Context(), remote, path, peer)
+		publishCallEvent(remote, "disconnected", nil)
+	})
+	server.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		incoming.ReadAck(req, tx)
+	})
+	server.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		if err := incoming.ReadBye(req, tx); errors.Is(err, sipgo.ErrDialogDoesNotExists) {
+			outgoing.ReadBye(req, tx)
+		}
+	})
+
+	// Find the address the call's media is reached at before offering it
+	path, err := performNATTraversal()
+	if err != nil {
+		log.Fatalf("Failed to perform NAT traversal: %v", err)
+	}
+	lc.OnShutdown("media", func(ctx context.Context) error {
+		path.Close()
+		return nil
+	})
+	fmt.Println("Media address:", path.addr)
+
+	// Make an outgoing call through the registrar
+	remote := callee.String()
+	invite := sip.NewRequest(sip.INVITE, callee)
+	from := &sip.FromHeader{Address: aor, Params: sip.NewParams()}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	invite.AppendHeader(from)
+	invite.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	invite.SetBody(generateSDP(path.addr))
+	registrar.route(invite)
+	session, err := outgoing.WriteInvite(lc.Context(), invite)
+	if err != nil {
+		log.Fatalf("Failed to initiate call: %v", err)
+	}
+
+	// Wait for the callee to answer and talk until either side hangs up
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		defer session.Close()
+		if err := session.WaitAnswer(lc.Context(), sipgo.AnswerOptions{Username: settings.Username, Password: settings.Password}); err != nil {
+			fmt.Printf("Call error: %v\n", err)
+			publishCallEvent(remote, "error", err)
+			return
+		}
+		if err := session.Ack(lc.Context()); err != nil {
+			fmt.Printf("Call error: %v\n", err)
+			publishCallEvent(remote, "error", err)
+			return
+		}
+		peer, err := parseRemoteMedia(session.InviteResponse.Body())
+		if err != nil {
+			fmt.Printf("Call error: %v\n", err)
+			publishCallEvent(remote, "error", err)
+			session.Bye(lc.Context())
+			return
+		}
+		fmt.Println("Call connected")
+		publishCallEvent(remote, "connected", nil)
+		handleRTPCommunication(session.Context(), remote, path, peer)
+		fmt.Println("Call disconnected")
+		publishCallEvent(remote, "disconnected", nil)
+	}()
+
+	// Hang up if the call is still going at shutdown
+	lc.OnShutdown("call", func(ctx context.Context) error {
+		if session.LoadState() != sip.DialogStateConfirmed {
+			return nil
+		}
+		publishCallEvent(remote, "hangup", nil)
+		if err := session.Bye(ctx); err != nil {
+			return err
+		}
+		// Let the media stop before its socket is closed
+		return lifecycle.WaitFunc(ctx, func() { <-ended })
+	})
+
+	// Wait for the call to end or a shutdown signal
+	select {
+	case <-ended:
+		fmt.Println("Call ended")
+	case <-lc.Context().Done():
+		fmt.Println("Shutdown signal received, hanging up...")
+	}
+	if err := lc.Shutdown(); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
+}
+
+// outboundIP returns the local address the host routes to target from, which the phone
+// gives as its contact.
+func outboundIP(target sipTarget) (net.IP, error) {
+	addr, err := resolveUDPAddr(context.Background(), net.JoinHostPort(target.Host, strconv.Itoa(target.Port)))
+	if err != nil {
+		return nil, err
+	}
+	// Connecting a UDP socket picks the route without sending anything
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// register binds the phone's address of record to contact at the registrar, answering
+// its digest challenge.
+func register(ctx context.Context, client *sipgo.Client, registrar sipTarget, aor, registerURI sip.Uri, contact sip.ContactHeader) error {
+	req := sip.NewRequest(sip.REGISTER, registerURI)
+	from := &sip.FromHeader{Address: aor, Params: sip.NewParams()}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	req.AppendHeader(from)
+	req.AppendHeader(&sip.ToHeader{Address: aor})
+	req.AppendHeader(&contact)
+	registrar.route(req)
+
+	res, err := client.Do(ctx, req, sipgo.ClientRequestRegisterBuild)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired {
+		res, err = client.DoDigestAuth(ctx, req, res, sipgo.DigestAuth{Username: settings.Username, Password: settings.Password})
+		if err != nil {
+			return err
+		}
+	}
+	if res.StatusCode != sip.StatusOK {
+		return fmt.Errorf("registrar answered %d %s", res.StatusCode, res.Reason)
+	}
+	return nil
+}
+
+// sipHostPort extracts the host and port, 0 if absent, from a SIP URI such as
+// sip:alice@example.com:5060;transport=udp
+func sipHostPort(uri string) (string, int) {
+	host := strings.TrimPrefix(strings.TrimPrefix(uri, "sips:"), "sip:")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.IndexAny(host, ";?"); i >= 0 {
+		host = host[:i]
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		port, _ := strconv.Atoi(p)
+		return h, port
+	}
+	return strings.Trim(host, "[]"), 0
+}
+
+// sipTransportParam returns the transport parameter of a SIP URI, or "" if absent.
+func sipTransportParam(uri string) string {
+	for _, param := range strings.Split(uri, ";")[1:] {
+		if value, ok := strings.CutPrefix(strings.ToLower(param), "transport="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// resolveSIPTarget locates the server for a SIP URI as RFC 3263 describes: NAPTR records
+// pick the transport, SRV records the host and port, and the URI host is the last resort.
+func resolveSIPTarget(ctx context.Context, uri string) (sipTarget, error) {
+	secure := strings.HasPrefix(uri, "sips:")
+	host, port := sipHostPort(uri)
+	transport := sipTransportParam(uri)
+
+	fallback := sipTarget{Host: host, Port: port, Transport: transport}
+	if fallback.Transport == "" {
+		fallback.Transport = "udp"
+		if secure {
+			fallback.Transport = "tls"
+		}
+	}
+	if fallback.Port == 0 {
+		fallback.Port = 5060
+		if fallback.Transport == "tls" {
+			fallback.Port = 5061
+		}
+	}
+
+	// A numeric host or an explicit port skips the DNS service lookups
+	if net.ParseIP(host) != nil || port != 0 {
+		return fallback, nil
+	}
+
+	naptrs, err := resolver.LookupNAPTR(ctx, host)
+	if err != nil && !errors.Is(err, dnsclient.ErrNotFound) {
+		return sipTarget{}, err
+	}
+	for _, naptr := range naptrs {
+		naptrTransport, ok := naptrTransports[strings.ToUpper(naptr.Service)]
+		if !ok || !strings.EqualFold(naptr.Flags, "s") {
+			continue
+		}
+		if (secure && naptrTransport != "tls") || (transport != "" && naptrTransport != transport) {
+			continue
+		}
+		if target, err := lookupSIPSRV(ctx, naptr.Replacement, naptrTransport); err == nil {
+			return target, nil
+		}
+	}
+
+	// Without usable NAPTR records, try the SRV records of each transport in turn
+	srvNames := []struct{ prefix, transport string }{
+		{"_sips._tcp.", "tls"},
+		{"_sip._tcp.", "tcp"},
+		{"_sip._udp.", "udp"},
+	}
+	for _, srv := range srvNames {
+		if (secure && srv.transport != "tls") || (transport != "" && srv.transport != transport) {
+			continue
+		}
+		if target, err := lookupSIPSRV(ctx, srv.prefix+host, srv.transport); err == nil {
+			return target, nil
+		}
+	}
+	return fallback, nil
+}
+
+// lookupSIPSRV returns the preferred server of an SRV name.
+func lookupSIPSRV(ctx context.Context, name, transport string) (sipTarget, error) {
+	srvs, err := resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return sipTarget{}, err
+	}
+	for _, srv := range srvs {
+		// A target of "." means the service is explicitly unavailable
+		if srv.Target != "." {
+			return sipTarget{Host: strings.TrimSuffix(srv.Target, "."), Port: int(srv.Port), Transport: transport}, nil
+		}
+	}
+	return sipTarget{}, fmt.Errorf("service %s is not available", name)
+}
+
+const (
+	// stunTimeout bounds the wait for the STUN server's answer.
+	stunTimeout = 3 * time.Second
+	// stunKeepaliveInterval is how often a binding request refreshes the NAT mapping of
+	// the media socket.
+	stunKeepaliveInterval = 30 * time.Second
+)
+
+// mediaPath is the socket a call's RTP is sent and received on, and the address peers
+// reach it at.
+type mediaPath struct {
+	conn  net.PacketConn
+	addr  *net.UDPAddr
+	close func()
+}
+
+// Close releases the socket, and the TURN relay or STUN keepalives behind it.
+func (path *mediaPath) Close() {
+	path.close()
+}
+
+// performNATTraversal opens the media socket and finds its public address through STUN,
+// falling back to a relay allocated over TURN.
+func performNATTraversal() (*mediaPath, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP connection: %v", err)
+	}
+
+	// Try STUN first
+	publicAddr, stunServer, err := performSTUN(conn)
+	if err == nil {
+		stop := make(chan struct{})
+		go keepSTUNMapping(conn, stunServer, stop)
+		return &mediaPath{conn: conn, addr: publicAddr, close: func() {
+			close(stop)
+			conn.Close()
+		}}, nil
+	}
+	log.Printf("STUN failed: %v", err)
+
+	// Fall back to TURN
+	path, err := performTURN(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TURN fallback failed: %v", err)
+	}
+	return path, nil
+}
+
+// resolveUDPAddr resolves a host:port with the cached DNS client.
+func resolveUDPAddr(ctx context.Context, addr string) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s: %v", addr, err)
+	}
+	ips, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ips[0], Port: port}, nil
+}
+
+// performSTUN discovers the public IP and port of conn with a STUN binding request,
+// returning them with the STUN server's address.
+func performSTUN(conn *net.UDPConn) (*net.UDPAddr, *net.UDPAddr, error) {
+	serverAddr, err := resolveUDPAddr(context.Background(), settings.STUNServer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve STUN server %s: %v", settings.STUNServer, err)
+	}
+
+	// Send a STUN request to discover the public IP and port
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.WriteTo(request.Raw, serverAddr); err != nil {
+		return nil, nil, fmt.Errorf("failed to perform STUN request: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(stunTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buffer := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read STUN response: %v", err)
+		}
+
+		// Skip anything but the answer to this request
+		response := &stun.Message{Raw: buffer[:n]}
+		if err := response.Decode(); err != nil || response.TransactionID != request.TransactionID {
+			continue
+		}
+
+		// Decode the STUN response
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(response); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode STUN response: %v", err)
+		}
+		return &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}, serverAddr, nil
+	}
+}
+
+// keepSTUNMapping sends STUN keepalives from conn until stop is closed, so the NAT keeps
+// its public address while no RTP flows.
+func keepSTUNMapping(conn net.PacketConn, serverAddr net.Addr, stop <-chan struct{}) {
+	ticker := time.NewTicker(stunKeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+			if _, err := conn.WriteTo(request.Raw, serverAddr); err != nil {
+				log.Printf("Failed to send STUN keepalive: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// performTURN allocates a relay through the TURN server from conn; the relayed address
+// then carries the call's RTP.
+func performTURN(conn *net.UDPConn) (*mediaPath, error) {
+	serverAddr, err := resolveUDPAddr(context.Background(), settings.TURNServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve TURN server %s: %v", settings.TURNServer, err)
+	}
+
+	// Create a TURN client
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: serverAddr.String(),
+		TURNServerAddr: serverAddr.String(),
+		Username:       settings.TURNUsername,
+		Password:       settings.TURNPassword,
+		Conn:           conn,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TURN client: %v", err)
+	}
+	if err := client.Listen(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to listen for TURN responses: %v", err)
+	}
+
+	// Allocate a relay address
+	relay, err := client.Allocate()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to allocate relay address: %v", err)
+	}
+	relayAddr, ok := relay.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		relay.Close()
+		client.Close()
+		return nil, fmt.Errorf("TURN server relayed %s, not a UDP address", relay.LocalAddr())
+	}
+	return &mediaPath{conn: relay, addr: relayAddr, close: func() {
+		relay.Close()
+		client.Close()
+		conn.Close()
+	}}, nil
+}
+
+// rtpQuality tracks loss and interarrival jitter of received RTP packets (RFC 3550).
+type rtpQuality struct {
+	mu       sync.Mutex
+	started  bool
+	start    time.Time
+	received int64
+	// baseSeq and maxSeq are extended sequence numbers, counting 16-bit wraparounds
+	baseSeq int64
+	maxSeq  int64
+	// transit is the last relative transit time and jitter the running estimate, in timestamp units
+	transit float64
+	jitter  float64
+}
+
+const (
+	// rtpClockRate is the timestamp rate of the 8 kHz audio codecs in use.
+	rtpClockRate = 8000
+	// rtpFrameSamples is the number of samples sent in each packet, 20 ms of audio.
+	rtpFrameSamples = 160
+	// maxOpusFrameSamples is the number of samples in the longest Opus packet, 120 ms.
+	maxOpusFrameSamples = 960
+)
+
+func (q *rtpQuality) record(packet *rtp.Packet, arrival time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq := int64(packet.SequenceNumber)
+	if !q.started {
+		q.start = arrival
+	}
+	transit := arrival.Sub(q.start).Seconds()*rtpClockRate - float64(packet.Timestamp)
+	if !q.started {
+		q.started = true
+		q.baseSeq, q.maxSeq = seq, seq
+		q.transit = transit
+		q.received = 1
+		return
+	}
+
+	// Pick the extended sequence number closest to the highest seen so far
+	ext := q.maxSeq&^0xffff | seq
+	if ext < q.maxSeq-0x8000 {
+		ext += 0x10000
+	} else if ext > q.maxSeq+0x8000 {
+		ext -= 0x10000
+	}
+	if ext > q.maxSeq {
+		q.maxSeq = ext
+	}
+	q.received++
+
+	d := transit - q.transit
+	if d < 0 {
+		d = -d
+	}
+	q.transit = transit
+	q.jitter += (d - q.jitter) / 16
+}
+
+func (q *rtpQuality) snapshot() map[string]any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	expected := int64(0)
+	if q.started {
+		expected = q.maxSeq - q.baseSeq + 1
+	}
+	lost := max(expected-q.received, 0)
+	lossPercent := 0.0
+	if expected > 0 {
+		lossPercent = float64(lost) / float64(expected) * 100
+	}
+	return map[string]any{
+		"packets_received": q.received,
+		"packets_expected": expected,
+		"packets_lost":     lost,
+		"loss_percent":     lossPercent,
+		"jitter_ms":        q.jitter / rtpClockRate * 1000,
+	}
+}
+
+// publishCallEvent sends a call state change over MQTT when telemetry is enabled.
+func publishCallEvent(remote string, state string, callErr error) {
+	if publisher == nil {
+		return
+	}
+	data := map[string]any{"remote": remote, "state": state}
+	if callErr != nil {
+		data["error"] = callErr.Error()
+	}
+	if err := publisher.Publish("call", data); err != nil {
+		log.Printf("Failed to publish call event: %v", err)
+	}
+}
+
+// publishCallQuality sends the call's RTP statistics over MQTT when telemetry is enabled.
+func publishCallQuality(remote string, quality *rtpQuality) {
+	if publisher == nil {
+		return
+	}
+	data := quality.snapshot()
+	data["remote"] = remote
+	if err := publisher.Publish("call-quality", data); err != nil {
+		log.Printf("Failed to publish call quality: %v", err)
+	}
+}
+
+// generateSDP describes the phone's audio at addr, its public or relayed address: PCMU,
+// which it sends, and Opus, which it also plays.
+func generateSDP(addr *net.UDPAddr) []byte {
+	return fmt.Appendf(nil, "v=0\r\n"+
+		"o=- 0 0 IN IP4 %s\r\n"+
+		"s=-\r\n"+
+		"c=IN IP4 %s\r\n"+
+		"t=0 0\r\n"+
+		"m=audio %d RTP/AVP 0 96\r\n"+
+		"a=rtpmap:0 PCMU/8000\r\n"+
+		"a=rtpmap:96 opus/48000/2\r\n",
+		addr.IP, addr.IP, addr.Port)
+}
+
+// parseRemoteMedia returns the address a peer's SDP asks for audio to be sent to. The peer
+// must accept PCMU, the only codec the phone sends.
+func parseRemoteMedia(body []byte) (*net.UDPAddr, error) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(body); err != nil {
+		return nil, fmt.Errorf("failed to parse SDP: %v", err)
+	}
+	for _, media := range desc.MediaDescriptions {
+		if media.MediaName.Media != "audio" || !slices.Contains(media.MediaName.Formats, "0") {
+			continue
+		}
+		conn := desc.ConnectionInformation
+		if media.ConnectionInformation != nil {
+			conn = media.ConnectionInformation
+		}
+		if conn == nil || conn.Address == nil {
+			return nil, errors.New("SDP gives no connection address")
+		}
+		ip := net.ParseIP(conn.Address.Address)
+		if ip == nil {
+			return nil, fmt.Errorf("SDP connection address %s is not an IP", conn.Address.Address)
+		}
+		return &net.UDPAddr{IP: ip, Port: media.MediaName.Port.Value}, nil
+	}
+	return nil, errors.New("SDP offers no PCMU audio")
+}
+
+// audioDevice captures from the microphone and plays to the speaker, 8 kHz mono.
+type audioDevice interface {
+	// Read fills frame with captured samples.
+	Read(frame []int16) error
+	// Write plays samples.
+	Write(samples []int16) error
+	Close() error
+}
+
+// handleRTPCommunication sends captured audio to peer and plays what arrives on path until
+// ctx is done, the call having ended.
+func handleRTPCommunication(ctx context.Context, remote string, path *mediaPath, peer *net.UDPAddr) {
+	audio, err := openAudio()
+	if err != nil {
+		log.Printf("Failed to open audio: %v", err)
+		return
+	}
+	defer audio.Close()
+
+	// Report call quality while the call lasts and once more when it ends
+	quality := &rtpQuality{}
+	stopQuality := make(chan struct{})
+	defer func() {
+		close(stopQuality)
+		publishCallQuality(remote, quality)
+	}()
+	if publisher != nil {
+		go func() {
+			ticker := time.NewTicker(settings.MQTTInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stopQuality:
+					return
+				case <-ticker.C:
+					publishCallQuality(remote, quality)
+				}
+			}
+		}()
+	}
+
+	// Handle incoming RTP packets until the call ends
+	received := make(chan struct{})
+	defer func() {
+		// Stop reading before the audio played to is closed
+		path.conn.SetReadDeadline(time.Now())
+		<-received
+	}()
+	go func() {
+		defer close(received)
+		decoder, err := opus.NewDecoderWithOutput(rtpClockRate, 1)
+		if err != nil {
+			log.Printf("Failed to create Opus decoder: %v", err)
+			return
+		}
+		buffer := make([]byte, 1500) // MTU size
+		for {
+			n, _, err := path.conn.ReadFrom(buffer)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to read RTP packet: %v", err)
+				}
+				return
+			}
+
+			// Answers to STUN keepalives arrive on the same socket
+			if stun.IsMessage(buffer[:n]) {
+				continue
+			}
+
+			// Parse the RTP packet
+			packet := &rtp.Packet{}
+			if err := packet.Unmarshal(buffer[:n]); err != nil {
+				log.Printf("Failed to parse RTP packet: %v", err)
+				continue
+			}
+			quality.record(packet, time.Now())
+
+			// Decode the audio based on the payload type
+			var decodedAudio []int16
+			switch packet.PayloadType {
+			case 0: // PCMU (G.711)
+				decodedAudio = decodePCMU(packet.Payload)
+			case 96: // Opus
+				decodedAudio, err = decodeOpus(&decoder, packet.Payload)
+			default:
+				log.Printf("Unsupported payload type: %d", packet.PayloadType)
+				continue
+			}
+
+			if err != nil {
+				log.Printf("Failed to decode audio: %v", err)
+				continue
+			}
+
+			// Play the decoded audio
+			if err := audio.Write(decodedAudio); err != nil {
+				log.Printf("Failed to play audio: %v", err)
+			}
+		}
+	}()
+
+	// Send RTP packets with PCMU audio, 50 a second
+	ticker := time.NewTicker(rtpFrameSamples * time.Second / rtpClockRate)
+	defer ticker.Stop()
+	ssrc := rand.Uint32()
+	sequenceNumber := uint16(rand.Uint32())
+	timestamp := rand.Uint32()
+	audioData := make([]int16, rtpFrameSamples)
+	for {
+		// Capture audio from the microphone
+		if err := audio.Read(audioData); err != nil {
+			log.Printf("Failed to capture audio: %v", err)
+			return
+		}
+
+		// Create an RTP packet
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    0, // PCMU payload type
+				SequenceNumber: sequenceNumber,
+				Timestamp:      timestamp,
+				SSRC:           ssrc,
+			},
+			Payload: encodePCMU(audioData),
+		}
+
+		// Marshal the RTP packet into bytes
+		packetBytes, err := packet.Marshal()
+		if err != nil {
+			log.Printf("Failed to marshal RTP packet: %v", err)
+			return
+		}
+
+		// Send the RTP packet
+		if _, err := path.conn.WriteTo(packetBytes, peer); err != nil {
+			log.Printf("Failed to send RTP packet: %v", err)
+			return
+		}
+
+		sequenceNumber++
+		timestamp += rtpFrameSamples
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// decodeOpus decodes an Opus packet into 8 kHz mono samples; decoder keeps its state
+// across the call's packets.
+func decodeOpus(decoder *opus.Decoder, encodedData []byte) ([]int16, error) {
+	decoded := make([]int16, maxOpusFrameSamples)
+	n, err := decoder.DecodeToInt16(encodedData, decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Opus audio: %v", err)
+	}
+	return decoded[:n], nil
+}
+
+const (
+	// pcmuBias is added to a sample's magnitude before µ-law companding (ITU-T G.711).
+	pcmuBias = 0x84
+	// pcmuClip is the largest magnitude that still fits once biased.
+	pcmuClip = 32635
+)
+
+// encodePCMU encodes raw audio data using G.711 µ-law (PCMU)
+func encodePCMU(audioData []int16) []byte {
+	encoded := make([]byte, len(audioData))
+	for i, sample := range audioData {
+		encoded[i] = linearToPCMU(sample)
+	}
+	return encoded
+}
+
+// decodePCMU decodes G.711 µ-law (PCMU) audio data into raw audio
+func decodePCMU(encodedData []byte) []int16 {
+	decoded := make([]int16, len(encodedData))
+	for i, b := range encodedData {
+		decoded[i] = pcmuToLinear(b)
+	}
+	return decoded
+}
+
+func linearToPCMU(sample int16) byte {
+	magnitude, sign := int(sample), 0
+	if magnitude < 0 {
+		magnitude, sign = -magnitude, 0x80
+	}
+	magnitude = min(magnitude, pcmuClip) + pcmuBias
+
+	// The segment is the position of the highest bit set above the lowest seven
+	exponent := 7
+	for mask := 0x4000; magnitude&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := magnitude >> (exponent + 3) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func pcmuToLinear(b byte) int16 {
+	b = ^b
+	exponent := int(b>>4) & 0x07
+	mantissa := int(b & 0x0f)
+	magnitude := (mantissa<<3+pcmuBias)<<exponent - pcmuBias
+	if b&0x80 != 0 {
+		return int16(-magnitude)
+	}
+	return int16(magnitude)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/sniffer"
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+// snifferConfig holds the sniffer settings.
+type snifferConfig struct {
+	Interface   string `config:"iface" usage:"network interface to capture on"`
+	Read        string `config:"read" usage:"pcap file to decode instead of capturing"`
+	Write       string `config:"write" usage:"pcap file the matching packets are written to"`
+	Ports       string `config:"ports" usage:"ports to capture with the protocol to decode, e.g. 8080=line,5201=length,10000-20000=rtp"`
+	Count       int    `config:"count" usage:"stop after this many matching packets, 0 for no limit"`
+	Snaplen     int    `config:"snaplen" usage:"bytes captured per packet"`
+	Promiscuous bool   `config:"promiscuous" usage:"capture traffic not addressed to this host"`
+	Quiet       bool   `config:"quiet" usage:"don't print packets, only write them"`
+}
+
+func (cfg *snifferConfig) Validate() error {
+	if (cfg.Interface == "") == (cfg.Read == "") {
+		return errors.New("exactly one of iface and read is required")
+	}
+	if cfg.Quiet && cfg.Write == "" {
+		return errors.New("quiet needs write, or there is no output")
+	}
+	_, err := sniffer.ParseFilter(cfg.Ports)
+	return err
+}
+
+func main() {
+	cfg := snifferConfig{Ports: sniffer.DefaultPorts, Snaplen: 65535}
+	if _, err := config.Load("sniffer", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	filter, _ := sniffer.ParseFilter(cfg.Ports)
+
+	var source sniffer.Source
+	var err error
+	if cfg.Read != "" {
+		source, err = sniffer.OpenFile(cfg.Read)
+	} else {
+		source, err = sniffer.OpenInterface(cfg.Interface, cfg.Snaplen, cfg.Promiscuous)
+	}
+	if err != nil {
+		log.Fatal("cannot open capture: ", err)
+	}
+
+	// Stop on an interrupt signal; closing the source unblocks the read
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { source.Close() })
+	defer stop()
+
+	var writer *pcapgo.Writer
+	if cfg.Write != "" {
+		file, err := os.Create(cfg.Write)
+		if err != nil {
+			log.Fatal("cannot create capture file: ", err)
+		}
+		defer file.Close()
+		writer = pcapgo.NewWriter(file)
+		if err := writer.WriteFileHeader(uint32(cfg.Snaplen), source.LinkType()); err != nil {
+			log.Fatal("cannot write capture file: ", err)
+		}
+	}
+
+	matched := 0
+	packets := gopacket.NewPacketSource(source, source.LinkType())
+	for cfg.Count == 0 || matched < cfg.Count {
+		packet, err := packets.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error reading packet: %v\n", err)
+			continue
+		}
+
+		proto, ok := filter.Match(packet)
+		if !ok {
+			continue
+		}
+		matched++
+
+		if writer != nil {
+			if err := writer.WritePacket(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+				log.Fatal("cannot write capture file: ", err)
+			}
+		}
+		if !cfg.Quiet {
+			fmt.Print(sniffer.Describe(packet, proto))
+		}
+	}
+	log.Printf("%d matching packets\n", matched)
+}
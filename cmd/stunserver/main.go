@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/stunserver"
+)
+
+// serverConfig holds the stunserver settings.
+type serverConfig struct {
+	Addr     string   `config:"addr" usage:"UDP address to listen on"`
+	Realm    string   `config:"realm" usage:"realm for long-term authentication"`
+	Users    []string `config:"users" usage:"comma-separated user:password pairs; empty disables authentication"`
+	Software string   `config:"software" usage:"SOFTWARE attribute sent in responses"`
+}
+
+func (cfg *serverConfig) Validate() error {
+	for _, user := range cfg.Users {
+		if !strings.Contains(user, ":") {
+			return fmt.Errorf("user %q must be user:password", user)
+		}
+	}
+	return nil
+}
+
+func main() {
+	cfg := serverConfig{Addr: ":3478", Realm: "net_prg"}
+	if _, err := config.Load("stunserver", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	users := make(map[string]string, len(cfg.Users))
+	for _, user := range cfg.Users {
+		name, password, _ := strings.Cut(user, ":")
+		users[name] = password
+	}
+
+	server, err := stunserver.Listen(cfg.Addr, stunserver.Config{Realm: cfg.Realm, Users: users, Software: cfg.Software})
+	if err != nil {
+		log.Fatal("cannot start STUN server: ", err)
+	}
+	log.Println("STUN server listening on", server.Addr())
+
+	// Stop serving on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := server.Serve(ctx); err != nil {
+		log.Fatal("STUN server failed: ", err)
+	}
+	log.Println("STUN server stopped")
+}
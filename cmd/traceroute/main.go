@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/traceroute"
+)
+
+// traceConfig holds the traceroute settings.
+type traceConfig struct {
+	Method  string        `config:"method" usage:"probe type: udp or icmp"`
+	MaxHops int           `config:"max-hops" usage:"highest TTL probed"`
+	Probes  int           `config:"probes" usage:"probes per hop"`
+	Timeout time.Duration `config:"timeout" usage:"time to wait for each probe's reply"`
+	Port    int           `config:"port" usage:"first UDP destination port"`
+	JSON    bool          `config:"json" usage:"print the whole trace as JSON when done"`
+}
+
+func main() {
+	cfg := traceConfig{Method: "udp", MaxHops: 30, Probes: 3, Timeout: time.Second, Port: 33434}
+	args, err := config.Load("traceroute", &cfg, os.Args[1:])
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	if len(args) != 1 {
+		log.Fatal("please provide a host")
+	}
+
+	// Stop early on an interrupt signal and report the hops found so far
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	opts := traceroute.Options{
+		Method:  traceroute.Method(cfg.Method),
+		MaxHops: cfg.MaxHops,
+		Probes:  cfg.Probes,
+		Timeout: cfg.Timeout,
+		Port:    cfg.Port,
+	}
+	var printHop traceroute.HopFunc
+	if !cfg.JSON {
+		printHop = func(hop traceroute.Hop) {
+			fmt.Printf("%2d  %s\n", hop.TTL, formatProbes(hop.Probes))
+		}
+	}
+
+	result, err := traceroute.Trace(ctx, args[0], opts, printHop)
+	if result != nil && cfg.JSON {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		if err := out.Encode(result); err != nil {
+			log.Fatal("cannot encode result: ", err)
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+// formatProbes prints each responding address once, followed by its RTTs.
+func formatProbes(probes []traceroute.Probe) string {
+	var parts []string
+	lastAddr := ""
+	for _, probe := range probes {
+		if probe.Addr == "" {
+			parts = append(parts, "*")
+			continue
+		}
+		if probe.Addr != lastAddr {
+			parts = append(parts, probe.Addr)
+			lastAddr = probe.Addr
+		}
+		parts = append(parts, probe.RTT.Round(10*time.Microsecond).String())
+	}
+	return strings.Join(parts, "  ")
+}
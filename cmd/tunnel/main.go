@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// tunnelConfig holds the tunnel settings.
+type tunnelConfig struct {
+	Listen         string        `config:"listen" usage:"local host:port to accept connections on" required:"true"`
+	Remote         string        `config:"remote" usage:"remote host:port to forward to" required:"true"`
+	MaxConns       int           `config:"max-conns" usage:"connections forwarded at once; more wait to be accepted"`
+	DialTimeout    time.Duration `config:"dial-timeout" usage:"timeout for connecting to the remote"`
+	IdleTimeout    time.Duration `config:"idle-timeout" usage:"close connections idle this long, 0 to never"`
+	StatsInterval  time.Duration `config:"stats-interval" usage:"how often byte totals are logged, 0 to never"`
+	MetricsAddr    string        `config:"metrics-addr" usage:"host:port serving byte counters at /debug/vars, empty disables"`
+	ListenCert     string        `config:"listen-cert" usage:"certificate file; accepts TLS on the local side when set"`
+	ListenKey      string        `config:"listen-key" usage:"key file for listen-cert"`
+	ListenClientCA string        `config:"listen-client-ca" usage:"CA file; requires client certificates signed by it"`
+	RemoteTLS      bool          `config:"remote-tls" usage:"connect to the remote over TLS"`
+	RemoteCA       string        `config:"remote-ca" usage:"CA file verifying the remote, empty uses the system pool"`
+	RemoteName     string        `config:"remote-server-name" usage:"server name expected in the remote certificate"`
+	RemoteCert     string        `config:"remote-cert" usage:"client certificate file presented to the remote"`
+	RemoteKey      string        `config:"remote-key" usage:"key file for remote-cert"`
+}
+
+func (cfg *tunnelConfig) Validate() error {
+	if cfg.MaxConns < 1 {
+		return fmt.Errorf("max-conns must be at least 1, got %d", cfg.MaxConns)
+	}
+	if (cfg.ListenCert == "") != (cfg.ListenKey == "") {
+		return errors.New("listen-cert and listen-key must be given together")
+	}
+	if cfg.ListenClientCA != "" && cfg.ListenCert == "" {
+		return errors.New("listen-client-ca needs listen-cert")
+	}
+	if (cfg.RemoteCert == "") != (cfg.RemoteKey == "") {
+		return errors.New("remote-cert and remote-key must be given together")
+	}
+	if !cfg.RemoteTLS && (cfg.RemoteCA != "" || cfg.RemoteCert != "" || cfg.RemoteName != "") {
+		return errors.New("remote TLS settings need remote-tls")
+	}
+	return nil
+}
+
+// Byte and connection totals, also served at /debug/vars
+var (
+	bytesSent     = expvar.NewInt("tunnel_bytes_sent")
+	bytesReceived = expvar.NewInt("tunnel_bytes_received")
+	connsTotal    = expvar.NewInt("tunnel_connections_total")
+	connsActive   = expvar.NewInt("tunnel_connections_active")
+	dialFailures  = expvar.NewInt("tunnel_dial_failures")
+)
+
+// Task implementation for forwarding a connection
+type TunnelTask struct {
+	conn   net.Conn
+	dialer func(ctx context.Context) (net.Conn, error)
+	idle   time.Duration
+}
+
+func (task *TunnelTask) Run(ctx context.Context) error {
+	defer task.conn.Close()
+
+	remote, err := task.dialer(ctx)
+	if err != nil {
+		dialFailures.Add(1)
+		return fmt.Errorf("failed to connect %s to remote: %w", task.conn.RemoteAddr(), err)
+	}
+	defer remote.Close()
+
+	connsTotal.Add(1)
+	connsActive.Add(1)
+	defer connsActive.Add(-1)
+
+	// Cut the connection short on shutdown
+	stop := context.AfterFunc(ctx, func() {
+		task.conn.Close()
+		remote.Close()
+	})
+	defer stop()
+
+	start := time.Now()
+	var sent, received atomic.Int64
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		forward(remote, task.conn, task.idle, &sent, bytesSent)
+	}()
+	go func() {
+		defer copies.Done()
+		forward(task.conn, remote, task.idle, &received, bytesReceived)
+	}()
+	copies.Wait()
+
+	log.Printf("Closed %s: %d bytes sent, %d bytes received in %v\n",
+		task.conn.RemoteAddr(), sent.Load(), received.Load(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// forward copies src to dst, counting bytes, until src is done or idle for too long, and
+// then half-closes dst so the other direction can finish.
+func forward(dst, src net.Conn, idle time.Duration, count *atomic.Int64, total *expvar.Int) {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				break
+			}
+			count.Add(int64(n))
+			total.Add(int64(n))
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				// An idle timeout or reset ends both directions
+				src.Close()
+				dst.Close()
+				return
+			}
+			break
+		}
+	}
+	closeWrite(dst)
+}
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// listenTLSConfig builds the local TLS config, or returns nil for plaintext.
+func listenTLSConfig(cfg *tunnelConfig) (*tls.Config, error) {
+	if cfg.ListenCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.ListenCert, cfg.ListenKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load listen certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ListenClientCA != "" {
+		pool, err := loadCertPool(cfg.ListenClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// remoteTLSConfig builds the remote TLS config, or returns nil for plaintext.
+func remoteTLSConfig(cfg *tunnelConfig) (*tls.Config, error) {
+	if !cfg.RemoteTLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.RemoteName, MinVersion: tls.VersionTLS12}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(cfg.Remote)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote %s: %w", cfg.Remote, err)
+		}
+		tlsConfig.ServerName = host
+	}
+	if cfg.RemoteCA != "" {
+		pool, err := loadCertPool(cfg.RemoteCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.RemoteCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.RemoteCert, cfg.RemoteKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load remote client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+func main() {
+	cfg := tunnelConfig{MaxConns: 100, DialTimeout: 10 * time.Second, StatsInterval: time.Minute}
+	if _, err := config.Load("tunnel", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	listenTLS, err := listenTLSConfig(&cfg)
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	remoteTLS, err := remoteTLSConfig(&cfg)
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		netDialer := &net.Dialer{Timeout: cfg.DialTimeout}
+		if remoteTLS != nil {
+			tlsDialer := &tls.Dialer{NetDialer: netDialer, Config: remoteTLS}
+			return tlsDialer.DialContext(ctx, "tcp", cfg.Remote)
+		}
+		return netDialer.DialContext(ctx, "tcp", cfg.Remote)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatal("cannot listen on address ", cfg.Listen)
+	}
+	if listenTLS != nil {
+		listener = tls.NewListener(listener, listenTLS)
+	}
+	log.Printf("Tunnel listening on %s (TLS %v), forwarding to %s (TLS %v)\n",
+		listener.Addr(), listenTLS != nil, cfg.Remote, remoteTLS != nil)
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
+				log.Printf("Error serving metrics: %v\n", err)
+			}
+		}()
+	}
+	if cfg.StatsInterval > 0 {
+		go logStats(ctx, cfg.StatsInterval)
+	}
+
+	// Create a worker pool with one worker per forwarded connection
+	workers := pool.New(cfg.MaxConns)
+	workers.RunContext(ctx)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Println("cannot accept connection on listener", err)
+			continue
+		}
+
+		// Create a new task for each connection and add it to the pool
+		workers.Submit(&TunnelTask{conn: conn, dialer: dialer, idle: cfg.IdleTimeout})
+	}
+
+	log.Println("Shutting down tunnel...")
+	workers.Close()
+	workers.Wait()
+	log.Printf("Tunnel shutdown complete: %d connections, %d bytes sent, %d bytes received\n",
+		connsTotal.Value(), bytesSent.Value(), bytesReceived.Value())
+}
+
+// logStats logs the byte totals every interval until ctx is done.
+func logStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("%d active connections, %d bytes sent, %d bytes received\n",
+				connsActive.Value(), bytesSent.Value(), bytesReceived.Value())
+		}
+	}
+}
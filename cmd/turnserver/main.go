@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/turnserver"
+)
+
+// serverConfig holds the turnserver settings.
+type serverConfig struct {
+	Addr    string   `config:"addr" usage:"UDP address to listen on"`
+	Realm   string   `config:"realm" usage:"realm for long-term authentication"`
+	Users   []string `config:"users" usage:"comma-separated user:password pairs" required:"true"`
+	RelayIP string   `config:"relay-ip" usage:"IP advertised in relay allocations"`
+	MinPort uint     `config:"min-port" usage:"lowest relay port, 0 for any"`
+	MaxPort uint     `config:"max-port" usage:"highest relay port, 0 for any"`
+}
+
+func (cfg *serverConfig) Validate() error {
+	for _, user := range cfg.Users {
+		if !strings.Contains(user, ":") {
+			return fmt.Errorf("user %q must be user:password", user)
+		}
+	}
+	if net.ParseIP(cfg.RelayIP) == nil {
+		return fmt.Errorf("invalid relay IP %q", cfg.RelayIP)
+	}
+	if cfg.MaxPort > 65535 || cfg.MinPort > cfg.MaxPort {
+		return fmt.Errorf("invalid relay port range %d-%d", cfg.MinPort, cfg.MaxPort)
+	}
+	return nil
+}
+
+func main() {
+	cfg := serverConfig{Addr: ":3478", Realm: "net_prg", RelayIP: "127.0.0.1"}
+	if _, err := config.Load("turnserver", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	users := make(map[string]string, len(cfg.Users))
+	for _, user := range cfg.Users {
+		name, password, _ := strings.Cut(user, ":")
+		users[name] = password
+	}
+
+	server, err := turnserver.Listen(cfg.Addr, turnserver.Config{
+		Realm:   cfg.Realm,
+		Users:   users,
+		RelayIP: net.ParseIP(cfg.RelayIP),
+		MinPort: uint16(cfg.MinPort),
+		MaxPort: uint16(cfg.MaxPort),
+	})
+	if err != nil {
+		log.Fatal("cannot start TURN server: ", err)
+	}
+	log.Println("TURN server listening on", server.Addr())
+
+	// Wait for an interrupt signal, then release every allocation
+	chSig := make(chan os.Signal, 1)
+	signal.Notify(chSig, os.Interrupt, syscall.SIGTERM)
+	<-chSig
+
+	if err := server.Close(); err != nil {
+		log.Println("error closing TURN server", err)
+	}
+	log.Println("TURN server stopped")
+}
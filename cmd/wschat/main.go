@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/pool"
+	"github.com/blueai2022/net_prg/wschat"
+)
+
+//go:embed index.html
+var indexPage []byte
+
+// chatConfig holds the wschat settings.
+type chatConfig struct {
+	Addr    string `config:"addr" usage:"host:port to listen on" required:"true"`
+	Clients int    `config:"clients" usage:"maximum number of connected clients"`
+	History int    `config:"history" usage:"messages replayed to clients joining a room"`
+}
+
+func (cfg *chatConfig) Validate() error {
+	if cfg.Clients < 1 {
+		return fmt.Errorf("clients must be at least 1, got %d", cfg.Clients)
+	}
+	if cfg.History < 0 {
+		return fmt.Errorf("history must not be negative, got %d", cfg.History)
+	}
+	return nil
+}
+
+func main() {
+	cfg := chatConfig{Clients: 1000, History: 50}
+	if _, err := config.Load("wschat", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+
+	// Stop on an interrupt signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Create a worker pool with one worker per connected client
+	workers := pool.New(cfg.Clients)
+	workers.Run()
+
+	hub := wschat.NewHub(cfg.History)
+	expvar.Publish("wschat_rooms", expvar.Func(func() any { return hub.Rooms() }))
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", wschat.NewServer(hub, workers))
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexPage)
+	})
+
+	server := &http.Server{Addr: cfg.Addr, Handler: mux}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("WebSocket chat server started listening on", cfg.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("cannot serve HTTP: ", err)
+	}
+	<-done
+	log.Println("Server shutdown complete.")
+}
@@ -0,0 +1,82 @@
+package concurtcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands is a Handler that parses the first word of each message as a command,
+// case-insensitively, and dispatches the rest of the message, its arguments, to the
+// Handler registered for the command. It answers unknown commands with an ERR message.
+// NewCommands registers:
+//
+//	PING [text]  answered with PONG, or text
+//	TIME         answered with the server's time in RFC 3339
+//	ECHO text    answered with text
+//	WHOAMI       answered with the client's Principal, or "anonymous"
+//	QUIT         answered with BYE, closing the connection
+type Commands struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewCommands creates a Commands with the built-in commands registered.
+func NewCommands() *Commands {
+	commands := &Commands{handlers: make(map[string]Handler)}
+	commands.Register("PING", HandlerFunc(ping))
+	commands.Register("TIME", HandlerFunc(serverTime))
+	commands.Register("ECHO", HandlerFunc(echo))
+	commands.Register("WHOAMI", HandlerFunc(whoami))
+	commands.Register("QUIT", HandlerFunc(quit))
+	return commands
+}
+
+// Register dispatches the command name to handler, replacing any handler registered for
+// it before, built-in ones included.
+func (commands *Commands) Register(name string, handler Handler) {
+	commands.mu.Lock()
+	defer commands.mu.Unlock()
+	commands.handlers[strings.ToUpper(name)] = handler
+}
+
+func (commands *Commands) Handle(ctx context.Context, request []byte) ([]byte, error) {
+	name, args, _ := bytes.Cut(request, []byte(" "))
+	commands.mu.RLock()
+	handler, ok := commands.handlers[strings.ToUpper(string(name))]
+	commands.mu.RUnlock()
+	if !ok {
+		return fmt.Appendf(nil, "ERR unknown command %q", name), nil
+	}
+	return handler.Handle(ctx, args)
+}
+
+func ping(ctx context.Context, args []byte) ([]byte, error) {
+	if len(args) == 0 {
+		return []byte("PONG"), nil
+	}
+	return args, nil
+}
+
+func serverTime(ctx context.Context, args []byte) ([]byte, error) {
+	return []byte(time.Now().Format(time.RFC3339)), nil
+}
+
+func echo(ctx context.Context, args []byte) ([]byte, error) {
+	return args, nil
+}
+
+func whoami(ctx context.Context, args []byte) ([]byte, error) {
+	if principal := SessionFromContext(ctx).Principal(); principal != "" {
+		return []byte(principal), nil
+	}
+	return []byte("anonymous"), nil
+}
+
+func quit(ctx context.Context, args []byte) ([]byte, error) {
+	SessionFromContext(ctx).Quit()
+	return []byte("BYE"), nil
+}
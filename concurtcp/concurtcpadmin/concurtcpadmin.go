@@ -0,0 +1,37 @@
+// Package concurtcpadmin serves an HTTP admin endpoint for a concurtcp server's open
+// connections: listing them, and closing one.
+package concurtcpadmin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/blueai2022/net_prg/concurtcp"
+)
+
+// Handler serves:
+//
+//	GET  /conns        the concurtcp.ConnInfo of every open connection of server
+//	POST /conns/close  close the connection whose ID is the id parameter
+func Handler(server *concurtcp.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conns", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(server.Conns())
+	})
+	mux.HandleFunc("POST /conns/close", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid connection id %q", r.FormValue("id")), http.StatusBadRequest)
+			return
+		}
+		if !server.CloseConn(id) {
+			http.Error(w, fmt.Sprintf("no open connection with id %d", id), http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Closed %d\n", id)
+	})
+	return mux
+}
@@ -0,0 +1,81 @@
+// Package concurtcpprom exports concurtcp server Stats as Prometheus metrics. It lives
+// apart from concurtcp so that programs without Prometheus don't depend on it.
+package concurtcpprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/blueai2022/net_prg/concurtcp"
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// Collector reports the Stats of a server on every scrape.
+type Collector struct {
+	server *concurtcp.Server
+
+	accepted     *prometheus.Desc
+	rejected     *prometheus.Desc
+	throttled    *prometheus.Desc
+	active       *prometheus.Desc
+	bytesRead    *prometheus.Desc
+	bytesWritten *prometheus.Desc
+	errors       *prometheus.Desc
+	handler      *prometheus.Desc
+}
+
+// NewCollector creates a collector for server. Connection counts are labelled with the
+// address of the listener that accepted them.
+func NewCollector(server *concurtcp.Server) *Collector {
+	desc := func(metric, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("concurtcp_"+metric, help, labels, nil)
+	}
+	return &Collector{
+		server:       server,
+		accepted:     desc("connections_accepted_total", "Connections accepted, including rejected and throttled ones.", "listener"),
+		rejected:     desc("connections_rejected_total", "Connections closed over the connection limit.", "listener"),
+		throttled:    desc("connections_throttled_total", "Connections closed over the per-client rate.", "listener"),
+		active:       desc("active_connections", "Connections being served by a worker.", "listener"),
+		bytesRead:    desc("read_bytes_total", "Bytes read from clients.", "listener"),
+		bytesWritten: desc("written_bytes_total", "Bytes written to clients.", "listener"),
+		errors:       desc("connection_errors_total", "Connections that failed, by error type.", "type"),
+		handler:      desc("handler_seconds", "How long the handler took to answer messages."),
+	}
+}
+
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.accepted
+	ch <- collector.rejected
+	ch <- collector.throttled
+	ch <- collector.active
+	ch <- collector.bytesRead
+	ch <- collector.bytesWritten
+	ch <- collector.errors
+	ch <- collector.handler
+}
+
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := collector.server.Stats()
+	for addr, listener := range stats.Listeners {
+		ch <- prometheus.MustNewConstMetric(collector.accepted, prometheus.CounterValue, float64(listener.Accepted), addr)
+		ch <- prometheus.MustNewConstMetric(collector.rejected, prometheus.CounterValue, float64(listener.Rejected), addr)
+		ch <- prometheus.MustNewConstMetric(collector.throttled, prometheus.CounterValue, float64(listener.Throttled), addr)
+		ch <- prometheus.MustNewConstMetric(collector.active, prometheus.GaugeValue, float64(listener.Active), addr)
+		ch <- prometheus.MustNewConstMetric(collector.bytesRead, prometheus.CounterValue, float64(listener.BytesRead), addr)
+		ch <- prometheus.MustNewConstMetric(collector.bytesWritten, prometheus.CounterValue, float64(listener.BytesWritten), addr)
+	}
+	for errorType, count := range stats.ErrorTypes {
+		ch <- prometheus.MustNewConstMetric(collector.errors, prometheus.CounterValue, float64(count), errorType)
+	}
+	ch <- histogram(collector.handler, stats.Handler)
+}
+
+// histogram converts a latency histogram to Prometheus' cumulative buckets.
+func histogram(desc *prometheus.Desc, h pool.Histogram) prometheus.Metric {
+	buckets := make(map[float64]uint64)
+	var cumulative uint64
+	for i, bound := range pool.LatencyBuckets() {
+		cumulative += uint64(h.Counts[i])
+		buckets[bound.Seconds()] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, uint64(h.Count), h.Sum.Seconds(), buckets)
+}
@@ -0,0 +1,474 @@
+package concurtcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/framing"
+	"github.com/blueai2022/net_prg/pool"
+)
+
+const (
+	// busyMessage is sent to connections rejected over MaxConns.
+	busyMessage = "server busy"
+	// tooLargeMessage is sent to connections closed for a message over MaxMessageSize.
+	tooLargeMessage = "message too large"
+	// turnAwayTimeout bounds sending a connection the reason it is turned away.
+	turnAwayTimeout = time.Second
+	// wakeTimeout bounds how long a connection woken by the event loop waits for room in
+	// a saturated pool before it is closed.
+	wakeTimeout = time.Second
+)
+
+// connLimiter caps the number of connections open at once, with a cap of 0 for none.
+// The cap may change while connections are open.
+type connLimiter struct {
+	mu  sync.Mutex
+	max int
+	// reject turns away connections over the cap rather than leave them unaccepted.
+	reject bool
+	open   int
+	// freed is closed and replaced when a slot frees up or the cap changes, waking wait.
+	freed chan struct{}
+}
+
+func newConnLimiter(max int, reject bool) *connLimiter {
+	return &connLimiter{max: max, reject: reject, freed: make(chan struct{})}
+}
+
+// set changes the cap and what happens to connections over it.
+func (limiter *connLimiter) set(max int, reject bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.max, limiter.reject = max, reject
+	limiter.wake()
+}
+
+// wake wakes wait. The caller holds mu.
+func (limiter *connLimiter) wake() {
+	close(limiter.freed)
+	limiter.freed = make(chan struct{})
+}
+
+// wait blocks until there is a slot for the next connection before it is accepted, so
+// connections over the cap wait in the kernel's backlog. It doesn't wait if the limiter
+// rejects connections instead, and reports false if ctx is done first.
+func (limiter *connLimiter) wait(ctx context.Context) bool {
+	for {
+		limiter.mu.Lock()
+		if limiter.reject || limiter.max == 0 || limiter.open < limiter.max {
+			limiter.mu.Unlock()
+			return true
+		}
+		freed := limiter.freed
+		limiter.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// admit takes a slot for an accepted connection. If there is none, it reports false if
+// the limiter rejects connections over the cap, and otherwise waits for one, as another
+// listener may have taken the slot wait saw; it reports false if ctx is done first.
+func (limiter *connLimiter) admit(ctx context.Context) bool {
+	for {
+		limiter.mu.Lock()
+		if limiter.max == 0 || limiter.open < limiter.max {
+			limiter.open++
+			limiter.mu.Unlock()
+			return true
+		}
+		if limiter.reject {
+			limiter.mu.Unlock()
+			return false
+		}
+		freed := limiter.freed
+		limiter.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release frees an admitted connection's slot once it is closed.
+func (limiter *connLimiter) release() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.open--
+	limiter.wake()
+}
+
+// trackedConn is an admitted connection. It counts the bytes it carries and when it last
+// carried any, and calls release with what ended it on the first close.
+type trackedConn struct {
+	net.Conn
+	// id identifies the connection among the server's, and listener is the address it
+	// was accepted on.
+	id       uint64
+	listener string
+	session  *Session
+	stats    *listenerStats
+	accepted time.Time
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	// lastActive is the UnixNano time of the last read or write that carried bytes.
+	lastActive atomic.Int64
+	release    func(cause error)
+	once       sync.Once
+	// forget, if set, removes the connection from the event loop before it is closed.
+	forget func()
+}
+
+func (conn *trackedConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	if n > 0 {
+		conn.lastActive.Store(time.Now().UnixNano())
+	}
+	conn.bytesIn.Add(int64(n))
+	conn.stats.bytesRead.Add(int64(n))
+	return n, err
+}
+
+func (conn *trackedConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	if n > 0 {
+		conn.lastActive.Store(time.Now().UnixNano())
+	}
+	conn.bytesOut.Add(int64(n))
+	conn.stats.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+func (conn *trackedConn) Close() error {
+	return conn.closeWith(nil)
+}
+
+// closeWith closes the connection, recording cause as what ended it unless it was
+// already closed. The cause is recorded first, so that a worker whose read or write
+// fails as a result doesn't take its place.
+func (conn *trackedConn) closeWith(cause error) error {
+	if conn.forget != nil {
+		conn.forget()
+	}
+	conn.once.Do(func() { conn.release(cause) })
+	return conn.Conn.Close()
+}
+
+// turnAway tells a client why its connection isn't served, and closes the connection.
+func turnAway(conn net.Conn, framer framing.Framer, message string) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(turnAwayTimeout))
+	framer.WriteFrame([]byte(message))
+}
+
+// Task implementation for serving a connection
+type connTask struct {
+	conn    *trackedConn
+	handler Handler
+	stats   *serverStats
+	// framer keeps the part of a message read before a read timed out. Messages are
+	// written through the session, which may also send them from other goroutines.
+	framer       framing.Framer
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// keepAlive serves messages until the client closes the connection. Either way, the
+	// connection is closed once it has taken idleTimeout to send a message since
+	// idleSince, when it was accepted or its last message answered.
+	keepAlive   bool
+	idleTimeout time.Duration
+	idleSince   time.Time
+	// answered is set once a kept-alive connection has answered a message. It is read
+	// by the drain callback, off the worker.
+	answered atomic.Bool
+	// draining is done once the server stops accepting, when a kept-alive connection is
+	// closed between messages, after sending goAwayMessage if set.
+	draining      context.Context
+	goAwayMessage string
+	// retry requeues the connection when a read times out. retrying is the task run with
+	// retry, submitted whenever the connection needs a worker. Its attempts start over
+	// with every message read, whether it then waits for the next on a worker or parked.
+	retry    pool.RetryPolicy
+	retrying pool.Task
+	// poller, if set, parks the connection while it waits for a message, by its file
+	// descriptor fd. The fields after it are guarded by the poller's mutex.
+	poller     *poller
+	fd         int
+	registered bool
+	forgotten  bool
+	idleTimer  *time.Timer
+}
+
+func (server *Server) newConnTask(conn *trackedConn, opts *Options, draining context.Context) *connTask {
+	task := &connTask{
+		conn:          conn,
+		handler:       server.handler,
+		stats:         &server.stats,
+		framer:        conn.session.framer,
+		readTimeout:   opts.ReadTimeout,
+		writeTimeout:  opts.WriteTimeout,
+		keepAlive:     opts.KeepAlive,
+		idleTimeout:   opts.IdleTimeout,
+		idleSince:     time.Now(),
+		draining:      draining,
+		goAwayMessage: opts.GoAwayMessage,
+		retry:         retryPolicy(opts),
+		fd:            -1,
+	}
+	task.retrying = server.workers.WithRetry(task, task.retry)
+	if server.poller != nil {
+		if fd, ok := connFD(conn.Conn); ok {
+			task.poller, task.fd = server.poller, fd
+			conn.forget = func() { server.poller.forget(task) }
+		}
+	}
+	return task
+}
+
+// connFD returns the file descriptor of a TCP or unix socket connection. TLS and other
+// wrapped connections have none, as they may buffer what they read.
+func connFD(conn net.Conn) (int, bool) {
+	sysConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := sysConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	fd := -1
+	raw.Control(func(sysFD uintptr) { fd = int(sysFD) })
+	return fd, fd >= 0
+}
+
+// errParked is returned by serve once the connection is parked, to be served again when
+// the client sends its next message.
+var errParked = errors.New("connection parked")
+
+// errWriteTimeout is returned when a client doesn't take a response within the write
+// timeout. Unlike a read, a timed-out write can't be resumed, so it isn't retried.
+var errWriteTimeout = errors.New("write timed out")
+
+// Run serves the connection. A read that times out leaves the connection open and
+// returns the error, so the pool can retry the connection later while the worker serves
+// others; any other outcome closes it.
+func (task *connTask) Run(ctx context.Context) (err error) {
+	task.conn.stats.active.Add(1)
+	returned, parked := false, false
+	defer func() {
+		task.conn.stats.active.Add(-1)
+		// A panicking handler's connection is closed by onPanic, recording the panic
+		if returned && !parked && !isTimeout(err) {
+			task.conn.closeWith(err)
+		}
+	}()
+
+	err = task.serve(ctx)
+	returned = true
+	if errors.Is(err, errParked) {
+		parked, err = true, nil
+	}
+	return err
+}
+
+// serve answers the client's messages until the connection ends or a read times out.
+func (task *connTask) serve(ctx context.Context) error {
+	// Abort reads and writes once the pool is cancelled
+	stop := context.AfterFunc(ctx, func() { task.conn.closeWith(ctx.Err()) })
+	defer stop()
+	ctx = context.WithValue(ctx, sessionKey{}, task.conn.session)
+
+	// Stop waiting for a kept-alive client's next message once draining begins
+	stopDrain := context.AfterFunc(task.draining, func() {
+		if task.answered.Load() {
+			task.conn.SetReadDeadline(time.Now())
+		}
+	})
+	defer stopDrain()
+
+	for {
+		task.setReadDeadline()
+		if task.drained(nil) {
+			task.goAway()
+			return nil
+		}
+
+		// Read a message from the client
+		message, err := task.framer.ReadFrame()
+		if err != nil {
+			if task.drained(err) {
+				task.goAway()
+				return nil
+			}
+			if task.finished(err) {
+				return nil
+			}
+			if errors.Is(err, framing.ErrFrameTooLarge) {
+				task.conn.session.write([]byte(tooLargeMessage), turnAwayTimeout)
+				return &connError{ErrorTooLarge, fmt.Errorf("failed to read from client: %w", err)}
+			}
+			return &connError{ErrorRead, fmt.Errorf("failed to read from client: %w", err)}
+		}
+		// Only reads timing out in a row count toward the read attempts
+		pool.ResetRetries(ctx)
+
+		// Upgrade to TLS if the client asks to with the StartTLS option
+		if conn, ok := task.conn.Conn.(*startTLSConn); ok && isStartTLS(message) {
+			if err := task.startTLS(conn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Process the message and generate a response
+		task.conn.session.authenticate()
+		start := time.Now()
+		response, err := task.handler.Handle(ctx, message)
+		task.stats.handler.observe(time.Since(start))
+		if err != nil {
+			return &connError{ErrorHandler, fmt.Errorf("failed to handle message: %w", err)}
+		}
+
+		// Send the response back to the client
+		if err := task.conn.session.write(response, task.writeTimeout); err != nil {
+			return task.writeFailed(err)
+		}
+		if !task.keepAlive || task.conn.session.quit.Load() {
+			return nil
+		}
+		task.answered.Store(true)
+		task.idleSince = time.Now()
+		if task.park() {
+			return errParked
+		}
+	}
+}
+
+// writeFailed returns the connection error for a failed write of err.
+func (task *connTask) writeFailed(err error) error {
+	if errors.Is(err, errSlowClient) {
+		return &connError{ErrorWriteTimeout, fmt.Errorf("failed to write to client: %w", err)}
+	}
+	if isTimeout(err) {
+		return &connError{ErrorWriteTimeout, fmt.Errorf("failed to write to client: %w after %v", errWriteTimeout, task.writeTimeout)}
+	}
+	return &connError{ErrorWrite, fmt.Errorf("failed to write to client: %w", err)}
+}
+
+// startTLS answers a STARTTLS command and upgrades the connection to TLS, or tells the
+// client it is already using TLS. A client that sent more after the command is cut off,
+// lest what it sent be taken as sent over TLS, and so is one whose framer can't tell.
+func (task *connTask) startTLS(conn *startTLSConn) error {
+	if _, ok := tlsConn(conn); ok {
+		if err := task.conn.session.write([]byte(startTLSAlreadyMessage), task.writeTimeout); err != nil {
+			return task.writeFailed(err)
+		}
+		return nil
+	}
+	framer, ok := task.framer.(framing.BufferedFramer)
+	if !ok {
+		return &connError{ErrorRead, fmt.Errorf("failed to read from client: %w", errStartTLSUnbuffered)}
+	}
+	if framer.Buffered() > 0 {
+		return &connError{ErrorRead, fmt.Errorf("failed to read from client: %w", errStartTLSPipelined)}
+	}
+	if err := task.conn.session.startTLS(conn, []byte(startTLSReadyMessage), task.writeTimeout); err != nil {
+		return task.writeFailed(err)
+	}
+	return nil
+}
+
+// park hands the connection to the event loop, if there is one, to wait for its next
+// message off the worker. It reports false if the connection stays on the worker,
+// e.g. because the next message was read already.
+func (task *connTask) park() bool {
+	if task.poller == nil {
+		return false
+	}
+	if framer, ok := task.framer.(framing.BufferedFramer); !ok || framer.Buffered() > 0 {
+		return false
+	}
+	return task.poller.park(task)
+}
+
+// finished reports whether a failed read ends the connection cleanly: it went idle, or
+// a kept-alive client closed it between messages.
+func (task *connTask) finished(err error) bool {
+	if isTimeout(err) && task.idleExpired() {
+		return true
+	}
+	return task.answered.Load() && errors.Is(err, io.EOF)
+}
+
+// drained reports whether a kept-alive connection is between messages, having answered
+// one and waiting for the next, or its wait cut short by err, once draining has begun.
+func (task *connTask) drained(err error) bool {
+	return task.answered.Load() && task.draining.Err() != nil && (err == nil || isTimeout(err))
+}
+
+// goAway tells a kept-alive client the server is going away, if there is a message to
+// say so, so that it reconnects elsewhere rather than finding the connection closed.
+func (task *connTask) goAway() {
+	if task.goAwayMessage == "" {
+		return
+	}
+	task.conn.session.write([]byte(task.goAwayMessage), turnAwayTimeout)
+}
+
+// setReadDeadline bounds the next read by the read timeout and the idle timeout.
+func (task *connTask) setReadDeadline() {
+	var deadline time.Time
+	if task.readTimeout > 0 {
+		deadline = time.Now().Add(task.readTimeout)
+	}
+	if task.idleTimeout > 0 {
+		if idleDeadline := task.idleSince.Add(task.idleTimeout); deadline.IsZero() || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	task.conn.SetReadDeadline(deadline)
+}
+
+// idleExpired reports whether the connection has waited the idle timeout for a message.
+func (task *connTask) idleExpired() bool {
+	return task.idleTimeout > 0 && time.Since(task.idleSince) >= task.idleTimeout
+}
+
+// Key returns the client's IP, so that one client opening many connections takes turns
+// with the others for workers.
+func (task *connTask) Key() string {
+	host, _, err := net.SplitHostPort(task.conn.RemoteAddr().String())
+	if err != nil {
+		return task.conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// Deadline returns when the connection's idle timeout expires, so that the
+// EarliestDeadline discipline serves the connections closest to being closed for idling
+// first. Connections without an idle timeout have none.
+func (task *connTask) Deadline() (time.Time, bool) {
+	if task.idleTimeout <= 0 {
+		return time.Time{}, false
+	}
+	return task.idleSince.Add(task.idleTimeout), true
+}
+
+// isTimeout reports whether err is a read that timed out, which the pool retries.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
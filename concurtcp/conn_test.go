@@ -0,0 +1,20 @@
+package concurtcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blueai2022/net_prg/pool"
+)
+
+func TestConnDeadline(t *testing.T) {
+	idleSince := time.Now()
+	var task pool.Deadlined = &connTask{idleTimeout: time.Minute, idleSince: idleSince}
+	if deadline, ok := task.Deadline(); !ok || !deadline.Equal(idleSince.Add(time.Minute)) {
+		t.Errorf("got %v, %v; want %v", deadline, ok, idleSince.Add(time.Minute))
+	}
+	task = &connTask{idleSince: idleSince}
+	if _, ok := task.Deadline(); ok {
+		t.Error("a connection without an idle timeout has a deadline")
+	}
+}
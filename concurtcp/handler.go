@@ -0,0 +1,35 @@
+package concurtcp
+
+import (
+	"context"
+	"fmt"
+)
+
+// Handler answers the messages of a connection. Handle is called on a worker with each
+// message, framing removed, and its response is sent back as one frame. An error closes
+// the connection; ctx is done when the worker pool is cancelled, and carries the
+// connection's Session for handlers that keep state across messages.
+type Handler interface {
+	Handle(ctx context.Context, request []byte) ([]byte, error)
+}
+
+// SessionHandler is a Handler told when connections open, before their first message,
+// and when they close, e.g. to track the clients connected and send them messages with
+// Session.Send.
+type SessionHandler interface {
+	Handler
+	OpenSession(session *Session)
+	CloseSession(session *Session)
+}
+
+// HandlerFunc lets an ordinary function be used as a Handler.
+type HandlerFunc func(ctx context.Context, request []byte) ([]byte, error)
+
+func (fn HandlerFunc) Handle(ctx context.Context, request []byte) ([]byte, error) {
+	return fn(ctx, request)
+}
+
+// Echo answers each message with the message itself, prefixed with "Received: ".
+var Echo Handler = HandlerFunc(func(ctx context.Context, request []byte) ([]byte, error) {
+	return fmt.Appendf(nil, "Received: %s", request), nil
+})
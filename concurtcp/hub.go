@@ -0,0 +1,130 @@
+package concurtcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Hub is a SessionHandler that makes the server a chat server: it tracks the connected
+// clients and relays the messages each sends to the others, all of them or those that
+// joined a topic. Clients send:
+//
+//	text             to relay text to every other client
+//	/join topic      to receive the messages published to topic
+//	/leave topic     to stop receiving them
+//	/pub topic text  to relay text to the other clients that joined topic
+//
+// and are answered with how many clients a message was relayed to. Relayed messages read
+// "from: text", or "from [topic]: text", where from is the sender's Principal, or its
+// address if it has none. Clients receive messages between their own only while their
+// connection is kept alive (Options.KeepAlive).
+type Hub struct {
+	mu sync.Mutex
+	// topics holds the sessions that joined each topic. Every session is in the ""
+	// topic, which messages to everyone are relayed to.
+	topics map[string]map[*Session]struct{}
+}
+
+// NewHub creates a Hub with no clients.
+func NewHub() *Hub {
+	return &Hub{topics: make(map[string]map[*Session]struct{})}
+}
+
+// OpenSession adds a connection to the clients messages to everyone are relayed to.
+func (hub *Hub) OpenSession(session *Session) {
+	hub.Join(session, "")
+}
+
+// CloseSession removes a connection from every topic.
+func (hub *Hub) CloseSession(session *Session) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for topic, sessions := range hub.topics {
+		delete(sessions, session)
+		if len(sessions) == 0 {
+			delete(hub.topics, topic)
+		}
+	}
+}
+
+// Join subscribes session to the messages published to topic.
+func (hub *Hub) Join(session *Session, topic string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sessions, ok := hub.topics[topic]
+	if !ok {
+		sessions = make(map[*Session]struct{})
+		hub.topics[topic] = sessions
+	}
+	sessions[session] = struct{}{}
+}
+
+// Leave unsubscribes session from topic.
+func (hub *Hub) Leave(session *Session, topic string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delete(hub.topics[topic], session)
+	if len(hub.topics[topic]) == 0 {
+		delete(hub.topics, topic)
+	}
+}
+
+// Broadcast sends message to the sessions that joined topic, or to every session for
+// the "" topic, other than from, which may be nil. It returns how many took the message;
+// the connections of those that didn't in time are closed by Session.Send.
+func (hub *Hub) Broadcast(from *Session, topic string, message []byte) int {
+	hub.mu.Lock()
+	recipients := make([]*Session, 0, len(hub.topics[topic]))
+	for session := range hub.topics[topic] {
+		if session != from {
+			recipients = append(recipients, session)
+		}
+	}
+	hub.mu.Unlock()
+
+	sent := 0
+	for _, session := range recipients {
+		if session.Send(message) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Handle relays a client's message or carries out its command.
+func (hub *Hub) Handle(ctx context.Context, request []byte) ([]byte, error) {
+	session := SessionFromContext(ctx)
+	command, args, _ := strings.Cut(string(request), " ")
+	switch command {
+	case "/join", "/leave":
+		topic := strings.TrimSpace(args)
+		if topic == "" {
+			return fmt.Appendf(nil, "usage: %s topic", command), nil
+		}
+		if command == "/join" {
+			hub.Join(session, topic)
+			return fmt.Appendf(nil, "joined %s", topic), nil
+		}
+		hub.Leave(session, topic)
+		return fmt.Appendf(nil, "left %s", topic), nil
+	case "/pub":
+		topic, text, ok := strings.Cut(args, " ")
+		if !ok || topic == "" {
+			return []byte("usage: /pub topic text"), nil
+		}
+		sent := hub.Broadcast(session, topic, fmt.Appendf(nil, "%s [%s]: %s", sender(session), topic, text))
+		return fmt.Appendf(nil, "sent to %d", sent), nil
+	}
+	sent := hub.Broadcast(session, "", fmt.Appendf(nil, "%s: %s", sender(session), request))
+	return fmt.Appendf(nil, "sent to %d", sent), nil
+}
+
+// sender names the client of session in relayed messages.
+func sender(session *Session) string {
+	if principal := session.Principal(); principal != "" {
+		return principal
+	}
+	return session.RemoteAddr().String()
+}
@@ -0,0 +1,29 @@
+package concurtcp
+
+import (
+	"fmt"
+	"net"
+)
+
+// keepAliveListener sets the keep-alive probes of the TCP connections it accepts to the
+// config current at the time, so reloaded options apply to new connections.
+type keepAliveListener struct {
+	net.Listener
+	config func() net.KeepAliveConfig
+}
+
+func (listener *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if err := tcp.SetKeepAliveConfig(listener.config()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set keep-alive probes: %w", err)
+	}
+	return conn, nil
+}
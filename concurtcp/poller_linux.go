@@ -0,0 +1,143 @@
+package concurtcp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollInterval is how long the poller waits for events at a time before checking whether
+// it was drained.
+const pollInterval = 100 * time.Millisecond
+
+// poller parks connections waiting for a message in an epoll set rather than on a
+// worker, and wakes them once they are readable. Registrations are one-shot, so a woken
+// connection is served by one worker until it is parked again.
+type poller struct {
+	epfd int
+
+	mu sync.Mutex
+	// parked holds the parked connections by file descriptor.
+	parked  map[int]*connTask
+	drained bool
+}
+
+func newPoller() (*poller, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create epoll set: %w", err)
+	}
+	return &poller{epfd: epfd, parked: make(map[int]*connTask)}, nil
+}
+
+// park waits for task's connection to become readable off the worker, or closes it once
+// it has idled for its idle timeout. It reports false if the connection can't be parked,
+// e.g. because the poller was drained, when the worker should keep serving it.
+func (p *poller) park(task *connTask) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drained || task.forgotten {
+		return false
+	}
+
+	op := unix.EPOLL_CTL_MOD
+	if !task.registered {
+		op = unix.EPOLL_CTL_ADD
+	}
+	event := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT, Fd: int32(task.fd)}
+	if err := unix.EpollCtl(p.epfd, op, task.fd, &event); err != nil {
+		return false
+	}
+	task.registered = true
+	p.parked[task.fd] = task
+	if task.idleTimeout > 0 {
+		task.idleTimer = time.AfterFunc(time.Until(task.idleSince.Add(task.idleTimeout)), func() {
+			if p.take(task.fd, task) != nil {
+				task.conn.Close()
+			}
+		})
+	}
+	return true
+}
+
+// take unparks the connection parked on fd, if it is task or task is nil, and returns it.
+func (p *poller) take(fd int, task *connTask) *connTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.takeLocked(fd, task)
+}
+
+// takeLocked is take for a caller holding mu.
+func (p *poller) takeLocked(fd int, task *connTask) *connTask {
+	parked, ok := p.parked[fd]
+	if !ok || task != nil && parked != task {
+		return nil
+	}
+	delete(p.parked, fd)
+	if parked.idleTimer != nil {
+		parked.idleTimer.Stop()
+	}
+	return parked
+}
+
+// forget removes task's connection from the epoll set before it is closed, so the file
+// descriptor can be reused, and keeps it from being parked again.
+func (p *poller) forget(task *connTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.takeLocked(task.fd, task)
+	task.forgotten = true
+	if task.registered {
+		unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, task.fd, nil)
+	}
+}
+
+// run hands readable connections to wake until the poller is drained, or returns the
+// error waiting for events failed with.
+func (p *poller) run(wake func(task *connTask)) error {
+	defer unix.Close(p.epfd)
+	events := make([]unix.EpollEvent, 128)
+	for {
+		n, err := unix.EpollWait(p.epfd, events, int(pollInterval.Milliseconds()))
+		if err != nil && !errors.Is(err, unix.EINTR) {
+			return fmt.Errorf("failed to wait for parked connections: %w", err)
+		}
+		p.mu.Lock()
+		drained := p.drained
+		p.mu.Unlock()
+		if drained {
+			return nil
+		}
+
+		for _, event := range events[:max(n, 0)] {
+			if task := p.take(int(event.Fd), nil); task != nil {
+				wake(task)
+			}
+		}
+	}
+}
+
+// drain stops parking connections and closes the parked ones, telling kept-alive clients
+// the server is going away.
+func (p *poller) drain() {
+	p.mu.Lock()
+	p.drained = true
+	parked := make([]*connTask, 0, len(p.parked))
+	for _, task := range p.parked {
+		parked = append(parked, task)
+	}
+	p.mu.Unlock()
+
+	for _, task := range parked {
+		if p.take(task.fd, task) == nil {
+			continue
+		}
+		if task.answered.Load() {
+			task.goAway()
+		}
+		task.conn.Close()
+	}
+}
@@ -0,0 +1,132 @@
+package concurtcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blueai2022/net_prg/pool"
+	"golang.org/x/sys/unix"
+)
+
+// Connections waiting for their next message in the event loop take no worker, so more
+// kept-alive connections than workers are all answered.
+func TestEventLoopFreesWorkers(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true, EventLoop: true})
+
+	// More than startServer's 4 workers
+	clients := make([]*testClient, 10)
+	for i := range clients {
+		clients[i] = dial(t, server)
+		message := fmt.Sprintf("first %d", i)
+		if got, want := clients[i].exchange(message), "Received: "+message; got != want {
+			t.Fatalf("client %d: got %q, want %q", i, got, want)
+		}
+	}
+	for i := len(clients) - 1; i >= 0; i-- {
+		message := fmt.Sprintf("second %d", i)
+		if got, want := clients[i].exchange(message), "Received: "+message; got != want {
+			t.Errorf("client %d: got %q, want %q", i, got, want)
+		}
+	}
+	// All of them wait in the event loop rather than on a worker
+	waitStats(t, server, func(stats Stats) bool { return stats.Active == 0 })
+	if conns := server.Conns(); len(conns) != len(clients) {
+		t.Errorf("%d connections open, want %d", len(conns), len(clients))
+	}
+}
+
+func TestEventLoopPipelined(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true, EventLoop: true})
+	client := dial(t, server)
+	client.send("one\ntwo")
+	for _, want := range []string{"Received: one", "Received: two"} {
+		if got := client.receive(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+// Connections woken from the event loop count their read attempts as they do on a worker.
+func TestEventLoopReadAttemptsPerMessage(t *testing.T) {
+	const readTimeout = 50 * time.Millisecond
+	server := startServer(t, nil, Options{KeepAlive: true, EventLoop: true, ReadTimeout: readTimeout, ReadAttempts: 2})
+	slowMessages(t, server, readTimeout)
+}
+
+// Connections woken while the only worker is busy, with no room to queue them, are closed
+// after wakeTimeout rather than holding up the event loop, which goes on waking others.
+func TestEventLoopWakeOverload(t *testing.T) {
+	release := make(chan struct{})
+	handler := HandlerFunc(func(ctx context.Context, request []byte) ([]byte, error) {
+		if string(request) == "block" {
+			<-release
+		}
+		return request, nil
+	})
+	workers := pool.NewPriority(1, 0)
+	workers.Run()
+	defer workers.Close()
+	server, err := Listen([]string{"127.0.0.1:0"}, handler, Options{KeepAlive: true, EventLoop: true, Logger: slog.New(slog.DiscardHandler)}, workers)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx)
+	defer server.Close()
+	defer close(release)
+
+	parked := []*testClient{dial(t, server), dial(t, server)}
+	for _, client := range parked {
+		client.exchange("hi")
+	}
+	dial(t, server).send("block")
+	waitStats(t, server, func(stats Stats) bool { return stats.Active == 1 })
+
+	for _, client := range parked {
+		client.send("again")
+	}
+	for i, client := range parked {
+		if !client.closed() {
+			t.Errorf("client %d still open, woken with no room in the pool", i)
+		}
+	}
+}
+
+// A failing event loop stops the server rather than crashing the process.
+func TestEventLoopFailureStopsServe(t *testing.T) {
+	workers := pool.NewPriority(4, 16)
+	workers.Run()
+	defer workers.Close()
+	server, err := Listen([]string{"127.0.0.1:0"}, nil, Options{KeepAlive: true, EventLoop: true, Logger: slog.New(slog.DiscardHandler)}, workers)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer server.Close()
+
+	// Put a file that isn't an epoll set in place of the poller's, so that waiting for
+	// events fails
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	if err := unix.Dup2(int(null.Fd()), server.poller.epfd); err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(context.Background()) }()
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("Serve returned no error for the failed event loop")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Serve kept accepting after the event loop failed")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package concurtcp
+
+import "errors"
+
+// poller is only implemented on Linux; elsewhere connections wait on their workers.
+type poller struct{}
+
+func newPoller() (*poller, error) {
+	return nil, errors.New("the event loop is only supported on Linux")
+}
+
+func (p *poller) park(task *connTask) bool {
+	return false
+}
+
+func (p *poller) forget(task *connTask) {}
+
+func (p *poller) run(wake func(task *connTask)) error {
+	return nil
+}
+
+func (p *poller) drain() {}
@@ -0,0 +1,69 @@
+package concurtcp
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// ConnInfo describes an open connection, as listed by Conns.
+type ConnInfo struct {
+	ID         uint64    `json:"id"`
+	Listener   string    `json:"listener"`
+	RemoteAddr string    `json:"remote_addr"`
+	Principal  string    `json:"principal,omitempty"`
+	Accepted   time.Time `json:"accepted"`
+	// Uptime is how long the connection has been open, and Idle how long since it last
+	// read or wrote any bytes.
+	Uptime     time.Duration `json:"uptime"`
+	LastActive time.Time     `json:"last_active"`
+	Idle       time.Duration `json:"idle"`
+	BytesIn    int64         `json:"bytes_in"`
+	BytesOut   int64         `json:"bytes_out"`
+}
+
+// Conns returns the connections open now, including the ones queued for a worker, in the
+// order they were accepted.
+func (server *Server) Conns() []ConnInfo {
+	server.mu.Lock()
+	conns := make([]*trackedConn, 0, len(server.conns))
+	for _, conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.mu.Unlock()
+
+	now := time.Now()
+	infos := make([]ConnInfo, len(conns))
+	for i, conn := range conns {
+		lastActive := time.Unix(0, conn.lastActive.Load())
+		infos[i] = ConnInfo{
+			ID:         conn.id,
+			Listener:   conn.listener,
+			RemoteAddr: conn.RemoteAddr().String(),
+			Principal:  conn.session.Principal(),
+			Accepted:   conn.accepted,
+			Uptime:     now.Sub(conn.accepted),
+			LastActive: lastActive,
+			Idle:       now.Sub(lastActive),
+			BytesIn:    conn.bytesIn.Load(),
+			BytesOut:   conn.bytesOut.Load(),
+		}
+	}
+	slices.SortFunc(infos, func(a, b ConnInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return infos
+}
+
+// CloseConn forcibly closes the open connection with id, as listed by Conns, e.g. to cut
+// off a misbehaving client. It returns false if there is no such connection.
+func (server *Server) CloseConn(id uint64) bool {
+	server.mu.Lock()
+	conn, ok := server.conns[id]
+	server.mu.Unlock()
+	if !ok {
+		return false
+	}
+	conn.closeWith(errKilled)
+	return true
+}
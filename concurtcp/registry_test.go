@@ -0,0 +1,39 @@
+package concurtcp
+
+import (
+	"testing"
+)
+
+func TestConnsAndCloseConn(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true})
+	client := dial(t, server)
+	if got, want := client.exchange("hello"), "Received: hello"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	conns := server.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("listed %d connections, want 1", len(conns))
+	}
+	conn := conns[0]
+	if conn.RemoteAddr != client.LocalAddr().String() {
+		t.Errorf("remote address %s, want %s", conn.RemoteAddr, client.LocalAddr())
+	}
+	if conn.BytesIn != int64(len("hello\n")) || conn.BytesOut != int64(len("Received: hello\n")) {
+		t.Errorf("counted %d bytes in and %d out", conn.BytesIn, conn.BytesOut)
+	}
+
+	if !server.CloseConn(conn.ID) {
+		t.Fatal("failed to close a listed connection")
+	}
+	if !client.closed() {
+		t.Error("connection still open after CloseConn")
+	}
+	waitStats(t, server, func(stats Stats) bool { return stats.Active == 0 })
+	if conns := server.Conns(); len(conns) != 0 {
+		t.Errorf("still listed %v", conns)
+	}
+	if server.CloseConn(conn.ID) {
+		t.Error("closed a connection that is gone")
+	}
+}
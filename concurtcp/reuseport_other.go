@@ -0,0 +1,13 @@
+//go:build !linux
+
+package concurtcp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is only implemented on Linux; elsewhere a port has one accept shard.
+func reusePort(network, address string, raw syscall.RawConn) error {
+	return errors.New("accept shards are only supported on Linux")
+}
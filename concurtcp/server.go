@@ -0,0 +1,548 @@
+// Package concurtcp serves message-based TCP connections on a worker pool. The server
+// accepts connections and hands each to a worker, which reads the client's messages and
+// answers them with a Handler, so applications supply only the Handler and reuse the
+// framing, timeouts, connection limit, TLS and graceful draining.
+package concurtcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueai2022/net_prg/chaos"
+	"github.com/blueai2022/net_prg/framing"
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// Options controls a server. Zero values select the defaults.
+type Options struct {
+	// NewFramer delimits the messages of a connection (default framing.NewLine).
+	NewFramer framing.NewFunc
+	// MaxMessageSize bounds the messages read with the built-in framings (default
+	// framing.MaxFrameSize). A client sending a larger one is answered with an error
+	// message and its connection closed.
+	MaxMessageSize int
+	// TLSConfig serves TLS when set. Handshakes happen on the workers.
+	TLSConfig *tls.Config
+	// StartTLS accepts connections in plaintext instead, and upgrades them to TLS with
+	// TLSConfig when the client sends STARTTLS, answered with "OK begin TLS", so that one
+	// port serves both while clients migrate. It needs KeepAlive for the messages after
+	// STARTTLS to be served, and a NewFramer returning a framing.BufferedFramer, as the
+	// built-in ones do, to catch data pipelined behind STARTTLS; with any other framer,
+	// connections sending STARTTLS are closed.
+	StartTLS bool
+	// KeepAlive answers every message a client sends until it closes the connection or
+	// idles, instead of only the first.
+	KeepAlive bool
+	// GoAwayMessage, if set, is sent to kept-alive connections closed between messages
+	// once the server stops accepting, so clients can reconnect elsewhere rather than
+	// find the connection dropped.
+	GoAwayMessage string
+	// ReadTimeout is how long a worker waits for a message before requeueing the
+	// connection, so slow clients don't hold a worker (default none). ReadAttempts is how
+	// many times in a row a connection may time out before it is dropped (default 3).
+	ReadTimeout  time.Duration
+	ReadAttempts int
+	// WriteTimeout is how long a client may take to take a response before the connection
+	// is dropped (default none).
+	WriteTimeout time.Duration
+	// WriteBufferSize is the size of the buffer responses are written to before they are
+	// flushed to the client whole, in chunks of up to this size (default 4096).
+	// SlowClientTimeout is how long a client may take none of a chunk, its send buffer
+	// staying full, before the connection is closed (default none); unlike WriteTimeout,
+	// it lets a client taking a large response steadily take as long as it needs.
+	WriteBufferSize   int
+	SlowClientTimeout time.Duration
+	// EventLoop parks connections waiting for a message in an epoll set instead of on a
+	// worker, and hands them back to the pool once they are readable, so that idle
+	// connections, kept-alive ones included, take no worker or goroutine. TLS connections,
+	// StartTLS ones included, still wait on their workers. It is only supported on Linux.
+	EventLoop bool
+	// IdleTimeout is how long a connection may take to send its first message, or a
+	// kept-alive one its next, before it is closed (default none).
+	IdleTimeout time.Duration
+	// MaxConns caps the connections open at once (default none). Connections over it wait
+	// in the kernel's backlog, or with RejectOverLimit are closed with a busy message.
+	MaxConns        int
+	RejectOverLimit bool
+	// ClientRate limits how many connections a second each client IP may open (default
+	// none), letting it open up to ClientBurst at once after a quiet spell (default 1).
+	// Connections over it are closed with a throttle message before taking a worker.
+	// ClientCacheSize is how many recent clients' limits are tracked (default 10000).
+	ClientRate      float64
+	ClientBurst     int
+	ClientCacheSize int
+	// TCPKeepAliveIdle, TCPKeepAliveInterval and TCPKeepAliveCount tune the probes sent on
+	// idle TCP connections, so clients that vanished without closing them, e.g. behind a
+	// NAT that forgot them, are detected and their workers freed: a probe after
+	// TCPKeepAliveIdle (default 15s), then one every TCPKeepAliveInterval (default 15s),
+	// until TCPKeepAliveCount went unanswered (default 9). NoTCPKeepAlive turns them off.
+	TCPKeepAliveIdle     time.Duration
+	TCPKeepAliveInterval time.Duration
+	TCPKeepAliveCount    int
+	NoTCPKeepAlive       bool
+	// AcceptShards is how many sockets are opened on each TCP address, sharing its port
+	// with SO_REUSEPORT, which the kernel spreads connections across, each accepted on
+	// its own goroutine, so accepting keeps up with very high connection rates (default
+	// 1). More than one is only supported on Linux.
+	AcceptShards int
+	// SocketMode is the permissions of the socket file when listening on a unix socket
+	// (default 0660).
+	SocketMode os.FileMode
+	// Logger receives an access record for every connection once it is closed, with the
+	// client's address, bytes read and written, duration and the error that ended it, if
+	// any (default slog.Default()).
+	Logger *slog.Logger
+	// Chaos, if set, delays accepts and drops connections at its configured rates.
+	Chaos *chaos.Monkey
+}
+
+func (opts *Options) setDefaults() {
+	if opts.NewFramer == nil {
+		opts.NewFramer = framing.NewLine
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = framing.MaxFrameSize
+	}
+	if opts.ReadAttempts <= 0 {
+		opts.ReadAttempts = 3
+	}
+	if opts.AcceptShards <= 0 {
+		opts.AcceptShards = 1
+	}
+	if opts.WriteBufferSize <= 0 {
+		opts.WriteBufferSize = 4096
+	}
+	if opts.ClientCacheSize <= 0 {
+		opts.ClientCacheSize = 10000
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.SocketMode == 0 {
+		opts.SocketMode = 0o660
+	}
+}
+
+// tcpKeepAlive returns the keep-alive probe settings; zero fields select net's defaults.
+func (opts *Options) tcpKeepAlive() net.KeepAliveConfig {
+	return net.KeepAliveConfig{
+		Enable:   !opts.NoTCPKeepAlive,
+		Idle:     opts.TCPKeepAliveIdle,
+		Interval: opts.TCPKeepAliveInterval,
+		Count:    opts.TCPKeepAliveCount,
+	}
+}
+
+// The backoff between failed accepts, doubling from the minimum.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+var (
+	// errChaosDrop ends connections dropped in chaos mode.
+	errChaosDrop = errors.New("dropped by chaos mode")
+	// errClosed ends connections closed by Close.
+	errClosed = errors.New("server closed")
+	// errKilled ends connections closed by CloseConn.
+	errKilled = errors.New("closed by an operator")
+)
+
+// Server accepts TCP or unix socket connections on one or more listeners and serves
+// each on a worker pool.
+type Server struct {
+	listeners []*listener
+	handler   Handler
+	workers   *pool.Pool
+	stats     serverStats
+
+	// options holds the options for connections accepted from now on, replaced by
+	// SetOptions, with the limits they set across listeners.
+	options  atomic.Pointer[Options]
+	limiter  *connLimiter
+	throttle *clientLimiter
+	// poller parks connections waiting for a message with the EventLoop option.
+	poller *poller
+
+	// conns holds the open connections by ID, for Close, Conns and CloseConn.
+	mu     sync.Mutex
+	conns  map[uint64]*trackedConn
+	nextID uint64
+}
+
+// listener is one of the addresses a server listens on, with a socket per accept shard.
+type listener struct {
+	// addr is the address as given to Listen.
+	addr string
+	// raw are closed to stop accepting; connections add the chaos accept delays and TLS
+	// to them.
+	raw         []net.Listener
+	connections []net.Listener
+	stats       listenerStats
+}
+
+// close stops accepting on every shard.
+func (listener *listener) close() {
+	for _, raw := range listener.raw {
+		raw.Close()
+	}
+}
+
+// Listen creates a server listening on every one of addrs, e.g. "0.0.0.0:8080",
+// "[::]:8080" or a unix socket such as "unix:///var/run/app.sock", that answers messages
+// with handler, or Echo if nil. The listeners share the workers pool, which must already
+// be running, and the connection limits. Listen sets the pool's OnError and OnPanic
+// functions to count and close failed connections; other tasks' failures are still
+// logged.
+func Listen(addrs []string, handler Handler, opts Options, workers *pool.Pool) (*Server, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no address to listen on")
+	}
+	opts.setDefaults()
+	if handler == nil {
+		handler = Echo
+	}
+
+	server := &Server{
+		handler:  handler,
+		workers:  workers,
+		limiter:  newConnLimiter(opts.MaxConns, opts.RejectOverLimit),
+		throttle: newClientLimiter(opts.ClientRate, opts.ClientBurst, opts.ClientCacheSize),
+		conns:    make(map[uint64]*trackedConn),
+	}
+	for _, addr := range addrs {
+		listener, err := server.listen(addr, &opts)
+		if err != nil {
+			server.closeListeners()
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		server.listeners = append(server.listeners, listener)
+	}
+	if opts.EventLoop {
+		var err error
+		if server.poller, err = newPoller(); err != nil {
+			server.closeListeners()
+			return nil, err
+		}
+	}
+	server.options.Store(&opts)
+	workers.OnPanic(server.onPanic)
+	workers.OnError(server.onError)
+	return server, nil
+}
+
+// listen opens opts.AcceptShards sockets sharing the port of addr, or one for a unix
+// socket.
+func (server *Server) listen(addr string, opts *Options) (*listener, error) {
+	shards := opts.AcceptShards
+	if strings.HasPrefix(addr, UnixScheme) {
+		shards = 1
+	}
+
+	listener := &listener{addr: addr}
+	bind := addr
+	for range shards {
+		raw, err := listen(bind, opts.SocketMode, shards > 1)
+		if err != nil {
+			listener.close()
+			return nil, err
+		}
+		// Bind the other shards to the port the first was given, should addr leave it to
+		// the system
+		bind = raw.Addr().String()
+
+		var connections net.Listener = &keepAliveListener{Listener: raw, config: server.tcpKeepAlive}
+		connections = opts.Chaos.Listener(connections)
+		if opts.TLSConfig != nil && opts.StartTLS {
+			connections = &startTLSListener{Listener: connections, config: opts.TLSConfig}
+		} else if opts.TLSConfig != nil {
+			connections = tls.NewListener(connections, opts.TLSConfig)
+		}
+		listener.raw = append(listener.raw, raw)
+		listener.connections = append(listener.connections, connections)
+	}
+	return listener, nil
+}
+
+// closeListeners stops accepting on every listener.
+func (server *Server) closeListeners() {
+	for _, listener := range server.listeners {
+		listener.close()
+	}
+}
+
+// tcpKeepAlive returns the keep-alive probe settings for connections accepted now.
+func (server *Server) tcpKeepAlive() net.KeepAliveConfig {
+	return server.options.Load().tcpKeepAlive()
+}
+
+// Addrs returns the addresses the server is listening on, in the order given to Listen.
+func (server *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(server.listeners))
+	for i, listener := range server.listeners {
+		addrs[i] = listener.raw[0].Addr()
+	}
+	return addrs
+}
+
+// SetOptions applies opts to the connections accepted from now on; connections already
+// accepted keep the options they were accepted with. The server keeps the TLSConfig,
+// StartTLS, SocketMode, AcceptShards, EventLoop and Chaos accept delays it was created
+// with. A lowered MaxConns lets open connections over it finish, while new ones wait or
+// are rejected.
+func (server *Server) SetOptions(opts Options) {
+	opts.setDefaults()
+	server.options.Store(&opts)
+	server.limiter.set(opts.MaxConns, opts.RejectOverLimit)
+	server.throttle.set(opts.ClientRate, opts.ClientBurst, opts.ClientCacheSize)
+}
+
+// Stats returns the server's connection counts so far.
+func (server *Server) Stats() Stats {
+	return server.stats.snapshot(server.listeners)
+}
+
+// Serve accepts connections on every listener until ctx is done, handing each to the
+// worker pool, up to the connection limit. Once ctx is done, kept-alive connections close
+// when they have answered the message at hand; the pool's Shutdown waits for them. Accept
+// errors are logged and retried with a backoff; Serve returns the errors of listeners
+// closed while ctx wasn't done, once the others have stopped too. If the event loop
+// fails, Serve stops accepting on every listener as if ctx were done and returns its
+// error.
+func (server *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		server.closeListeners()
+		if server.poller != nil {
+			server.poller.drain()
+		}
+	})
+	defer stop()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	if server.poller != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.poller.run(server.wake); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+	for _, listener := range server.listeners {
+		for _, connections := range listener.connections {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := server.accept(ctx, listener, connections); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// accept accepts connections on one of listener's shards until ctx is done, or returns
+// an error if the listener is closed before.
+func (server *Server) accept(ctx context.Context, listener *listener, connections net.Listener) error {
+	var backoff time.Duration
+	for {
+		if !server.limiter.wait(ctx) {
+			return nil
+		}
+		conn, err := connections.Accept()
+		opts := server.options.Load()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("listener %s closed: %w", listener.addr, err)
+			}
+			// Back off from errors such as running out of file descriptors rather than spin,
+			// until ctx is done
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			opts.Logger.Error("failed to accept connection", "listener", listener.addr, "error", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		backoff = 0
+
+		listener.stats.accepted.Add(1)
+
+		// Turn away clients connecting too fast and connections over the limit, without
+		// holding up accepting
+		if !server.throttle.allow(conn.RemoteAddr()) {
+			listener.stats.throttled.Add(1)
+			opts.Logger.Warn("connection throttled over the client rate", "remote", conn.RemoteAddr().String())
+			go turnAway(conn, opts.NewFramer(conn), throttleMessage)
+			continue
+		}
+		if !server.limiter.admit(ctx) {
+			if ctx.Err() != nil {
+				conn.Close()
+				return nil
+			}
+			listener.stats.rejected.Add(1)
+			opts.Logger.Warn("connection rejected over the connection limit", "remote", conn.RemoteAddr().String())
+			go turnAway(conn, opts.NewFramer(conn), busyMessage)
+			continue
+		}
+		tracked := server.track(conn, listener, opts)
+
+		// In chaos mode, drop some connections as an overloaded server would
+		if opts.Chaos.DropTask() {
+			tracked.closeWith(errChaosDrop)
+			continue
+		}
+
+		// Create a new task for each connection and add it to the pool, unless ctx is done
+		// while every worker is busy, or park it until the client sends a message
+		task := server.newConnTask(tracked, opts, ctx)
+		if task.park() {
+			continue
+		}
+		if err := server.workers.SubmitContext(ctx, task.retrying); err != nil {
+			tracked.closeWith(fmt.Errorf("failed to queue connection: %w", err))
+			continue
+		}
+	}
+}
+
+// wake hands a parked connection that became readable to the worker pool. It is called
+// by the event loop, which must not wait for room in a saturated pool, so the connection
+// is handed over from a goroutine of its own, and closed if it finds no room within
+// wakeTimeout.
+func (server *Server) wake(task *connTask) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), wakeTimeout)
+		defer cancel()
+		if err := server.workers.SubmitContext(ctx, task.retrying); err != nil {
+			task.conn.closeWith(fmt.Errorf("failed to queue connection: %w", err))
+		}
+	}()
+}
+
+// Close closes every open connection, e.g. once draining has taken too long, including
+// the ones queued for a worker.
+func (server *Server) Close() {
+	server.mu.Lock()
+	conns := make([]*trackedConn, 0, len(server.conns))
+	for _, conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.closeWith(errClosed)
+	}
+}
+
+// retryPolicy requeues slow clients when a read times out, so they don't hold a worker.
+func retryPolicy(opts *Options) pool.RetryPolicy {
+	return pool.RetryPolicy{
+		MaxAttempts:    opts.ReadAttempts,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Jitter:         0.2,
+		Retryable:      isTimeout,
+	}
+}
+
+// track records conn, accepted and counted by listener, as open until it is closed, when
+// its slot under the connection limit is freed and its access record logged. A
+// SessionHandler is told when it opens and closes.
+func (server *Server) track(conn net.Conn, listener *listener, opts *Options) *trackedConn {
+	tracked := &trackedConn{Conn: conn, listener: listener.addr, stats: &listener.stats, accepted: time.Now()}
+	tracked.lastActive.Store(tracked.accepted.UnixNano())
+	tracked.session = newSession(tracked, opts)
+	sessions, _ := server.handler.(SessionHandler)
+	tracked.release = func(cause error) {
+		server.mu.Lock()
+		delete(server.conns, tracked.id)
+		server.mu.Unlock()
+		server.limiter.release()
+		if sessions != nil {
+			sessions.CloseSession(tracked.session)
+		}
+		logAccess(opts.Logger, tracked, cause)
+	}
+
+	server.mu.Lock()
+	server.nextID++
+	tracked.id = server.nextID
+	server.conns[tracked.id] = tracked
+	server.mu.Unlock()
+	if sessions != nil {
+		sessions.OpenSession(tracked.session)
+	}
+	return tracked
+}
+
+// logAccess logs the access record of a connection ended by cause, nil if it ended
+// cleanly.
+func logAccess(logger *slog.Logger, conn *trackedConn, cause error) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.Uint64("id", conn.id),
+		slog.String("remote", conn.RemoteAddr().String()),
+		slog.Int64("bytes_in", conn.bytesIn.Load()),
+		slog.Int64("bytes_out", conn.bytesOut.Load()),
+		slog.Duration("duration", time.Since(conn.accepted)),
+	}
+	if cause != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.Any("error", cause))
+	}
+	logger.LogAttrs(context.Background(), level, "connection closed", attrs...)
+}
+
+func (server *Server) onPanic(task any, value any, stack []byte) {
+	connTask, ok := task.(*connTask)
+	if !ok {
+		server.options.Load().Logger.Error("task panicked", "task", fmt.Sprintf("%T", task), "panic", value, "stack", string(stack))
+		return
+	}
+	connTask.conn.stats.errors.Add(1)
+	server.stats.failed(ErrorPanic)
+	server.options.Load().Logger.Error("connection handler panicked", "remote", connTask.conn.RemoteAddr().String(), "panic", value, "stack", string(stack))
+	connTask.conn.closeWith(fmt.Errorf("handler panicked: %v", value))
+}
+
+func (server *Server) onError(task any, err error) {
+	connTask, ok := task.(*connTask)
+	if !ok {
+		server.options.Load().Logger.Error("task failed", "task", fmt.Sprintf("%T", task), "error", err)
+		return
+	}
+	connTask.conn.stats.errors.Add(1)
+	server.stats.failed(errorType(err))
+	// A connection that timed out for the last time is still open; others were closed
+	// with err already
+	connTask.conn.closeWith(err)
+}
@@ -0,0 +1,171 @@
+package concurtcp
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// testTimeout bounds every read and write of a test client.
+const testTimeout = 5 * time.Second
+
+// startServer serves handler, or Echo if nil, with opts on a loopback port until the test
+// ends. Access records are discarded unless opts has a Logger.
+func startServer(t *testing.T, handler Handler, opts Options) *Server {
+	t.Helper()
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	workers := pool.NewPriority(4, 16)
+	workers.Run()
+	server, err := Listen([]string{"127.0.0.1:0"}, handler, opts, workers)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+		server.Close()
+		shutdown, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		workers.Shutdown(shutdown)
+	})
+	return server
+}
+
+// testClient is a connection to a test server exchanging newline framed messages.
+type testClient struct {
+	t *testing.T
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, server *Server) *testClient {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", server.Addrs()[0].String(), testTimeout)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes message as a line.
+func (client *testClient) send(message string) {
+	client.t.Helper()
+	client.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := client.Write([]byte(message + "\n")); err != nil {
+		client.t.Fatalf("failed to send %.20q: %v", message, err)
+	}
+}
+
+// receive reads a line, without its newline.
+func (client *testClient) receive() string {
+	client.t.Helper()
+	client.SetReadDeadline(time.Now().Add(testTimeout))
+	line, err := client.reader.ReadString('\n')
+	if err != nil {
+		client.t.Fatalf("failed to receive: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// exchange sends message and returns the response.
+func (client *testClient) exchange(message string) string {
+	client.t.Helper()
+	client.send(message)
+	return client.receive()
+}
+
+// closed reports whether the server closed the connection without sending anything more.
+func (client *testClient) closed() bool {
+	client.SetReadDeadline(time.Now().Add(testTimeout))
+	_, err := client.reader.ReadByte()
+	return err != nil && !isTimeout(err)
+}
+
+// waitStats waits for the server's Stats to satisfy done.
+func waitStats(t *testing.T, server *Server, done func(Stats) bool) Stats {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		stats := server.Stats()
+		if done(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats not reached: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	server := startServer(t, nil, Options{})
+	client := dial(t, server)
+	if got, want := client.exchange("hello"), "Received: hello"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !client.closed() {
+		t.Error("connection still open after the response without KeepAlive")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true})
+	client := dial(t, server)
+	for _, message := range []string{"one", "two", "three"} {
+		if got, want := client.exchange(message), "Received: "+message; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+// slowMessages sends messages whose second halves come a read timeout after their first,
+// so that reading each one times out once, and checks they are answered.
+func slowMessages(t *testing.T, server *Server, readTimeout time.Duration) {
+	t.Helper()
+	client := dial(t, server)
+	for _, message := range []string{"one", "two", "three"} {
+		client.Write([]byte(message[:1]))
+		time.Sleep(readTimeout * 3 / 2)
+		if got, want := client.exchange(message[1:]), "Received: "+message; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+// Only timeouts in a row count toward the read attempts, so a kept-alive connection whose
+// every message times out once outlives them.
+func TestReadAttemptsPerMessage(t *testing.T) {
+	const readTimeout = 50 * time.Millisecond
+	server := startServer(t, nil, Options{KeepAlive: true, ReadTimeout: readTimeout, ReadAttempts: 2})
+	slowMessages(t, server, readTimeout)
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true, MaxMessageSize: 100})
+	client := dial(t, server)
+	if got, want := client.exchange(strings.Repeat("a", 100)), "Received: "+strings.Repeat("a", 100); got != want {
+		t.Errorf("message at the limit: got %.20q, want %.20q", got, want)
+	}
+	if got := client.exchange(strings.Repeat("a", 101)); got != tooLargeMessage {
+		t.Errorf("message over the limit: got %.20q, want %q", got, tooLargeMessage)
+	}
+	if !client.closed() {
+		t.Error("connection still open after a message over the limit")
+	}
+	waitStats(t, server, func(stats Stats) bool { return stats.ErrorTypes[ErrorTooLarge] == 1 })
+}
@@ -0,0 +1,209 @@
+package concurtcp
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueai2022/net_prg/framing"
+)
+
+// sendTimeout bounds Send when there is no WriteTimeout, so a client that stopped reading
+// doesn't hold up the sender.
+const sendTimeout = time.Second
+
+// Session is the state of one connection, kept across its messages so that handlers can
+// be stateful, e.g. remember a client that logged in. Handlers get it from their ctx with
+// SessionFromContext. It is safe for concurrent use.
+type Session struct {
+	created time.Time
+	conn    *trackedConn
+	// framer reads the client's messages and writes the connection's responses and the
+	// messages sent to it to writer, which flushes each, writeMu keeping them whole,
+	// within writeTimeout if set.
+	framer       framing.Framer
+	writer       *connWriter
+	writeMu      sync.Mutex
+	writeTimeout time.Duration
+	// quit is set once the connection should be closed after the response at hand.
+	quit atomic.Bool
+
+	mu        sync.Mutex
+	principal string
+	values    map[string]any
+	// authenticated is set once the principal was taken from the client's certificate, if
+	// any.
+	authenticated bool
+}
+
+func newSession(conn *trackedConn, opts *Options) *Session {
+	writer := newConnWriter(conn, opts.WriteBufferSize, opts.SlowClientTimeout)
+	return &Session{
+		created: conn.accepted,
+		conn:    conn,
+		framer: framing.Limit(opts.NewFramer, opts.MaxMessageSize)(struct {
+			io.Reader
+			io.Writer
+		}{conn, writer}),
+		writer:       writer,
+		writeTimeout: opts.WriteTimeout,
+	}
+}
+
+// sessionKey is the context key of the Session.
+type sessionKey struct{}
+
+// SessionFromContext returns the Session of the connection whose message a Handler was
+// called with, or nil if ctx is not a Handler's.
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+// Created returns when the connection was accepted.
+func (session *Session) Created() time.Time {
+	return session.created
+}
+
+// RemoteAddr returns the client's address.
+func (session *Session) RemoteAddr() net.Addr {
+	return session.conn.RemoteAddr()
+}
+
+// Send sends message to the client as a frame of its own, between the responses to its
+// messages, e.g. to relay another client's message. It waits for the client to take it
+// up to the WriteTimeout, or a second if there is none, and closes the connection if it
+// doesn't, as a message cut short can't be resumed.
+func (session *Session) Send(message []byte) error {
+	timeout := session.writeTimeout
+	if timeout <= 0 {
+		timeout = sendTimeout
+	}
+	if err := session.write(message, timeout); err != nil {
+		err = fmt.Errorf("failed to send to client: %w", err)
+		session.conn.closeWith(err)
+		return err
+	}
+	return nil
+}
+
+// write writes message as one frame, giving up after timeout if it's positive.
+func (session *Session) write(message []byte, timeout time.Duration) error {
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+	return session.writeLocked(message, timeout)
+}
+
+// startTLS writes message, the answer to STARTTLS, and then upgrades conn to TLS before
+// anything else can be sent in plaintext.
+func (session *Session) startTLS(conn *startTLSConn, message []byte, timeout time.Duration) error {
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+	if err := session.writeLocked(message, timeout); err != nil {
+		return err
+	}
+	conn.upgrade()
+	return nil
+}
+
+// writeLocked writes message as write does, with writeMu held.
+func (session *Session) writeLocked(message []byte, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	session.writer.setDeadline(deadline)
+	if err := session.framer.WriteFrame(message); err != nil {
+		return err
+	}
+	return session.writer.Flush()
+}
+
+// Principal returns who the client authenticated as: the common name of its verified TLS
+// client certificate, or its first subject alternative name if it has none, or whatever
+// a handler set, or "" if anonymous.
+func (session *Session) Principal() string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.principal
+}
+
+// SetPrincipal records who the client authenticated as, e.g. after a login message.
+func (session *Session) SetPrincipal(principal string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.principal = principal
+}
+
+// Quit closes the connection once the response to the message at hand is sent, as for a
+// client that said goodbye.
+func (session *Session) Quit() {
+	session.quit.Store(true)
+}
+
+// Get returns the value stored under key, and whether there is one.
+func (session *Session) Get(key string) (any, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	value, ok := session.values[key]
+	return value, ok
+}
+
+// Set stores value under key for the connection's later messages.
+func (session *Session) Set(key string, value any) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.values == nil {
+		session.values = make(map[string]any)
+	}
+	session.values[key] = value
+}
+
+// Delete removes the value stored under key.
+func (session *Session) Delete(key string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	delete(session.values, key)
+}
+
+// authenticate takes the principal from the client's verified certificate once the
+// connection has completed its TLS handshake, which happens on the first read, or the
+// first after STARTTLS.
+func (session *Session) authenticate() {
+	conn, ok := tlsConn(session.conn.Conn)
+	if !ok {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.authenticated {
+		return
+	}
+	session.authenticated = true
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) > 0 && session.principal == "" {
+		session.principal = certPrincipal(state.VerifiedChains[0][0])
+	}
+}
+
+// certPrincipal names who a client certificate was issued to: its common name, or if it
+// has none, as is common for certificates identifying services, its first DNS name,
+// email address or URI subject alternative name.
+func certPrincipal(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
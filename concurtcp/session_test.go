@@ -0,0 +1,28 @@
+package concurtcp
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestCertPrincipal(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/service")
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{"common name first", &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, DNSNames: []string{"alice.example.org"}}, "alice"},
+		{"dns name", &x509.Certificate{DNSNames: []string{"svc.example.org", "other.example.org"}}, "svc.example.org"},
+		{"email", &x509.Certificate{EmailAddresses: []string{"bob@example.org"}, URIs: []*url.URL{spiffe}}, "bob@example.org"},
+		{"uri", &x509.Certificate{URIs: []*url.URL{spiffe}}, "spiffe://example.org/service"},
+		{"anonymous", &x509.Certificate{}, ""},
+	}
+	for _, test := range tests {
+		if got := certPrincipal(test.cert); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package concurtcp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// startTLSCommand is the message a client sends to upgrade its connection to TLS with
+// the StartTLS option, case-insensitively.
+const startTLSCommand = "STARTTLS"
+
+// The answers to STARTTLS.
+const (
+	startTLSReadyMessage   = "OK begin TLS"
+	startTLSAlreadyMessage = "ERR already using TLS"
+)
+
+// errStartTLSPipelined ends connections that sent more after STARTTLS without waiting for
+// the answer. It could have been injected by an attacker to be taken as sent over TLS.
+var errStartTLSPipelined = errors.New("data sent after STARTTLS before the handshake")
+
+// errStartTLSUnbuffered ends connections that sent STARTTLS through a framer that can't
+// report whether more data followed it, since pipelined data could not be caught.
+var errStartTLSUnbuffered = errors.New("STARTTLS needs a framing.BufferedFramer")
+
+// startTLSListener accepts connections in plaintext that can be upgraded to TLS.
+type startTLSListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func (listener *startTLSListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newStartTLSConn(conn, listener.config), nil
+}
+
+// startTLSConn is a plaintext connection until upgrade switches it to TLS, with the
+// handshake happening on the next read or write. Its methods go to whichever connection
+// is current, so that other goroutines, e.g. one closing it, needn't know.
+type startTLSConn struct {
+	config *tls.Config
+	conn   atomic.Pointer[net.Conn]
+	tls    atomic.Pointer[tls.Conn]
+}
+
+func newStartTLSConn(conn net.Conn, config *tls.Config) *startTLSConn {
+	startTLS := &startTLSConn{config: config}
+	startTLS.conn.Store(&conn)
+	return startTLS
+}
+
+// upgrade switches the connection to TLS, once.
+func (conn *startTLSConn) upgrade() {
+	if conn.tls.Load() != nil {
+		return
+	}
+	tlsConn := tls.Server(conn.current(), conn.config)
+	conn.tls.Store(tlsConn)
+	var current net.Conn = tlsConn
+	conn.conn.Store(&current)
+}
+
+func (conn *startTLSConn) current() net.Conn {
+	return *conn.conn.Load()
+}
+
+func (conn *startTLSConn) Read(b []byte) (int, error) {
+	return conn.current().Read(b)
+}
+
+func (conn *startTLSConn) Write(b []byte) (int, error) {
+	return conn.current().Write(b)
+}
+
+func (conn *startTLSConn) Close() error {
+	return conn.current().Close()
+}
+
+func (conn *startTLSConn) LocalAddr() net.Addr {
+	return conn.current().LocalAddr()
+}
+
+func (conn *startTLSConn) RemoteAddr() net.Addr {
+	return conn.current().RemoteAddr()
+}
+
+func (conn *startTLSConn) SetDeadline(t time.Time) error {
+	return conn.current().SetDeadline(t)
+}
+
+func (conn *startTLSConn) SetReadDeadline(t time.Time) error {
+	return conn.current().SetReadDeadline(t)
+}
+
+func (conn *startTLSConn) SetWriteDeadline(t time.Time) error {
+	return conn.current().SetWriteDeadline(t)
+}
+
+// isStartTLS reports whether message is the STARTTLS command.
+func isStartTLS(message []byte) bool {
+	return bytes.EqualFold(bytes.TrimSpace(message), []byte(startTLSCommand))
+}
+
+// tlsConn returns the TLS connection conn is, or has been upgraded to.
+func tlsConn(conn net.Conn) (*tls.Conn, bool) {
+	switch conn := conn.(type) {
+	case *tls.Conn:
+		return conn, true
+	case *startTLSConn:
+		tlsConn := conn.tls.Load()
+		return tlsConn, tlsConn != nil
+	}
+	return nil, false
+}
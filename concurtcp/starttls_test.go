@@ -0,0 +1,96 @@
+package concurtcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/blueai2022/net_prg/certgen"
+	"github.com/blueai2022/net_prg/framing"
+)
+
+// startTLSServer serves Echo with opts and the StartTLS option, returning the client's
+// TLS config.
+func startTLSServer(t *testing.T, opts Options) (*Server, *tls.Config) {
+	t.Helper()
+	pki, err := certgen.New(nil, certgen.Leaf{CommonName: "localhost", Hosts: []string{"127.0.0.1"}}, certgen.Leaf{CommonName: "client"})
+	if err != nil {
+		t.Fatalf("failed to create certificates: %v", err)
+	}
+	opts.KeepAlive, opts.StartTLS, opts.TLSConfig = true, true, pki.ServerTLSConfig(false)
+	server := startServer(t, nil, opts)
+	config := pki.ClientTLSConfig()
+	config.ServerName = "127.0.0.1"
+	return server, config
+}
+
+// upgrade switches client to TLS as a STARTTLS client does after the server's answer.
+func (client *testClient) upgrade(config *tls.Config) {
+	client.t.Helper()
+	conn := tls.Client(client.Conn, config)
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	if err := conn.Handshake(); err != nil {
+		client.t.Fatalf("TLS handshake failed: %v", err)
+	}
+	client.Conn = conn
+	client.reader = bufio.NewReader(conn)
+}
+
+func TestStartTLS(t *testing.T) {
+	server, config := startTLSServer(t, Options{})
+	client := dial(t, server)
+	if got, want := client.exchange("plain"), "Received: plain"; got != want {
+		t.Fatalf("before STARTTLS: got %q, want %q", got, want)
+	}
+	if got := client.exchange("starttls"); got != startTLSReadyMessage {
+		t.Fatalf("answer to STARTTLS: got %q, want %q", got, startTLSReadyMessage)
+	}
+	client.upgrade(config)
+	if got, want := client.exchange("secret"), "Received: secret"; got != want {
+		t.Errorf("over TLS: got %q, want %q", got, want)
+	}
+	if got := client.exchange("STARTTLS"); got != startTLSAlreadyMessage {
+		t.Errorf("STARTTLS over TLS: got %q, want %q", got, startTLSAlreadyMessage)
+	}
+}
+
+// A message sent in plaintext right behind STARTTLS could have been injected by an
+// attacker, to be taken as sent over TLS, so the connection is closed instead.
+func TestStartTLSRejectsPipelinedData(t *testing.T) {
+	server, _ := startTLSServer(t, Options{})
+	client := dial(t, server)
+	client.send("STARTTLS\nINJECTED")
+	if !client.closed() {
+		t.Fatal("connection still open after data pipelined behind STARTTLS")
+	}
+	waitStats(t, server, func(stats Stats) bool { return stats.ErrorTypes[ErrorRead] == 1 })
+}
+
+// unbufferedFramer hides whether the framer it wraps read ahead.
+type unbufferedFramer struct {
+	framing.Framer
+}
+
+// A framer that can't report read-ahead data could let pipelined data through, so
+// STARTTLS closes the connection rather than upgrade it.
+func TestStartTLSNeedsBufferedFramer(t *testing.T) {
+	server, _ := startTLSServer(t, Options{NewFramer: func(rw io.ReadWriter) framing.Framer {
+		return unbufferedFramer{framing.NewLine(rw)}
+	}})
+	client := dial(t, server)
+	client.send("STARTTLS")
+	if !client.closed() {
+		t.Fatal("connection still open after STARTTLS through an unbuffered framer")
+	}
+	waitStats(t, server, func(stats Stats) bool { return stats.ErrorTypes[ErrorRead] == 1 })
+}
+
+func TestStartTLSNeedsOption(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true})
+	client := dial(t, server)
+	if got, want := client.exchange("STARTTLS"), "Received: STARTTLS"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,182 @@
+package concurtcp
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// The error types Stats counts connection errors by.
+const (
+	ErrorRead         = "read"
+	ErrorReadTimeout  = "read_timeout"
+	ErrorTooLarge     = "too_large"
+	ErrorWrite        = "write"
+	ErrorWriteTimeout = "write_timeout"
+	ErrorHandler      = "handler"
+	ErrorPanic        = "panic"
+	ErrorOther        = "other"
+)
+
+// ListenerStats counts the connections of a listener.
+type ListenerStats struct {
+	Accepted  int64 `json:"accepted"`
+	Rejected  int64 `json:"rejected"`
+	Throttled int64 `json:"throttled"`
+	Active    int64 `json:"active"`
+	// BytesRead and BytesWritten count the bytes of messages and responses, after TLS
+	// decryption.
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+	// Errors counts connections that failed.
+	Errors int64 `json:"errors"`
+}
+
+// add adds other's counts to stats.
+func (stats *ListenerStats) add(other ListenerStats) {
+	stats.Accepted += other.Accepted
+	stats.Rejected += other.Rejected
+	stats.Throttled += other.Throttled
+	stats.Active += other.Active
+	stats.BytesRead += other.BytesRead
+	stats.BytesWritten += other.BytesWritten
+	stats.Errors += other.Errors
+}
+
+// Stats counts a server's connections.
+type Stats struct {
+	// ListenerStats totals the counts of every listener, and Listeners breaks them down
+	// by listen address.
+	ListenerStats
+	Listeners map[string]ListenerStats `json:"listeners"`
+	// ErrorTypes breaks Errors down by the Error types.
+	ErrorTypes map[string]int64 `json:"error_types"`
+	// Handler is how long the Handler took to answer messages, in pool.LatencyBuckets.
+	Handler pool.Histogram `json:"handler"`
+}
+
+// listenerStats counts a listener's connections for Stats.
+type listenerStats struct {
+	accepted     atomic.Int64
+	rejected     atomic.Int64
+	throttled    atomic.Int64
+	active       atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	errors       atomic.Int64
+}
+
+func (s *listenerStats) snapshot() ListenerStats {
+	return ListenerStats{
+		Accepted:     s.accepted.Load(),
+		Rejected:     s.rejected.Load(),
+		Throttled:    s.throttled.Load(),
+		Active:       s.active.Load(),
+		BytesRead:    s.bytesRead.Load(),
+		BytesWritten: s.bytesWritten.Load(),
+		Errors:       s.errors.Load(),
+	}
+}
+
+// serverStats records what Stats reports across listeners.
+type serverStats struct {
+	handler histogram
+
+	errorsMu   sync.Mutex
+	errorTypes map[string]int64
+}
+
+// failed counts a connection that failed with an error of errorType.
+func (s *serverStats) failed(errorType string) {
+	s.errorsMu.Lock()
+	defer s.errorsMu.Unlock()
+	if s.errorTypes == nil {
+		s.errorTypes = make(map[string]int64)
+	}
+	s.errorTypes[errorType]++
+}
+
+// snapshot returns the server's Stats with the given listeners' counts.
+func (s *serverStats) snapshot(listeners []*listener) Stats {
+	stats := Stats{Listeners: make(map[string]ListenerStats, len(listeners)), Handler: s.handler.snapshot()}
+	for _, listener := range listeners {
+		listenerStats := listener.stats.snapshot()
+		stats.Listeners[listener.addr] = listenerStats
+		stats.add(listenerStats)
+	}
+
+	s.errorsMu.Lock()
+	stats.ErrorTypes = maps.Clone(s.errorTypes)
+	s.errorsMu.Unlock()
+	return stats
+}
+
+// connError is a connection failure of one of the Error types.
+type connError struct {
+	errorType string
+	err       error
+}
+
+func (err *connError) Error() string {
+	return err.err.Error()
+}
+
+func (err *connError) Unwrap() error {
+	return err.err
+}
+
+// errorType returns the Error type of a connection's err.
+func errorType(err error) string {
+	var connErr *connError
+	if !errors.As(err, &connErr) {
+		return ErrorOther
+	}
+	if connErr.errorType == ErrorRead && isTimeout(err) {
+		return ErrorReadTimeout
+	}
+	return connErr.errorType
+}
+
+// histogram records latencies in pool.LatencyBuckets for Stats.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	counts []int64
+	count  int64
+	sum    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.bounds == nil {
+		h.bounds = pool.LatencyBuckets()
+		h.counts = make([]int64, len(h.bounds)+1)
+	}
+	bucket := len(h.bounds)
+	for i, bound := range h.bounds {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) snapshot() pool.Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := h.counts
+	if counts == nil {
+		counts = make([]int64, len(pool.LatencyBuckets())+1)
+	}
+	return pool.Histogram{Counts: append([]int64(nil), counts...), Count: h.count, Sum: h.sum}
+}
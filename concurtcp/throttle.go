@@ -0,0 +1,101 @@
+package concurtcp
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// throttleMessage is sent to connections turned away by the per-client rate limit.
+const throttleMessage = "too many connections"
+
+// clientLimiter limits how fast each client IP may open connections, with a token
+// bucket per client holding up to burst tokens, refilled at rate per second. Buckets are
+// kept for the most recent clients only; a client evicted from the cache comes back with
+// a full bucket, which it would mostly have refilled anyway. A rate of 0 is no limit.
+type clientLimiter struct {
+	mu    sync.Mutex
+	rate  float64
+	burst float64
+	size  int
+	// recent orders the clients' buckets by their last connection, most recent first,
+	// with buckets indexing them by IP.
+	recent  *list.List
+	buckets map[string]*list.Element
+}
+
+// clientBucket is a client's token bucket.
+type clientBucket struct {
+	ip     string
+	tokens float64
+	last   time.Time
+}
+
+func newClientLimiter(rate float64, burst, size int) *clientLimiter {
+	limiter := &clientLimiter{recent: list.New(), buckets: make(map[string]*list.Element)}
+	limiter.set(rate, burst, size)
+	return limiter
+}
+
+// set changes the rate, burst and number of clients tracked. Clients keep their tokens,
+// up to the new burst.
+func (limiter *clientLimiter) set(rate float64, burst, size int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.rate = rate
+	limiter.burst = float64(max(burst, 1))
+	limiter.size = size
+	if rate <= 0 {
+		limiter.recent.Init()
+		clear(limiter.buckets)
+	}
+	for limiter.recent.Len() > limiter.size {
+		limiter.evictOldest()
+	}
+}
+
+// evictOldest drops the bucket of the client that connected least recently. The caller
+// holds mu.
+func (limiter *clientLimiter) evictOldest() {
+	oldest := limiter.recent.Back()
+	limiter.recent.Remove(oldest)
+	delete(limiter.buckets, oldest.Value.(*clientBucket).ip)
+}
+
+// allow takes a token from the bucket of the client at addr, reporting false if it has
+// none left. Clients on unix sockets have no IP and share one bucket.
+func (limiter *clientLimiter) allow(addr net.Addr) bool {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	element, ok := limiter.buckets[ip]
+	if ok {
+		limiter.recent.MoveToFront(element)
+	} else {
+		element = limiter.recent.PushFront(&clientBucket{ip: ip, tokens: limiter.burst, last: now})
+		limiter.buckets[ip] = element
+		if limiter.recent.Len() > limiter.size {
+			limiter.evictOldest()
+		}
+	}
+
+	bucket := element.Value.(*clientBucket)
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,85 @@
+package concurtcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// UnixScheme prefixes listen addresses naming a unix domain socket, as in
+// unix:///var/run/app.sock.
+const UnixScheme = "unix://"
+
+// listen listens on addr, a TCP host:port or a unix socket path after UnixScheme. A
+// shared TCP port may be listened on by other sockets with SO_REUSEPORT.
+func listen(addr string, socketMode os.FileMode, shared bool) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, UnixScheme)
+	if !ok {
+		// Keep-alive probes are set on each accepted connection instead, from the options
+		// current at the time
+		config := net.ListenConfig{KeepAlive: -1}
+		if shared {
+			config.Control = reusePort
+		}
+		return config.Listen(context.Background(), tcpNetwork(addr), addr)
+	}
+	return listenUnix(path, socketMode)
+}
+
+// tcpNetwork returns tcp6 for an address with an IPv6 host, which then accepts IPv6 only
+// so that the port can be listened on for IPv4 as well, and tcp4 otherwise.
+func tcpNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); err == nil && ip != nil && ip.To4() == nil {
+		return "tcp6"
+	}
+	return "tcp4"
+}
+
+// listenUnix creates a unix socket at path with mode permissions. The socket file is
+// removed once the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket file left at path by a server that didn't shut down
+// cleanly. It refuses to remove anything but a socket, or a socket a server is still
+// listening on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check socket file: %w", err)
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
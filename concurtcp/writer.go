@@ -0,0 +1,68 @@
+package concurtcp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// errSlowClient ends connections whose clients stopped taking what is written to them.
+var errSlowClient = errors.New("client too slow")
+
+// connWriter buffers the frames written to a connection until they are flushed, and
+// writes them in chunks of up to the buffer's size, as they come for a frame larger than
+// the buffer. A frame must be written by its deadline, if any, and each chunk within
+// slowClientTimeout, if set, so a client that keeps its send buffer full for that long is
+// cut off however long the frame takes.
+type connWriter struct {
+	*bufio.Writer
+	conn              net.Conn
+	chunkSize         int
+	deadline          time.Time
+	slowClientTimeout time.Duration
+}
+
+func newConnWriter(conn net.Conn, size int, slowClientTimeout time.Duration) *connWriter {
+	writer := &connWriter{conn: conn, chunkSize: size, slowClientTimeout: slowClientTimeout}
+	writer.Writer = bufio.NewWriterSize(chunkWriter{writer}, size)
+	return writer
+}
+
+// setDeadline bounds the writes of the next frame, including the chunks written before it
+// is flushed when it doesn't fit the buffer, by deadline, or by none if it's zero.
+func (writer *connWriter) setDeadline(deadline time.Time) {
+	writer.deadline = deadline
+}
+
+// chunkWriter writes a connWriter's buffer to its connection.
+type chunkWriter struct {
+	writer *connWriter
+}
+
+func (w chunkWriter) Write(b []byte) (int, error) {
+	writer := w.writer
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(len(b), written+writer.chunkSize)]
+		deadline, slow := writer.deadline, false
+		if writer.slowClientTimeout > 0 {
+			if stall := time.Now().Add(writer.slowClientTimeout); deadline.IsZero() || stall.Before(deadline) {
+				deadline, slow = stall, true
+			}
+		}
+		writer.conn.SetWriteDeadline(deadline)
+
+		n, err := writer.conn.Write(chunk)
+		written += n
+		if err != nil {
+			// Not a timeout any more, lest the connection be retried as for a slow read
+			if slow && isTimeout(err) {
+				err = fmt.Errorf("%w: took %d of %d bytes in %v", errSlowClient, n, len(chunk), writer.slowClientTimeout)
+			}
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,54 @@
+package concurtcp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A response larger than the write buffer is written partly before it is flushed, which
+// must be bounded by its own deadline rather than the one left by the previous response.
+func TestLargeResponseAfterIdle(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true, WriteTimeout: 100 * time.Millisecond})
+	client := dial(t, server)
+	if got, want := client.exchange("hi"), "Received: hi"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	large := strings.Repeat("a", 10000)
+	if got, want := client.exchange(large), "Received: "+large; got != want {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+	if stats := server.Stats(); stats.Errors != 0 {
+		t.Errorf("connection failed: %v", stats.ErrorTypes)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	server := startServer(t, nil, Options{KeepAlive: true, WriteBufferSize: 1024, SlowClientTimeout: 200 * time.Millisecond})
+	client := dial(t, server)
+
+	// Never read, so that the responses fill the socket buffers
+	large := strings.Repeat("a", 64*1024)
+	for range 100 {
+		client.SetWriteDeadline(time.Now().Add(testTimeout))
+		if _, err := client.Write([]byte(large + "\n")); err != nil {
+			break
+		}
+	}
+	waitStats(t, server, func(stats Stats) bool { return stats.ErrorTypes[ErrorWriteTimeout] == 1 })
+}
+
+// A slow client's connection is closed, not retried as a read that timed out is.
+func TestSlowClientNotRetried(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	writer := newConnWriter(server, 1024, 10*time.Millisecond)
+	_, err := chunkWriter{writer}.Write(make([]byte, 10))
+	if !errors.Is(err, errSlowClient) || isTimeout(err) {
+		t.Errorf("got %v, want %v and not a timeout", err, errSlowClient)
+	}
+}
@@ -0,0 +1,310 @@
+// Package config loads binary settings from defaults, a JSON config file, environment
+// variables and command-line flags, in increasing order of precedence.
+//
+// Settings are the fields of a struct, bound by tags:
+//
+//	type Config struct {
+//		Addr    string        `config:"addr" usage:"listen address" required:"true"`
+//		Workers int           `config:"workers" usage:"number of workers"`
+//		Timeout time.Duration `config:"timeout" env:"APP_TIMEOUT" usage:"request timeout"`
+//	}
+//
+// The config name is both the flag name and the key in the config file. The environment
+// variable defaults to the binary name and config name in upper snake case, e.g.
+// CONCURTCP_ADDR, unless an env tag overrides it. Values already in the struct are the defaults.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validator is implemented by configs that check their settings after loading.
+type Validator interface {
+	Validate() error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one bound struct field.
+type setting struct {
+	name     string
+	env      string
+	required bool
+	value    *fieldValue
+}
+
+// Load fills cfg, a pointer to a struct, for the binary called name and returns the
+// positional arguments left after the flags. The config file is given by -config or
+// <NAME>_CONFIG.
+func Load(name string, cfg any, args []string) ([]string, error) {
+	target := reflect.ValueOf(cfg)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+
+	prefix := envName(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(prefix+"_CONFIG"), "JSON config file (env "+prefix+"_CONFIG)")
+
+	settings, err := bind(fs, prefix, target.Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse flags first to find the config file and remember what was set explicitly
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if *configFile != "" {
+		if err := applyFile(*configFile, settings, explicit); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		raw, ok := os.LookupEnv(s.env)
+		if !ok || explicit[s.name] {
+			continue
+		}
+		if err := s.value.Set(raw); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.env, err)
+		}
+	}
+
+	for _, s := range settings {
+		if s.required && s.value.v.IsZero() {
+			return nil, fmt.Errorf("missing required setting %s (flag -%s or %s)", s.name, s.name, s.env)
+		}
+	}
+
+	if validator, ok := cfg.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	return fs.Args(), nil
+}
+
+// File returns the config file Load reads for the binary called name given args, or ""
+// if there is none, e.g. to watch it for changes. Without the settings it can't tell a
+// bare boolean flag followed by a positional argument from a flag and its value, so it
+// takes the argument after any flag without = as the flag's value.
+func File(name string, args []string) string {
+	file := os.Getenv(envName(name) + "_CONFIG")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		flagName, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			value = args[i]
+		}
+		if flagName == "config" {
+			file = value
+		}
+	}
+	return file
+}
+
+// bind registers a flag for every tagged field of v.
+func bind(fs *flag.FlagSet, prefix string, v reflect.Value) ([]setting, error) {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, ok := field.Tag.Lookup("config")
+		if !ok || name == "-" {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, fmt.Errorf("config field %s must be exported", field.Name)
+		}
+		if !supported(field.Type) {
+			return nil, fmt.Errorf("config field %s has unsupported type %s", field.Name, field.Type)
+		}
+
+		env := field.Tag.Get("env")
+		if env == "" {
+			env = prefix + "_" + envName(name)
+		}
+		s := setting{
+			name:     name,
+			env:      env,
+			required: field.Tag.Get("required") == "true",
+			value:    &fieldValue{v: v.Field(i)},
+		}
+		fs.Var(s.value, name, fmt.Sprintf("%s (env %s)", field.Tag.Get("usage"), env))
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// applyFile sets every value from the JSON config file that was not given as a flag.
+// Unknown keys are rejected so typos don't go unnoticed.
+func applyFile(path string, settings []setting, explicit map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	byName := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byName[s.name] = s
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := byName[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %q in config file %s", key, path)
+		}
+		if explicit[key] {
+			continue
+		}
+		raw, err := rawString(values[key])
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", key, path, err)
+		}
+		if err := s.value.Set(raw); err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", key, path, err)
+		}
+	}
+	return nil
+}
+
+// rawString turns a JSON value into the text a flag would be given.
+func rawString(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case len(raw) > 0 && raw[0] == '[':
+		var list []string
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return "", errors.New("lists must contain strings")
+		}
+		return strings.Join(list, ","), nil
+	default:
+		return string(raw), nil
+	}
+}
+
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+func supported(t reflect.Type) bool {
+	if t == durationType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// fieldValue adapts a struct field to flag.Value.
+type fieldValue struct {
+	v reflect.Value
+}
+
+func (value *fieldValue) String() string {
+	// flag calls String on a zero fieldValue to detect zero defaults
+	if value == nil || !value.v.IsValid() || value.v.IsZero() {
+		return ""
+	}
+	if value.v.Type() == durationType {
+		return time.Duration(value.v.Int()).String()
+	}
+	if value.v.Kind() == reflect.Slice {
+		return strings.Join(value.v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(value.v.Interface())
+}
+
+func (value *fieldValue) Set(raw string) error {
+	v := value.v
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as a bare -flag.
+func (value *fieldValue) IsBoolFlag() bool {
+	return value != nil && value.v.IsValid() && value.v.Kind() == reflect.Bool
+}
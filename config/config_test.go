@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr    string        `config:"addr" usage:"listen address" required:"true"`
+	Workers int           `config:"workers" usage:"number of workers"`
+	Timeout time.Duration `config:"timeout" env:"TEST_TIMEOUT" usage:"request timeout"`
+	Verbose bool          `config:"verbose" usage:"log more"`
+	Rate    float64       `config:"rate" usage:"requests per second"`
+	Peers   []string      `config:"peers" usage:"comma-separated peers"`
+	Ignored string
+}
+
+func (cfg *testConfig) Validate() error {
+	if cfg.Workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+	return nil
+}
+
+// writeFile writes a config file into the test's temporary directory.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, `{"addr": ":1", "workers": 2, "timeout": "2s", "peers": ["a", "b"], "rate": 1.5}`)
+	t.Setenv("APP_WORKERS", "3")
+	t.Setenv("TEST_TIMEOUT", "3s")
+
+	cfg := testConfig{Workers: 1, Timeout: time.Second}
+	args, err := Load("app", &cfg, []string{"-config", file, "-timeout", "4s", "-verbose", "rest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{Addr: ":1", Workers: 3, Timeout: 4 * time.Second, Verbose: true, Rate: 1.5, Peers: []string{"a", "b"}}
+	if cfg.Addr != want.Addr || cfg.Workers != want.Workers || cfg.Timeout != want.Timeout ||
+		cfg.Verbose != want.Verbose || cfg.Rate != want.Rate || !slices.Equal(cfg.Peers, want.Peers) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+	if !slices.Equal(args, []string{"rest"}) {
+		t.Errorf("args left %v, want [rest]", args)
+	}
+}
+
+func TestDefaultsKept(t *testing.T) {
+	cfg := testConfig{Workers: 4, Timeout: time.Second}
+	if _, err := Load("app", &cfg, []string{"-addr", ":1"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 4 || cfg.Timeout != time.Second {
+		t.Errorf("defaults overwritten: %+v", cfg)
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	t.Setenv("APP_CONFIG", writeFile(t, `{"addr": ":2"}`))
+	cfg := testConfig{Workers: 1}
+	if _, err := Load("app", &cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":2" {
+		t.Errorf("addr %q, want :2", cfg.Addr)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing required", nil, "missing required setting addr"},
+		{"validation", []string{"-addr", ":1", "-workers", "0"}, "workers must be at least 1"},
+		{"bad value", []string{"-addr", ":1", "-timeout", "soon"}, "invalid value"},
+		{"unknown key", []string{"-config", "unknown"}, "unknown setting"},
+		{"bad list", []string{"-config", "list"}, "lists must contain strings"},
+	}
+	files := map[string]string{
+		"unknown": writeFile(t, `{"addr": ":1", "adr": ":2"}`),
+		"list":    writeFile(t, `{"addr": ":1", "peers": [1, 2]}`),
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := slices.Clone(test.args)
+			for i, arg := range args {
+				if file, ok := files[arg]; ok {
+					args[i] = file
+				}
+			}
+			cfg := testConfig{Workers: 1}
+			_, err := Load("app", &cfg, args)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnsupportedFields(t *testing.T) {
+	var cfg struct {
+		Limits map[string]int `config:"limits"`
+	}
+	if _, err := Load("app", &cfg, nil); err == nil {
+		t.Error("bound a map field")
+	}
+	if _, err := Load("app", cfg, nil); err == nil {
+		t.Error("loaded into a struct that isn't a pointer")
+	}
+}
+
+func TestFile(t *testing.T) {
+	t.Setenv("APP_CONFIG", "env.json")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "env.json"},
+		{[]string{"-config", "a.json"}, "a.json"},
+		{[]string{"--config=b.json", "-workers", "2"}, "b.json"},
+		{[]string{"-workers", "2", "-config", "c.json"}, "c.json"},
+		{[]string{"rest", "-config", "d.json"}, "env.json"},
+	}
+	for _, test := range tests {
+		if got := File("app", test.args); got != test.want {
+			t.Errorf("File(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -1,45 +0,0 @@
-package main
-
-import (
-	"crypto/tls"
-	"crypto/x509"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"io/ioutil"
-	"log"
-)
-
-func main() {
-	// Load client certificate and private key
-	clientCert, err := tls.LoadX509KeyPair("client-cert.pem", "client-key.pem")
-	if err != nil {
-		log.Fatalf("Failed to load client certificate: %v", err)
-	}
-
-	// Load CA certificate
-	caCert, err := ioutil.ReadFile("ca-cert.pem")
-	if err != nil {
-		log.Fatalf("Failed to read CA certificate: %v", err)
-	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	// Create TLS credentials
-	creds := credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{clientCert},
-		RootCAs:      caCertPool,
-	})
-
-	// Create gRPC client with TLS credentials
-	conn, err := grpc.Dial(
-		"localhost:8080",  // Envoy's address
-		grpc.WithTransportCredentials(creds),
-	)
-	if err != nil {
-		log.Fatalf("Failed to dial: %v", err)
-	}
-	defer conn.Close()
-
-	// Use the connection to make gRPC calls.
-	// client := pb.NewYourServiceClient(conn)
-}
\ No newline at end of file
@@ -0,0 +1,380 @@
+// Package dnsclient resolves A, AAAA, SRV and NAPTR records against configurable
+// resolvers and caches every answer, including negative ones, for as long as its TTL
+// allows.
+package dnsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	resolvConf = "/etc/resolv.conf"
+	dnsPort    = "53"
+	// defaultNegativeTTL applies to failed lookups whose response has no SOA record.
+	defaultNegativeTTL = 30 * time.Second
+	maxCacheEntries    = 4096
+)
+
+// ErrNotFound is returned when a name has no records of the requested type.
+var ErrNotFound = errors.New("no such record")
+
+// Config configures a Client. Zero values select the defaults.
+type Config struct {
+	// Resolvers are DNS servers as host or host:port; empty uses the system's resolv.conf.
+	Resolvers []string
+	// Timeout bounds each query to one resolver (default 2s).
+	Timeout time.Duration
+	// MaxTTL caps how long an answer is cached; zero keeps the record TTL.
+	MaxTTL time.Duration
+}
+
+// SRV is a service location record.
+type SRV struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// NAPTR is a naming authority pointer record, used by SIP to pick a transport.
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+type cacheKey struct {
+	name  string
+	qtype uint16
+}
+
+type cacheEntry struct {
+	records []dns.RR
+	err     error
+	expires time.Time
+}
+
+// Client resolves records and caches the answers. It is safe for concurrent use.
+type Client struct {
+	resolvers []string
+	udp       *dns.Client
+	tcp       *dns.Client
+	maxTTL    time.Duration
+
+	mu    sync.Mutex
+	cache map[cacheKey]cacheEntry
+}
+
+// New creates a client for the configured resolvers.
+func New(cfg Config) (*Client, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+
+	resolvers := make([]string, 0, len(cfg.Resolvers))
+	for _, resolver := range cfg.Resolvers {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(resolver, dnsPort)
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	if len(resolvers) == 0 {
+		system, err := dns.ClientConfigFromFile(resolvConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", resolvConf, err)
+		}
+		for _, server := range system.Servers {
+			resolvers = append(resolvers, net.JoinHostPort(server, system.Port))
+		}
+	}
+	if len(resolvers) == 0 {
+		return nil, errors.New("no DNS resolvers configured")
+	}
+
+	return &Client{
+		resolvers: resolvers,
+		udp:       &dns.Client{Net: "udp", Timeout: cfg.Timeout},
+		tcp:       &dns.Client{Net: "tcp", Timeout: cfg.Timeout},
+		maxTTL:    cfg.MaxTTL,
+		cache:     make(map[cacheKey]cacheEntry),
+	}, nil
+}
+
+// LookupA returns the IPv4 addresses of name.
+func (client *Client) LookupA(ctx context.Context, name string) ([]net.IP, error) {
+	records, err := client.query(ctx, name, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(records))
+	for _, rr := range records {
+		ips = append(ips, rr.(*dns.A).A)
+	}
+	return ips, nil
+}
+
+// LookupAAAA returns the IPv6 addresses of name.
+func (client *Client) LookupAAAA(ctx context.Context, name string) ([]net.IP, error) {
+	records, err := client.query(ctx, name, dns.TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(records))
+	for _, rr := range records {
+		ips = append(ips, rr.(*dns.AAAA).AAAA)
+	}
+	return ips, nil
+}
+
+// LookupHost returns the IPv4 then IPv6 addresses of host. IP literals are returned as is.
+func (client *Client) LookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	v4, errV4 := client.LookupA(ctx, host)
+	v6, errV6 := client.LookupAAAA(ctx, host)
+	ips := append(v4, v6...)
+	if len(ips) == 0 {
+		// Prefer a transport error over a plain "not found" from the other family
+		if errV4 != nil && !errors.Is(errV4, ErrNotFound) {
+			return nil, errV4
+		}
+		if errV6 != nil && !errors.Is(errV6, ErrNotFound) {
+			return nil, errV6
+		}
+		return nil, fmt.Errorf("%w: %s has no addresses", ErrNotFound, host)
+	}
+	return ips, nil
+}
+
+// LookupSRV returns the _service._proto.name records ordered by priority, and randomly by
+// weight within a priority (RFC 2782). With an empty service and proto, name is queried
+// directly.
+func (client *Client) LookupSRV(ctx context.Context, service, proto, name string) ([]SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	records, err := client.query(ctx, name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	srvs := make([]SRV, 0, len(records))
+	for _, rr := range records {
+		srv := rr.(*dns.SRV)
+		srvs = append(srvs, SRV{Target: srv.Target, Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+	}
+	sortSRV(srvs)
+	return srvs, nil
+}
+
+// LookupNAPTR returns the records of name ordered by order then preference.
+func (client *Client) LookupNAPTR(ctx context.Context, name string) ([]NAPTR, error) {
+	records, err := client.query(ctx, name, dns.TypeNAPTR)
+	if err != nil {
+		return nil, err
+	}
+
+	naptrs := make([]NAPTR, 0, len(records))
+	for _, rr := range records {
+		naptr := rr.(*dns.NAPTR)
+		naptrs = append(naptrs, NAPTR{
+			Order:       naptr.Order,
+			Preference:  naptr.Preference,
+			Flags:       naptr.Flags,
+			Service:     naptr.Service,
+			Regexp:      naptr.Regexp,
+			Replacement: naptr.Replacement,
+		})
+	}
+	sort.SliceStable(naptrs, func(i, j int) bool {
+		if naptrs[i].Order != naptrs[j].Order {
+			return naptrs[i].Order < naptrs[j].Order
+		}
+		return naptrs[i].Preference < naptrs[j].Preference
+	})
+	return naptrs, nil
+}
+
+// DialContext resolves the host in addr with the client and connects to each address in
+// turn until one succeeds.
+func (client *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	ips, err := client.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	var errs []error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("failed to connect to %s: %w", addr, errors.Join(errs...))
+}
+
+// Flush drops every cached answer.
+func (client *Client) Flush() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	clear(client.cache)
+}
+
+// query returns the records of type qtype for name from the cache or the resolvers.
+func (client *Client) query(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	key := cacheKey{name: dns.CanonicalName(name), qtype: qtype}
+
+	client.mu.Lock()
+	entry, ok := client.cache[key]
+	client.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.records, entry.err
+	}
+
+	records, ttl, err := client.exchange(ctx, key.name, qtype)
+	// Transport failures are not answers and must not be cached
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if client.maxTTL > 0 && ttl > client.maxTTL {
+		ttl = client.maxTTL
+	}
+	if ttl > 0 {
+		client.store(key, cacheEntry{records: records, err: err, expires: time.Now().Add(ttl)})
+	}
+	return records, err
+}
+
+func (client *Client) store(key cacheKey, entry cacheEntry) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if len(client.cache) >= maxCacheEntries {
+		now := time.Now()
+		for k, e := range client.cache {
+			if now.After(e.expires) {
+				delete(client.cache, k)
+			}
+		}
+		// Still full of live entries: make room at random
+		for k := range client.cache {
+			if len(client.cache) < maxCacheEntries {
+				break
+			}
+			delete(client.cache, k)
+		}
+	}
+	client.cache[key] = entry
+}
+
+// exchange asks each resolver in turn, returning the matching records and how long the
+// answer may be cached.
+func (client *Client) exchange(ctx context.Context, name string, qtype uint16) ([]dns.RR, time.Duration, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(dns.DefaultMsgSize, false)
+
+	var errs []error
+	for _, resolver := range client.resolvers {
+		resp, _, err := client.udp.ExchangeContext(ctx, msg, resolver)
+		if err == nil && resp.Truncated {
+			resp, _, err = client.tcp.ExchangeContext(ctx, msg, resolver)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", resolver, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeNameError:
+			return nil, negativeTTL(resp), fmt.Errorf("%w: %s does not exist", ErrNotFound, strings.TrimSuffix(name, "."))
+		default:
+			// SERVFAIL, REFUSED and the like are the resolver's problem; try the next one
+			errs = append(errs, fmt.Errorf("%s: %s", resolver, dns.RcodeToString[resp.Rcode]))
+			continue
+		}
+
+		var records []dns.RR
+		var ttl uint32
+		for _, rr := range resp.Answer {
+			if rr.Header().Rrtype != qtype {
+				continue
+			}
+			if len(records) == 0 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+			records = append(records, rr)
+		}
+		if len(records) == 0 {
+			return nil, negativeTTL(resp), fmt.Errorf("%w: %s has no %s records", ErrNotFound, strings.TrimSuffix(name, "."), dns.TypeToString[qtype])
+		}
+		return records, time.Duration(ttl) * time.Second, nil
+	}
+	return nil, 0, fmt.Errorf("failed to resolve %s: %w", strings.TrimSuffix(name, "."), errors.Join(errs...))
+}
+
+// negativeTTL returns how long a negative answer may be cached (RFC 2308): the smaller of
+// the SOA TTL and its minimum field.
+func negativeTTL(resp *dns.Msg) time.Duration {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+		}
+	}
+	return defaultNegativeTTL
+}
+
+// sortSRV orders records by priority and shuffles each priority by weight, so heavier
+// targets come first more often.
+func sortSRV(srvs []SRV) {
+	sort.SliceStable(srvs, func(i, j int) bool {
+		return srvs[i].Priority < srvs[j].Priority
+	})
+
+	for start := 0; start < len(srvs); {
+		end := start
+		total := 0
+		for end < len(srvs) && srvs[end].Priority == srvs[start].Priority {
+			total += int(srvs[end].Weight)
+			end++
+		}
+		for i := start; i < end && total > 0; i++ {
+			pick := rand.IntN(total + 1)
+			sum := 0
+			for j := i; j < end; j++ {
+				sum += int(srvs[j].Weight)
+				if sum >= pick {
+					srvs[i], srvs[j] = srvs[j], srvs[i]
+					break
+				}
+			}
+			total -= int(srvs[i].Weight)
+		}
+		start = end
+	}
+}
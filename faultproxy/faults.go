@@ -0,0 +1,131 @@
+// Package faultproxy relays TCP connections or UDP datagrams between clients and a
+// server while degrading the network in between, netem style: added latency and jitter,
+// packet loss, reordering, a bandwidth cap and, for TCP, connections reset mid-stream.
+// It is meant for checking how clients and servers cope with bad networks without
+// root access or tc.
+//
+// TCP is a byte stream, so faults apply to the chunks the proxy reads rather than to
+// packets on the wire: a lost chunk is delayed by a retransmission timeout instead of
+// dropped, and chunks are never reordered. UDP datagrams are dropped and reordered as is.
+package faultproxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// retransmitDelay is how long a lost TCP chunk is held back, TCP's minimum RTO.
+	retransmitDelay = 200 * time.Millisecond
+	// maxQueueDelay is how far a bandwidth-capped direction may fall behind before UDP
+	// datagrams are dropped, like a router's full queue.
+	maxQueueDelay = time.Second
+	// maxChunkSize bounds how much TCP data travels as one chunk, so delays and the
+	// bandwidth cap apply at a packet-like granularity.
+	maxChunkSize = 16 * 1024
+)
+
+// Faults describes how the network is degraded. Each direction is degraded separately.
+// The zero value forwards traffic unchanged.
+type Faults struct {
+	// Latency is added to every packet.
+	Latency time.Duration
+	// Jitter adds a random delay of up to this much on top of Latency.
+	Jitter time.Duration
+	// Loss is the percentage of packets lost.
+	Loss float64
+	// Reorder is the percentage of UDP datagrams sent without delay, overtaking the
+	// datagrams still delayed. It has no effect without Latency or Jitter.
+	Reorder float64
+	// Bandwidth caps each direction in bits per second; zero is unlimited.
+	Bandwidth int64
+	// Reset is the percentage of TCP chunks after which the connection is reset.
+	Reset float64
+}
+
+// Validate checks that the percentages and durations are in range.
+func (faults Faults) Validate() error {
+	if faults.Latency < 0 || faults.Jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	if faults.Bandwidth < 0 {
+		return fmt.Errorf("bandwidth must not be negative, got %d", faults.Bandwidth)
+	}
+	for name, percent := range map[string]float64{"loss": faults.Loss, "reorder": faults.Reorder, "reset": faults.Reset} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("%s must be a percentage between 0 and 100, got %v", name, percent)
+		}
+	}
+	return nil
+}
+
+func (faults Faults) String() string {
+	return fmt.Sprintf("latency %v, jitter %v, loss %v%%, reorder %v%%, bandwidth %d bit/s, reset %v%%",
+		faults.Latency, faults.Jitter, faults.Loss, faults.Reorder, faults.Bandwidth, faults.Reset)
+}
+
+// delay returns the latency for one packet.
+func (faults Faults) delay() time.Duration {
+	if faults.Jitter <= 0 {
+		return faults.Latency
+	}
+	return faults.Latency + rand.N(faults.Jitter+1)
+}
+
+// chance reports whether an event with the given percentage happens.
+func chance(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// Stats counts what a proxy did to the traffic.
+type Stats struct {
+	Connections atomic.Int64
+	Packets     atomic.Int64
+	Bytes       atomic.Int64
+	Lost        atomic.Int64
+	Reordered   atomic.Int64
+	Resets      atomic.Int64
+}
+
+func (stats *Stats) String() string {
+	return fmt.Sprintf("%d connections, %d packets, %d bytes, %d lost, %d reordered, %d resets",
+		stats.Connections.Load(), stats.Packets.Load(), stats.Bytes.Load(),
+		stats.Lost.Load(), stats.Reordered.Load(), stats.Resets.Load())
+}
+
+// pacer enforces the bandwidth cap of one direction by spacing packets out by their
+// transmission time.
+type pacer struct {
+	bandwidth int64
+
+	mu sync.Mutex
+	// free is when the link finishes sending what was queued so far.
+	free time.Time
+}
+
+// schedule queues a packet of size bytes and returns how long until it has been sent.
+func (p *pacer) schedule(size int) time.Duration {
+	if p.bandwidth <= 0 {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.free.Before(now) {
+		p.free = now
+	}
+	p.free = p.free.Add(time.Duration(int64(size) * 8 * int64(time.Second) / p.bandwidth))
+	return p.free.Sub(now)
+}
+
+// backlog returns how long until what is already queued has been sent.
+func (p *pacer) backlog() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return max(time.Until(p.free), 0)
+}
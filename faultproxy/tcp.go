@@ -0,0 +1,198 @@
+package faultproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// dialTimeout bounds connecting to the server.
+	dialTimeout = 10 * time.Second
+	// chunkQueue is how many chunks a direction holds while they are delayed, which caps
+	// the data in flight like a receive window.
+	chunkQueue = 64
+)
+
+// TCPProxy relays TCP connections to a server through Faults.
+type TCPProxy struct {
+	// Stats counts the relayed chunks; a chunk is what one read returned.
+	Stats Stats
+
+	listener net.Listener
+	remote   string
+	faults   Faults
+	wg       sync.WaitGroup
+}
+
+// ListenTCP creates a proxy listening on addr that relays connections to remote.
+func ListenTCP(addr, remote string, faults Faults) (*TCPProxy, error) {
+	if err := faults.Validate(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &TCPProxy{listener: listener, remote: remote, faults: faults}, nil
+}
+
+// Addr returns the address the proxy is listening on.
+func (proxy *TCPProxy) Addr() net.Addr {
+	return proxy.listener.Addr()
+}
+
+// Serve relays connections until ctx is done, then closes the running ones.
+func (proxy *TCPProxy) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { proxy.listener.Close() })
+	defer stop()
+	defer proxy.wg.Wait()
+
+	for {
+		conn, err := proxy.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		proxy.wg.Add(1)
+		go func() {
+			defer proxy.wg.Done()
+			defer conn.Close()
+			if err := proxy.handle(ctx, conn); err != nil {
+				log.Printf("Error relaying %s: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (proxy *TCPProxy) handle(ctx context.Context, client net.Conn) error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	server, err := dialer.DialContext(ctx, "tcp", proxy.remote)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", proxy.remote, err)
+	}
+	defer server.Close()
+	proxy.Stats.Connections.Add(1)
+
+	// Cut the connection short on shutdown
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		server.Close()
+	})
+	defer stop()
+
+	var resetOnce sync.Once
+	reset := func() {
+		resetOnce.Do(func() {
+			proxy.Stats.Resets.Add(1)
+			log.Printf("Resetting %s\n", client.RemoteAddr())
+			abort(client)
+			abort(server)
+		})
+	}
+
+	var directions sync.WaitGroup
+	directions.Add(2)
+	go func() {
+		defer directions.Done()
+		proxy.relay(server, client, reset)
+	}()
+	go func() {
+		defer directions.Done()
+		proxy.relay(client, server, reset)
+	}()
+	directions.Wait()
+	return nil
+}
+
+// chunk is data read from one side, held back until its release time.
+type chunk struct {
+	data    []byte
+	release time.Time
+	// reset makes the connection reset once the chunk has been written.
+	reset bool
+}
+
+// relay copies src to dst through the faults, then half-closes dst so the other
+// direction can finish.
+func (proxy *TCPProxy) relay(dst, src net.Conn, reset func()) {
+	chunks := make(chan chunk, chunkQueue)
+	go proxy.read(src, dst, chunks)
+
+	link := &pacer{bandwidth: proxy.faults.Bandwidth}
+	for c := range chunks {
+		time.Sleep(time.Until(c.release))
+		time.Sleep(link.schedule(len(c.data)))
+		if _, err := dst.Write(c.data); err != nil {
+			src.Close()
+			dst.Close()
+			// Let read finish
+			for range chunks {
+			}
+			return
+		}
+		if c.reset {
+			reset()
+		}
+	}
+	closeWrite(dst)
+}
+
+// read splits src into chunks with their release times. Release times never go
+// backwards, so the stream stays in order however the delays fall.
+func (proxy *TCPProxy) read(src, dst net.Conn, chunks chan<- chunk) {
+	defer close(chunks)
+
+	var last time.Time
+	buf := make([]byte, maxChunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			proxy.Stats.Packets.Add(1)
+			proxy.Stats.Bytes.Add(int64(n))
+
+			delay := proxy.faults.delay()
+			if chance(proxy.faults.Loss) {
+				proxy.Stats.Lost.Add(1)
+				delay += retransmitDelay
+			}
+			release := time.Now().Add(delay)
+			if release.Before(last) {
+				release = last
+			}
+			last = release
+			chunks <- chunk{data: append([]byte(nil), buf[:n]...), release: release, reset: chance(proxy.faults.Reset)}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				// A reset by the peer ends both directions
+				dst.Close()
+			}
+			return
+		}
+	}
+}
+
+// abort closes conn with a TCP reset instead of a graceful close.
+func abort(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		return
+	}
+	conn.Close()
+}
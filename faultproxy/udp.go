@@ -0,0 +1,199 @@
+package faultproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionTimeout is how long a client's session is kept without traffic.
+	sessionTimeout = time.Minute
+	maxPacketSize  = 64 * 1024
+)
+
+// UDPProxy relays UDP datagrams to a server through Faults. Each client address gets
+// its own socket to the server, so replies find their way back.
+type UDPProxy struct {
+	// Stats counts datagrams received from either side; Connections counts sessions.
+	Stats Stats
+
+	conn   net.PacketConn
+	remote *net.UDPAddr
+	faults Faults
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	wg       sync.WaitGroup
+}
+
+// udpSession relays between one client and the server.
+type udpSession struct {
+	client   net.Addr
+	upstream *net.UDPConn
+	toServer pacer
+	toClient pacer
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+// ListenUDP creates a proxy listening on addr that relays datagrams to remote.
+func ListenUDP(addr, remote string, faults Faults) (*UDPProxy, error) {
+	if err := faults.Validate(); err != nil {
+		return nil, err
+	}
+	remoteAddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", remote, err)
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &UDPProxy{conn: conn, remote: remoteAddr, faults: faults, sessions: make(map[string]*udpSession)}, nil
+}
+
+// Addr returns the address the proxy is listening on.
+func (proxy *UDPProxy) Addr() net.Addr {
+	return proxy.conn.LocalAddr()
+}
+
+// Serve relays datagrams until ctx is done.
+func (proxy *UDPProxy) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(ctx, func() { proxy.conn.Close() })
+	defer stop()
+	defer proxy.wg.Wait()
+	defer proxy.closeSessions(0)
+	defer cancel()
+
+	proxy.wg.Add(1)
+	go proxy.expireSessions(ctx)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := proxy.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read datagram: %w", err)
+		}
+
+		session, err := proxy.session(addr)
+		if err != nil {
+			log.Printf("Error relaying %s: %v\n", addr, err)
+			continue
+		}
+		proxy.send(&session.toServer, buf[:n], func(packet []byte) {
+			session.upstream.Write(packet)
+		})
+	}
+}
+
+// session returns the client's session, creating it on its first datagram.
+func (proxy *UDPProxy) session(client net.Addr) (*udpSession, error) {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+
+	session, ok := proxy.sessions[client.String()]
+	if !ok {
+		upstream, err := net.DialUDP("udp", nil, proxy.remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", proxy.remote, err)
+		}
+		session = &udpSession{
+			client:   client,
+			upstream: upstream,
+			toServer: pacer{bandwidth: proxy.faults.Bandwidth},
+			toClient: pacer{bandwidth: proxy.faults.Bandwidth},
+		}
+		proxy.sessions[client.String()] = session
+		proxy.Stats.Connections.Add(1)
+
+		proxy.wg.Add(1)
+		go proxy.receive(session)
+	}
+
+	session.mu.Lock()
+	session.lastSeen = time.Now()
+	session.mu.Unlock()
+	return session, nil
+}
+
+// receive relays the server's replies to the client until the session is closed.
+func (proxy *UDPProxy) receive(session *udpSession) {
+	defer proxy.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		proxy.send(&session.toClient, buf[:n], func(packet []byte) {
+			proxy.conn.WriteTo(packet, session.client)
+		})
+	}
+}
+
+// send passes one datagram through the faults and writes whatever survives.
+func (proxy *UDPProxy) send(link *pacer, packet []byte, write func(packet []byte)) {
+	proxy.Stats.Packets.Add(1)
+	proxy.Stats.Bytes.Add(int64(len(packet)))
+
+	if chance(proxy.faults.Loss) || (link.bandwidth > 0 && link.backlog() > maxQueueDelay) {
+		proxy.Stats.Lost.Add(1)
+		return
+	}
+
+	delay := proxy.faults.delay()
+	if delay > 0 && chance(proxy.faults.Reorder) {
+		proxy.Stats.Reordered.Add(1)
+		delay = 0
+	}
+	delay += link.schedule(len(packet))
+	if delay <= 0 {
+		write(packet)
+		return
+	}
+
+	packet = append([]byte(nil), packet...)
+	time.AfterFunc(delay, func() { write(packet) })
+}
+
+// expireSessions closes idle sessions until ctx is done.
+func (proxy *UDPProxy) expireSessions(ctx context.Context) {
+	defer proxy.wg.Done()
+
+	ticker := time.NewTicker(sessionTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			proxy.closeSessions(sessionTimeout)
+		}
+	}
+}
+
+// closeSessions closes the sessions idle for longer than idle.
+func (proxy *UDPProxy) closeSessions(idle time.Duration) {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+
+	for key, session := range proxy.sessions {
+		session.mu.Lock()
+		lastSeen := session.lastSeen
+		session.mu.Unlock()
+		if time.Since(lastSeen) >= idle {
+			session.upstream.Close()
+			delete(proxy.sessions, key)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// checkedVersion is the version of the checked frame layout.
+	checkedVersion = 1
+	// checkedHeaderSize is the size of a checked frame's header: the magic bytes, the
+	// version, and the payload's length and CRC-32C, both 4 bytes, big-endian.
+	checkedHeaderSize = len(checkedMagic) + 1 + 4 + 4
+)
+
+// checkedMagic starts every checked frame, so a client speaking another protocol is
+// caught at its first frame.
+var checkedMagic = [2]byte{'N', 'P'}
+
+// ErrCorruptFrame is returned, wrapped, for a checked frame with bad magic bytes, an
+// unknown version or a payload that doesn't match its checksum. The stream can't be
+// resynchronized after one, so the connection should be closed.
+var ErrCorruptFrame = errors.New("framing: corrupt frame")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checkedFramer prefixes each frame with a header of magic bytes, a version, and the
+// payload's length and CRC-32C.
+type checkedFramer struct {
+	reader  *bufio.Reader
+	writer  io.Writer
+	maxSize int
+	// header and payload hold the frame read so far, read counting the bytes of
+	// whichever is being read.
+	header  [checkedHeaderSize]byte
+	payload []byte
+	read    int
+}
+
+// NewChecked creates a Framer for length-prefixed frames of up to MaxFrameSize bytes
+// with a CRC-32C checksum, so corrupt frames are reported as ErrCorruptFrame and
+// truncated ones as io.ErrUnexpectedEOF rather than handled as messages.
+func NewChecked(rw io.ReadWriter) Framer {
+	return &checkedFramer{reader: bufio.NewReader(rw), writer: rw, maxSize: MaxFrameSize}
+}
+
+func (framer *checkedFramer) ReadFrame() ([]byte, error) {
+	if framer.payload == nil {
+		n, err := io.ReadFull(framer.reader, framer.header[framer.read:])
+		framer.read += n
+		if err != nil {
+			if errors.Is(err, io.EOF) && framer.read > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		magic, version, size, _ := framer.parseHeader()
+		if magic != checkedMagic {
+			return nil, fmt.Errorf("%w: bad magic bytes %x", ErrCorruptFrame, magic)
+		}
+		if version != checkedVersion {
+			return nil, fmt.Errorf("%w: unknown version %d", ErrCorruptFrame, version)
+		}
+		if int64(size) > int64(framer.maxSize) {
+			return nil, fmt.Errorf("%w: frame of %d bytes exceeds %d", ErrFrameTooLarge, size, framer.maxSize)
+		}
+		framer.payload = make([]byte, size)
+		framer.read = 0
+	}
+
+	n, err := io.ReadFull(framer.reader, framer.payload[framer.read:])
+	framer.read += n
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := framer.payload
+	framer.payload = nil
+	framer.read = 0
+
+	_, _, _, checksum := framer.parseHeader()
+	if sum := crc32.Checksum(payload, castagnoli); sum != checksum {
+		return nil, fmt.Errorf("%w: checksum %08x, want %08x", ErrCorruptFrame, sum, checksum)
+	}
+	return payload, nil
+}
+
+// parseHeader splits the header read into its fields.
+func (framer *checkedFramer) parseHeader() (magic [2]byte, version byte, size, checksum uint32) {
+	header := framer.header[:]
+	copy(magic[:], header)
+	header = header[len(magic):]
+	return magic, header[0], binary.BigEndian.Uint32(header[1:]), binary.BigEndian.Uint32(header[5:])
+}
+
+func (framer *checkedFramer) setMaxSize(max int) {
+	framer.maxSize = max
+}
+
+func (framer *checkedFramer) Buffered() int {
+	return framer.read + framer.reader.Buffered()
+}
+
+func (framer *checkedFramer) WriteFrame(payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds %d", len(payload), MaxFrameSize)
+	}
+	frame := make([]byte, checkedHeaderSize+len(payload))
+	header := append(frame[:0], checkedMagic[:]...)
+	header = append(header, checkedVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(payload)))
+	binary.BigEndian.AppendUint32(header, crc32.Checksum(payload, castagnoli))
+	copy(frame[checkedHeaderSize:], payload)
+	_, err := framer.writer.Write(frame)
+	return err
+}
@@ -0,0 +1,191 @@
+// Package framing splits a connection's byte stream into messages. A Framer reads and
+// writes whole frames, so servers handle messages without knowing how they are delimited
+// on the wire: by a newline for text clients, or by a length prefix for binary ones,
+// with a checksum for clients that need corrupt or truncated frames detected.
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxFrameSize bounds the frames a Framer reads unless Limit sets another bound, so a
+// bad length or a line without end can't make it allocate without limit. It also bounds
+// the frames a length-prefixed or checked Framer writes.
+const MaxFrameSize = 1 << 20
+
+// ErrFrameTooLarge is returned, wrapped, for a frame over the size a Framer reads.
+var ErrFrameTooLarge = errors.New("framing: frame too large")
+
+// Framer reads and writes the frames of one connection.
+type Framer interface {
+	// ReadFrame returns the next frame's payload. It returns io.EOF if the stream ends
+	// between frames and io.ErrUnexpectedEOF if it ends within one. A read that fails
+	// with a timeout keeps what it read, so the next call carries on with the frame.
+	ReadFrame() ([]byte, error)
+	// WriteFrame writes payload as one frame.
+	WriteFrame(payload []byte) error
+}
+
+// BufferedFramer is implemented by Framers that read ahead of the frames they return, so
+// callers waiting for a connection to become readable can tell whether the next frame
+// was read already.
+type BufferedFramer interface {
+	Framer
+	// Buffered returns how many bytes were read but not yet returned in a frame.
+	Buffered() int
+}
+
+// NewFunc creates a Framer for a connection. Servers take one to let callers supply
+// their own framing.
+type NewFunc func(rw io.ReadWriter) Framer
+
+// Limit returns a NewFunc creating newFramer's Framers that read frames of up to max
+// bytes instead of MaxFrameSize. Framers other than the built-in ones keep their bounds.
+func Limit(newFramer NewFunc, max int) NewFunc {
+	return func(rw io.ReadWriter) Framer {
+		framer := newFramer(rw)
+		if limited, ok := framer.(sizeLimited); ok {
+			limited.setMaxSize(max)
+		}
+		return framer
+	}
+}
+
+// sizeLimited is implemented by the built-in Framers, which Limit bounds.
+type sizeLimited interface {
+	setMaxSize(max int)
+}
+
+// Framings maps the names of the built-in framings to their constructors.
+var Framings = map[string]NewFunc{
+	"newline": NewLine,
+	"length":  NewLengthPrefixed,
+	"checked": NewChecked,
+}
+
+// lineFramer delimits frames by a newline, which is not part of the payload.
+type lineFramer struct {
+	reader  *bufio.Reader
+	writer  io.Writer
+	maxSize int
+	// partial holds the part of the line read before a read failed.
+	partial []byte
+}
+
+// NewLine creates a Framer for newline-delimited text, with lines of up to MaxFrameSize
+// bytes.
+func NewLine(rw io.ReadWriter) Framer {
+	return &lineFramer{reader: bufio.NewReader(rw), writer: rw, maxSize: MaxFrameSize}
+}
+
+func (framer *lineFramer) ReadFrame() ([]byte, error) {
+	for {
+		data, err := framer.reader.ReadSlice('\n')
+		framer.partial = append(framer.partial, data...)
+		if len(bytes.TrimSuffix(framer.partial, []byte("\n"))) > framer.maxSize {
+			return nil, fmt.Errorf("%w: line exceeds %d bytes", ErrFrameTooLarge, framer.maxSize)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(framer.partial) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line := bytes.TrimSuffix(framer.partial, []byte("\n"))
+		framer.partial = nil
+		return line, nil
+	}
+}
+
+func (framer *lineFramer) setMaxSize(max int) {
+	framer.maxSize = max
+}
+
+func (framer *lineFramer) Buffered() int {
+	return len(framer.partial) + framer.reader.Buffered()
+}
+
+func (framer *lineFramer) WriteFrame(payload []byte) error {
+	if bytes.IndexByte(payload, '\n') >= 0 {
+		return errors.New("framing: newline in line payload")
+	}
+	_, err := framer.writer.Write(append(payload[:len(payload):len(payload)], '\n'))
+	return err
+}
+
+// lengthFramer prefixes each frame with its payload's length as 4 bytes, big-endian.
+type lengthFramer struct {
+	reader  *bufio.Reader
+	writer  io.Writer
+	maxSize int
+	// header and payload hold the frame read so far, read counting the bytes of
+	// whichever is being read.
+	header  [4]byte
+	payload []byte
+	read    int
+}
+
+// NewLengthPrefixed creates a Framer for 4-byte big-endian length-prefixed frames of up
+// to MaxFrameSize bytes.
+func NewLengthPrefixed(rw io.ReadWriter) Framer {
+	return &lengthFramer{reader: bufio.NewReader(rw), writer: rw, maxSize: MaxFrameSize}
+}
+
+func (framer *lengthFramer) ReadFrame() ([]byte, error) {
+	if framer.payload == nil {
+		n, err := io.ReadFull(framer.reader, framer.header[framer.read:])
+		framer.read += n
+		if err != nil {
+			if errors.Is(err, io.EOF) && framer.read > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		size := binary.BigEndian.Uint32(framer.header[:])
+		if int64(size) > int64(framer.maxSize) {
+			return nil, fmt.Errorf("%w: frame of %d bytes exceeds %d", ErrFrameTooLarge, size, framer.maxSize)
+		}
+		framer.payload = make([]byte, size)
+		framer.read = 0
+	}
+
+	n, err := io.ReadFull(framer.reader, framer.payload[framer.read:])
+	framer.read += n
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := framer.payload
+	framer.payload = nil
+	framer.read = 0
+	return payload, nil
+}
+
+func (framer *lengthFramer) setMaxSize(max int) {
+	framer.maxSize = max
+}
+
+func (framer *lengthFramer) Buffered() int {
+	return framer.read + framer.reader.Buffered()
+}
+
+func (framer *lengthFramer) WriteFrame(payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds %d", len(payload), MaxFrameSize)
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := framer.writer.Write(frame)
+	return err
+}
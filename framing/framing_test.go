@@ -0,0 +1,162 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readWriter makes a Framer of a reader or a writer.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func encode(t testing.TB, newFramer NewFunc, payloads ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	framer := newFramer(readWriter{Writer: &buf})
+	for _, payload := range payloads {
+		if err := framer.WriteFrame([]byte(payload)); err != nil {
+			t.Fatalf("failed to write %q: %v", payload, err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	payloads := []string{"hello", "", strings.Repeat("x", 10000), "last"}
+	for name, newFramer := range Framings {
+		t.Run(name, func(t *testing.T) {
+			framer := newFramer(readWriter{Reader: bytes.NewReader(encode(t, newFramer, payloads...))})
+			for _, want := range payloads {
+				got, err := framer.ReadFrame()
+				if err != nil {
+					t.Fatalf("failed to read %.10q: %v", want, err)
+				}
+				if string(got) != want {
+					t.Fatalf("read %.10q, want %.10q", got, want)
+				}
+			}
+			if _, err := framer.ReadFrame(); !errors.Is(err, io.EOF) {
+				t.Errorf("got %v after the last frame, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for name, newFramer := range Framings {
+		t.Run(name, func(t *testing.T) {
+			frame := encode(t, newFramer, "hello")
+			for _, n := range []int{1, len(frame) - 1} {
+				framer := newFramer(readWriter{Reader: bytes.NewReader(frame[:n])})
+				if _, err := framer.ReadFrame(); !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("%d of %d bytes: got %v, want io.ErrUnexpectedEOF", n, len(frame), err)
+				}
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	for name, newFramer := range Framings {
+		t.Run(name, func(t *testing.T) {
+			data := encode(t, newFramer, "12345", "123456")
+			framer := Limit(newFramer, 5)(readWriter{Reader: bytes.NewReader(data)})
+			if got, err := framer.ReadFrame(); err != nil || string(got) != "12345" {
+				t.Fatalf("frame at the limit: got %q, %v", got, err)
+			}
+			if _, err := framer.ReadFrame(); !errors.Is(err, ErrFrameTooLarge) {
+				t.Errorf("frame over the limit: got %v, want ErrFrameTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestCheckedCorrupt(t *testing.T) {
+	frame := encode(t, NewChecked, "hello")
+	tests := map[string]func(frame []byte){
+		"bad magic":       func(frame []byte) { frame[0] = 'X' },
+		"unknown version": func(frame []byte) { frame[2] = checkedVersion + 1 },
+		"flipped payload": func(frame []byte) { frame[len(frame)-1] ^= 0x01 },
+		"bad checksum":    func(frame []byte) { frame[checkedHeaderSize-1] ^= 0x80 },
+	}
+	for name, corrupt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := slices.Clone(frame)
+			corrupt(data)
+			framer := NewChecked(readWriter{Reader: bytes.NewReader(data)})
+			if _, err := framer.ReadFrame(); !errors.Is(err, ErrCorruptFrame) {
+				t.Errorf("got %v, want ErrCorruptFrame", err)
+			}
+		})
+	}
+}
+
+func TestLineWriteRejectsNewline(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewLine(readWriter{Writer: &buf}).WriteFrame([]byte("two\nlines")); err == nil {
+		t.Error("wrote a payload with a newline as one line")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q", buf.Bytes())
+	}
+}
+
+// timeoutReader returns the bytes of its chunks one read each, failing the reads between
+// them with a timeout, as a connection with a read deadline does while a frame arrives.
+type timeoutReader struct {
+	chunks [][]byte
+	wait   bool
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if r.wait {
+		r.wait = false
+		return 0, os.ErrDeadlineExceeded
+	}
+	r.wait = true
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestResumeAfterTimeout(t *testing.T) {
+	for name, newFramer := range Framings {
+		t.Run(name, func(t *testing.T) {
+			data := encode(t, newFramer, "hello, world")
+			var chunks [][]byte
+			for i := 0; i < len(data); i += 3 {
+				chunks = append(chunks, data[i:min(i+3, len(data))])
+			}
+			framer := newFramer(readWriter{Reader: &timeoutReader{chunks: chunks}})
+
+			timeouts := 0
+			for {
+				payload, err := framer.ReadFrame()
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					timeouts++
+					continue
+				}
+				if err != nil {
+					t.Fatalf("failed after %d timeouts: %v", timeouts, err)
+				}
+				if string(payload) != "hello, world" {
+					t.Errorf("read %q after %d timeouts", payload, timeouts)
+				}
+				break
+			}
+			if timeouts == 0 {
+				t.Error("the frame arrived without a timeout")
+			}
+		})
+	}
+}
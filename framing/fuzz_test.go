@@ -0,0 +1,64 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fuzzMaxSize bounds the frames the fuzz targets read, so that over-long frames are
+// reached with small inputs.
+const fuzzMaxSize = 256
+
+func FuzzNewline(f *testing.F) {
+	fuzzFraming(f, "newline", []byte("hello\nworld\n"), []byte("\n"), []byte("unterminated"))
+}
+
+func FuzzLength(f *testing.F) {
+	fuzzFraming(f, "length", []byte("\x00\x00\x00\x05hello"), []byte("\x00\x00\x00\x00"), []byte("\xff\xff\xff\xff"))
+}
+
+func FuzzChecked(f *testing.F) {
+	frame := encode(f, NewChecked, "hello")
+	corrupt := bytes.Clone(frame)
+	corrupt[len(corrupt)-1] ^= 1
+	fuzzFraming(f, "checked", frame, corrupt, frame[:len(frame)-1], []byte("XX\x01\x00\x00\x00\x00\x00\x00\x00\x00"))
+}
+
+// fuzzFraming reads the frames of an input with the named framing and checks that they
+// stop only on the errors a Framer documents, and that writing them back gives the input
+// read: no frame is split, merged or altered.
+func fuzzFraming(f *testing.F, name string, seeds ...[]byte) {
+	newFramer := Limit(Framings[name], fuzzMaxSize)
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		framer := newFramer(readWriter{Reader: bytes.NewReader(data)})
+		var written bytes.Buffer
+		writer := newFramer(readWriter{Writer: &written})
+		for {
+			payload, err := framer.ReadFrame()
+			if err != nil {
+				if errors.Is(err, io.EOF) && written.Len() != len(data) {
+					t.Fatalf("EOF after %d of %d bytes", written.Len(), len(data))
+				}
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) &&
+					!errors.Is(err, ErrFrameTooLarge) && !errors.Is(err, ErrCorruptFrame) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				break
+			}
+			if len(payload) > fuzzMaxSize {
+				t.Fatalf("read a frame of %d bytes over the limit of %d", len(payload), fuzzMaxSize)
+			}
+			if err := writer.WriteFrame(payload); err != nil {
+				t.Fatalf("failed to write back a frame read: %v", err)
+			}
+			if !bytes.HasPrefix(data, written.Bytes()) {
+				t.Fatalf("frame %q does not encode to the bytes it was read from", payload)
+			}
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package fuzzer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Corpus is a deduplicated set of inputs, kept in a directory when it has one. Crashing
+// and hanging inputs go to its crashers and hangs subdirectories.
+type Corpus struct {
+	dir string
+
+	mu     sync.Mutex
+	inputs [][]byte
+	seen   map[[sha256.Size]byte]bool
+}
+
+// OpenCorpus loads the inputs in dir, creating it if needed; an empty dir keeps the
+// corpus in memory.
+func OpenCorpus(dir string) (*Corpus, error) {
+	corpus := &Corpus{dir: dir, seen: make(map[[sha256.Size]byte]bool)}
+	if dir == "" {
+		return corpus, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create corpus directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read corpus directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		input, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read corpus input: %w", err)
+		}
+		corpus.add(input)
+	}
+	return corpus, nil
+}
+
+// Len returns the number of inputs.
+func (corpus *Corpus) Len() int {
+	corpus.mu.Lock()
+	defer corpus.mu.Unlock()
+	return len(corpus.inputs)
+}
+
+// Inputs returns a copy of the input list.
+func (corpus *Corpus) Inputs() [][]byte {
+	corpus.mu.Lock()
+	defer corpus.mu.Unlock()
+	return append([][]byte(nil), corpus.inputs...)
+}
+
+// Pick returns a random input, or nil if the corpus is empty.
+func (corpus *Corpus) Pick(r *rand.Rand) []byte {
+	corpus.mu.Lock()
+	defer corpus.mu.Unlock()
+	if len(corpus.inputs) == 0 {
+		return nil
+	}
+	return corpus.inputs[r.IntN(len(corpus.inputs))]
+}
+
+// Add adds an input unless the corpus has it already, and reports whether it was new.
+func (corpus *Corpus) Add(input []byte) (bool, error) {
+	if !corpus.add(input) {
+		return false, nil
+	}
+	if _, err := corpus.save("", input); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+func (corpus *Corpus) add(input []byte) bool {
+	sum := sha256.Sum256(input)
+	corpus.mu.Lock()
+	defer corpus.mu.Unlock()
+	if corpus.seen[sum] {
+		return false
+	}
+	corpus.seen[sum] = true
+	corpus.inputs = append(corpus.inputs, append([]byte(nil), input...))
+	return true
+}
+
+// SaveFinding writes a crashing or hanging input to the crashers or hangs subdirectory
+// and returns its path, or "" for an in-memory corpus.
+func (corpus *Corpus) SaveFinding(input []byte, outcome Outcome) (string, error) {
+	subdir := "hangs"
+	if outcome == Crash {
+		subdir = "crashers"
+	}
+	return corpus.save(subdir, input)
+}
+
+// save writes input to a file named after its hash.
+func (corpus *Corpus) save(subdir string, input []byte) (string, error) {
+	if corpus.dir == "" {
+		return "", nil
+	}
+	dir := filepath.Join(corpus.dir, subdir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	sum := sha256.Sum256(input)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:10]))
+	if err := os.WriteFile(path, input, 0o644); err != nil {
+		return "", fmt.Errorf("failed to save input: %w", err)
+	}
+	return path, nil
+}
+
+// Seeds returns well-formed inputs for a protocol to start an empty corpus from.
+func Seeds(proto string) ([][]byte, error) {
+	seeds, ok := seedInputs[proto]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q", proto)
+	}
+	var inputs [][]byte
+	for _, seed := range seeds {
+		inputs = append(inputs, []byte(seed))
+	}
+	if proto == ProtoLength {
+		for i, input := range inputs {
+			inputs[i] = Frame(input)
+		}
+	}
+	return inputs, nil
+}
+
+// Protocols returns the protocols with seeds and mutations, sorted.
+func Protocols() []string {
+	var protos []string
+	for proto := range seedInputs {
+		protos = append(protos, proto)
+	}
+	sort.Strings(protos)
+	return protos
+}
+
+// Frame prefixes payload with its 4-byte big-endian length.
+func Frame(payload []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+const sdpSeed = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 198.51.100.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 198.51.100.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0 111\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=sendrecv\r\n"
+
+var seedInputs = map[string][]string{
+	ProtoLine: {
+		"Hello, server\n",
+		"\n",
+		"first line\nsecond line\n",
+	},
+	ProtoLength: {
+		"",
+		"hello",
+		`{"mode":"upload","protocol":"tcp"}`,
+	},
+	ProtoSDP: {
+		sdpSeed,
+		"v=0\r\no=- 0 0 IN IP6 ::1\r\ns=-\r\nt=0 0\r\n",
+	},
+	ProtoSIP: {
+		"OPTIONS sip:bob@example.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 198.51.100.1:5060;branch=z9hG4bK776asdhds\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=1928301774\r\n" +
+			"To: <sip:bob@example.com>\r\n" +
+			"Call-ID: a84b4c76e66710@198.51.100.1\r\n" +
+			"CSeq: 63104 OPTIONS\r\n" +
+			"Content-Length: 0\r\n\r\n",
+		"INVITE sip:bob@example.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 198.51.100.1:5060;branch=z9hG4bK776asdhdt\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: Alice <sip:alice@example.com>;tag=1928301775\r\n" +
+			"To: Bob <sip:bob@example.com>\r\n" +
+			"Call-ID: a84b4c76e66711@198.51.100.1\r\n" +
+			"CSeq: 314159 INVITE\r\n" +
+			"Contact: <sip:alice@198.51.100.1>\r\n" +
+			"Content-Type: application/sdp\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n\r\n", len(sdpSeed)) +
+			sdpSeed,
+		"SIP/2.0 200 OK\r\n" +
+			"Via: SIP/2.0/UDP 198.51.100.1:5060;branch=z9hG4bK776asdhds\r\n" +
+			"From: <sip:alice@example.com>;tag=1928301774\r\n" +
+			"To: <sip:bob@example.com>;tag=a6c85cf\r\n" +
+			"Call-ID: a84b4c76e66710@198.51.100.1\r\n" +
+			"CSeq: 63104 OPTIONS\r\n" +
+			"Content-Length: 0\r\n\r\n",
+	},
+}
@@ -0,0 +1,52 @@
+package fuzzer
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/sdp/v3"
+)
+
+// addSeeds adds the protocol's seeds to the fuzz target's corpus, besides the ones in
+// testdata/fuzz.
+func addSeeds(f *testing.F, proto string) {
+	seeds, err := Seeds(proto)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+}
+
+// FuzzSIP parses input as a SIP message and parses the message it prints again. The
+// parser accepts malformed messages that print as something else, so only panics count.
+func FuzzSIP(f *testing.F) {
+	addSeeds(f, ProtoSIP)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := sip.ParseMessage(data)
+		if err != nil {
+			return
+		}
+		sip.ParseMessage([]byte(msg.String()))
+	})
+}
+
+// FuzzSDP parses input as a session description, marshals it and parses the result
+// again, counting only panics like FuzzSIP: fields such as an empty username are dropped
+// when marshaled.
+func FuzzSDP(f *testing.F) {
+	addSeeds(f, ProtoSDP)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var desc sdp.SessionDescription
+		if err := desc.Unmarshal(data); err != nil {
+			return
+		}
+		out, err := desc.Marshal()
+		if err != nil {
+			return
+		}
+		var again sdp.SessionDescription
+		again.Unmarshal(out)
+	})
+}
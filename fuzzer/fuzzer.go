@@ -0,0 +1,213 @@
+// Package fuzzer looks for inputs that crash or hang the repo's protocol handlers. It
+// keeps a corpus of inputs, mutates them with generic and protocol-aware strategies and
+// feeds the results to a live listener over the network. Inputs that crash or hang the
+// target are saved so they can be replayed.
+//
+// The parsers themselves are fuzzed in-process by the Go fuzz tests of the framing
+// package and of this one, e.g. go test -fuzz FuzzSIP ./fuzzer.
+package fuzzer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProtoLine   = "line"
+	ProtoLength = "length"
+	ProtoSIP    = "sip"
+	ProtoSDP    = "sdp"
+
+	defaultMaxSize = 4096
+	// signatureSize caps the response word kept in a signature.
+	signatureSize = 32
+)
+
+// Outcome classifies what an input did to the target.
+type Outcome int
+
+const (
+	OK Outcome = iota
+	Hang
+	Crash
+)
+
+func (outcome Outcome) String() string {
+	switch outcome {
+	case Hang:
+		return "hang"
+	case Crash:
+		return "crash"
+	default:
+		return "ok"
+	}
+}
+
+// Result is what a target did with one input.
+type Result struct {
+	Outcome  Outcome
+	Response []byte
+	Err      error
+}
+
+// Finding is an input that crashed or hung the target.
+type Finding struct {
+	Input   []byte
+	Outcome Outcome
+	Err     error
+	// Path is where the input was saved, empty for an in-memory corpus.
+	Path string
+}
+
+// Stats summarizes a fuzzing run.
+type Stats struct {
+	Execs   int
+	Crashes int
+	Hangs   int
+	Corpus  int
+	Elapsed time.Duration
+}
+
+func (stats Stats) String() string {
+	return fmt.Sprintf("%d execs (%.0f/s), %d crashes, %d hangs, corpus %d",
+		stats.Execs, float64(stats.Execs)/max(stats.Elapsed.Seconds(), 1e-9), stats.Crashes, stats.Hangs, stats.Corpus)
+}
+
+// Options controls a fuzzing run. Zero values select the defaults.
+type Options struct {
+	// Target runs each input; it is required.
+	Target Target
+	// Protocol selects the protocol-aware mutations and the seeds of an empty corpus.
+	Protocol string
+	// Corpus holds the inputs to mutate (default an in-memory corpus).
+	Corpus *Corpus
+	// Iterations bounds the number of inputs run; zero runs until ctx is done.
+	Iterations int
+	// Seed makes a run reproducible; zero picks one from the clock.
+	Seed uint64
+	// MaxSize caps the size of mutated inputs (default 4096).
+	MaxSize int
+	// StopOnCrash ends the run at the first crash, for targets that do not come back.
+	StopOnCrash bool
+}
+
+// Run mutates corpus inputs and runs them against the target until ctx is done or the
+// iterations are used up, passing every crash and hang to found. Inputs that draw a
+// response not seen before are added to the corpus, which steers the mutations toward
+// new behavior without coverage instrumentation.
+func Run(ctx context.Context, opts Options, found func(Finding)) (Stats, error) {
+	if opts.Target == nil {
+		return Stats{}, errors.New("a target is required")
+	}
+	if opts.Corpus == nil {
+		opts.Corpus, _ = OpenCorpus("")
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	if opts.Seed == 0 {
+		opts.Seed = uint64(time.Now().UnixNano())
+	}
+	if opts.Corpus.Len() == 0 {
+		seeds, err := Seeds(opts.Protocol)
+		if err != nil {
+			return Stats{}, err
+		}
+		for _, seed := range seeds {
+			if _, err := opts.Corpus.Add(seed); err != nil {
+				return Stats{}, err
+			}
+		}
+	}
+
+	mutator := NewMutator(opts.Protocol, opts.Seed, opts.MaxSize)
+	picker := rand.New(rand.NewPCG(opts.Seed, opts.Seed>>1))
+	signatures := make(map[[sha256.Size]byte]bool)
+	start := time.Now()
+	var stats Stats
+
+	// Run the corpus as is first so its responses are not mistaken for new ones
+	pending := opts.Corpus.Inputs()
+	for opts.Iterations == 0 || stats.Execs < opts.Iterations {
+		if ctx.Err() != nil {
+			break
+		}
+
+		var input []byte
+		seeded := len(pending) > 0
+		if seeded {
+			input, pending = pending[0], pending[1:]
+		} else {
+			input = mutator.Mutate(opts.Corpus.Pick(picker), opts.Corpus)
+		}
+
+		result := opts.Target.Exec(ctx, input)
+		if ctx.Err() != nil {
+			break
+		}
+		stats.Execs++
+
+		if result.Outcome != OK {
+			if result.Outcome == Crash {
+				stats.Crashes++
+			} else {
+				stats.Hangs++
+			}
+			path, err := opts.Corpus.SaveFinding(input, result.Outcome)
+			if err != nil {
+				return stats, err
+			}
+			if found != nil {
+				found(Finding{Input: input, Outcome: result.Outcome, Err: result.Err, Path: path})
+			}
+			if result.Outcome == Crash && opts.StopOnCrash {
+				break
+			}
+			continue
+		}
+
+		signature := responseSignature(result)
+		if !signatures[signature] {
+			signatures[signature] = true
+			if !seeded {
+				if _, err := opts.Corpus.Add(input); err != nil {
+					return stats, err
+				}
+			}
+		}
+	}
+
+	stats.Corpus = opts.Corpus.Len()
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// responseSignature reduces a response to what tells handler paths apart without
+// depending on echoed input: its first word, a status code following it, the magnitude of
+// its length and the kind of error, if any.
+func responseSignature(result Result) [sha256.Size]byte {
+	line, _, _ := bytes.Cut(result.Response, []byte("\n"))
+	fields := bytes.Fields(line)
+	var sb strings.Builder
+	if len(fields) > 0 {
+		sb.Write(fields[0][:min(len(fields[0]), signatureSize)])
+	}
+	if len(fields) > 1 {
+		if _, err := strconv.Atoi(string(fields[1])); err == nil {
+			fmt.Fprintf(&sb, " %s", fields[1])
+		}
+	}
+	fmt.Fprintf(&sb, " %d", bits.Len(uint(len(result.Response))))
+	if result.Err != nil {
+		fmt.Fprintf(&sb, " %T", result.Err)
+	}
+	return sha256.Sum256([]byte(sb.String()))
+}
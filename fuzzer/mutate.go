@@ -0,0 +1,246 @@
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+)
+
+// maxStack is the most mutations applied to one input.
+const maxStack = 4
+
+// interestingBytes and interestingNumbers sit at the edges parsers tend to get wrong.
+var (
+	interestingBytes   = []byte{0x00, 0x01, 0x7f, 0x80, 0xff, '\n', '\r', ' ', ':', ';', '%'}
+	interestingNumbers = []string{"0", "-1", "1", "65535", "65536", "2147483647", "4294967296", "99999999999999999999"}
+)
+
+// dictionaries hold the tokens each protocol's parser branches on.
+var dictionaries = map[string][]string{
+	ProtoLine: {"\n", "\r\n", "\x00"},
+	ProtoSIP: {
+		"\r\n", "\r\n\r\n", "SIP/2.0", "INVITE ", "ACK ", "BYE ", "REGISTER ", "sip:", "sips:", "@",
+		"Via: ", "SIP/2.0/UDP ", ";branch=z9hG4bK", ";tag=", "Content-Length: ", "Content-Type: application/sdp",
+		"CSeq: ", "Call-ID: ", "Max-Forwards: ", "<", ">", ";transport=tcp", " ",
+	},
+	ProtoSDP: {
+		"\r\n", "v=0", "o=", "s=", "c=IN IP4 ", "c=IN IP6 ", "t=", "m=audio ", "m=video ", "RTP/AVP",
+		"a=rtpmap:", "a=fmtp:", "a=sendrecv", "a=candidate:", "/8000", "/",
+	},
+}
+
+// Mutator derives new inputs from corpus inputs.
+type Mutator struct {
+	rand    *rand.Rand
+	proto   string
+	dict    [][]byte
+	maxSize int
+}
+
+// NewMutator returns a mutator for a protocol whose choices are determined by seed.
+func NewMutator(proto string, seed uint64, maxSize int) *Mutator {
+	mutator := &Mutator{rand: rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)), proto: proto, maxSize: maxSize}
+	for _, token := range dictionaries[proto] {
+		mutator.dict = append(mutator.dict, []byte(token))
+	}
+	if proto == ProtoSIP {
+		// SIP bodies are SDP
+		for _, token := range dictionaries[ProtoSDP] {
+			mutator.dict = append(mutator.dict, []byte(token))
+		}
+	}
+	return mutator
+}
+
+// Mutate returns a mutated copy of input, using corpus for splicing.
+func (mutator *Mutator) Mutate(input []byte, corpus *Corpus) []byte {
+	out := append([]byte(nil), input...)
+	for range 1 + mutator.rand.IntN(maxStack) {
+		out = mutator.mutateOnce(out, corpus)
+	}
+	if len(out) > mutator.maxSize {
+		out = out[:mutator.maxSize]
+	}
+	return out
+}
+
+func (mutator *Mutator) mutateOnce(data []byte, corpus *Corpus) []byte {
+	// Protocol-aware strategies take half the picks when they apply
+	if mutator.rand.IntN(2) == 0 {
+		switch mutator.proto {
+		case ProtoLength:
+			if out, ok := mutator.mutateFrameLength(data); ok {
+				return out
+			}
+		case ProtoSIP, ProtoSDP:
+			if out, ok := mutator.mutateNumber(data); ok {
+				return out
+			}
+			if out, ok := mutator.duplicateLine(data); ok {
+				return out
+			}
+		case ProtoLine:
+			return mutator.longLine(data)
+		}
+	}
+
+	switch mutator.rand.IntN(8) {
+	case 0:
+		return mutator.flipBit(data)
+	case 1:
+		return mutator.setInteresting(data)
+	case 2:
+		return mutator.insertRandom(data)
+	case 3:
+		return mutator.deleteRange(data)
+	case 4:
+		return mutator.duplicateRange(data)
+	case 5:
+		return mutator.insertToken(data)
+	case 6:
+		return mutator.splice(data, corpus)
+	default:
+		return mutator.truncate(data)
+	}
+}
+
+func (mutator *Mutator) flipBit(data []byte) []byte {
+	if len(data) == 0 {
+		return mutator.insertRandom(data)
+	}
+	data[mutator.rand.IntN(len(data))] ^= 1 << mutator.rand.IntN(8)
+	return data
+}
+
+func (mutator *Mutator) setInteresting(data []byte) []byte {
+	if len(data) == 0 {
+		return mutator.insertRandom(data)
+	}
+	data[mutator.rand.IntN(len(data))] = interestingBytes[mutator.rand.IntN(len(interestingBytes))]
+	return data
+}
+
+func (mutator *Mutator) insertRandom(data []byte) []byte {
+	chunk := make([]byte, 1+mutator.rand.IntN(16))
+	for i := range chunk {
+		chunk[i] = byte(mutator.rand.UintN(256))
+	}
+	return mutator.insert(data, chunk)
+}
+
+func (mutator *Mutator) insertToken(data []byte) []byte {
+	if len(mutator.dict) == 0 {
+		return mutator.insertRandom(data)
+	}
+	return mutator.insert(data, mutator.dict[mutator.rand.IntN(len(mutator.dict))])
+}
+
+func (mutator *Mutator) insert(data, chunk []byte) []byte {
+	pos := mutator.rand.IntN(len(data) + 1)
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:pos]...)
+	out = append(out, chunk...)
+	return append(out, data[pos:]...)
+}
+
+func (mutator *Mutator) deleteRange(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	start := mutator.rand.IntN(len(data))
+	end := start + 1 + mutator.rand.IntN(min(len(data)-start, 32))
+	return append(data[:start], data[end:]...)
+}
+
+func (mutator *Mutator) duplicateRange(data []byte) []byte {
+	if len(data) == 0 {
+		return mutator.insertRandom(data)
+	}
+	start := mutator.rand.IntN(len(data))
+	end := start + 1 + mutator.rand.IntN(min(len(data)-start, 64))
+	chunk := bytes.Repeat(data[start:end], 1+mutator.rand.IntN(8))
+	return mutator.insert(data, chunk)
+}
+
+func (mutator *Mutator) truncate(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	return data[:mutator.rand.IntN(len(data))]
+}
+
+// splice joins the head of data to the tail of another corpus input.
+func (mutator *Mutator) splice(data []byte, corpus *Corpus) []byte {
+	other := corpus.Pick(mutator.rand)
+	if len(other) == 0 {
+		return mutator.insertToken(data)
+	}
+	head := data[:mutator.rand.IntN(len(data)+1)]
+	tail := other[mutator.rand.IntN(len(other)):]
+	return append(append([]byte(nil), head...), tail...)
+}
+
+// longLine grows a line far past what a reader buffers by default.
+func (mutator *Mutator) longLine(data []byte) []byte {
+	filler := bytes.Repeat([]byte{'A' + byte(mutator.rand.IntN(26))}, 256+mutator.rand.IntN(mutator.maxSize))
+	return mutator.insert(data, filler)
+}
+
+// mutateFrameLength rewrites the length prefix of one frame to an edge value.
+func (mutator *Mutator) mutateFrameLength(data []byte) ([]byte, bool) {
+	// Walk the frames that parse to find the header offsets
+	var offsets []int
+	for offset := 0; offset+4 <= len(data); {
+		offsets = append(offsets, offset)
+		offset += 4 + int(binary.BigEndian.Uint32(data[offset:]))
+	}
+	if len(offsets) == 0 {
+		return nil, false
+	}
+	offset := offsets[mutator.rand.IntN(len(offsets))]
+	size := binary.BigEndian.Uint32(data[offset:])
+	lengths := []uint32{0, 1, size - 1, size + 1, 1 << 16, 1<<31 - 1, 1<<32 - 1}
+	binary.BigEndian.PutUint32(data[offset:], lengths[mutator.rand.IntN(len(lengths))])
+	return data, true
+}
+
+// mutateNumber replaces a run of digits, such as a port, CSeq or Content-Length, with an
+// edge value.
+func (mutator *Mutator) mutateNumber(data []byte) ([]byte, bool) {
+	var runs [][2]int
+	for i := 0; i < len(data); {
+		if data[i] < '0' || data[i] > '9' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+			i++
+		}
+		runs = append(runs, [2]int{start, i})
+	}
+	if len(runs) == 0 {
+		return nil, false
+	}
+	run := runs[mutator.rand.IntN(len(runs))]
+	number := interestingNumbers[mutator.rand.IntN(len(interestingNumbers))]
+	out := append(append(append([]byte(nil), data[:run[0]]...), number...), data[run[1]:]...)
+	return out, true
+}
+
+// duplicateLine repeats one CRLF-terminated line, as a repeated header or attribute.
+func (mutator *Mutator) duplicateLine(data []byte) ([]byte, bool) {
+	lines := bytes.SplitAfter(data, []byte("\r\n"))
+	if len(lines) < 2 {
+		return nil, false
+	}
+	i := mutator.rand.IntN(len(lines))
+	out := make([]byte, 0, len(data)+len(lines[i]))
+	for j, line := range lines {
+		out = append(out, line...)
+		if j == i {
+			out = append(out, line...)
+		}
+	}
+	return out, true
+}
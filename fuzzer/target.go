@@ -0,0 +1,123 @@
+package fuzzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+	maxResponse    = 64 * 1024
+)
+
+// Target runs one input and reports what it did.
+type Target interface {
+	Exec(ctx context.Context, input []byte) Result
+}
+
+// TCPTarget sends each input on a new connection to a listener, half-closes it and reads
+// the reply until the server closes. A reply that does not finish within Timeout is a
+// hang; a listener that stops accepting connections after an input is a crash.
+type TCPTarget struct {
+	Addr string
+	// Timeout bounds each exchange (default 2s).
+	Timeout time.Duration
+}
+
+func (target *TCPTarget) Exec(ctx context.Context, input []byte) Result {
+	timeout := target.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Addr)
+	if err != nil {
+		// A listener too busy to accept is hung; one that refuses is gone
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return Result{Outcome: Hang, Err: fmt.Errorf("failed to connect: %w", err)}
+		}
+		return Result{Outcome: Crash, Err: fmt.Errorf("failed to connect: %w", err)}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(input); err != nil {
+		return target.checkAlive(ctx, Result{Err: err})
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	response, err := io.ReadAll(io.LimitReader(conn, maxResponse))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return Result{Outcome: Hang, Response: response, Err: err}
+	}
+	if err != nil {
+		return target.checkAlive(ctx, Result{Response: response, Err: err})
+	}
+	return Result{Response: response}
+}
+
+// checkAlive turns a failed exchange into a crash if the listener is gone.
+func (target *TCPTarget) checkAlive(ctx context.Context, result Result) Result {
+	dialer := &net.Dialer{Timeout: defaultTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Addr)
+	if err != nil {
+		return Result{Outcome: Crash, Response: result.Response, Err: fmt.Errorf("listener gone after %v: %w", result.Err, err)}
+	}
+	conn.Close()
+	return result
+}
+
+// UDPTarget sends each input as one datagram. Servers drop malformed datagrams silently,
+// so a missing reply is not a hang; instead, when no reply comes, Probe is sent and a
+// target that does not answer it either has crashed.
+type UDPTarget struct {
+	Addr string
+	// Probe is a datagram the target always answers, e.g. a SIP OPTIONS request.
+	Probe []byte
+	// Timeout bounds the wait for each reply (default 2s).
+	Timeout time.Duration
+}
+
+func (target *UDPTarget) Exec(ctx context.Context, input []byte) Result {
+	response, err := target.exchange(ctx, input)
+	if err == nil {
+		return Result{Response: response}
+	}
+	if len(target.Probe) == 0 {
+		return Result{Err: err}
+	}
+	if _, probeErr := target.exchange(ctx, target.Probe); probeErr != nil {
+		return Result{Outcome: Crash, Err: fmt.Errorf("no answer to probe: %w", probeErr)}
+	}
+	return Result{Err: err}
+}
+
+func (target *UDPTarget) exchange(ctx context.Context, datagram []byte) ([]byte, error) {
+	timeout := target.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", target.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(datagram); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxResponse)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,283 @@
+package holepunch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	recvBuffer = 64
+)
+
+// ErrClosed is returned by Receive and Send once the connection is closed.
+var ErrClosed = errors.New("connection closed")
+
+// Options controls how a peer connects. Zero values select the defaults.
+type Options struct {
+	// ID names this peer and Peer the one to connect to; both must be given.
+	ID   string
+	Peer string
+	// Rendezvous is the rendezvous server's host:port.
+	Rendezvous string
+	// LocalAddr is the UDP address to bind (default ":0").
+	LocalAddr string
+	// RetryInterval is the time between registrations and punches (default 500ms).
+	RetryInterval time.Duration
+	// KeepaliveInterval is the time between keepalives once connected (default 15s).
+	KeepaliveInterval time.Duration
+}
+
+func (opts *Options) setDefaults() error {
+	if opts.ID == "" || opts.Peer == "" {
+		return errors.New("both a peer ID and the ID of the peer to connect to are required")
+	}
+	if opts.Rendezvous == "" {
+		return errors.New("a rendezvous server is required")
+	}
+	if opts.LocalAddr == "" {
+		opts.LocalAddr = ":0"
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 500 * time.Millisecond
+	}
+	if opts.KeepaliveInterval <= 0 {
+		opts.KeepaliveInterval = 15 * time.Second
+	}
+	return nil
+}
+
+// Conn is an established path to the peer.
+type Conn struct {
+	conn   *net.UDPConn
+	peer   *net.UDPAddr
+	id     string
+	peerID string
+	public string
+
+	recv      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Connect registers with the rendezvous server, waits to be introduced to the peer and
+// punches through to it. It gives up when ctx is done.
+func Connect(ctx context.Context, opts Options) (*Conn, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	rendezvous, err := net.ResolveUDPAddr("udp", opts.Rendezvous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve rendezvous server %s: %w", opts.Rendezvous, err)
+	}
+	localAddr, err := net.ResolveUDPAddr("udp", opts.LocalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", opts.LocalAddr, err)
+	}
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	c := &Conn{conn: conn, id: opts.ID, peerID: opts.Peer, recv: make(chan []byte, recvBuffer), done: make(chan struct{})}
+	register := message{Type: msgRegister, ID: opts.ID, Peer: opts.Peer, Private: privateEndpoint(conn, rendezvous)}
+
+	if err := c.establish(ctx, opts, rendezvous, register); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to reach peer %s: %w", opts.Peer, ctx.Err())
+		}
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	go c.readLoop()
+	go c.keepalive(opts.KeepaliveInterval)
+	return c, nil
+}
+
+// establish registers until introduced, then punches until the peer answers.
+func (c *Conn) establish(ctx context.Context, opts Options, rendezvous *net.UDPAddr, register message) error {
+	var candidates []*net.UDPAddr
+	buf := make([]byte, maxPacketSize)
+	next := time.Now()
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Keep registering until introduced, which also refreshes our NAT mapping to the
+		// server, then punch every candidate endpoint of the peer
+		if !time.Now().Before(next) {
+			if candidates == nil {
+				if err := send(c.conn, rendezvous, register); err != nil {
+					return fmt.Errorf("failed to register: %w", err)
+				}
+			}
+			for _, addr := range candidates {
+				send(c.conn, addr, message{Type: msgPunch, ID: c.id})
+			}
+			next = time.Now().Add(opts.RetryInterval)
+		}
+
+		c.conn.SetReadDeadline(next)
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("failed to read: %w", err)
+		}
+
+		var msg message
+		if json.Unmarshal(buf[:n], &msg) != nil {
+			continue
+		}
+		switch {
+		case msg.Type == msgRegistered && addr.String() == rendezvous.String():
+			c.public = msg.Public
+		case msg.Type == msgPeer && addr.String() == rendezvous.String() && msg.ID == opts.Peer:
+			candidates = peerCandidates(msg)
+			// Punch right away: the peer was introduced at the same moment
+			next = time.Now()
+		case msg.Type == msgPunch && msg.ID == opts.Peer:
+			// The peer got through first; answer on the path it used
+			send(c.conn, addr, message{Type: msgPunchAck, ID: c.id})
+			c.peer = addr
+			return nil
+		case msg.Type == msgPunchAck && msg.ID == opts.Peer:
+			c.peer = addr
+			return nil
+		}
+	}
+}
+
+// peerCandidates returns the endpoints to punch: the public one and, for peers behind the
+// same NAT, the private one.
+func peerCandidates(msg message) []*net.UDPAddr {
+	var candidates []*net.UDPAddr
+	for _, endpoint := range []string{msg.Public, msg.Private} {
+		addr, err := net.ResolveUDPAddr("udp", endpoint)
+		if err != nil || addr.IP == nil || addr.IP.IsUnspecified() {
+			continue
+		}
+		if len(candidates) > 0 && candidates[0].String() == addr.String() {
+			continue
+		}
+		candidates = append(candidates, addr)
+	}
+	return candidates
+}
+
+// privateEndpoint returns the local address the rendezvous server is reached from, with
+// the socket's port.
+func privateEndpoint(conn *net.UDPConn, rendezvous *net.UDPAddr) string {
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	probe, err := net.DialUDP("udp", nil, rendezvous)
+	if err != nil {
+		return ""
+	}
+	defer probe.Close()
+	return net.JoinHostPort(probe.LocalAddr().(*net.UDPAddr).IP.String(), strconv.Itoa(port))
+}
+
+// PeerAddr returns the endpoint the peer is reached at.
+func (c *Conn) PeerAddr() *net.UDPAddr {
+	return c.peer
+}
+
+// PublicAddr returns this peer's endpoint as the rendezvous server saw it.
+func (c *Conn) PublicAddr() string {
+	return c.public
+}
+
+// Send sends one datagram to the peer.
+func (c *Conn) Send(data []byte) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	return send(c.conn, c.peer, message{Type: msgData, Data: data})
+}
+
+// Receive returns the next datagram from the peer.
+func (c *Conn) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case data, ok := <-c.recv:
+		if !ok {
+			return nil, ErrClosed
+		}
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops the keepalives and closes the socket.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// readLoop delivers data from the peer and answers late punches.
+func (c *Conn) readLoop() {
+	defer close(c.recv)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var msg message
+		if json.Unmarshal(buf[:n], &msg) != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case msgPunch:
+			// Our ack was lost and the peer is still punching
+			if msg.ID == c.peerID {
+				send(c.conn, addr, message{Type: msgPunchAck, ID: c.id})
+			}
+		case msgData:
+			if addr.String() != c.peer.String() {
+				continue
+			}
+			select {
+			case c.recv <- msg.Data:
+			default:
+				// Like any UDP receiver, drop what the reader cannot keep up with
+			}
+		}
+	}
+}
+
+// keepalive holds the NAT mappings open until the connection is closed.
+func (c *Conn) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			send(c.conn, c.peer, message{Type: msgKeepalive})
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package holepunch establishes direct UDP paths between two peers behind NATs. Each peer
+// registers with a rendezvous server from the socket it will use for the peer, so the
+// server sees the endpoint its NAT assigned. Once both sides are registered, the server
+// sends each the other's public and private endpoints, and both send punch packets to
+// them at the same time; the first packet to get through opens both NAT mappings.
+// Keepalives then hold the mappings open.
+package holepunch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	msgRegister   = "register"
+	msgRegistered = "registered"
+	msgPeer       = "peer"
+	msgPunch      = "punch"
+	msgPunchAck   = "punch-ack"
+	msgKeepalive  = "keepalive"
+	msgData       = "data"
+
+	maxPacketSize = 1500
+	// registrationTTL drops peers that stopped re-registering.
+	registrationTTL = time.Minute
+)
+
+// message is every packet exchanged with the server and between peers.
+type message struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Peer    string `json:"peer,omitempty"`
+	Public  string `json:"public,omitempty"`
+	Private string `json:"private,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+}
+
+func send(conn *net.UDPConn, addr *net.UDPAddr, msg message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteToUDP(payload, addr)
+	return err
+}
+
+// registration is a peer waiting for, or matched with, its counterpart.
+type registration struct {
+	peer    string
+	public  *net.UDPAddr
+	private string
+	seen    time.Time
+}
+
+// Server is a rendezvous server introducing peers to each other.
+type Server struct {
+	conn *net.UDPConn
+
+	mu    sync.Mutex
+	peers map[string]registration
+}
+
+// Listen creates a rendezvous server on a UDP address.
+func Listen(addr string) (*Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &Server{conn: conn, peers: make(map[string]registration)}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (server *Server) Addr() net.Addr {
+	return server.conn.LocalAddr()
+}
+
+// Serve answers registrations until ctx is done.
+func (server *Server) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { server.conn.Close() })
+	defer stop()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := server.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read registration: %w", err)
+		}
+
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil || msg.Type != msgRegister || msg.ID == "" || msg.Peer == "" {
+			continue
+		}
+		if err := server.register(msg, addr); err != nil {
+			log.Printf("Error answering registration from %s: %v\n", addr, err)
+		}
+	}
+}
+
+// Close stops the server.
+func (server *Server) Close() error {
+	return server.conn.Close()
+}
+
+// register records a peer, tells it its public endpoint and introduces it to its
+// counterpart if that one is registered too.
+func (server *Server) register(msg message, addr *net.UDPAddr) error {
+	server.mu.Lock()
+	now := time.Now()
+	for id, reg := range server.peers {
+		if now.Sub(reg.seen) > registrationTTL {
+			delete(server.peers, id)
+		}
+	}
+	self := registration{peer: msg.Peer, public: addr, private: msg.Private, seen: now}
+	server.peers[msg.ID] = self
+	other, matched := server.peers[msg.Peer]
+	matched = matched && other.peer == msg.ID
+	server.mu.Unlock()
+
+	if err := send(server.conn, addr, message{Type: msgRegistered, Public: addr.String()}); err != nil {
+		return err
+	}
+	if !matched {
+		return nil
+	}
+
+	// Introduce both sides so they start punching at about the same time
+	if err := send(server.conn, addr, message{Type: msgPeer, ID: msg.Peer, Public: other.public.String(), Private: other.private}); err != nil {
+		return err
+	}
+	return send(server.conn, other.public, message{Type: msgPeer, ID: msg.ID, Public: addr.String(), Private: self.private})
+}
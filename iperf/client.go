@@ -0,0 +1,197 @@
+package iperf
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	helloInterval = 100 * time.Millisecond
+)
+
+// ErrRejected is returned when the server refuses a test.
+var ErrRejected = errors.New("server rejected test")
+
+// Run connects to the server at addr and runs one test.
+func Run(ctx context.Context, addr string, req Request) (*Report, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	reader := bufio.NewReaderSize(conn, chunkSize)
+	if err := writeLine(conn, req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	var setup setupReply
+	if err := readLine(reader, &setup); err != nil {
+		return nil, fmt.Errorf("failed to read setup: %w", err)
+	}
+	if setup.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrRejected, setup.Error)
+	}
+
+	switch {
+	case req.Protocol == ProtocolTCP && req.Mode == ModeUpload:
+		return uploadTCP(ctx, conn.(*net.TCPConn), reader, req)
+	case req.Protocol == ProtocolTCP:
+		return downloadTCP(reader)
+	case req.Mode == ModeUpload:
+		return uploadUDP(ctx, conn.(*net.TCPConn), reader, addr, setup.UDPPort, req)
+	default:
+		return downloadUDP(ctx, reader, addr, setup.UDPPort, req)
+	}
+}
+
+// uploadTCP pushes data for the test duration; the server reports what it received.
+func uploadTCP(ctx context.Context, conn *net.TCPConn, reader *bufio.Reader, req Request) (*Report, error) {
+	chunk := make([]byte, chunkSize)
+	start := time.Now()
+	for time.Since(start) < req.Duration && ctx.Err() == nil {
+		if _, err := conn.Write(chunk); err != nil {
+			return nil, fmt.Errorf("failed to send data: %w", err)
+		}
+	}
+	// Read the retransmits before the close handshake adds to them
+	sent := retransmits(conn)
+	if err := conn.CloseWrite(); err != nil {
+		return nil, fmt.Errorf("failed to finish upload: %w", err)
+	}
+
+	var report Report
+	if err := readLine(reader, &report); err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	report.Retransmits = sent
+	return &report, nil
+}
+
+// downloadTCP reads length-prefixed chunks until the empty one, then the server's report.
+func downloadTCP(reader *bufio.Reader) (*Report, error) {
+	var report Report
+	var start time.Time
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, fmt.Errorf("failed to read data: %w", err)
+		}
+		if start.IsZero() {
+			start = time.Now()
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size == 0 {
+			break
+		}
+		n, err := reader.Discard(int(size))
+		report.Bytes += int64(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data: %w", err)
+		}
+	}
+	report.Duration = time.Since(start)
+
+	var server Report
+	if err := readLine(reader, &server); err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	report.Retransmits = server.Retransmits
+	return &report, nil
+}
+
+func uploadUDP(ctx context.Context, conn *net.TCPConn, reader *bufio.Reader, addr string, port int, req Request) (*Report, error) {
+	udpConn, err := dialUDP(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	defer udpConn.Close()
+
+	sent, err := sendUDP(ctx, udpConn, nil, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send datagrams: %w", err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		return nil, fmt.Errorf("failed to finish upload: %w", err)
+	}
+
+	var report Report
+	if err := readLine(reader, &report); err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	// The server only sees the highest sequence number; the exact count is known here
+	report.PacketsSent = sent
+	return &report, nil
+}
+
+func downloadUDP(ctx context.Context, reader *bufio.Reader, addr string, port int, req Request) (*Report, error) {
+	udpConn, err := dialUDP(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	defer udpConn.Close()
+
+	receiver := newUDPReceiver()
+	done := make(chan struct{})
+	go func() {
+		receiver.receive(udpConn, req.PacketSize)
+		close(done)
+	}()
+
+	// Say hello until the server starts sending, so it learns our address through any NAT
+	helloCtx, stopHello := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(helloInterval)
+		defer ticker.Stop()
+		for {
+			udpConn.Write([]byte("hello"))
+			select {
+			case <-helloCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var server Report
+	err = readLine(reader, &server)
+	stopHello()
+	time.Sleep(udpGrace)
+	udpConn.Close()
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+
+	report := receiver.report(server.PacketsSent)
+	return &report, nil
+}
+
+func dialUDP(addr string, port int) (*net.UDPConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve UDP address: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	return conn, nil
+}
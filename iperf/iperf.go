@@ -0,0 +1,144 @@
+// Package iperf measures throughput between a client and server over TCP or UDP,
+// iperf style. The client pushes (upload) or pulls (download) data for a fixed duration;
+// TCP reports include sender retransmits where TCP_INFO is available, UDP reports
+// include loss, reordering and jitter.
+//
+// Each test runs over a TCP control connection. The client sends a Request as a JSON
+// line and the server answers with a setup line. TCP data then flows on the same
+// connection; UDP data flows between the client and a per-test UDP port on the server.
+// The test ends with the server's Report as a JSON line.
+package iperf
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	ModeUpload   = "upload"
+	ModeDownload = "download"
+
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+const (
+	chunkSize         = 128 * 1024
+	defaultPacketSize = 1200
+	defaultBitrate    = 10_000_000
+	// udpHeaderSize covers the sequence number and send time at the start of every datagram.
+	udpHeaderSize = 16
+	// udpGrace is how long late datagrams are still counted after the sender is done.
+	udpGrace = 250 * time.Millisecond
+)
+
+// Request describes one test.
+type Request struct {
+	Mode     string        `json:"mode"`
+	Protocol string        `json:"protocol"`
+	Duration time.Duration `json:"duration"`
+	// Bitrate in bits per second and PacketSize in bytes pace UDP tests.
+	Bitrate    int64 `json:"bitrate,omitempty"`
+	PacketSize int   `json:"packet_size,omitempty"`
+}
+
+func (req *Request) validate() error {
+	if req.Mode != ModeUpload && req.Mode != ModeDownload {
+		return fmt.Errorf("unknown mode %q", req.Mode)
+	}
+	if req.Protocol != ProtocolTCP && req.Protocol != ProtocolUDP {
+		return fmt.Errorf("unknown protocol %q", req.Protocol)
+	}
+	if req.Duration <= 0 {
+		return fmt.Errorf("duration must be positive, got %v", req.Duration)
+	}
+	if req.Protocol == ProtocolUDP {
+		if req.Bitrate <= 0 {
+			req.Bitrate = defaultBitrate
+		}
+		if req.PacketSize <= 0 {
+			req.PacketSize = defaultPacketSize
+		}
+		if req.PacketSize < udpHeaderSize || req.PacketSize > 65507 {
+			return fmt.Errorf("packet size must be between %d and 65507 bytes", udpHeaderSize)
+		}
+	}
+	return nil
+}
+
+// Report is the result of one test, measured at the receiver.
+type Report struct {
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	// Retransmits is the sender's TCP retransmit count, nil where TCP_INFO is unavailable.
+	Retransmits *uint32 `json:"retransmits,omitempty"`
+
+	// UDP only
+	PacketsSent     int64         `json:"packets_sent,omitempty"`
+	PacketsReceived int64         `json:"packets_received,omitempty"`
+	OutOfOrder      int64         `json:"out_of_order,omitempty"`
+	Jitter          time.Duration `json:"jitter,omitempty"`
+}
+
+// Goodput returns the application-level throughput in bits per second.
+func (report *Report) Goodput() float64 {
+	if report.Duration <= 0 {
+		return 0
+	}
+	return float64(report.Bytes*8) / report.Duration.Seconds()
+}
+
+// Lost returns the number of UDP datagrams that never arrived.
+func (report *Report) Lost() int64 {
+	return max(report.PacketsSent-report.PacketsReceived, 0)
+}
+
+func (report *Report) String() string {
+	s := fmt.Sprintf("%d bytes in %v, goodput %s", report.Bytes, report.Duration.Round(time.Millisecond), formatBitrate(report.Goodput()))
+	if report.Retransmits != nil {
+		s += fmt.Sprintf(", %d retransmits", *report.Retransmits)
+	}
+	if report.PacketsSent > 0 {
+		loss := float64(report.Lost()) / float64(report.PacketsSent) * 100
+		s += fmt.Sprintf(", %d/%d datagrams lost (%.2f%%), %d out of order, jitter %v",
+			report.Lost(), report.PacketsSent, loss, report.OutOfOrder, report.Jitter.Round(time.Microsecond))
+	}
+	return s
+}
+
+func formatBitrate(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.2f Gbit/s", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.2f Mbit/s", bps/1e6)
+	default:
+		return fmt.Sprintf("%.2f kbit/s", bps/1e3)
+	}
+}
+
+// setupReply answers a Request before data starts flowing.
+type setupReply struct {
+	UDPPort int    `json:"udp_port,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func writeLine(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func readLine(r *bufio.Reader, v any) error {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
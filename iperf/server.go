@@ -0,0 +1,186 @@
+package iperf
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// helloTimeout bounds how long a UDP download waits for the client's first datagram.
+	helloTimeout = 5 * time.Second
+)
+
+// Server accepts measurement streams.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// Listen creates a server listening on addr, e.g. ":5201".
+func Listen(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &Server{listener: listener}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (server *Server) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+// Serve runs tests until ctx is done, then waits for running tests to finish.
+func (server *Server) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { server.listener.Close() })
+	defer stop()
+	defer server.wg.Wait()
+
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			defer conn.Close()
+			if err := server.handle(ctx, conn); err != nil {
+				log.Printf("Error running test for %s: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (server *Server) handle(ctx context.Context, conn net.Conn) error {
+	reader := bufio.NewReaderSize(conn, chunkSize)
+
+	var req Request
+	if err := readLine(reader, &req); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	if err := req.validate(); err != nil {
+		writeLine(conn, setupReply{Error: err.Error()})
+		return err
+	}
+	log.Printf("Running %s %s test for %s, %v\n", req.Protocol, req.Mode, conn.RemoteAddr(), req.Duration)
+
+	switch {
+	case req.Protocol == ProtocolTCP && req.Mode == ModeUpload:
+		return server.receiveTCP(conn, reader)
+	case req.Protocol == ProtocolTCP:
+		return server.sendTCP(ctx, conn, req)
+	default:
+		return server.runUDP(ctx, conn, reader, req)
+	}
+}
+
+// receiveTCP counts bytes until the client closes its side, then reports.
+func (server *Server) receiveTCP(conn net.Conn, reader *bufio.Reader) error {
+	if err := writeLine(conn, setupReply{}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	var report Report
+	var start time.Time
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 && start.IsZero() {
+			start = time.Now()
+		}
+		report.Bytes += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+	}
+	if !start.IsZero() {
+		report.Duration = time.Since(start)
+	}
+	return writeLine(conn, report)
+}
+
+// sendTCP streams length-prefixed chunks for the test duration, then an empty chunk
+// and the report carrying this side's retransmits.
+func (server *Server) sendTCP(ctx context.Context, conn net.Conn, req Request) error {
+	if err := writeLine(conn, setupReply{}); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 4+chunkSize)
+	binary.BigEndian.PutUint32(chunk, chunkSize)
+
+	var report Report
+	start := time.Now()
+	for time.Since(start) < req.Duration && ctx.Err() == nil {
+		if _, err := conn.Write(chunk); err != nil {
+			return fmt.Errorf("failed to send data: %w", err)
+		}
+		report.Bytes += chunkSize
+	}
+	report.Duration = time.Since(start)
+
+	if _, err := conn.Write(make([]byte, 4)); err != nil {
+		return fmt.Errorf("failed to end data: %w", err)
+	}
+	report.Retransmits = retransmits(conn)
+	return writeLine(conn, report)
+}
+
+// runUDP opens a UDP port for the test and either counts the client's datagrams or sends
+// datagrams to wherever the client's hello came from.
+func (server *Server) runUDP(ctx context.Context, conn net.Conn, reader *bufio.Reader, req Request) error {
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host)})
+	if err != nil {
+		writeLine(conn, setupReply{Error: "cannot open UDP port"})
+		return fmt.Errorf("failed to open UDP port: %w", err)
+	}
+	defer udpConn.Close()
+
+	if err := writeLine(conn, setupReply{UDPPort: udpConn.LocalAddr().(*net.UDPAddr).Port}); err != nil {
+		return err
+	}
+
+	if req.Mode == ModeUpload {
+		receiver := newUDPReceiver()
+		done := make(chan struct{})
+		go func() {
+			receiver.receive(udpConn, req.PacketSize)
+			close(done)
+		}()
+
+		// The client closes its side of the control connection when it is done sending
+		io.Copy(io.Discard, reader)
+		time.Sleep(udpGrace)
+		udpConn.Close()
+		<-done
+		return writeLine(conn, receiver.report(0))
+	}
+
+	udpConn.SetReadDeadline(time.Now().Add(helloTimeout))
+	_, clientAddr, err := udpConn.ReadFromUDP(make([]byte, 64))
+	if err != nil {
+		return fmt.Errorf("no hello from client: %w", err)
+	}
+
+	sent, err := sendUDP(ctx, udpConn, clientAddr, req)
+	if err != nil {
+		return fmt.Errorf("failed to send datagrams: %w", err)
+	}
+	return writeLine(conn, Report{PacketsSent: sent})
+}
@@ -0,0 +1,28 @@
+package iperf
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// retransmits returns the total retransmitted segments of a TCP connection.
+func retransmits(conn net.Conn) *uint32 {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var info *unix.TCPInfo
+	raw.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || info == nil {
+		return nil
+	}
+	return &info.Total_retrans
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package iperf
+
+import "net"
+
+// retransmits is unavailable without TCP_INFO.
+func retransmits(conn net.Conn) *uint32 {
+	return nil
+}
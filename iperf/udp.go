@@ -0,0 +1,116 @@
+package iperf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// sendUDP paces datagrams at req.Bitrate for req.Duration and returns how many were sent.
+// With addr nil, conn must be connected.
+func sendUDP(ctx context.Context, conn *net.UDPConn, addr *net.UDPAddr, req Request) (int64, error) {
+	packet := make([]byte, req.PacketSize)
+	interval := time.Duration(float64(req.PacketSize*8) / float64(req.Bitrate) * float64(time.Second))
+
+	start := time.Now()
+	end := start.Add(req.Duration)
+	next := start
+	var seq int64
+	for now := start; now.Before(end) && ctx.Err() == nil; now = time.Now() {
+		if wait := next.Sub(now); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		binary.BigEndian.PutUint64(packet[0:8], uint64(seq))
+		binary.BigEndian.PutUint64(packet[8:16], uint64(time.Now().UnixNano()))
+		var err error
+		if addr != nil {
+			_, err = conn.WriteToUDP(packet, addr)
+		} else {
+			_, err = conn.Write(packet)
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return seq, err
+		}
+		// Other send errors, like a full socket buffer, show up as loss at the receiver
+		seq++
+		next = next.Add(interval)
+	}
+	return seq, nil
+}
+
+// udpReceiver accumulates UDP datagram statistics. Jitter is the RFC 3550 interarrival
+// jitter, which only depends on differences in transit time, so clock offset cancels out.
+type udpReceiver struct {
+	packets    int64
+	bytes      int64
+	outOfOrder int64
+	maxSeq     int64
+
+	jitter      float64
+	lastTransit int64
+	first, last time.Time
+}
+
+func newUDPReceiver() *udpReceiver {
+	return &udpReceiver{maxSeq: -1}
+}
+
+func (receiver *udpReceiver) add(packet []byte, now time.Time) {
+	if len(packet) < udpHeaderSize {
+		return
+	}
+	seq := int64(binary.BigEndian.Uint64(packet[0:8]))
+	sent := int64(binary.BigEndian.Uint64(packet[8:16]))
+
+	transit := now.UnixNano() - sent
+	if receiver.packets > 0 {
+		d := float64(transit - receiver.lastTransit)
+		if d < 0 {
+			d = -d
+		}
+		receiver.jitter += (d - receiver.jitter) / 16
+	} else {
+		receiver.first = now
+	}
+	receiver.lastTransit = transit
+	receiver.last = now
+
+	if seq < receiver.maxSeq {
+		receiver.outOfOrder++
+	} else {
+		receiver.maxSeq = seq
+	}
+	receiver.packets++
+	receiver.bytes += int64(len(packet))
+}
+
+// receive reads datagrams until conn is closed.
+func (receiver *udpReceiver) receive(conn *net.UDPConn, size int) {
+	buf := make([]byte, size+1)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		receiver.add(buf[:n], time.Now())
+	}
+}
+
+// report returns the statistics. sent is the sender's datagram count if known, otherwise
+// it is estimated from the highest sequence number seen.
+func (receiver *udpReceiver) report(sent int64) Report {
+	if sent <= 0 {
+		sent = receiver.maxSeq + 1
+	}
+	return Report{
+		Bytes:           receiver.bytes,
+		Duration:        receiver.last.Sub(receiver.first),
+		PacketsSent:     sent,
+		PacketsReceived: receiver.packets,
+		OutOfOrder:      receiver.outOfOrder,
+		Jitter:          time.Duration(receiver.jitter),
+	}
+}
@@ -0,0 +1,181 @@
+// Package lifecycle runs a process from startup to a graceful shutdown. It turns SIGINT
+// and SIGTERM into a cancelled context, tracks whether the process is ready for traffic,
+// and on shutdown runs the registered hooks within one drain timeout. Hooks run in the
+// reverse order they were registered, like deferred calls, so what was started last
+// (accepting connections) stops first and what it depends on (workers, telemetry) after.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hook is a named shutdown step.
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Lifecycle coordinates the shutdown of one process.
+type Lifecycle struct {
+	ctx          context.Context
+	stop         context.CancelFunc
+	drainTimeout time.Duration
+
+	mu    sync.Mutex
+	hooks []hook
+	ready bool
+	// readyCh is closed while the process is ready and replaced when it stops being ready.
+	readyCh chan struct{}
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// New starts listening for SIGINT and SIGTERM. A second signal after shutdown has begun
+// kills the process the default way, so a stuck drain can still be interrupted.
+func New(drainTimeout time.Duration) *Lifecycle {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	lc := &Lifecycle{ctx: ctx, stop: stop, drainTimeout: drainTimeout, readyCh: make(chan struct{})}
+	context.AfterFunc(ctx, func() {
+		stop()
+		lc.SetReady(false)
+	})
+	return lc
+}
+
+// Context returns a context that is done once shutdown begins.
+func (lc *Lifecycle) Context() context.Context {
+	return lc.ctx
+}
+
+// Stop begins shutdown as a signal would.
+func (lc *Lifecycle) Stop() {
+	lc.stop()
+}
+
+// OnShutdown registers a shutdown step. Steps run one at a time, the last registered
+// first; ctx expires when the drain timeout does, shared by all steps.
+func (lc *Lifecycle) OnShutdown(name string, fn func(ctx context.Context) error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.hooks = append(lc.hooks, hook{name: name, fn: fn})
+}
+
+// SetReady marks whether the process should receive traffic. Shutdown marks it not ready.
+func (lc *Lifecycle) SetReady(ready bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if ready && lc.ctx.Err() != nil {
+		return
+	}
+	if ready == lc.ready {
+		return
+	}
+	lc.ready = ready
+	if ready {
+		close(lc.readyCh)
+	} else {
+		lc.readyCh = make(chan struct{})
+	}
+}
+
+// Ready reports whether the process is ready for traffic.
+func (lc *Lifecycle) Ready() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.ready
+}
+
+// WaitReady blocks until the process is ready, ctx is done or shutdown begins.
+func (lc *Lifecycle) WaitReady(ctx context.Context) error {
+	lc.mu.Lock()
+	readyCh := lc.readyCh
+	lc.mu.Unlock()
+
+	select {
+	case <-readyCh:
+		return nil
+	case <-lc.ctx.Done():
+		return errors.New("shutting down")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadyHandler answers readiness probes: 200 while ready, 503 before and during shutdown.
+func (lc *Lifecycle) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !lc.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+}
+
+// LiveHandler answers liveness probes: 200 as long as the process serves them, during
+// shutdown too, so orchestrators let a drain finish rather than restart the process.
+func (lc *Lifecycle) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Wait blocks until shutdown begins and then runs the shutdown steps. It returns the
+// errors of the steps that failed or ran out of time.
+func (lc *Lifecycle) Wait() error {
+	<-lc.ctx.Done()
+	return lc.Shutdown()
+}
+
+// Shutdown begins shutdown if it has not begun and runs the shutdown steps once; later
+// calls return the first call's result.
+func (lc *Lifecycle) Shutdown() error {
+	lc.shutdownOnce.Do(func() {
+		lc.stop()
+		lc.SetReady(false)
+
+		lc.mu.Lock()
+		hooks := lc.hooks
+		lc.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), lc.drainTimeout)
+		defer cancel()
+
+		var errs []error
+		for _, h := range slices.Backward(hooks) {
+			if err := h.fn(ctx); err != nil {
+				log.Printf("Error shutting down %s: %v\n", h.name, err)
+				errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			}
+		}
+		lc.shutdownErr = errors.Join(errs...)
+	})
+	return lc.shutdownErr
+}
+
+// WaitFunc runs wait, such as a pool's Wait, and returns once it does or ctx is done,
+// whichever comes first.
+func WaitFunc(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wait()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting: %w", ctx.Err())
+	}
+}
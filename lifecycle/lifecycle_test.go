@@ -0,0 +1,98 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownRunsHooksInReverse(t *testing.T) {
+	lc := New(time.Second)
+	var order []string
+	for _, name := range []string{"telemetry", "workers", "listener"} {
+		lc.OnShutdown(name, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+	lc.Stop()
+	if err := lc.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"listener", "workers", "telemetry"}; !slices.Equal(order, want) {
+		t.Errorf("ran %v, want %v", order, want)
+	}
+}
+
+func TestShutdownCollectsErrors(t *testing.T) {
+	lc := New(50 * time.Millisecond)
+	errFailed := errors.New("failed")
+	lc.OnShutdown("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	lc.OnShutdown("failing", func(ctx context.Context) error { return errFailed })
+
+	err := lc.Shutdown()
+	if !errors.Is(err, errFailed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want both steps' errors", err)
+	}
+	if again := lc.Shutdown(); again != err {
+		t.Errorf("second Shutdown returned %v, want the first's %v", again, err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	lc := New(time.Second)
+	probe := func() int {
+		recorder := httptest.NewRecorder()
+		lc.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return recorder.Code
+	}
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("before ready: %d", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	waited := make(chan error, 1)
+	go func() { waited <- lc.WaitReady(ctx) }()
+	lc.SetReady(true)
+	if err := <-waited; err != nil {
+		t.Errorf("WaitReady: %v", err)
+	}
+	if code := probe(); code != http.StatusOK {
+		t.Errorf("ready: %d", code)
+	}
+
+	lc.Stop()
+	lc.Shutdown()
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("shutting down: %d", code)
+	}
+	lc.SetReady(true)
+	if lc.Ready() {
+		t.Error("became ready again during shutdown")
+	}
+	if err := lc.WaitReady(ctx); err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Errorf("WaitReady during shutdown: %v", err)
+	}
+}
+
+func TestWaitFunc(t *testing.T) {
+	if err := WaitFunc(context.Background(), func() {}); err != nil {
+		t.Errorf("returned %v for a wait that finished", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	if err := WaitFunc(ctx, func() { <-block }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
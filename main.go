@@ -1,108 +1,660 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+
+	"github.com/blueai2022/net_prg/chaos"
+	"github.com/blueai2022/net_prg/concurtcp"
+	"github.com/blueai2022/net_prg/concurtcp/concurtcpadmin"
+	"github.com/blueai2022/net_prg/concurtcp/concurtcpprom"
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/framing"
+	"github.com/blueai2022/net_prg/lifecycle"
+	"github.com/blueai2022/net_prg/mdns"
+	"github.com/blueai2022/net_prg/pool"
+	"github.com/blueai2022/net_prg/pool/pooladmin"
+	"github.com/blueai2022/net_prg/pool/poolprom"
+	"github.com/blueai2022/net_prg/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	numWorkers = 5
+
+	// The max-conns-policy settings.
+	maxConnsWait   = "wait"
+	maxConnsReject = "reject"
+
+	// The mode settings.
+	modeEcho     = "echo"
+	modeCommands = "commands"
+	modeChat     = "chat"
+
+	// configPollInterval is how often the config file is checked for changes.
+	configPollInterval = time.Second
 )
 
-// Task implementation for handling a connection
-type ConnectionTask struct {
-	conn net.Conn
+// serverConfig holds the concurtcp settings.
+type serverConfig struct {
+	Addrs        []string `config:"addr" usage:"comma-separated addresses to listen on, sharing the workers: host:ports, e.g. 0.0.0.0:9000 and [::]:9000, or unix sockets, e.g. unix:///var/run/concurtcp.sock" required:"true"`
+	SocketMode   string   `config:"socket-mode" usage:"permissions of the unix socket file, in octal"`
+	Mode         string   `config:"mode" usage:"how messages are answered: echo; commands, dispatching PING, TIME, ECHO and QUIT; or chat to relay each to the other clients, which needs keep-alive"`
+	AcceptShards int      `config:"accept-shards" usage:"sockets opened on each TCP addr, sharing its port with SO_REUSEPORT and accepting on goroutines of their own, for very high connection rates; Linux only"`
+	Workers      int      `config:"workers" usage:"number of worker goroutines"`
+	QueueSize    int      `config:"queue-size" usage:"connections queued while every worker is busy"`
+	QueueOrder   string   `config:"queue-order" usage:"order queued connections are taken in: fifo, taking turns by client IP; lifo, newest first; or earliest-deadline, closest to the idle timeout first"`
+
+	WorkerIdleTimeout time.Duration `config:"worker-idle-timeout" usage:"start workers only when connections need them and stop them after this long idle; 0 keeps all running"`
+	ConnRate          float64       `config:"conn-rate" usage:"most connections handled per second, the rest wait their turn; 0 for no limit"`
+	ConnBurst         int           `config:"conn-burst" usage:"connections conn-rate lets start at once after a quiet spell"`
+
+	ReadTimeout       time.Duration `config:"read-timeout" usage:"how long a worker waits for a client's line before requeueing the connection; 0 waits indefinitely"`
+	ReadAttempts      int           `config:"read-attempts" usage:"how many times in a row a connection may time out reading before it is dropped; each message read starts the count over"`
+	WriteTimeout      time.Duration `config:"write-timeout" usage:"how long a worker waits for a client to take a response before dropping the connection; 0 waits indefinitely"`
+	WriteBufferSize   int           `config:"write-buffer-size" usage:"bytes of a response buffered before it is flushed to the client whole, and written at a time"`
+	SlowClientTimeout time.Duration `config:"slow-client-timeout" usage:"how long a client may take none of a response, its send buffer full, before the connection is dropped, however long the whole response takes; 0 waits up to write-timeout"`
+	KeepAlive         bool          `config:"keep-alive" usage:"answer every message a client sends until it closes the connection or idles, instead of only the first"`
+	IdleTimeout       time.Duration `config:"idle-timeout" usage:"how long a connection may take to send its first message, or a kept-alive one its next, before it is closed; 0 waits indefinitely"`
+	EventLoop         bool          `config:"event-loop" usage:"park connections waiting for a message in an epoll set instead of on a worker, so idle ones take no worker or goroutine; not for TLS connections; Linux only"`
+	Framing           string        `config:"framing" usage:"how messages are delimited: newline, length for a 4-byte big-endian length prefix, or checked for a length prefix with a CRC-32C checksum"`
+	MaxMessageSize    int           `config:"max-message-size" usage:"most bytes in a message; a client sending a larger one is answered with an error and disconnected"`
+
+	TCPKeepAlive         bool          `config:"tcp-keepalive" usage:"probe idle TCP connections so clients that vanished without closing them, e.g. behind a NAT, are dropped and their workers freed"`
+	TCPKeepAliveIdle     time.Duration `config:"tcp-keepalive-idle" usage:"how long a TCP connection idles before the first keep-alive probe"`
+	TCPKeepAliveInterval time.Duration `config:"tcp-keepalive-interval" usage:"time between unanswered keep-alive probes"`
+	TCPKeepAliveCount    int           `config:"tcp-keepalive-count" usage:"unanswered keep-alive probes after which a TCP connection is dropped"`
+
+	MaxConns       int    `config:"max-conns" usage:"most connections open at once, 0 for no limit"`
+	MaxConnsPolicy string `config:"max-conns-policy" usage:"what happens to connections over max-conns: wait, leaving them in the kernel's backlog, or reject, closing them with a busy message"`
+
+	ClientRate      float64 `config:"client-rate" usage:"most connections per second from one client IP, the rest closed with a throttle message; 0 for no limit"`
+	ClientBurst     int     `config:"client-burst" usage:"connections a client IP may open at once over client-rate after a quiet spell"`
+	ClientCacheSize int     `config:"client-cache-size" usage:"how many recent client IPs client-rate is tracked for"`
+
+	TLSCert       string `config:"tls-cert" usage:"certificate file; serves TLS when set"`
+	TLSKey        string `config:"tls-key" usage:"key file for tls-cert"`
+	TLSMinVersion string `config:"tls-min-version" usage:"oldest TLS version accepted: 1.2 or 1.3"`
+	TLSClientCA   string `config:"tls-client-ca" usage:"CA file; requires client certificates signed by it, whose common name, or else first subject alternative name, is the client's principal"`
+	StartTLS      bool   `config:"start-tls" usage:"accept plaintext connections and upgrade them to TLS when the client sends STARTTLS, so one port serves both while clients migrate; needs tls-cert and keep-alive"`
+
+	TLSClientFingerprints []string `config:"tls-client-fingerprints" usage:"comma-separated SHA-256 fingerprints of the only client certificates accepted, in hex with or without colons; needs tls-client-ca"`
+
+	MQTTBroker   string        `config:"mqtt-broker" usage:"MQTT broker URL for telemetry, e.g. tcp://broker:1883; empty disables"`
+	MQTTTopic    string        `config:"mqtt-topic" usage:"telemetry topic template with {host}, {service} and {kind} placeholders"`
+	MQTTInterval time.Duration `config:"mqtt-interval" usage:"how often server metrics are published"`
+
+	MetricsAddr string `config:"metrics-addr" usage:"host:port serving connection and worker pool metrics for Prometheus at /metrics; empty disables"`
+	AdminAddr   string `config:"admin-addr" usage:"host:port serving worker pool status at /pools and pausing at /pools/pause and /pools/resume, and open connections at /conns and closing them at /conns/close; empty disables"`
+
+	HealthAddr string        `config:"health-addr" usage:"host:port serving liveness probes at /healthz and readiness probes at /readyz; empty disables"`
+	ReadyGrace time.Duration `config:"ready-grace" usage:"how long to keep accepting connections on shutdown after readiness starts failing, so orchestrators stop routing traffic first; counts toward drain-timeout"`
+
+	MDNSInstance string `config:"mdns-instance" usage:"instance name advertised over mDNS as _echo._tcp, _commands._tcp or _chat._tcp, by mode; empty disables"`
+
+	DrainTimeout  time.Duration `config:"drain-timeout" usage:"how long running connections may take to finish on shutdown"`
+	GoAwayMessage string        `config:"go-away-message" usage:"message sent to kept-alive connections closed between messages on shutdown, so clients reconnect elsewhere; empty closes them without one"`
+
+	LogFormat string `config:"log-format" usage:"format of the access and server logs on stderr: text or json"`
+
+	ChaosSeed        int64         `config:"chaos-seed" usage:"seed for chaos faults, 0 picks one; the seed is logged so a run can be repeated"`
+	ChaosAcceptDelay time.Duration `config:"chaos-accept-delay" usage:"delay every accept randomly by up to this much"`
+	ChaosDropTasks   float64       `config:"chaos-drop-tasks" usage:"percentage of connections closed instead of handed to a worker"`
+	ChaosReconnects  float64       `config:"chaos-reconnects" usage:"percentage of connection reads that reset the connection"`
 }
 
-func (task *ConnectionTask) Run(wg *sync.WaitGroup) {
-	defer func() {
-		task.conn.Close()
-		wg.Done()
-	}()
+// queueOrders maps the queue-order setting to the pool's queue discipline.
+var queueOrders = map[string]pool.Discipline{
+	"fifo":              pool.FIFO,
+	"lifo":              pool.LIFO,
+	"earliest-deadline": pool.EarliestDeadline,
+}
+
+// tlsMinVersions maps the tls-min-version setting to the TLS version.
+var tlsMinVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultServerConfig returns the settings concurtcp uses when nothing overrides them.
+func defaultServerConfig() serverConfig {
+	return serverConfig{
+		Workers:              numWorkers,
+		SocketMode:           "0660",
+		Mode:                 modeEcho,
+		AcceptShards:         1,
+		QueueOrder:           "fifo",
+		ConnBurst:            1,
+		ReadAttempts:         3,
+		WriteBufferSize:      4096,
+		SlowClientTimeout:    5 * time.Second,
+		Framing:              "newline",
+		MaxMessageSize:       framing.MaxFrameSize,
+		TCPKeepAlive:         true,
+		TCPKeepAliveIdle:     15 * time.Second,
+		TCPKeepAliveInterval: 15 * time.Second,
+		TCPKeepAliveCount:    9,
+		MaxConns:             1000,
+		MaxConnsPolicy:       maxConnsWait,
+		ClientBurst:          10,
+		ClientCacheSize:      10000,
+		TLSMinVersion:        "1.2",
+		MQTTInterval:         10 * time.Second,
+		DrainTimeout:         30 * time.Second,
+		LogFormat:            "text",
+	}
+}
+
+func (cfg *serverConfig) Validate() error {
+	if _, err := cfg.socketMode(); err != nil {
+		return fmt.Errorf("socket-mode must be octal permissions, e.g. 0660, got %q", cfg.SocketMode)
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	if cfg.QueueSize < 0 {
+		return fmt.Errorf("queue-size must not be negative, got %d", cfg.QueueSize)
+	}
+	if _, ok := queueOrders[cfg.QueueOrder]; !ok {
+		return fmt.Errorf("queue-order must be fifo, lifo or earliest-deadline, got %q", cfg.QueueOrder)
+	}
+	if cfg.WorkerIdleTimeout < 0 {
+		return fmt.Errorf("worker-idle-timeout must not be negative, got %v", cfg.WorkerIdleTimeout)
+	}
+	if cfg.ConnRate < 0 {
+		return fmt.Errorf("conn-rate must not be negative, got %v", cfg.ConnRate)
+	}
+	if cfg.ConnBurst < 1 {
+		return fmt.Errorf("conn-burst must be at least 1, got %d", cfg.ConnBurst)
+	}
+	if cfg.ReadTimeout < 0 {
+		return fmt.Errorf("read-timeout must not be negative, got %v", cfg.ReadTimeout)
+	}
+	if cfg.ReadAttempts < 1 {
+		return fmt.Errorf("read-attempts must be at least 1, got %d", cfg.ReadAttempts)
+	}
+	if cfg.MaxConns < 0 {
+		return fmt.Errorf("max-conns must not be negative, got %d", cfg.MaxConns)
+	}
+	if cfg.Mode != modeEcho && cfg.Mode != modeCommands && cfg.Mode != modeChat {
+		return fmt.Errorf("mode must be %s, %s or %s, got %q", modeEcho, modeCommands, modeChat, cfg.Mode)
+	}
+	if cfg.Mode == modeChat && !cfg.KeepAlive {
+		return errors.New("mode chat needs keep-alive")
+	}
+	if cfg.AcceptShards < 1 {
+		return fmt.Errorf("accept-shards must be at least 1, got %d", cfg.AcceptShards)
+	}
+	if cfg.MaxConnsPolicy != maxConnsWait && cfg.MaxConnsPolicy != maxConnsReject {
+		return fmt.Errorf("max-conns-policy must be %s or %s, got %q", maxConnsWait, maxConnsReject, cfg.MaxConnsPolicy)
+	}
+	if cfg.ClientRate < 0 {
+		return fmt.Errorf("client-rate must not be negative, got %v", cfg.ClientRate)
+	}
+	if cfg.ClientBurst < 1 {
+		return fmt.Errorf("client-burst must be at least 1, got %d", cfg.ClientBurst)
+	}
+	if cfg.ClientCacheSize < 1 {
+		return fmt.Errorf("client-cache-size must be at least 1, got %d", cfg.ClientCacheSize)
+	}
+	if cfg.WriteTimeout < 0 {
+		return fmt.Errorf("write-timeout must not be negative, got %v", cfg.WriteTimeout)
+	}
+	if cfg.WriteBufferSize < 1 {
+		return fmt.Errorf("write-buffer-size must be at least 1, got %d", cfg.WriteBufferSize)
+	}
+	if cfg.SlowClientTimeout < 0 {
+		return fmt.Errorf("slow-client-timeout must not be negative, got %v", cfg.SlowClientTimeout)
+	}
+	if cfg.IdleTimeout < 0 {
+		return fmt.Errorf("idle-timeout must not be negative, got %v", cfg.IdleTimeout)
+	}
+	if cfg.TCPKeepAliveIdle <= 0 {
+		return fmt.Errorf("tcp-keepalive-idle must be positive, got %v", cfg.TCPKeepAliveIdle)
+	}
+	if cfg.TCPKeepAliveInterval <= 0 {
+		return fmt.Errorf("tcp-keepalive-interval must be positive, got %v", cfg.TCPKeepAliveInterval)
+	}
+	if cfg.TCPKeepAliveCount < 1 {
+		return fmt.Errorf("tcp-keepalive-count must be at least 1, got %d", cfg.TCPKeepAliveCount)
+	}
+	if _, ok := framing.Framings[cfg.Framing]; !ok {
+		return fmt.Errorf("framing must be newline, length or checked, got %q", cfg.Framing)
+	}
+	if cfg.MaxMessageSize < 1 {
+		return fmt.Errorf("max-message-size must be at least 1, got %d", cfg.MaxMessageSize)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be given together")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return errors.New("tls-client-ca needs tls-cert")
+	}
+	if len(cfg.TLSClientFingerprints) > 0 && cfg.TLSClientCA == "" {
+		return errors.New("tls-client-fingerprints needs tls-client-ca")
+	}
+	for _, fingerprint := range cfg.TLSClientFingerprints {
+		if _, err := parseFingerprint(fingerprint); err != nil {
+			return fmt.Errorf("tls-client-fingerprints must be SHA-256 fingerprints in hex, got %q", fingerprint)
+		}
+	}
+	if cfg.StartTLS && (cfg.TLSCert == "" || !cfg.KeepAlive) {
+		return errors.New("start-tls needs tls-cert and keep-alive")
+	}
+	if _, ok := tlsMinVersions[cfg.TLSMinVersion]; !ok {
+		return fmt.Errorf("tls-min-version must be 1.2 or 1.3, got %q", cfg.TLSMinVersion)
+	}
+	if cfg.MQTTBroker != "" && cfg.MQTTInterval <= 0 {
+		return fmt.Errorf("mqtt-interval must be positive, got %v", cfg.MQTTInterval)
+	}
+	if cfg.MDNSInstance != "" && !slices.ContainsFunc(cfg.Addrs, isTCPAddr) {
+		return errors.New("mdns-instance needs a TCP addr, not only unix sockets")
+	}
+	if cfg.DrainTimeout <= 0 {
+		return fmt.Errorf("drain-timeout must be positive, got %v", cfg.DrainTimeout)
+	}
+	if cfg.ReadyGrace < 0 || cfg.ReadyGrace >= cfg.DrainTimeout {
+		return fmt.Errorf("ready-grace must be between 0 and drain-timeout, got %v", cfg.ReadyGrace)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return fmt.Errorf("log-format must be text or json, got %q", cfg.LogFormat)
+	}
+	return cfg.chaos().Validate()
+}
 
-	// Read data from the client
-	data, err := bufio.NewReader(task.conn).ReadString('\n')
+// tlsConfig builds the listener's TLS config, or returns nil for plaintext.
+func (cfg *serverConfig) tlsConfig() (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
 	if err != nil {
-		log.Printf("error reading from client: %v\n", err)
-		return
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
 	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tlsMinVersions[cfg.TLSMinVersion]}
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(cfg.TLSClientFingerprints) > 0 {
+		tlsConfig.VerifyConnection = verifyFingerprints(cfg.TLSClientFingerprints)
+	}
+	return tlsConfig, nil
+}
 
-	// Process the data and generate a response
-	response := fmt.Sprintf("Received: %s", data)
+// verifyFingerprints accepts only client certificates whose SHA-256 fingerprint is one of
+// fingerprints, which are valid.
+func verifyFingerprints(fingerprints []string) func(tls.ConnectionState) error {
+	allowed := make(map[[sha256.Size]byte]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		sum, _ := parseFingerprint(fingerprint)
+		allowed[sum] = true
+	}
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("no client certificate")
+		}
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if !allowed[sum] {
+			return fmt.Errorf("client certificate %x is not allowed", sum)
+		}
+		return nil
+	}
+}
 
-	// Send the response back to the client
-	_, err = task.conn.Write([]byte(response))
+// parseFingerprint parses a SHA-256 fingerprint in hex, as printed by
+// openssl x509 -fingerprint -sha256 or without the colons.
+func parseFingerprint(fingerprint string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	decoded, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
 	if err != nil {
-		log.Printf("error writing to client: %v\n", err)
-		return
+		return sum, err
+	}
+	if len(decoded) != sha256.Size {
+		return sum, fmt.Errorf("fingerprint is %d bytes, not %d", len(decoded), sha256.Size)
+	}
+	copy(sum[:], decoded)
+	return sum, nil
+}
+
+// logger returns a logger writing to stderr in the log-format.
+func (cfg *serverConfig) logger() *slog.Logger {
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, nil))
+}
+
+// isTCPAddr reports whether addr is a host:port rather than a unix socket.
+func isTCPAddr(addr string) bool {
+	return !strings.HasPrefix(addr, concurtcp.UnixScheme)
+}
+
+// socketMode parses the socket-mode setting.
+func (cfg *serverConfig) socketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid permissions %q", cfg.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// options returns the server options for the settings, with the TLS config and chaos
+// monkey built from them.
+func (cfg *serverConfig) options(tlsConfig *tls.Config, monkey *chaos.Monkey) concurtcp.Options {
+	socketMode, _ := cfg.socketMode()
+	return concurtcp.Options{
+		NewFramer:            framing.Framings[cfg.Framing],
+		MaxMessageSize:       cfg.MaxMessageSize,
+		TLSConfig:            tlsConfig,
+		StartTLS:             cfg.StartTLS,
+		KeepAlive:            cfg.KeepAlive,
+		GoAwayMessage:        cfg.GoAwayMessage,
+		ReadTimeout:          cfg.ReadTimeout,
+		ReadAttempts:         cfg.ReadAttempts,
+		WriteTimeout:         cfg.WriteTimeout,
+		WriteBufferSize:      cfg.WriteBufferSize,
+		SlowClientTimeout:    cfg.SlowClientTimeout,
+		IdleTimeout:          cfg.IdleTimeout,
+		EventLoop:            cfg.EventLoop,
+		TCPKeepAliveIdle:     cfg.TCPKeepAliveIdle,
+		TCPKeepAliveInterval: cfg.TCPKeepAliveInterval,
+		TCPKeepAliveCount:    cfg.TCPKeepAliveCount,
+		NoTCPKeepAlive:       !cfg.TCPKeepAlive,
+		MaxConns:             cfg.MaxConns,
+		RejectOverLimit:      cfg.MaxConnsPolicy == maxConnsReject,
+		ClientRate:           cfg.ClientRate,
+		ClientBurst:          cfg.ClientBurst,
+		ClientCacheSize:      cfg.ClientCacheSize,
+		SocketMode:           socketMode,
+		AcceptShards:         cfg.AcceptShards,
+		Chaos:                monkey,
+	}
+}
+
+// handler returns the handler answering messages in the configured mode.
+func (cfg *serverConfig) handler() concurtcp.Handler {
+	switch cfg.Mode {
+	case modeCommands:
+		return concurtcp.NewCommands()
+	case modeChat:
+		return concurtcp.NewHub()
+	}
+	return concurtcp.Echo
+}
+
+// mdnsService returns the mDNS service type the server is advertised as in the configured
+// mode, so clients browsing for one kind of server don't find the others.
+func (cfg *serverConfig) mdnsService() string {
+	switch cfg.Mode {
+	case modeCommands:
+		return mdns.ServiceCommands
+	case modeChat:
+		return mdns.ServiceChat
+	}
+	return mdns.ServiceEcho
+}
+
+// chaos returns the chaos fault rates; all zero leaves chaos mode off.
+func (cfg *serverConfig) chaos() chaos.Config {
+	return chaos.Config{
+		Seed:        cfg.ChaosSeed,
+		AcceptDelay: cfg.ChaosAcceptDelay,
+		DropTasks:   cfg.ChaosDropTasks,
+		Reconnects:  cfg.ChaosReconnects,
 	}
 }
 
 func main() {
-	if len(os.Args) == 1 {
-		log.Fatal("please provide host:port")
+	cfg := defaultServerConfig()
+	if _, err := config.Load("concurtcp", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
 	}
 
-	tcpAdr, err := net.ResolveTCPAddr("tcp4", os.Args[1])
+	// Log structured records in the configured format, including the log package's
+	// output, which slog.SetDefault redirects
+	slog.SetDefault(cfg.logger())
+
+	tlsConfig, err := cfg.tlsConfig()
 	if err != nil {
-		log.Fatal("cannot resolve address", os.Args[1])
+		log.Fatal("invalid configuration: ", err)
 	}
 
-	listener, err := net.ListenTCP("tcp", tcpAdr)
+	// Shut down on an interrupt signal, undoing the steps below in reverse
+	lc := lifecycle.New(cfg.DrainTimeout)
+
+	// Answer health probes if an address is configured, until every other step has shut down
+	if cfg.HealthAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", lc.LiveHandler())
+		mux.Handle("/readyz", lc.ReadyHandler())
+		healthServer := &http.Server{Addr: cfg.HealthAddr, Handler: mux}
+		go func() {
+			if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("failed to serve health probes", "error", err)
+			}
+		}()
+		lc.OnShutdown("health", func(ctx context.Context) error {
+			return healthServer.Shutdown(ctx)
+		})
+	}
+
+	// Fail on purpose at the configured rates in chaos mode
+	var monkey *chaos.Monkey
+	if cfg.chaos().Enabled() {
+		monkey = chaos.New(cfg.chaos())
+		slog.Info("chaos mode enabled", "chaos", monkey)
+		lc.OnShutdown("chaos", func(ctx context.Context) error {
+			slog.Info("chaos faults injected", "stats", monkey.Stats())
+			return nil
+		})
+	}
+	// Create a worker pool with up to the configured number of workers
+	workers := pool.NewPriority(cfg.Workers, cfg.QueueSize)
+	workers.SetDiscipline(queueOrders[cfg.QueueOrder])
+	workers.SetIdleTimeout(cfg.WorkerIdleTimeout)
+	workers.SetRateLimit(cfg.ConnRate, cfg.ConnBurst)
+	workers.Run()
+	if err := pool.Register("connections", workers); err != nil {
+		log.Fatal("cannot register worker pool: ", err)
+	}
+
+	// Echo each message back on the workers
+	server, err := concurtcp.Listen(cfg.Addrs, cfg.handler(), cfg.options(tlsConfig, monkey), workers)
 	if err != nil {
-		log.Fatal("cannot listen on address", tcpAdr.String())
+		log.Fatal("cannot start server: ", err)
 	}
-	log.Println("TCP server started listening on", tcpAdr.String())
+	slog.Info("server started", "addrs", server.Addrs(), "tls", tlsConfig != nil, "start_tls", cfg.StartTLS)
+	go reloadOnChange(lc.Context(), workers, server, tlsConfig, monkey)
 
-	defer listener.Close()
+	// Publish server metrics over MQTT if a broker is configured
+	if cfg.MQTTBroker != "" {
+		publisher, err := telemetry.Connect(telemetry.Config{Broker: cfg.MQTTBroker, Service: "concurtcp", Topic: cfg.MQTTTopic})
+		if err != nil {
+			log.Fatal("cannot connect to MQTT broker: ", err)
+		}
+		go publisher.PublishEvery(lc.Context(), cfg.MQTTInterval, "metrics", func() any { return server.Stats() })
+		lc.OnShutdown("telemetry", func(ctx context.Context) error {
+			publisher.Close()
+			return nil
+		})
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Serve server and worker pool metrics to Prometheus if an address is configured
+	if cfg.MetricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(poolprom.NewRegisteredCollector(), concurtcpprom.NewCollector(server))
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		metricsServer := &http.Server{Addr: cfg.MetricsAddr, Handler: mux}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("failed to serve metrics", "error", err)
+			}
+		}()
+		lc.OnShutdown("metrics", func(ctx context.Context) error {
+			return metricsServer.Shutdown(ctx)
+		})
+	}
 
-	// Create a channel to receive interrupt signals
-	chSig := make(chan os.Signal, 1)
-	signal.Notify(chSig, os.Interrupt, syscall.SIGTERM)
+	// Let operators pause the worker pools and close connections if an admin address is
+	// configured
+	if cfg.AdminAddr != "" {
+		conns := concurtcpadmin.Handler(server)
+		mux := http.NewServeMux()
+		mux.Handle("/", pooladmin.Handler())
+		mux.Handle("/conns", conns)
+		mux.Handle("/conns/", conns)
+		adminServer := &http.Server{Addr: cfg.AdminAddr, Handler: mux}
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("failed to serve admin endpoint", "error", err)
+			}
+		}()
+		lc.OnShutdown("admin", func(ctx context.Context) error {
+			return adminServer.Shutdown(ctx)
+		})
+	}
 
-	// Goroutine to handle the interrupt signal
+	// Accept connections until the listener shutdown step stops serving
+	serving, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+	accepting := make(chan struct{})
 	go func() {
-		<-chSig
-		fmt.Println("Interrupt signal received. Cancelling context...")
-		cancel()
-		listener.Close()
+		defer close(accepting)
+		if err := server.Serve(serving); err != nil {
+			// Nothing left to serve without being told to stop, so shut down
+			slog.Error("server stopped accepting", "error", err)
+			lc.Stop()
+		}
 	}()
 
-	// Create a worker pool with a fixed number of workers
-	pool := NewPool(numWorkers)
-	pool.Run()
+	lc.OnShutdown("workers", func(ctx context.Context) error {
+		// Close the pool and wait for all tasks to complete, aborting the ones that take too
+		// long. Kept-alive connections close once they have answered the message at hand.
+		abandoned, err := workers.Shutdown(ctx)
+		if err != nil {
+			// Close the connections still queued too; running ones closed on cancel
+			server.Close()
+			return fmt.Errorf("aborted %d connections: %w", len(abandoned), err)
+		}
+		return nil
+	})
+	lc.OnShutdown("listener", func(ctx context.Context) error {
+		// Readiness is already failing; keep accepting while orchestrators notice and stop
+		// routing new connections here
+		if cfg.ReadyGrace > 0 {
+			slog.Info("waiting for readiness to propagate", "grace", cfg.ReadyGrace)
+			select {
+			case <-time.After(cfg.ReadyGrace):
+			case <-ctx.Done():
+			}
+		}
+		slog.Info("shutting down server")
+		stopServing()
+		return lifecycle.WaitFunc(ctx, func() { <-accepting })
+	})
+
+	// Advertise the server on the LAN if an instance name is configured, on the port of
+	// the first TCP listener
+	if cfg.MDNSInstance != "" {
+		i := slices.IndexFunc(server.Addrs(), func(addr net.Addr) bool { return addr.Network() == "tcp" })
+		ad, err := mdns.Advertise(cfg.MDNSInstance, cfg.mdnsService(), server.Addrs()[i].(*net.TCPAddr).Port, nil)
+		if err != nil {
+			log.Fatal("cannot advertise over mDNS: ", err)
+		}
+		lc.OnShutdown("mDNS advertisement", func(ctx context.Context) error {
+			ad.Close()
+			return nil
+		})
+	}
+
+	lc.SetReady(true)
+	if err := lc.Wait(); err != nil {
+		slog.Error("server shutdown incomplete", "error", err)
+		return
+	}
+	slog.Info("server shutdown complete")
+}
+
+// reloadOnChange reloads the configuration on SIGHUP or once the config file changes,
+// until ctx is done, and applies the worker pool settings and the server's limits and
+// timeouts to the connections accepted from then on. The listener, TLS, chaos, logging
+// and admin settings still need a restart, and flags override whatever the config file
+// or environment say.
+func reloadOnChange(ctx context.Context, workers *pool.Pool, server *concurtcp.Server, tlsConfig *tls.Config, monkey *chaos.Monkey) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	file := config.File("concurtcp", os.Args[1:])
+	modTime := configModTime(file)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Shutting down server...")
-
-			// Close the pool and wait for all tasks to complete
-			pool.Close()
-			pool.Wait()
-
-			log.Println("Server shutdown complete.")
 			return
-		default:
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Println("cannot accept connection on listener", err)
+		case <-hangup:
+		case <-ticker.C:
+			if file == "" || configModTime(file).Equal(modTime) {
 				continue
 			}
+		}
+		modTime = configModTime(file)
 
-			// Create a new task for each connection and add it to the pool
-			task := &ConnectionTask{conn: conn}
-			pool.Submit(task)
+		cfg := defaultServerConfig()
+		if _, err := config.Load("concurtcp", &cfg, os.Args[1:]); err != nil {
+			slog.Error("failed to reload configuration", "error", err)
+			continue
 		}
+		if cfg.Workers != workers.Size() {
+			slog.Info("resizing worker pool", "from", workers.Size(), "to", cfg.Workers)
+			workers.Resize(cfg.Workers)
+		}
+		workers.SetDiscipline(queueOrders[cfg.QueueOrder])
+		workers.SetIdleTimeout(cfg.WorkerIdleTimeout)
+		workers.SetRateLimit(cfg.ConnRate, cfg.ConnBurst)
+		server.SetOptions(cfg.options(tlsConfig, monkey))
+		slog.Info("configuration reloaded")
+	}
+}
+
+// configModTime returns when the config file was last modified, or the zero time if it
+// can't be read; a file that comes back is then picked up as changed.
+func configModTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
 	}
+	return info.ModTime()
 }
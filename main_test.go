@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blueai2022/net_prg/certgen"
+	"github.com/blueai2022/net_prg/mdns"
+)
+
+// fingerprint formats cert's SHA-256 fingerprint as openssl x509 -fingerprint does.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	var parts []string
+	for _, b := range sum {
+		parts = append(parts, strings.ToUpper(hex.EncodeToString([]byte{b})))
+	}
+	return strings.Join(parts, ":")
+}
+
+func TestParseFingerprint(t *testing.T) {
+	want := sha256.Sum256([]byte("cert"))
+	plain := hex.EncodeToString(want[:])
+	for _, input := range []string{plain, strings.ToUpper(plain), fingerprint(&x509.Certificate{Raw: []byte("cert")})} {
+		got, err := parseFingerprint(input)
+		if err != nil {
+			t.Errorf("%s: %v", input, err)
+		} else if got != want {
+			t.Errorf("%s: got %x, want %x", input, got, want)
+		}
+	}
+	for _, input := range []string{"", "zz", plain[:62], plain + "00"} {
+		if _, err := parseFingerprint(input); err == nil {
+			t.Errorf("parsed %q", input)
+		}
+	}
+}
+
+func TestValidateFingerprints(t *testing.T) {
+	cfg := defaultServerConfig()
+	cfg.Addrs = []string{"127.0.0.1:0"}
+	cfg.TLSCert, cfg.TLSKey = "cert.pem", "key.pem"
+	cfg.TLSClientFingerprints = []string{strings.Repeat("ab", sha256.Size)}
+	if err := cfg.Validate(); err == nil {
+		t.Error("accepted fingerprints without tls-client-ca")
+	}
+	cfg.TLSClientCA = "ca.pem"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("rejected a valid fingerprint: %v", err)
+	}
+	cfg.TLSClientFingerprints = []string{"not hex"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("accepted a malformed fingerprint")
+	}
+}
+
+func TestValidateQueueOrder(t *testing.T) {
+	cfg := defaultServerConfig()
+	cfg.Addrs = []string{"127.0.0.1:0"}
+	for order := range queueOrders {
+		cfg.QueueOrder = order
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("rejected queue order %s: %v", order, err)
+		}
+	}
+	cfg.QueueOrder = "round-robin"
+	if err := cfg.Validate(); err == nil {
+		t.Error("accepted an unknown queue order")
+	}
+}
+
+func TestValidateConnBurst(t *testing.T) {
+	cfg := defaultServerConfig()
+	cfg.Addrs = []string{"127.0.0.1:0"}
+	cfg.ConnRate = 10
+	for _, tt := range []struct {
+		burst int
+		valid bool
+	}{
+		{burst: 1, valid: true},
+		{burst: 20, valid: true},
+		{burst: 0, valid: false},
+		{burst: -1, valid: false},
+	} {
+		cfg.ConnBurst = tt.burst
+		if err := cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("conn-burst %d: got %v, want valid %v", tt.burst, err, tt.valid)
+		}
+	}
+}
+
+func TestMDNSServiceByMode(t *testing.T) {
+	for mode, want := range map[string]string{
+		modeEcho:     mdns.ServiceEcho,
+		modeCommands: mdns.ServiceCommands,
+		modeChat:     mdns.ServiceChat,
+	} {
+		cfg := defaultServerConfig()
+		cfg.Mode = mode
+		if got := cfg.mdnsService(); got != want {
+			t.Errorf("mode %s advertised as %s, want %s", mode, got, want)
+		}
+	}
+}
+
+// handshake runs a TLS handshake between server and client configs, returning the
+// server's error.
+func handshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	go func() {
+		tlsConn := tls.Client(clientConn, client)
+		tlsConn.Handshake()
+		// Wait for the server to accept or reject the certificate
+		tlsConn.Read(make([]byte, 1))
+		clientConn.Close()
+	}()
+	return tls.Server(serverConn, server).Handshake()
+}
+
+func TestFingerprintPinning(t *testing.T) {
+	pki, err := certgen.New(nil, certgen.Leaf{CommonName: "localhost", Hosts: []string{"localhost"}}, certgen.Leaf{CommonName: "pinned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := pki.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	// Another client of the same CA, which the CA alone would accept
+	other, err := pki.CA.IssueClient(certgen.Leaf{CommonName: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultServerConfig()
+	cfg.TLSCert = filepath.Join(dir, certgen.ServerCertFile)
+	cfg.TLSKey = filepath.Join(dir, certgen.ServerKeyFile)
+	cfg.TLSClientCA = filepath.Join(dir, certgen.CACertFile)
+	cfg.TLSClientFingerprints = []string{fingerprint(pki.Client.Cert)}
+	serverConfig, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConfig := pki.ClientTLSConfig()
+	clientConfig.ServerName = "localhost"
+	if err := handshake(t, serverConfig, clientConfig); err != nil {
+		t.Errorf("rejected the pinned client: %v", err)
+	}
+
+	clientConfig.Certificates = []tls.Certificate{other.TLSCertificate()}
+	if err := handshake(t, serverConfig, clientConfig); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("got %v for a client that isn't pinned, want it not allowed", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package mdns advertises the repo's services on the local network over multicast DNS
+// and discovers them by service name (DNS-SD), so clients need no hardcoded host:port.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+const (
+	// ServiceEcho is advertised by concurtcp echoing messages.
+	ServiceEcho = "_echo._tcp"
+	// ServiceCommands is advertised by concurtcp answering commands.
+	ServiceCommands = "_commands._tcp"
+	// ServiceChat is advertised by concurtcp running a chat hub.
+	ServiceChat = "_chat._tcp"
+	// ServiceSIP is advertised by the SIP client.
+	ServiceSIP = "_sip._udp"
+
+	domain = "local."
+	// defaultBrowseTimeout bounds a browse whose context has no deadline.
+	defaultBrowseTimeout = 3 * time.Second
+)
+
+// ErrNotFound is returned when no instance of a service answers in time.
+var ErrNotFound = errors.New("no service instance found")
+
+// Advertisement answers mDNS queries for one service instance until closed.
+type Advertisement struct {
+	server *zeroconf.Server
+}
+
+// Advertise announces instance of service, e.g. "office" of "_echo._tcp", on port of this
+// host with optional key=value TXT records.
+func Advertise(instance, service string, port int, txt []string) (*Advertisement, error) {
+	server, err := zeroconf.Register(instance, service, domain, port, txt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to advertise %s.%s: %w", instance, service, err)
+	}
+	return &Advertisement{server: server}, nil
+}
+
+// Close withdraws the advertisement with a goodbye announcement.
+func (ad *Advertisement) Close() {
+	ad.server.Shutdown()
+}
+
+// Instance is a discovered service instance.
+type Instance struct {
+	Name    string
+	Service string
+	Host    string
+	Port    int
+	IPs     []net.IP
+	TXT     []string
+}
+
+// Addr returns the host:port to connect to, preferring an IPv4 address.
+func (inst Instance) Addr() string {
+	host := inst.Host
+	if len(inst.IPs) > 0 {
+		host = inst.IPs[0].String()
+	}
+	for _, ip := range inst.IPs {
+		if ip.To4() != nil {
+			host = ip.String()
+			break
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(inst.Port))
+}
+
+// Browse collects the instances of service that answer before ctx is done, or within a
+// few seconds if ctx has no deadline.
+func Browse(ctx context.Context, service string) ([]Instance, error) {
+	var instances []Instance
+	err := browse(ctx, service, func(inst Instance) bool {
+		instances = append(instances, inst)
+		return true
+	})
+	return instances, err
+}
+
+// Lookup returns the first instance of service to answer, or the one named instance if
+// instance is not empty.
+func Lookup(ctx context.Context, service, instance string) (Instance, error) {
+	var found Instance
+	var ok bool
+	err := browse(ctx, service, func(inst Instance) bool {
+		if instance != "" && inst.Name != instance {
+			return true
+		}
+		found, ok = inst, true
+		return false
+	})
+	if err != nil {
+		return Instance{}, err
+	}
+	if !ok {
+		return Instance{}, fmt.Errorf("failed to discover %s: %w", service, ErrNotFound)
+	}
+	return found, nil
+}
+
+// browse passes each instance that answers to found until it returns false or the browse
+// times out.
+func browse(ctx context.Context, service string, found func(Instance) bool) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultBrowseTimeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create mDNS resolver: %w", err)
+	}
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, service, domain, entries); err != nil {
+		return fmt.Errorf("failed to browse %s: %w", service, err)
+	}
+
+	// The resolver closes entries once ctx is done
+	seen := make(map[string]bool)
+	for entry := range entries {
+		if seen[entry.Instance] || ctx.Err() != nil {
+			continue
+		}
+		seen[entry.Instance] = true
+		inst := Instance{
+			Name:    entry.Instance,
+			Service: entry.Service,
+			Host:    entry.HostName,
+			Port:    entry.Port,
+			IPs:     append(append([]net.IP(nil), entry.AddrIPv4...), entry.AddrIPv6...),
+			TXT:     entry.Text,
+		}
+		if !found(inst) {
+			cancel()
+		}
+	}
+	return nil
+}
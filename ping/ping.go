@@ -0,0 +1,258 @@
+// Package ping sends ICMP echo requests and reports round-trip statistics. It uses raw
+// ICMP sockets when permitted and falls back to unprivileged ICMP datagram sockets
+// (net.ipv4.ping_group_range on Linux) otherwise.
+package ping
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+	tokenSize        = 8
+)
+
+// Options controls a ping run. Zero values select the defaults.
+type Options struct {
+	// Count is the number of echo requests to send (default 4).
+	Count int
+	// Interval is the time between requests (default 1s).
+	Interval time.Duration
+	// Timeout is how long to wait for each reply (default 1s).
+	Timeout time.Duration
+	// Size is the payload size in bytes (default 56, minimum 8).
+	Size int
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Count <= 0 {
+		opts.Count = 4
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.Size < tokenSize {
+		opts.Size = 56
+	}
+}
+
+// Stats summarizes a ping run.
+type Stats struct {
+	Addr       string
+	Privileged bool
+	Sent       int
+	Received   int
+	RTTs       []time.Duration
+	MinRTT     time.Duration
+	AvgRTT     time.Duration
+	MaxRTT     time.Duration
+	StdDevRTT  time.Duration
+}
+
+// Loss returns the fraction of requests that got no reply.
+func (stats *Stats) Loss() float64 {
+	if stats.Sent == 0 {
+		return 0
+	}
+	return float64(stats.Sent-stats.Received) / float64(stats.Sent)
+}
+
+func (stats *Stats) String() string {
+	return fmt.Sprintf("%s: %d sent, %d received, %.1f%% loss, rtt min/avg/max/stddev = %v/%v/%v/%v",
+		stats.Addr, stats.Sent, stats.Received, stats.Loss()*100,
+		stats.MinRTT, stats.AvgRTT, stats.MaxRTT, stats.StdDevRTT)
+}
+
+// Reply is called with the sequence number and RTT of every echo reply received.
+type Reply func(seq int, rtt time.Duration)
+
+// Ping sends opts.Count echo requests to host and returns the RTT statistics.
+// It stops early when ctx is done.
+func Ping(ctx context.Context, host string, opts Options) (*Stats, error) {
+	return PingWithReplies(ctx, host, opts, nil)
+}
+
+// PingWithReplies is Ping, calling onReply for every reply as it arrives.
+func PingWithReplies(ctx context.Context, host string, opts Options, onReply Reply) (*Stats, error) {
+	opts.setDefaults()
+
+	ip, err := resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	conn, dst, privileged, err := listen(ip)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stats := &Stats{Addr: ip.String(), Privileged: privileged}
+	id := os.Getpid() & 0xffff
+
+	for seq := 0; seq < opts.Count; seq++ {
+		if seq > 0 {
+			select {
+			case <-ctx.Done():
+				return stats.summarize(), ctx.Err()
+			case <-time.After(opts.Interval):
+			}
+		}
+
+		rtt, err := echo(conn, dst, ip, id, seq, opts)
+		stats.Sent++
+		if err != nil {
+			continue
+		}
+		stats.Received++
+		stats.RTTs = append(stats.RTTs, rtt)
+		if onReply != nil {
+			onReply(seq, rtt)
+		}
+	}
+	return stats.summarize(), nil
+}
+
+// Reachable sends up to three echo requests and returns nil as soon as one is answered.
+// It is meant as a cheap pre-check before dialing; ICMP may be filtered where TCP is not.
+func Reachable(ctx context.Context, host string, timeout time.Duration) error {
+	ip, err := resolve(ctx, host)
+	if err != nil {
+		return err
+	}
+	conn, dst, _, err := listen(ip)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := Options{Timeout: timeout}
+	opts.setDefaults()
+	for seq := 0; seq < 3; seq++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := echo(conn, dst, ip, os.Getpid()&0xffff, seq, opts); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s (%s) did not answer ICMP echo", host, ip)
+}
+
+func resolve(ctx context.Context, host string) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	// Prefer IPv4, which is what most of our paths still use
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP, nil
+		}
+	}
+	return addrs[0].IP, nil
+}
+
+// listen opens a raw ICMP socket, falling back to an unprivileged datagram socket.
+func listen(ip net.IP) (*icmp.PacketConn, net.Addr, bool, error) {
+	network, udpNetwork, address := "ip4:icmp", "udp4", "0.0.0.0"
+	if ip.To4() == nil {
+		network, udpNetwork, address = "ip6:ipv6-icmp", "udp6", "::"
+	}
+
+	if conn, err := icmp.ListenPacket(network, address); err == nil {
+		return conn, &net.IPAddr{IP: ip}, true, nil
+	}
+	conn, err := icmp.ListenPacket(udpNetwork, address)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to open ICMP socket (raw sockets need CAP_NET_RAW, datagram sockets need net.ipv4.ping_group_range): %w", err)
+	}
+	return conn, &net.UDPAddr{IP: ip}, false, nil
+}
+
+// echo sends one echo request and waits for its reply.
+func echo(conn *icmp.PacketConn, dst net.Addr, ip net.IP, id, seq int, opts Options) (time.Duration, error) {
+	proto := protocolICMP
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		proto = protocolIPv6ICMP
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	// A random token in the payload tells our replies apart, since datagram
+	// sockets rewrite the echo ID
+	payload := make([]byte, opts.Size)
+	if _, err := rand.Read(payload[:tokenSize]); err != nil {
+		return 0, err
+	}
+	request, err := (&icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: payload},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode echo request: %w", err)
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(request, dst); err != nil {
+		return 0, fmt.Errorf("failed to send echo request: %w", err)
+	}
+
+	deadline := start.Add(opts.Timeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		body, ok := reply.Body.(*icmp.Echo)
+		if !ok || body.Seq != seq || len(body.Data) < tokenSize || !bytes.Equal(body.Data[:tokenSize], payload[:tokenSize]) {
+			continue
+		}
+		return time.Since(start), nil
+	}
+}
+
+func (stats *Stats) summarize() *Stats {
+	if len(stats.RTTs) == 0 {
+		return stats
+	}
+
+	stats.MinRTT, stats.MaxRTT = stats.RTTs[0], stats.RTTs[0]
+	var sum time.Duration
+	for _, rtt := range stats.RTTs {
+		sum += rtt
+		stats.MinRTT = min(stats.MinRTT, rtt)
+		stats.MaxRTT = max(stats.MaxRTT, rtt)
+	}
+	stats.AvgRTT = sum / time.Duration(len(stats.RTTs))
+
+	var variance float64
+	for _, rtt := range stats.RTTs {
+		diff := float64(rtt - stats.AvgRTT)
+		variance += diff * diff
+	}
+	stats.StdDevRTT = time.Duration(math.Sqrt(variance / float64(len(stats.RTTs))))
+	return stats
+}
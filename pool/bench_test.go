@@ -0,0 +1,164 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchQueueSize is the number of tasks the queued pools hold while every worker is busy.
+const benchQueueSize = 1024
+
+// spinTask spins for a fixed time, standing in for a short request.
+type spinTask time.Duration
+
+func (task spinTask) Run(ctx context.Context) error {
+	for start := time.Now(); time.Since(start) < time.Duration(task); {
+	}
+	return nil
+}
+
+// chanPool is the pool as it was before tasks were queued by priority: a fixed number of
+// workers ranging over a buffered channel. It is kept as the baseline the queues are
+// measured against.
+type chanPool struct {
+	tasks chan Task
+	wg    sync.WaitGroup
+}
+
+func newChanPool(numThreads, queueSize int) *chanPool {
+	pool := &chanPool{tasks: make(chan Task, queueSize)}
+	for range numThreads {
+		go func() {
+			for task := range pool.tasks {
+				task.Run(context.Background())
+				pool.wg.Done()
+			}
+		}()
+	}
+	return pool
+}
+
+func (pool *chanPool) Submit(task Task) {
+	pool.wg.Add(1)
+	pool.tasks <- task
+}
+
+func (pool *chanPool) Wait() {
+	pool.wg.Wait()
+}
+
+func (pool *chanPool) Close() {
+	close(pool.tasks)
+}
+
+// benchPool is the part of a pool the benchmarks drive.
+type benchPool interface {
+	Submit(task Task)
+	Wait()
+	Close()
+}
+
+// benchPools submits b.N tasks spinning for work to each kind of pool, from as many
+// goroutines as RunParallel starts, and waits for them to run.
+func benchPools(b *testing.B, work time.Duration) {
+	pools := []struct {
+		name string
+		new  func(workers int) benchPool
+	}{
+		{"channel", func(workers int) benchPool { return newChanPool(workers, benchQueueSize) }},
+		{"locked", func(workers int) benchPool { return started(NewPriority(workers, benchQueueSize)) }},
+		{"ring", func(workers int) benchPool { return started(NewRing(workers, benchQueueSize)) }},
+	}
+	for _, kind := range pools {
+		b.Run(kind.name, func(b *testing.B) {
+			pool := kind.new(4)
+			defer pool.Close()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					pool.Submit(spinTask(work))
+				}
+			})
+			pool.Wait()
+		})
+	}
+}
+
+func started(pool *Pool) *Pool {
+	pool.Run()
+	return pool
+}
+
+func BenchmarkSubmitEmpty(b *testing.B) {
+	benchPools(b, 0)
+}
+
+func BenchmarkSubmitShort(b *testing.B) {
+	benchPools(b, time.Microsecond)
+}
+
+// BenchmarkQueue hands b.N empty tasks through each queue alone to four workers that do
+// nothing but take them, leaving out the per-task bookkeeping of a Pool: stats, hooks,
+// in-flight tracking and panic recovery. The channel is timestamped as the queues are.
+func BenchmarkQueue(b *testing.B) {
+	queues := []struct {
+		name string
+		new  func(spawn func()) dispatcher
+	}{
+		{"locked", func(spawn func()) dispatcher { return newTaskQueue(benchQueueSize, 4, spawn) }},
+		{"ring", func(spawn func()) dispatcher { return newRingQueue(benchQueueSize, 4, spawn) }},
+	}
+
+	nop := taskFunc(func(ctx context.Context) error { return nil })
+
+	b.Run("channel", func(b *testing.B) {
+		tasks := make(chan queuedTask, benchQueueSize)
+		defer close(tasks)
+		var wg sync.WaitGroup
+		for range 4 {
+			go func() {
+				for queued := range tasks {
+					queued.task.Run(context.Background())
+					wg.Done()
+				}
+			}()
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				wg.Add(1)
+				tasks <- queuedTask{task: nop, queued: time.Now()}
+			}
+		})
+		wg.Wait()
+	})
+	for _, kind := range queues {
+		b.Run(kind.name, func(b *testing.B) {
+			var wg sync.WaitGroup
+			var queue dispatcher
+			queue = kind.new(func() {
+				var state workerState
+				for {
+					queued, ok := queue.pop(&state)
+					if !ok {
+						return
+					}
+					queued.task.Run(context.Background())
+					wg.Done()
+				}
+			})
+			queue.start()
+			defer queue.close()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					wg.Add(1)
+					queue.push(context.Background(), nop)
+				}
+			})
+			wg.Wait()
+		})
+	}
+}
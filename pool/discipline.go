@@ -0,0 +1,81 @@
+package pool
+
+import "time"
+
+// Discipline orders the queued tasks of equal priority; tasks of higher priority are
+// always taken first. It is called with the queue's lock held and must not block.
+type Discipline interface {
+	// Less reports whether a should be taken before b.
+	Less(a, b Waiting) bool
+}
+
+// Waiting describes a queued task to a Discipline.
+type Waiting struct {
+	// Task is the task as submitted.
+	Task any
+	// Key is the task's Key, and Round its turn among the tasks of that key.
+	Key   string
+	Round uint64
+	// Seq numbers tasks in submission order.
+	Seq    uint64
+	Queued time.Time
+}
+
+// Deadlined is implemented by tasks that should start by a deadline, for the
+// EarliestDeadline discipline. ok is false if the task has none.
+type Deadlined interface {
+	Deadline() (deadline time.Time, ok bool)
+}
+
+var (
+	// FIFO takes tasks in submission order, taking turns by Key. It is the default.
+	FIFO Discipline = fifo{}
+	// LIFO takes the newest task first, which keeps latency low for most tasks under
+	// overload at the expense of the oldest ones. It ignores Key.
+	LIFO Discipline = lifo{}
+	// EarliestDeadline takes the task with the earliest Deadline first, then tasks
+	// without one as FIFO does.
+	EarliestDeadline Discipline = earliestDeadline{}
+)
+
+type fifo struct{}
+
+func (fifo) Less(a, b Waiting) bool {
+	if a.Round != b.Round {
+		return a.Round < b.Round
+	}
+	return a.Seq < b.Seq
+}
+
+type lifo struct{}
+
+func (lifo) Less(a, b Waiting) bool {
+	return a.Seq > b.Seq
+}
+
+type earliestDeadline struct{}
+
+func (earliestDeadline) Less(a, b Waiting) bool {
+	aDeadline, aOK := deadline(a.Task)
+	bDeadline, bOK := deadline(b.Task)
+	switch {
+	case aOK && bOK && !aDeadline.Equal(bDeadline):
+		return aDeadline.Before(bDeadline)
+	case aOK != bOK:
+		return aOK
+	}
+	return FIFO.Less(a, b)
+}
+
+func deadline(task any) (time.Time, bool) {
+	if deadlined, ok := task.(Deadlined); ok {
+		return deadlined.Deadline()
+	}
+	return time.Time{}, false
+}
+
+// SetDiscipline changes the order queued tasks of equal priority are taken in, including
+// the tasks already queued.
+func (pool *Pool) SetDiscipline(discipline Discipline) {
+	pool.queue.setDiscipline(discipline)
+}
@@ -0,0 +1,105 @@
+package pool
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// order runs tasks on one worker, queued while the pool is paused so that they are all
+// waiting when the discipline picks, and returns the order they ran in.
+func order(t *testing.T, discipline Discipline, tasks ...*namedTask) []string {
+	t.Helper()
+	pool := NewPriority(1, len(tasks))
+	if discipline != nil {
+		pool.SetDiscipline(discipline)
+	}
+	pool.Pause()
+	pool.Run()
+	ran := &recorder{}
+	for _, task := range tasks {
+		task.ran = ran
+		pool.Submit(task)
+	}
+	pool.Resume()
+	pool.Wait()
+	pool.Close()
+	return ran.get()
+}
+
+func TestDisciplines(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		discipline Discipline
+		tasks      []*namedTask
+		want       []string
+	}{
+		{
+			name:  "fifo by default",
+			tasks: []*namedTask{{name: "a"}, {name: "b"}, {name: "c"}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:       "fifo takes turns by key",
+			discipline: FIFO,
+			tasks: []*namedTask{
+				{name: "a1", key: "a"}, {name: "a2", key: "a"}, {name: "a3", key: "a"},
+				{name: "b1", key: "b"}, {name: "c1", key: "c"}, {name: "b2", key: "b"},
+			},
+			want: []string{"a1", "b1", "c1", "a2", "b2", "a3"},
+		},
+		{
+			name:       "lifo",
+			discipline: LIFO,
+			tasks:      []*namedTask{{name: "a", key: "a"}, {name: "b", key: "a"}, {name: "c", key: "b"}},
+			want:       []string{"c", "b", "a"},
+		},
+		{
+			name:       "earliest deadline",
+			discipline: EarliestDeadline,
+			tasks: []*namedTask{
+				{name: "none1"},
+				{name: "late", deadline: now.Add(time.Hour)},
+				{name: "none2"},
+				{name: "early", deadline: now.Add(time.Minute)},
+			},
+			want: []string{"early", "late", "none1", "none2"},
+		},
+		{
+			name:  "priority before turns",
+			tasks: []*namedTask{{name: "a1", key: "a"}, {name: "a2", key: "a"}, {name: "b1", key: "b", priority: 1}},
+			want:  []string{"b1", "a1", "a2"},
+		},
+		{
+			name:       "priority first",
+			discipline: LIFO,
+			tasks:      []*namedTask{{name: "urgent", priority: 1}, {name: "a"}, {name: "b"}},
+			want:       []string{"urgent", "b", "a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := order(t, test.discipline, test.tasks...); !slices.Equal(got, test.want) {
+				t.Errorf("ran %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetDisciplineReordersQueued(t *testing.T) {
+	pool := NewPriority(1, 3)
+	pool.Pause()
+	pool.Run()
+	defer pool.Close()
+	ran := &recorder{}
+	for _, name := range []string{"a", "b", "c"} {
+		pool.Submit(&namedTask{name: name, ran: ran})
+	}
+	pool.SetDiscipline(LIFO)
+	pool.Resume()
+	pool.Wait()
+	if got, want := ran.get(), []string{"c", "b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+}
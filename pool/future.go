@@ -0,0 +1,105 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ResultTask is a task that produces a value, for request/response work on the pool.
+type ResultTask interface {
+	RunResult(ctx context.Context) (any, error)
+}
+
+// ResultFunc adapts a function to ResultTask.
+type ResultFunc func(ctx context.Context) (any, error)
+
+func (fn ResultFunc) RunResult(ctx context.Context) (any, error) {
+	return fn(ctx)
+}
+
+// Future is the pending result of a ResultTask.
+type Future struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// Wait blocks until the task has finished.
+func (future *Future) Wait() {
+	<-future.done
+}
+
+// Done returns a channel closed once the task has finished.
+func (future *Future) Done() <-chan struct{} {
+	return future.done
+}
+
+// Err waits for the task and returns its error. A task that panicked returns an error
+// saying so.
+func (future *Future) Err() error {
+	<-future.done
+	return future.err
+}
+
+// Value waits for the task and returns its value.
+func (future *Future) Value() any {
+	<-future.done
+	return future.value
+}
+
+func (future *Future) complete(value any, err error) {
+	future.value, future.err = value, err
+	close(future.done)
+}
+
+// SubmitWithResult submits a task with the pool's context and returns its pending result.
+// Like Submit, it blocks while the pool has no room for the task.
+func (pool *Pool) SubmitWithResult(task ResultTask) *Future {
+	future := &Future{done: make(chan struct{})}
+	pool.Submit(&resultTask{task: task, future: future, pool: pool})
+	return future
+}
+
+// WaitAll waits for every future and returns their values in order, with the errors of
+// the ones that failed joined.
+func WaitAll(futures []*Future) ([]any, error) {
+	values := make([]any, len(futures))
+	var errs []error
+	for i, future := range futures {
+		if err := future.Err(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values[i] = future.value
+	}
+	return values, errors.Join(errs...)
+}
+
+// resultTask adapts a ResultTask to Task. Its error goes to the future rather than the
+// OnError function.
+type resultTask struct {
+	task   ResultTask
+	future *Future
+	pool   *Pool
+}
+
+func (task *resultTask) Run(ctx context.Context) error {
+	defer func() {
+		// Complete the future before the pool reports the panic, so waiters don't hang
+		if value := recover(); value != nil {
+			task.future.complete(nil, fmt.Errorf("task panicked: %v", value))
+			panic(value)
+		}
+	}()
+	value, err := task.task.RunResult(ctx)
+	if err != nil {
+		task.pool.counters.failed.Add(1)
+	}
+	task.future.complete(value, err)
+	return nil
+}
+
+func (task *resultTask) unwrap() any {
+	return task.task
+}
@@ -0,0 +1,73 @@
+package pool
+
+import (
+	"context"
+	"slices"
+)
+
+// TaskStartFunc is called on the worker about to run a task, as submitted, with the
+// pool's context. The context it returns is the one the task runs with and is passed on
+// to TaskEndFunc, so it can carry a tracing span or a start time.
+type TaskStartFunc func(ctx context.Context, task any) context.Context
+
+// TaskEndFunc is called once a task has finished, with the context the start hooks
+// returned, the error the task returned and the value it panicked with, or nil.
+type TaskEndFunc func(ctx context.Context, task any, err error, panicked any)
+
+// WorkerStartFunc is called on each worker goroutine as it starts.
+type WorkerStartFunc func()
+
+// hooks holds the registered hooks. Registering replaces the slices rather than
+// appending in place, so a copy of hooks can be used without holding the pool's lock.
+type hooks struct {
+	taskStart   []TaskStartFunc
+	taskEnd     []TaskEndFunc
+	workerStart []WorkerStartFunc
+}
+
+// OnTaskStart registers a function called before each task runs. Start functions run in
+// the order registered, each given the context the previous one returned.
+func (pool *Pool) OnTaskStart(fn TaskStartFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.hooks.taskStart = append(slices.Clip(pool.hooks.taskStart), fn)
+}
+
+// OnTaskEnd registers a function called after each task, including ones that panicked.
+// End functions run in the reverse order they were registered, so that spans started in
+// order end in reverse.
+func (pool *Pool) OnTaskEnd(fn TaskEndFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.hooks.taskEnd = append(slices.Clip(pool.hooks.taskEnd), fn)
+}
+
+// OnWorkerStart registers a function called on every worker goroutine as it starts,
+// including workers started later by Resize or on demand.
+func (pool *Pool) OnWorkerStart(fn WorkerStartFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.hooks.workerStart = append(slices.Clip(pool.hooks.workerStart), fn)
+}
+
+// currentHooks returns the registered hooks.
+func (pool *Pool) currentHooks() hooks {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.hooks
+}
+
+// startTask runs the start hooks for task and returns the context it runs with.
+func (hooks hooks) startTask(ctx context.Context, task any) context.Context {
+	for _, fn := range hooks.taskStart {
+		ctx = fn(ctx, task)
+	}
+	return ctx
+}
+
+// endTask runs the end hooks for task.
+func (hooks hooks) endTask(ctx context.Context, task any, err error, panicked any) {
+	for _, fn := range slices.Backward(hooks.taskEnd) {
+		fn(ctx, task, err, panicked)
+	}
+}
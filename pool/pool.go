@@ -0,0 +1,339 @@
+// Package pool runs tasks on worker goroutines. The number of workers is set at
+// construction and can be changed with Resize while the pool is running. With an idle
+// timeout, workers start only when tasks need them and exit once idle. Waiting tasks are
+// taken highest Priority first, then in the order of the pool's Discipline.
+package pool
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when submitting to a closed pool.
+var ErrClosed = errors.New("pool: closed")
+
+// Task is run by a worker with the pool's context, so it can abort blocking reads and
+// writes when the pool is cancelled instead of holding up shutdown. The pool counts the
+// task done for Wait once Run returns or panics. An error is counted as a failure and
+// passed to the OnError function.
+type Task interface {
+	Run(ctx context.Context) error
+}
+
+// PanicFunc is called with the task that panicked, a Task or ResultTask as submitted,
+// the value it panicked with and the stack of the panic.
+type PanicFunc func(task any, value any, stack []byte)
+
+// ErrorFunc is called with a task, as submitted, that failed for good and its error.
+type ErrorFunc func(task any, err error)
+
+type Pool struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	onPanic PanicFunc
+	onError ErrorFunc
+	hooks   hooks
+
+	queue    dispatcher
+	limiter  rateLimiter
+	wg       sync.WaitGroup
+	counters counters
+
+	// inFlight holds the running tasks by submission order, for Shutdown to report.
+	inFlightMu sync.Mutex
+	inFlight   map[uint64]Task
+}
+
+// New creates a pool whose Submit blocks while every worker is busy.
+func New(numThreads int) *Pool {
+	return NewPriority(numThreads, 0)
+}
+
+// NewPriority creates a pool that queues up to queueSize tasks while every worker is
+// busy, so tasks implementing Prioritized can overtake bulk work. Submit blocks while
+// the queue is full.
+func NewPriority(numThreads, queueSize int) *Pool {
+	pool := newPool()
+	pool.queue = newTaskQueue(queueSize, max(numThreads, 1), pool.worker)
+	return pool
+}
+
+// NewRing creates a pool for high task rates, where a single locked queue would hold up
+// submitters and workers. Tasks are queued in lock-free ring buffers, one per worker,
+// holding queueSize tasks between them and at least two each. Workers take tasks from
+// their own ring and steal from the others when it is empty. Tasks are taken roughly in
+// submission order: Priority, Key and the Discipline are ignored.
+func NewRing(numThreads, queueSize int) *Pool {
+	pool := newPool()
+	pool.queue = newRingQueue(queueSize, max(numThreads, 1), pool.worker)
+	return pool
+}
+
+// newPool creates a pool without its queue, which the constructors add.
+func newPool() *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		ctx:      ctx,
+		cancel:   cancel,
+		onPanic:  logPanic,
+		onError:  logError,
+		inFlight: make(map[uint64]Task),
+	}
+}
+
+func (pool *Pool) worker() {
+	for _, fn := range pool.currentHooks().workerStart {
+		fn()
+	}
+	var state workerState
+	for {
+		queued, ok := pool.queue.pop(&state)
+		if !ok {
+			return
+		}
+		pool.limiter.wait(pool.context())
+		pool.run(queued)
+	}
+}
+
+// run runs one queued task and marks it done. A panic is recovered and reported, so the
+// worker carries on with the next task and the pool keeps its capacity.
+func (pool *Pool) run(queued queuedTask) {
+	task := queued.task
+	hooks := pool.currentHooks()
+	ctx := pool.context()
+	var err error
+	start := time.Now()
+	pool.counters.wait.observe(start.Sub(queued.queued))
+	pool.counters.running.Add(1)
+
+	pool.inFlightMu.Lock()
+	pool.inFlight[queued.seq] = task
+	pool.inFlightMu.Unlock()
+
+	defer func() {
+		pool.inFlightMu.Lock()
+		delete(pool.inFlight, queued.seq)
+		pool.inFlightMu.Unlock()
+
+		pool.counters.run.observe(time.Since(start))
+		pool.counters.running.Add(-1)
+		pool.counters.completed.Add(1)
+		defer pool.wg.Done()
+
+		value := recover()
+		hooks.endTask(ctx, submitted(task), err, value)
+		if err != nil {
+			pool.counters.failed.Add(1)
+			pool.reportError(task, err)
+		}
+		if value != nil {
+			pool.counters.failed.Add(1)
+			stack := debug.Stack()
+			pool.mu.Lock()
+			onPanic := pool.onPanic
+			pool.mu.Unlock()
+			onPanic(submitted(task), value, stack)
+		}
+	}()
+
+	ctx = hooks.startTask(ctx, submitted(task))
+	err = task.Run(ctx)
+}
+
+// OnPanic sets the function called when a task panics, instead of logging the panic.
+func (pool *Pool) OnPanic(fn PanicFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.onPanic = fn
+}
+
+// OnError sets the function called when a task returns an error, or a retried task
+// fails for good, instead of logging the error.
+func (pool *Pool) OnError(fn ErrorFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.onError = fn
+}
+
+func (pool *Pool) reportError(task any, err error) {
+	pool.mu.Lock()
+	onError := pool.onError
+	pool.mu.Unlock()
+	onError(submitted(task), err)
+}
+
+// adapter is implemented by the tasks the pool wraps around submitted ones. The queue
+// looks through them for Prioritized and Keyed.
+type adapter interface {
+	unwrap() any
+}
+
+// submitted returns the task as it was submitted, before the pool wrapped it.
+func submitted(task any) any {
+	for {
+		wrapped, ok := task.(adapter)
+		if !ok {
+			return task
+		}
+		task = wrapped.unwrap()
+	}
+}
+
+func logPanic(task any, value any, stack []byte) {
+	log.Printf("Error: task %T panicked: %v\n%s", task, value, stack)
+}
+
+func logError(task any, err error) {
+	log.Printf("Error: task %T failed: %v\n", task, err)
+}
+
+func (pool *Pool) Run() {
+	pool.RunContext(context.Background())
+}
+
+// RunContext starts the workers. Tasks are given a context that is done when ctx is or
+// when Cancel is called.
+func (pool *Pool) RunContext(ctx context.Context) {
+	pool.mu.Lock()
+	pool.cancel()
+	pool.ctx, pool.cancel = context.WithCancel(ctx)
+	pool.mu.Unlock()
+
+	pool.queue.start()
+}
+
+// Resize changes the number of workers. New workers start right away; when shrinking,
+// idle workers exit first and busy ones exit once their current task is done. Before
+// Run it only changes how many workers Run starts.
+func (pool *Pool) Resize(numThreads int) {
+	pool.queue.resize(max(numThreads, 1))
+}
+
+// SetIdleTimeout makes workers start only when a task finds none idle, up to the pool's
+// size, and exit after waiting timeout for a task, so an idle pool keeps no goroutines.
+// A timeout of 0, the default, keeps every worker running.
+func (pool *Pool) SetIdleTimeout(timeout time.Duration) {
+	pool.queue.setIdleTimeout(timeout)
+}
+
+// Pause stops workers taking queued tasks, e.g. while a dependency of the tasks is being
+// restarted. Running tasks finish, and Submit keeps queueing tasks until the queue is
+// full. Queued tasks wait for Resume, even once the pool is closed.
+func (pool *Pool) Pause() {
+	pool.queue.setPaused(true)
+}
+
+// Resume lets workers take queued tasks again after Pause.
+func (pool *Pool) Resume() {
+	pool.queue.setPaused(false)
+}
+
+// Paused reports whether the pool is paused.
+func (pool *Pool) Paused() bool {
+	return pool.queue.isPaused()
+}
+
+// Size returns the number of workers the pool is sized for.
+func (pool *Pool) Size() int {
+	return pool.queue.size()
+}
+
+// Workers returns the number of workers started, which is below Size while workers with
+// an idle timeout are not needed.
+func (pool *Pool) Workers() int {
+	return pool.queue.live()
+}
+
+// Queued returns the number of tasks waiting for a worker.
+func (pool *Pool) Queued() int {
+	return pool.queue.len()
+}
+
+func (pool *Pool) Wait() {
+	pool.wg.Wait()
+}
+
+// Cancel cancels the context of running and queued tasks, e.g. once a drain has taken
+// too long. The pool keeps running tasks.
+func (pool *Pool) Cancel() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.cancel()
+}
+
+// Close stops the pool taking tasks. Workers run the tasks already queued and exit.
+func (pool *Pool) Close() {
+	pool.queue.close()
+}
+
+// Shutdown stops the pool taking tasks and waits for the queued and running ones to
+// finish. If ctx is done first, it drops the tasks still queued, cancels the context of
+// the running ones and returns ctx's error with the tasks it gave up on, as submitted
+// and in submission order. Tasks that ignore their context may still be running when it
+// returns.
+func (pool *Pool) Shutdown(ctx context.Context) ([]any, error) {
+	pool.Close()
+
+	done := make(chan struct{})
+	go func() {
+		pool.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil, nil
+	case <-ctx.Done():
+	}
+
+	abandoned := pool.queue.drain()
+	for range abandoned {
+		pool.wg.Done()
+	}
+	pool.Cancel()
+
+	pool.inFlightMu.Lock()
+	for seq, task := range pool.inFlight {
+		abandoned = append(abandoned, queuedTask{task: task, seq: seq})
+	}
+	pool.inFlightMu.Unlock()
+
+	slices.SortFunc(abandoned, func(a, b queuedTask) int { return cmp.Compare(a.seq, b.seq) })
+	tasks := make([]any, len(abandoned))
+	for i, queued := range abandoned {
+		tasks[i] = submitted(queued.task)
+	}
+	return tasks, ctx.Err()
+}
+
+func (pool *Pool) Submit(task Task) {
+	if err := pool.SubmitContext(context.Background(), task); err != nil {
+		panic("pool: Submit on closed pool")
+	}
+}
+
+// SubmitContext is like Submit but gives up waiting for room once ctx is done, returning
+// ctx's error, so a caller feeding a saturated pool can still shut down. It returns
+// ErrClosed instead of panicking if the pool is closed.
+func (pool *Pool) SubmitContext(ctx context.Context, task Task) error {
+	pool.wg.Add(1)
+	if err := pool.queue.push(ctx, task); err != nil {
+		pool.wg.Done()
+		return err
+	}
+	return nil
+}
+
+// context returns the context tasks run with.
+func (pool *Pool) context() context.Context {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.ctx
+}
@@ -0,0 +1,363 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// taskFunc is a Task calling a function.
+type taskFunc func(ctx context.Context) error
+
+func (fn taskFunc) Run(ctx context.Context) error {
+	return fn(ctx)
+}
+
+// namedTask records its name when run, with the priority, key and deadline a test gives
+// it.
+type namedTask struct {
+	name     string
+	priority int
+	key      string
+	deadline time.Time
+	ran      *recorder
+}
+
+func (task *namedTask) Run(ctx context.Context) error {
+	task.ran.add(task.name)
+	return nil
+}
+
+func (task *namedTask) Priority() int {
+	return task.priority
+}
+
+func (task *namedTask) Key() string {
+	return task.key
+}
+
+func (task *namedTask) Deadline() (time.Time, bool) {
+	return task.deadline, !task.deadline.IsZero()
+}
+
+// recorder collects the names of the tasks run, in the order they ran.
+type recorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *recorder) add(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = append(r.names, name)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.names...)
+}
+
+func TestRunsEveryTask(t *testing.T) {
+	for name, pool := range map[string]*Pool{
+		"blocking": New(4),
+		"queued":   NewPriority(4, 16),
+		"ring":     NewRing(4, 16),
+	} {
+		t.Run(name, func(t *testing.T) {
+			pool.Run()
+			defer pool.Close()
+
+			var ran atomic.Int64
+			for range 1000 {
+				pool.Submit(taskFunc(func(ctx context.Context) error {
+					ran.Add(1)
+					return nil
+				}))
+			}
+			pool.Wait()
+			if got := ran.Load(); got != 1000 {
+				t.Errorf("ran %d tasks, want 1000", got)
+			}
+			if stats := pool.Stats(); stats.Completed != 1000 || stats.Failed != 0 {
+				t.Errorf("stats %+v, want 1000 completed and none failed", stats)
+			}
+		})
+	}
+}
+
+func TestPanicKeepsWorker(t *testing.T) {
+	pool := New(1)
+	panicked := make(chan any, 1)
+	pool.OnPanic(func(task any, value any, stack []byte) {
+		panicked <- value
+	})
+	pool.Run()
+	defer pool.Close()
+
+	pool.Submit(taskFunc(func(ctx context.Context) error { panic("boom") }))
+	ran := make(chan struct{})
+	pool.Submit(taskFunc(func(ctx context.Context) error {
+		close(ran)
+		return nil
+	}))
+	pool.Wait()
+	if value := <-panicked; value != "boom" {
+		t.Errorf("panicked with %v, want boom", value)
+	}
+	select {
+	case <-ran:
+	default:
+		t.Error("the worker did not run the task after the panic")
+	}
+	if failed := pool.Stats().Failed; failed != 1 {
+		t.Errorf("%d failed, want 1", failed)
+	}
+}
+
+func TestFutures(t *testing.T) {
+	pool := New(2)
+	pool.Run()
+	defer pool.Close()
+
+	errBoom := errors.New("boom")
+	futures := []*Future{
+		pool.SubmitWithResult(ResultFunc(func(ctx context.Context) (any, error) { return 1, nil })),
+		pool.SubmitWithResult(ResultFunc(func(ctx context.Context) (any, error) { return nil, errBoom })),
+		pool.SubmitWithResult(ResultFunc(func(ctx context.Context) (any, error) { panic("boom") })),
+		pool.SubmitWithResult(ResultFunc(func(ctx context.Context) (any, error) { return 4, nil })),
+	}
+	values, err := WaitAll(futures)
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got %v, want it to include %v", err, errBoom)
+	}
+	if futures[2].Err() == nil {
+		t.Error("a panicking task reported no error")
+	}
+	if !slices.Equal(values, []any{1, nil, nil, 4}) {
+		t.Errorf("got values %v, want [1 <nil> <nil> 4]", values)
+	}
+}
+
+func TestErrorReported(t *testing.T) {
+	pool := New(1)
+	errBoom := errors.New("boom")
+	reported := make(chan error, 1)
+	pool.OnError(func(task any, err error) {
+		reported <- err
+	})
+	pool.Run()
+	defer pool.Close()
+
+	pool.Submit(taskFunc(func(ctx context.Context) error { return errBoom }))
+	pool.Wait()
+	if err := <-reported; !errors.Is(err, errBoom) {
+		t.Errorf("reported %v, want %v", err, errBoom)
+	}
+}
+
+func TestCancelStopsTasks(t *testing.T) {
+	pool := New(1)
+	reported := make(chan error, 1)
+	pool.OnError(func(task any, err error) {
+		reported <- err
+	})
+	pool.RunContext(context.Background())
+	defer pool.Close()
+
+	started := make(chan struct{})
+	pool.Submit(taskFunc(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	<-started
+	pool.Cancel()
+	pool.Wait()
+	if err := <-reported; !errors.Is(err, context.Canceled) {
+		t.Errorf("task's context ended with %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestResize(t *testing.T) {
+	pool := New(1)
+	pool.Run()
+	defer pool.Close()
+
+	release := make(chan struct{})
+	var running, peak atomic.Int64
+	task := taskFunc(func(ctx context.Context) error {
+		n := running.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		return nil
+	})
+	pool.Resize(4)
+	if size := pool.Size(); size != 4 {
+		t.Errorf("size %d after resizing, want 4", size)
+	}
+	for range 4 {
+		pool.Submit(task)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for peak.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	pool.Wait()
+	if got := peak.Load(); got != 4 {
+		t.Errorf("%d tasks ran at once, want 4", got)
+	}
+}
+
+func TestShutdownAbandonsTasks(t *testing.T) {
+	pool := NewPriority(1, 4)
+	pool.Run()
+
+	started := make(chan struct{})
+	blocking := taskFunc(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	pool.OnError(func(task any, err error) {})
+	pool.Submit(blocking)
+	<-started
+	queued := &namedTask{name: "queued", ran: &recorder{}}
+	pool.Submit(queued)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	abandoned, err := pool.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(abandoned) != 2 {
+		t.Fatalf("abandoned %d tasks, want 2", len(abandoned))
+	}
+	if _, ok := abandoned[0].(taskFunc); !ok || abandoned[1] != queued {
+		t.Errorf("abandoned %v, want the running task then the queued one", abandoned)
+	}
+	if ran := queued.ran.get(); len(ran) != 0 {
+		t.Error("ran a task dropped by Shutdown")
+	}
+}
+
+func TestIdleWorkersExit(t *testing.T) {
+	pool := NewPriority(4, 16)
+	pool.SetIdleTimeout(20 * time.Millisecond)
+	pool.Run()
+	defer pool.Close()
+	if workers := pool.Workers(); workers != 0 {
+		t.Errorf("%d workers started before any task, want 0", workers)
+	}
+
+	release := make(chan struct{})
+	for range 4 {
+		pool.Submit(taskFunc(func(ctx context.Context) error {
+			<-release
+			return nil
+		}))
+	}
+	if workers := pool.Workers(); workers != 4 {
+		t.Errorf("%d workers started for 4 blocked tasks, want 4", workers)
+	}
+	close(release)
+	pool.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Workers() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if workers := pool.Workers(); workers != 0 {
+		t.Errorf("%d workers left after the idle timeout, want 0", workers)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var limiter rateLimiter
+	if delay := limiter.reserve(); delay != 0 {
+		t.Errorf("waited %v without a limit", delay)
+	}
+
+	limiter.set(10, 2)
+	for i := range 2 {
+		if delay := limiter.reserve(); delay != 0 {
+			t.Errorf("task %d of the burst waited %v", i+1, delay)
+		}
+	}
+	// Each token past the burst is refilled a tenth of a second after the one before
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		if delay := limiter.reserve(); delay < want-10*time.Millisecond || delay > want {
+			t.Errorf("task %d past the burst waited %v, want about %v", i+1, delay, want)
+		}
+	}
+}
+
+func TestSubmitContextGivesUp(t *testing.T) {
+	pool := NewPriority(1, 1)
+	pool.Run()
+	defer pool.Close()
+
+	// Keep the only worker busy and fill the queue
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	pool.Submit(taskFunc(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	<-started
+	idle := taskFunc(func(ctx context.Context) error { return nil })
+	if err := pool.SubmitContext(context.Background(), idle); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.SubmitContext(ctx, idle); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("submitting to a full queue returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSubmitAfterClose(t *testing.T) {
+	pool := New(1)
+	pool.Run()
+	pool.Close()
+	if err := pool.SubmitContext(context.Background(), taskFunc(func(ctx context.Context) error { return nil })); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestPauseHoldsTasks(t *testing.T) {
+	pool := NewPriority(2, 4)
+	pool.Pause()
+	pool.Run()
+	defer pool.Close()
+
+	ran := &recorder{}
+	for _, name := range []string{"a", "b"} {
+		pool.Submit(&namedTask{name: name, ran: ran})
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := ran.get(); len(got) != 0 {
+		t.Errorf("ran %v while paused", got)
+	}
+	if queued := pool.Queued(); queued != 2 {
+		t.Errorf("%d tasks queued while paused, want 2", queued)
+	}
+	pool.Resume()
+	pool.Wait()
+	if got := ran.get(); len(got) != 2 {
+		t.Errorf("ran %v after resuming, want both tasks", got)
+	}
+}
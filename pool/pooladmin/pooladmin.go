@@ -0,0 +1,75 @@
+// Package pooladmin serves an HTTP admin endpoint for the pools registered with
+// pool.Register: their status, and pausing and resuming them.
+package pooladmin
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// Status is a registered pool's state as reported by the handler.
+type Status struct {
+	Workers   int   `json:"workers"`
+	Live      int   `json:"live"`
+	Paused    bool  `json:"paused"`
+	Queued    int   `json:"queued"`
+	Running   int   `json:"running"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+}
+
+// Handler serves:
+//
+//	GET  /pools         the Status of every registered pool by name
+//	POST /pools/pause   pause the pool named by the pool parameter, or all pools
+//	POST /pools/resume  resume the pool named by the pool parameter, or all pools
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pools", func(w http.ResponseWriter, r *http.Request) {
+		statuses := make(map[string]Status)
+		for name, p := range pool.Registered() {
+			stats := p.Stats()
+			statuses[name] = Status{
+				Workers:   stats.Workers,
+				Live:      stats.Live,
+				Paused:    stats.Paused,
+				Queued:    stats.Queued,
+				Running:   stats.Running,
+				Completed: stats.Completed,
+				Failed:    stats.Failed,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+	mux.HandleFunc("POST /pools/pause", func(w http.ResponseWriter, r *http.Request) {
+		apply(w, r, "Paused", (*pool.Pool).Pause)
+	})
+	mux.HandleFunc("POST /pools/resume", func(w http.ResponseWriter, r *http.Request) {
+		apply(w, r, "Resumed", (*pool.Pool).Resume)
+	})
+	return mux
+}
+
+// apply calls fn on the pool named by the request's pool parameter, or on every
+// registered pool if it has none, and reports the pools it was applied to.
+func apply(w http.ResponseWriter, r *http.Request, action string, fn func(*pool.Pool)) {
+	pools := pool.Registered()
+	if name := r.FormValue("pool"); name != "" {
+		p, ok := pools[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("no pool named %q", name), http.StatusNotFound)
+			return
+		}
+		pools = map[string]*pool.Pool{name: p}
+	}
+	for _, name := range slices.Sorted(maps.Keys(pools)) {
+		fn(pools[name])
+		fmt.Fprintf(w, "%s %s\n", action, name)
+	}
+}
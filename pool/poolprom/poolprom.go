@@ -0,0 +1,104 @@
+// Package poolprom exports worker pool Stats as Prometheus metrics. It lives apart from
+// pool so that programs without Prometheus don't depend on it.
+package poolprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// Collector reports the Stats of one or more pools on every scrape.
+type Collector struct {
+	// pools returns the pools to report by name.
+	pools func() map[string]*pool.Pool
+
+	workers   *prometheus.Desc
+	live      *prometheus.Desc
+	paused    *prometheus.Desc
+	queued    *prometheus.Desc
+	running   *prometheus.Desc
+	completed *prometheus.Desc
+	failed    *prometheus.Desc
+	wait      *prometheus.Desc
+	run       *prometheus.Desc
+}
+
+// NewCollector creates a collector for p. name is the pool label, telling pools of the
+// same program apart.
+func NewCollector(name string, p *pool.Pool) *Collector {
+	return newCollector(func() map[string]*pool.Pool {
+		return map[string]*pool.Pool{name: p}
+	})
+}
+
+// NewRegisteredCollector creates a collector for the pools registered with pool.Register
+// at the time of each scrape, labelled with their registered names.
+func NewRegisteredCollector() *Collector {
+	return newCollector(pool.Registered)
+}
+
+func newCollector(pools func() map[string]*pool.Pool) *Collector {
+	labels := []string{"pool"}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("pool_"+metric, help, labels, nil)
+	}
+	return &Collector{
+		pools:     pools,
+		workers:   desc("workers", "Number of workers the pool is sized for."),
+		live:      desc("live_workers", "Number of workers started."),
+		paused:    desc("paused", "1 while the pool is paused, else 0."),
+		queued:    desc("queued_tasks", "Tasks waiting for a worker."),
+		running:   desc("running_tasks", "Tasks being run."),
+		completed: desc("tasks_completed_total", "Tasks finished, including failed ones."),
+		failed:    desc("tasks_failed_total", "Tasks that panicked or returned an error."),
+		wait:      desc("task_wait_seconds", "How long tasks waited for a worker."),
+		run:       desc("task_run_seconds", "How long tasks ran."),
+	}
+}
+
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.workers
+	ch <- collector.live
+	ch <- collector.paused
+	ch <- collector.queued
+	ch <- collector.running
+	ch <- collector.completed
+	ch <- collector.failed
+	ch <- collector.wait
+	ch <- collector.run
+}
+
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	for name, p := range collector.pools() {
+		stats := p.Stats()
+		ch <- prometheus.MustNewConstMetric(collector.workers, prometheus.GaugeValue, float64(stats.Workers), name)
+		ch <- prometheus.MustNewConstMetric(collector.live, prometheus.GaugeValue, float64(stats.Live), name)
+		ch <- prometheus.MustNewConstMetric(collector.paused, prometheus.GaugeValue, paused(stats), name)
+		ch <- prometheus.MustNewConstMetric(collector.queued, prometheus.GaugeValue, float64(stats.Queued), name)
+		ch <- prometheus.MustNewConstMetric(collector.running, prometheus.GaugeValue, float64(stats.Running), name)
+		ch <- prometheus.MustNewConstMetric(collector.completed, prometheus.CounterValue, float64(stats.Completed), name)
+		ch <- prometheus.MustNewConstMetric(collector.failed, prometheus.CounterValue, float64(stats.Failed), name)
+		ch <- histogram(collector.wait, stats.Wait, name)
+		ch <- histogram(collector.run, stats.Run, name)
+	}
+}
+
+// paused returns the paused gauge's value.
+func paused(stats pool.Stats) float64 {
+	if stats.Paused {
+		return 1
+	}
+	return 0
+}
+
+// histogram converts a pool histogram to Prometheus' cumulative buckets.
+func histogram(desc *prometheus.Desc, h pool.Histogram, name string) prometheus.Metric {
+	buckets := make(map[float64]uint64)
+	var cumulative uint64
+	for i, bound := range pool.LatencyBuckets() {
+		cumulative += uint64(h.Counts[i])
+		buckets[bound.Seconds()] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, uint64(h.Count), h.Sum.Seconds(), buckets, name)
+}
@@ -0,0 +1,384 @@
+package pool
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Prioritized is implemented by tasks that should run before others. Tasks with a higher
+// Priority are taken first; tasks without one have priority 0. Tasks of equal priority
+// are taken in the order of the pool's Discipline, by default the order they were
+// submitted in, taking turns by Key.
+type Prioritized interface {
+	Priority() int
+}
+
+// Keyed is implemented by tasks that belong to a client, such as connections keyed by
+// remote IP. Queued tasks of equal priority are taken round-robin across keys, so one
+// client submitting many tasks can't hold up the others. Tasks without a Key share the
+// empty key.
+type Keyed interface {
+	Key() string
+}
+
+// queuedTask is a task waiting in the queue.
+type queuedTask struct {
+	task     Task
+	priority int
+	key      string
+	// round is the task's turn among the tasks of its key; each key gets one task per
+	// round.
+	round  uint64
+	seq    uint64
+	queued time.Time
+}
+
+// waiting describes the task to a Discipline.
+func (queued queuedTask) waiting() Waiting {
+	return Waiting{Task: submitted(queued.task), Key: queued.key, Round: queued.round, Seq: queued.seq, Queued: queued.queued}
+}
+
+// taskHeap orders queued tasks by priority, then by its discipline.
+type taskHeap struct {
+	items      []queuedTask
+	discipline Discipline
+}
+
+func (h *taskHeap) Len() int { return len(h.items) }
+
+func (h *taskHeap) Less(i, j int) bool {
+	if h.items[i].priority != h.items[j].priority {
+		return h.items[i].priority > h.items[j].priority
+	}
+	return h.discipline.Less(h.items[i].waiting(), h.items[j].waiting())
+}
+
+func (h *taskHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *taskHeap) Push(x any) { h.items = append(h.items, x.(queuedTask)) }
+
+func (h *taskHeap) Pop() any {
+	old := h.items
+	item := old[len(old)-1]
+	old[len(old)-1] = queuedTask{}
+	h.items = old[:len(old)-1]
+	return item
+}
+
+// dispatcher hands tasks from Submit to the workers and starts and stops them: a
+// taskQueue, or a ringQueue for NewRing.
+type dispatcher interface {
+	start()
+	resize(target int)
+	setIdleTimeout(timeout time.Duration)
+	push(ctx context.Context, task Task) error
+	pop(worker *workerState) (queuedTask, bool)
+	setDiscipline(discipline Discipline)
+	setPaused(paused bool)
+	isPaused() bool
+	close()
+	drain() []queuedTask
+	len() int
+	live() int
+	size() int
+}
+
+// workerState is what the queue keeps of a worker between its calls to pop.
+type workerState struct {
+	// started is set once the worker has asked for a task.
+	started bool
+	// home is the ring a ringQueue worker takes tasks from before stealing.
+	home int
+}
+
+// taskQueue hands tasks from Submit to the workers and keeps count of them. It holds at
+// most capacity tasks beyond those idle workers are about to take, so with a capacity of
+// 0 Submit blocks while every worker is busy.
+type taskQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	tasks    taskHeap
+	capacity int
+	seq      uint64
+	closed   bool
+	// paused holds queued tasks back from the workers.
+	paused bool
+	// round is the round of the task taken last; rounds holds the round of the last
+	// queued task of each key with tasks queued.
+	round  uint64
+	rounds map[string]uint64
+
+	// spawn runs a worker goroutine until pop tells it to exit.
+	spawn   func()
+	running bool
+	// target is the number of workers the pool is sized for; workers counts the started
+	// ones, of which starting have yet to ask for a task and idle are waiting for one.
+	target   int
+	workers  int
+	starting int
+	idle     int
+	// idleTimeout, when set, stops workers that have waited that long for a task and
+	// starts workers only when tasks need them.
+	idleTimeout time.Duration
+}
+
+func newTaskQueue(capacity, target int, spawn func()) *taskQueue {
+	queue := &taskQueue{
+		tasks:    taskHeap{discipline: FIFO},
+		capacity: max(capacity, 0),
+		target:   target,
+		spawn:    spawn,
+		rounds:   make(map[string]uint64),
+	}
+	queue.notEmpty = sync.NewCond(&queue.mu)
+	queue.notFull = sync.NewCond(&queue.mu)
+	return queue
+}
+
+// start lets the queue start workers, starting all of them unless they start on demand.
+func (queue *taskQueue) start() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.running = true
+	if queue.idleTimeout == 0 {
+		for queue.workers < queue.target {
+			queue.startWorker()
+		}
+	}
+}
+
+// startWorker starts one more worker. The caller holds mu.
+func (queue *taskQueue) startWorker() {
+	queue.workers++
+	queue.starting++
+	go queue.spawn()
+}
+
+// canStart reports whether another worker may be started. The caller holds mu.
+func (queue *taskQueue) canStart() bool {
+	return queue.running && queue.workers < queue.target
+}
+
+// resize changes the number of workers. Extra workers exit as soon as they are idle.
+func (queue *taskQueue) resize(target int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.target = target
+	if queue.running && queue.idleTimeout == 0 {
+		for queue.workers < queue.target {
+			queue.startWorker()
+		}
+	}
+	for queue.tasks.Len() > queue.idle+queue.starting && queue.canStart() {
+		queue.startWorker()
+	}
+	queue.notEmpty.Broadcast()
+}
+
+// setIdleTimeout changes how long workers wait for a task before exiting, 0 for never.
+func (queue *taskQueue) setIdleTimeout(timeout time.Duration) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.idleTimeout = max(timeout, 0)
+	// Wake idle workers so they start their timers, or start the missing workers when
+	// the timeout is turned off
+	if queue.idleTimeout == 0 && queue.running {
+		for queue.workers < queue.target {
+			queue.startWorker()
+		}
+	}
+	queue.notEmpty.Broadcast()
+}
+
+// push queues task, waiting for room until ctx is done. It returns ErrClosed if the queue
+// is closed.
+func (queue *taskQueue) push(ctx context.Context, task Task) error {
+	priority, key := 0, ""
+	if prioritized, ok := submitted(task).(Prioritized); ok {
+		priority = prioritized.Priority()
+	}
+	if keyed, ok := submitted(task).(Keyed); ok {
+		key = keyed.Key()
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			queue.mu.Lock()
+			defer queue.mu.Unlock()
+			queue.notFull.Broadcast()
+		})
+		defer stop()
+	}
+
+	for {
+		if queue.closed {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if queue.tasks.Len() < queue.capacity+queue.idle+queue.starting {
+			break
+		}
+		if queue.canStart() {
+			queue.startWorker()
+			continue
+		}
+		queue.notFull.Wait()
+	}
+	// A key with no tasks queued joins at the current round
+	round := max(queue.round, queue.rounds[key]) + 1
+	queue.rounds[key] = round
+	queue.seq++
+	heap.Push(&queue.tasks, queuedTask{task: task, priority: priority, key: key, round: round, seq: queue.seq, queued: time.Now()})
+	// Start a worker if every one is busy rather than leave the task queued
+	if queue.tasks.Len() > queue.idle+queue.starting && queue.canStart() {
+		queue.startWorker()
+	}
+	queue.notEmpty.Signal()
+	return nil
+}
+
+// pop takes the highest priority task, waiting for one. It reports false when the worker
+// should exit: the pool shrank, the worker was idle for the idle timeout, or the queue is
+// closed and drained.
+func (queue *taskQueue) pop(worker *workerState) (queuedTask, bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if !worker.started {
+		worker.started = true
+		queue.starting--
+	}
+	queue.idle++
+	defer func() { queue.idle-- }()
+	// An idle worker makes room for one more task
+	queue.notFull.Signal()
+
+	idleSince := time.Now()
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if queue.workers > queue.target {
+			queue.workers--
+			return queuedTask{}, false
+		}
+		if queue.tasks.Len() > 0 && !queue.paused {
+			queued := heap.Pop(&queue.tasks).(queuedTask)
+			queue.round = queued.round
+			if queue.rounds[queued.key] == queued.round {
+				delete(queue.rounds, queued.key)
+			}
+			return queued, true
+		}
+		if queue.closed && queue.tasks.Len() == 0 {
+			queue.workers--
+			return queuedTask{}, false
+		}
+		if queue.idleTimeout > 0 {
+			idle := time.Since(idleSince)
+			if idle >= queue.idleTimeout {
+				queue.workers--
+				return queuedTask{}, false
+			}
+			if timer == nil {
+				timer = time.AfterFunc(queue.idleTimeout-idle, func() {
+					queue.mu.Lock()
+					defer queue.mu.Unlock()
+					queue.notEmpty.Broadcast()
+				})
+			}
+		}
+		queue.notEmpty.Wait()
+	}
+}
+
+// live returns the number of started workers.
+func (queue *taskQueue) live() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.workers
+}
+
+// size returns the number of workers the pool is sized for.
+func (queue *taskQueue) size() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.target
+}
+
+// setDiscipline changes the order of the queue, reordering the tasks already queued.
+func (queue *taskQueue) setDiscipline(discipline Discipline) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.tasks.discipline = discipline
+	heap.Init(&queue.tasks)
+}
+
+// setPaused pauses or resumes handing out tasks. On resuming, workers are started for
+// the queued tasks if idle ones were stopped meanwhile.
+func (queue *taskQueue) setPaused(paused bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.paused = paused
+	if paused {
+		return
+	}
+	for queue.tasks.Len() > queue.idle+queue.starting && queue.canStart() {
+		queue.startWorker()
+	}
+	queue.notEmpty.Broadcast()
+}
+
+// isPaused reports whether handing out tasks is paused.
+func (queue *taskQueue) isPaused() bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.paused
+}
+
+// close wakes every worker so they drain the queue and exit, and fails later pushes.
+func (queue *taskQueue) close() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.closed = true
+	queue.notEmpty.Broadcast()
+	queue.notFull.Broadcast()
+}
+
+// drain removes and returns the queued tasks.
+func (queue *taskQueue) drain() []queuedTask {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	drained := queue.tasks.items
+	queue.tasks.items = nil
+	clear(queue.rounds)
+	queue.notFull.Broadcast()
+	return drained
+}
+
+// len returns the number of queued tasks.
+func (queue *taskQueue) len() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.tasks.Len()
+}
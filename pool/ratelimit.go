@@ -0,0 +1,72 @@
+package pool
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding up to burst tokens, refilled at rate per second.
+// Callers take a token before starting a task; the bucket may go into debt, and each
+// caller waits until its token would have been refilled, so waiting workers start in
+// turn at the rate.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// set changes the rate and burst, with a rate of 0 or less removing the limit.
+func (limiter *rateLimiter) set(rate float64, burst int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.rate = rate
+	limiter.burst = float64(max(burst, 1))
+	limiter.tokens = limiter.burst
+	limiter.last = time.Now()
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (limiter *rateLimiter) reserve() time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	limiter.tokens = math.Min(limiter.burst, limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	limiter.last = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+// wait takes a token, waiting for it unless ctx is done first.
+func (limiter *rateLimiter) wait(ctx context.Context) {
+	delay := limiter.reserve()
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// SetRateLimit makes workers start no more than perSecond tasks a second, however fast
+// they are submitted, e.g. when tasks call a rate-limited backend. Up to burst tasks may
+// start at once after a quiet spell. Tasks waiting for their turn keep their worker busy,
+// so Submit blocks as usual once the workers and queue are full. A rate of 0 removes the
+// limit. Once the pool is cancelled, tasks start without waiting for their turn.
+func (pool *Pool) SetRateLimit(perSecond float64, burst int) {
+	pool.limiter.set(perSecond, burst)
+}
@@ -0,0 +1,46 @@
+package pool
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// registry holds the pools registered by name, for metrics and admin endpoints to find.
+var registry = struct {
+	mu    sync.Mutex
+	pools map[string]*Pool
+}{pools: make(map[string]*Pool)}
+
+// Register makes p known as name. It fails if another pool already has the name.
+func Register(name string, p *Pool) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.pools[name]; ok {
+		return fmt.Errorf("pool %q already registered", name)
+	}
+	registry.pools[name] = p
+	return nil
+}
+
+// Unregister forgets the pool registered as name, if any.
+func Unregister(name string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.pools, name)
+}
+
+// Lookup returns the pool registered as name, or nil.
+func Lookup(name string) *Pool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.pools[name]
+}
+
+// Registered returns a copy of the registered pools by name.
+func Registered() map[string]*Pool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return maps.Clone(registry.pools)
+}
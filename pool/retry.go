@@ -0,0 +1,125 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how a task that returns an error is retried.
+type RetryPolicy struct {
+	// MaxAttempts is how many times the task runs at most, including the first.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry; each further retry waits
+	// Multiplier times longer (2 if unset), up to MaxBackoff if set.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomizes each backoff by up to this fraction in either direction.
+	Jitter float64
+	// Retryable reports whether an error may be retried; nil retries every error.
+	Retryable func(err error) bool
+}
+
+// backoff returns the delay before the given retry (1 for the first retry).
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay := float64(policy.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+	}
+	if policy.MaxBackoff > 0 {
+		delay = min(delay, float64(policy.MaxBackoff))
+	}
+	delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// retryable reports whether a task that failed with err on the given attempt runs again.
+func (policy RetryPolicy) retryable(attempt int, err error) bool {
+	if attempt >= policy.MaxAttempts {
+		return false
+	}
+	return policy.Retryable == nil || policy.Retryable(err)
+}
+
+// SubmitRetry submits a task that is queued again after a backoff when it fails, until
+// it succeeds, returns an error the policy doesn't retry or runs out of attempts. The
+// last error is passed to the OnError function. A task waiting to be retried counts as
+// not yet done for Wait; it gives up if the pool is cancelled or closed meanwhile.
+func (pool *Pool) SubmitRetry(task Task, policy RetryPolicy) {
+	pool.Submit(pool.WithRetry(task, policy))
+}
+
+// WithRetry returns a Task that runs task as SubmitRetry does, for submitting with
+// SubmitContext. Once it has succeeded it may be submitted again, starting over.
+func (pool *Pool) WithRetry(task Task, policy RetryPolicy) Task {
+	return &retryTask{task: task, policy: policy, pool: pool}
+}
+
+// retryTask runs a task once per attempt, returning its error only once it gives up.
+type retryTask struct {
+	task    Task
+	policy  RetryPolicy
+	pool    *Pool
+	attempt int
+}
+
+// retryKey is the context key of the retryTask running with a context.
+type retryKey struct{}
+
+func (task *retryTask) Run(ctx context.Context) error {
+	task.attempt++
+	err := task.task.Run(context.WithValue(ctx, retryKey{}, task))
+	if err == nil {
+		task.attempt = 0
+		return nil
+	}
+	if ctx.Err() != nil || !task.policy.retryable(task.attempt, err) {
+		return err
+	}
+	task.pool.counters.failed.Add(1)
+	// Keep Wait waiting until the retry is queued or given up
+	task.pool.wg.Add(1)
+	go task.retry(ctx, err)
+	return nil
+}
+
+// ResetRetries tells a task submitted with retries, given ctx, that it made progress, so
+// its current attempt counts as its first: a task serving a stream of work, such as the
+// messages of a connection, then gives up only after MaxAttempts failures in a row. It
+// does nothing for other tasks.
+func ResetRetries(ctx context.Context) {
+	if task, ok := ctx.Value(retryKey{}).(*retryTask); ok {
+		task.attempt = 1
+	}
+}
+
+// retry queues the task again once its backoff has passed.
+func (task *retryTask) retry(ctx context.Context, err error) {
+	timer := time.NewTimer(task.policy.backoff(task.attempt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		pushErr := task.pool.queue.push(ctx, task)
+		if pushErr == nil {
+			return
+		}
+		if errors.Is(pushErr, ErrClosed) {
+			err = fmt.Errorf("pool closed before retry: %w", err)
+		}
+	case <-ctx.Done():
+	}
+	task.pool.reportError(task.task, err)
+	task.pool.wg.Done()
+}
+
+func (task *retryTask) unwrap() any {
+	return task.task
+}
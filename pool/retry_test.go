@@ -0,0 +1,144 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetryUntilSuccess(t *testing.T) {
+	pool := New(2)
+	pool.Run()
+	defer pool.Close()
+
+	var attempts atomic.Int64
+	pool.SubmitRetry(taskFunc(func(ctx context.Context) error {
+		if attempts.Add(1) < 3 {
+			return errTransient
+		}
+		return nil
+	}), RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})
+	pool.Wait()
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("ran %d times, want 3", got)
+	}
+	if failed := pool.Stats().Failed; failed != 2 {
+		t.Errorf("%d failed attempts counted, want 2", failed)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	tests := []struct {
+		name     string
+		err      error
+		policy   RetryPolicy
+		attempts int64
+	}{
+		{"out of attempts", errTransient, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, 3},
+		{"not retryable", errPermanent, RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Retryable:      func(err error) bool { return errors.Is(err, errTransient) },
+		}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := New(1)
+			reported := make(chan error, 1)
+			pool.OnError(func(task any, err error) { reported <- err })
+			pool.Run()
+			defer pool.Close()
+
+			var attempts atomic.Int64
+			pool.SubmitRetry(taskFunc(func(ctx context.Context) error {
+				attempts.Add(1)
+				return test.err
+			}), test.policy)
+			pool.Wait()
+			if got := attempts.Load(); got != test.attempts {
+				t.Errorf("ran %d times, want %d", got, test.attempts)
+			}
+			if err := <-reported; !errors.Is(err, test.err) {
+				t.Errorf("reported %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRetryGivesUpOnClose(t *testing.T) {
+	pool := New(1)
+	reported := make(chan error, 1)
+	pool.OnError(func(task any, err error) { reported <- err })
+	pool.Run()
+
+	var attempts atomic.Int64
+	pool.SubmitRetry(taskFunc(func(ctx context.Context) error {
+		attempts.Add(1)
+		return errTransient
+	}), RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond})
+	pool.Close()
+	pool.Wait()
+	if err := <-reported; !errors.Is(err, errTransient) {
+		t.Errorf("reported %v, want %v", err, errTransient)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("ran %d times after Close, want 1", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+	} {
+		if got := policy.backoff(retry); got != want {
+			t.Errorf("backoff before retry %d: got %v, want %v", retry, got, want)
+		}
+	}
+
+	policy.Multiplier = 3
+	if got, want := policy.backoff(3), 900*time.Millisecond; got != want {
+		t.Errorf("backoff with multiplier 3: got %v, want %v", got, want)
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff with jitter 0.5: got %v, want 50ms to 150ms", got)
+		}
+	}
+}
+
+// A task that resets its retries after making progress only gives up after MaxAttempts
+// failures in a row.
+func TestResetRetries(t *testing.T) {
+	pool := New(1)
+	reported := make(chan error, 1)
+	pool.OnError(func(task any, err error) { reported <- err })
+	pool.Run()
+	defer pool.Close()
+
+	// The second and fourth attempts make progress before failing
+	var attempts atomic.Int64
+	pool.SubmitRetry(taskFunc(func(ctx context.Context) error {
+		if n := attempts.Add(1); n == 2 || n == 4 {
+			ResetRetries(ctx)
+		}
+		return errTransient
+	}), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	pool.Wait()
+	if got := attempts.Load(); got != 6 {
+		t.Errorf("ran %d times, want 6", got)
+	}
+	if err := <-reported; !errors.Is(err, errTransient) {
+		t.Errorf("reported %v, want %v", err, errTransient)
+	}
+}
@@ -0,0 +1,511 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheLine pads the ring's positions apart, so producers and consumers don't contend
+// for one cache line.
+const cacheLine = 64
+
+// ring is a bounded multi-producer, multi-consumer queue. Each slot carries a sequence
+// number telling whether it is free for the push at its position or holds the task for
+// the pop at its position, so pushes and pops claim slots with one compare-and-swap and
+// never take a lock.
+type ring struct {
+	_    [cacheLine]byte
+	head atomic.Uint64
+	_    [cacheLine - 8]byte
+	tail atomic.Uint64
+	_    [cacheLine - 8]byte
+
+	mask  uint64
+	slots []ringSlot
+}
+
+type ringSlot struct {
+	seq    atomic.Uint64
+	queued queuedTask
+}
+
+// newRing creates a ring holding size tasks, rounded up to a power of two. It holds at
+// least two, as with one slot a push can't tell a taken task's slot from a free one.
+func newRing(size int) *ring {
+	size = 1 << bits.Len(uint(max(size, 2)-1))
+	r := &ring{mask: uint64(size - 1), slots: make([]ringSlot, size)}
+	for i := range r.slots {
+		r.slots[i].seq.Store(uint64(i))
+	}
+	return r
+}
+
+// push adds queued, reporting false if the ring is full.
+func (r *ring) push(queued queuedTask) bool {
+	pos := r.tail.Load()
+	for {
+		slot := &r.slots[pos&r.mask]
+		switch diff := int64(slot.seq.Load() - pos); {
+		case diff == 0:
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				slot.queued = queued
+				slot.seq.Store(pos + 1)
+				return true
+			}
+			pos = r.tail.Load()
+		case diff < 0:
+			// The slot still holds the task pushed a lap ago
+			return false
+		default:
+			pos = r.tail.Load()
+		}
+	}
+}
+
+// pop takes the oldest task, reporting false if the ring is empty.
+func (r *ring) pop() (queuedTask, bool) {
+	pos := r.head.Load()
+	for {
+		slot := &r.slots[pos&r.mask]
+		switch diff := int64(slot.seq.Load() - (pos + 1)); {
+		case diff == 0:
+			if r.head.CompareAndSwap(pos, pos+1) {
+				queued := slot.queued
+				slot.queued = queuedTask{}
+				slot.seq.Store(pos + r.mask + 1)
+				return queued, true
+			}
+			pos = r.head.Load()
+		case diff < 0:
+			// The slot's task hasn't been pushed yet
+			return queuedTask{}, false
+		default:
+			pos = r.head.Load()
+		}
+	}
+}
+
+// full reports whether the ring has no free slot.
+func (r *ring) full() bool {
+	pos := r.tail.Load()
+	return int64(r.slots[pos&r.mask].seq.Load()-pos) < 0
+}
+
+// ringQueue hands tasks to the workers through one ring per worker. Submit spreads tasks
+// across the rings and each worker takes from its own ring first, stealing from the
+// others when it is empty. Pushing and taking tasks only touches atomics; the lock is
+// taken only to park a worker with nothing to do, to wake one, or to wait for room.
+type ringQueue struct {
+	rings []*ring
+	// next picks the ring for the next push; homes the home ring of the next worker.
+	next  atomic.Uint64
+	homes atomic.Uint64
+	seq   atomic.Uint64
+
+	// queued counts the tasks in the rings. pushing counts pushes between checking closed
+	// and counting their task, which workers wait for before exiting a closed queue.
+	queued  atomic.Int64
+	pushing atomic.Int64
+	// sleeping counts workers parked on notEmpty and blocked pushes parked on notFull, so
+	// the other side knows when it must take the lock to wake them. Whoever wakes them
+	// uncounts them, so pushes and takes after the wakeup don't take the lock again before
+	// the woken goroutine has run. woken counts the workers woken but not yet running.
+	sleeping atomic.Int64
+	blocked  atomic.Int64
+	woken    int
+	closed   atomic.Bool
+	paused   atomic.Bool
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	// spawn, running, target, workers, starting and idleTimeout are as for taskQueue,
+	// changed with mu held. The ones read on every task are atomic.
+	spawn       func()
+	running     atomic.Bool
+	target      atomic.Int64
+	workers     atomic.Int64
+	starting    int
+	idleTimeout time.Duration
+}
+
+// newRingQueue creates a queue with a ring for each of target workers, holding capacity
+// tasks between them and at least two each.
+func newRingQueue(capacity, target int, spawn func()) *ringQueue {
+	queue := &ringQueue{
+		rings: make([]*ring, target),
+		spawn: spawn,
+	}
+	for i := range queue.rings {
+		queue.rings[i] = newRing((capacity + target - 1) / target)
+	}
+	queue.target.Store(int64(target))
+	queue.notEmpty = sync.NewCond(&queue.mu)
+	queue.notFull = sync.NewCond(&queue.mu)
+	return queue
+}
+
+func (queue *ringQueue) start() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.running.Store(true)
+	if queue.idleTimeout == 0 {
+		queue.startMissing()
+	}
+}
+
+// startWorker starts one more worker. The caller holds mu.
+func (queue *ringQueue) startWorker() {
+	queue.workers.Add(1)
+	queue.starting++
+	go queue.spawn()
+}
+
+// canStart reports whether another worker may be started. The caller holds mu.
+func (queue *ringQueue) canStart() bool {
+	return queue.running.Load() && queue.workers.Load() < queue.target.Load()
+}
+
+// startMissing starts workers up to the target. The caller holds mu.
+func (queue *ringQueue) startMissing() {
+	for queue.canStart() {
+		queue.startWorker()
+	}
+}
+
+// startNeeded starts workers for the queued tasks no idle worker will take. The caller
+// holds mu.
+func (queue *ringQueue) startNeeded() {
+	for queue.queued.Load() > queue.sleeping.Load()+int64(queue.woken+queue.starting) && queue.canStart() {
+		queue.startWorker()
+	}
+}
+
+// resize changes the number of workers. It keeps the rings, so workers beyond the
+// original number share them.
+func (queue *ringQueue) resize(target int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.target.Store(int64(target))
+	if queue.idleTimeout == 0 {
+		queue.startMissing()
+	}
+	queue.startNeeded()
+	queue.wakeAll()
+}
+
+func (queue *ringQueue) setIdleTimeout(timeout time.Duration) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.idleTimeout = max(timeout, 0)
+	if queue.idleTimeout == 0 {
+		queue.startMissing()
+	}
+	queue.wakeAll()
+}
+
+// push adds task to the next ring with room, waiting for room until ctx is done. It
+// returns ErrClosed if the queue is closed.
+func (queue *ringQueue) push(ctx context.Context, task Task) error {
+	queued := queuedTask{task: task, seq: queue.seq.Add(1), queued: time.Now()}
+	for {
+		queue.pushing.Add(1)
+		if queue.closed.Load() {
+			queue.pushing.Add(-1)
+			queue.wake(true)
+			return ErrClosed
+		}
+		if queue.offer(queued) {
+			queue.queued.Add(1)
+			queue.pushing.Add(-1)
+			break
+		}
+		queue.pushing.Add(-1)
+		if err := queue.waitRoom(ctx); err != nil {
+			return err
+		}
+	}
+	queue.wake(false)
+	return nil
+}
+
+// offer pushes queued to the first ring with room, starting with the next one in turn.
+func (queue *ringQueue) offer(queued queuedTask) bool {
+	n := uint64(len(queue.rings))
+	next := queue.next.Add(1)
+	for i := range n {
+		if queue.rings[(next+i)%n].push(queued) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitRoom waits until a ring has room or ctx is done.
+func (queue *ringQueue) waitRoom(ctx context.Context) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			queue.mu.Lock()
+			defer queue.mu.Unlock()
+			queue.unblockAll()
+		})
+		defer stop()
+	}
+
+	for {
+		// Count as blocked before looking, so a worker taking a task after the look wakes us
+		queue.blocked.Add(1)
+		if err := queue.roomOrErr(ctx); err != errNoRoom {
+			queue.blocked.Add(-1)
+			return err
+		}
+		queue.notFull.Wait()
+	}
+}
+
+// errNoRoom is returned by roomOrErr while every ring is full.
+var errNoRoom = errors.New("pool: no room")
+
+// roomOrErr returns nil if a ring has room, the error to give up with, or errNoRoom.
+func (queue *ringQueue) roomOrErr(ctx context.Context) error {
+	if queue.closed.Load() {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, r := range queue.rings {
+		if !r.full() {
+			return nil
+		}
+	}
+	return errNoRoom
+}
+
+// wakeAll wakes every parked worker. The caller holds mu.
+func (queue *ringQueue) wakeAll() {
+	queue.woken += int(queue.sleeping.Swap(0))
+	queue.notEmpty.Broadcast()
+}
+
+// unblockAll wakes every blocked push. The caller holds mu.
+func (queue *ringQueue) unblockAll() {
+	queue.blocked.Store(0)
+	queue.notFull.Broadcast()
+}
+
+// wake wakes a parked worker for a pushed task, or all of them when all is set, or else
+// starts a worker if none is idle.
+func (queue *ringQueue) wake(all bool) {
+	if queue.sleeping.Load() > 0 {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		if all {
+			queue.wakeAll()
+		} else if queue.sleeping.Load() > 0 {
+			queue.sleeping.Add(-1)
+			queue.woken++
+			queue.notEmpty.Signal()
+		}
+		return
+	}
+	if !all && queue.running.Load() && queue.workers.Load() < queue.target.Load() {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		queue.startNeeded()
+	}
+}
+
+// pop takes a task from the worker's home ring, or steals one from another ring, parking
+// the worker while there is none. It reports false when the worker should exit, as for
+// taskQueue.
+func (queue *ringQueue) pop(worker *workerState) (queuedTask, bool) {
+	if !worker.started {
+		worker.started = true
+		worker.home = int(queue.homes.Add(1)-1) % len(queue.rings)
+		queue.mu.Lock()
+		queue.starting--
+		queue.mu.Unlock()
+	}
+	// The idle time is only read with an idle timeout, so the clock is read on parking
+	var idleSince time.Time
+	for {
+		if queue.workers.Load() > queue.target.Load() && queue.retire() {
+			return queuedTask{}, false
+		}
+		if !queue.paused.Load() {
+			if queued, ok := queue.take(worker.home); ok {
+				return queued, true
+			}
+		}
+		if !queue.park(&idleSince) {
+			return queuedTask{}, false
+		}
+	}
+}
+
+// retire stops the worker if there are more than the target.
+func (queue *ringQueue) retire() bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.workers.Load() <= queue.target.Load() {
+		return false
+	}
+	queue.workers.Add(-1)
+	return true
+}
+
+// take pops a task from the home ring or, failing that, from the others in turn.
+func (queue *ringQueue) take(home int) (queuedTask, bool) {
+	for i := range queue.rings {
+		queued, ok := queue.rings[(home+i)%len(queue.rings)].pop()
+		if !ok {
+			continue
+		}
+		queue.queued.Add(-1)
+		if queue.blocked.Load() > 0 {
+			queue.mu.Lock()
+			if queue.blocked.Load() > 0 {
+				queue.blocked.Add(-1)
+				queue.notFull.Signal()
+			}
+			queue.mu.Unlock()
+		}
+		return queued, true
+	}
+	return queuedTask{}, false
+}
+
+// park waits until a task may be queued, reporting false when the worker should exit
+// instead.
+func (queue *ringQueue) park(idleSince *time.Time) bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		// Count as sleeping before looking, so a push after the look wakes us
+		queue.sleeping.Add(1)
+		wait, ok := queue.idle(idleSince, &timer)
+		if !wait {
+			queue.sleeping.Add(-1)
+			return ok
+		}
+		queue.notEmpty.Wait()
+		queue.woken--
+	}
+}
+
+// idle reports whether a parked worker should go on waiting and, if not, whether it
+// should look for a task rather than exit. With an idle timeout, it notes when the
+// worker first went idle and starts a timer to wake it once the timeout expires. The
+// caller holds mu.
+func (queue *ringQueue) idle(idleSince *time.Time, timer **time.Timer) (wait, ok bool) {
+	if queue.workers.Load() > queue.target.Load() {
+		queue.workers.Add(-1)
+		return false, false
+	}
+	if !queue.paused.Load() && queue.queued.Load() > 0 {
+		return false, true
+	}
+	// Pushes that saw the queue open count their tasks before pushing drops
+	if queue.closed.Load() && queue.pushing.Load() == 0 && queue.queued.Load() <= 0 {
+		queue.workers.Add(-1)
+		return false, false
+	}
+	if queue.idleTimeout > 0 {
+		if idleSince.IsZero() {
+			*idleSince = time.Now()
+		}
+		idle := time.Since(*idleSince)
+		if idle >= queue.idleTimeout {
+			queue.workers.Add(-1)
+			return false, false
+		}
+		if *timer == nil {
+			*timer = time.AfterFunc(queue.idleTimeout-idle, func() {
+				queue.mu.Lock()
+				defer queue.mu.Unlock()
+				queue.wakeAll()
+			})
+		}
+	}
+	return true, false
+}
+
+func (queue *ringQueue) live() int {
+	return int(queue.workers.Load())
+}
+
+func (queue *ringQueue) size() int {
+	return int(queue.target.Load())
+}
+
+// setDiscipline does nothing: the rings take tasks roughly in submission order.
+func (queue *ringQueue) setDiscipline(Discipline) {}
+
+func (queue *ringQueue) setPaused(paused bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.paused.Store(paused)
+	if paused {
+		return
+	}
+	queue.startNeeded()
+	queue.wakeAll()
+}
+
+func (queue *ringQueue) isPaused() bool {
+	return queue.paused.Load()
+}
+
+func (queue *ringQueue) close() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.closed.Store(true)
+	queue.wakeAll()
+	queue.unblockAll()
+}
+
+func (queue *ringQueue) drain() []queuedTask {
+	var drained []queuedTask
+	for _, r := range queue.rings {
+		for {
+			queued, ok := r.pop()
+			if !ok {
+				break
+			}
+			queue.queued.Add(-1)
+			drained = append(drained, queued)
+		}
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.unblockAll()
+	return drained
+}
+
+func (queue *ringQueue) len() int {
+	return max(int(queue.queued.Load()), 0)
+}
@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRingSize(t *testing.T) {
+	for _, test := range []struct{ size, want int }{{0, 2}, {1, 2}, {2, 2}, {3, 4}, {4, 4}, {5, 8}} {
+		if got := len(newRing(test.size).slots); got != test.want {
+			t.Errorf("newRing(%d) holds %d tasks, want %d", test.size, got, test.want)
+		}
+	}
+}
+
+func TestRingOrderAndWrap(t *testing.T) {
+	r := newRing(4)
+	// Go round the ring a few times, so positions wrap past the slots
+	for lap := range 3 {
+		for i := range 4 {
+			if !r.push(queuedTask{seq: uint64(lap*4 + i)}) {
+				t.Fatalf("lap %d: push %d failed on a ring with room", lap, i)
+			}
+		}
+		if !r.full() || r.push(queuedTask{}) {
+			t.Fatalf("lap %d: full ring took a task", lap)
+		}
+		for i := range 4 {
+			queued, ok := r.pop()
+			if !ok || queued.seq != uint64(lap*4+i) {
+				t.Fatalf("lap %d: popped %d, %v, want %d", lap, queued.seq, ok, lap*4+i)
+			}
+		}
+		if _, ok := r.pop(); ok {
+			t.Fatalf("lap %d: popped from an empty ring", lap)
+		}
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	const producers, perProducer = 4, 2000
+	r := newRing(64)
+	var popped sync.Map
+	var count atomic.Int64
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Go(func() {
+			for i := range perProducer {
+				for !r.push(queuedTask{seq: uint64(p*perProducer + i)}) {
+					runtime.Gosched()
+				}
+			}
+		})
+	}
+	for range producers {
+		wg.Go(func() {
+			for count.Load() < producers*perProducer {
+				queued, ok := r.pop()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				if _, dup := popped.LoadOrStore(queued.seq, true); dup {
+					t.Errorf("task %d popped twice", queued.seq)
+				}
+				count.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if got := count.Load(); got != producers*perProducer {
+		t.Errorf("popped %d tasks, want %d", got, producers*perProducer)
+	}
+}
+
+func TestRingPoolRunsQueuedOnClose(t *testing.T) {
+	pool := NewRing(2, 8)
+	pool.Pause()
+	pool.Run()
+	var ran atomic.Int64
+	for range 8 {
+		pool.Submit(taskFunc(func(ctx context.Context) error {
+			ran.Add(1)
+			return nil
+		}))
+	}
+	if queued := pool.Queued(); queued != 8 {
+		t.Errorf("%d queued, want 8", queued)
+	}
+	pool.Close()
+	pool.Resume()
+	pool.Wait()
+	if got := ran.Load(); got != 8 {
+		t.Errorf("ran %d of the 8 tasks queued before Close", got)
+	}
+}
+
+func TestRingPoolBlocksWhenFull(t *testing.T) {
+	pool := NewRing(1, 2)
+	pool.Pause()
+	pool.Run()
+	defer pool.Close()
+	defer pool.Resume()
+
+	task := taskFunc(func(ctx context.Context) error { return nil })
+	for range 2 {
+		pool.Submit(task)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pool.SubmitContext(ctx, task); err == nil {
+		t.Error("a full ring took a task")
+	}
+}
@@ -0,0 +1,108 @@
+package pool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets.
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats is a snapshot of a pool's load.
+type Stats struct {
+	// Workers is the number of workers the pool is sized for; Live counts the ones
+	// started, fewer while idle workers have been stopped.
+	Workers int
+	Live    int
+	// Paused is set while the pool is paused.
+	Paused bool
+	// Queued counts tasks waiting for a worker; Running counts tasks being run.
+	Queued  int
+	Running int
+	// Completed counts finished tasks, including the Failed ones: tasks that panicked or
+	// returned an error, counting every failed attempt of retried tasks.
+	Completed int64
+	Failed    int64
+	// Wait is how long tasks waited for a worker; Run is how long they ran.
+	Wait Histogram
+	Run  Histogram
+}
+
+// LatencyBuckets returns the upper bounds of the latency histogram buckets. A last,
+// unbounded bucket counts everything slower.
+func LatencyBuckets() []time.Duration {
+	return append([]time.Duration(nil), latencyBuckets[:]...)
+}
+
+// Histogram counts latencies in LatencyBuckets.
+type Histogram struct {
+	// Counts has one count per bucket plus one for latencies above the last bound.
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+// histogram records latencies for Stats.
+type histogram struct {
+	mu     sync.Mutex
+	counts [len(latencyBuckets) + 1]int64
+	count  int64
+	sum    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) snapshot() Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Histogram{Counts: append([]int64(nil), h.counts[:]...), Count: h.count, Sum: h.sum}
+}
+
+// counters tracks what Stats reports.
+type counters struct {
+	running   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	wait      histogram
+	run       histogram
+}
+
+// Stats returns a snapshot of the pool's load.
+func (pool *Pool) Stats() Stats {
+	return Stats{
+		Workers:   pool.Size(),
+		Live:      pool.Workers(),
+		Paused:    pool.Paused(),
+		Queued:    pool.Queued(),
+		Running:   int(pool.counters.running.Load()),
+		Completed: pool.counters.completed.Load(),
+		Failed:    pool.counters.failed.Load(),
+		Wait:      pool.counters.wait.snapshot(),
+		Run:       pool.counters.run.snapshot(),
+	}
+}
@@ -0,0 +1,142 @@
+// Package typed runs functions returning values of one type on a worker pool and
+// delivers their results on a channel, for fanning out work without defining a Task
+// type and result channel for each use.
+package typed
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/blueai2022/net_prg/pool"
+)
+
+// Result is the outcome of one submitted function. Index is the order it was submitted
+// in, starting at 0, for callers that want results in submission order.
+type Result[T any] struct {
+	Index int
+	Value T
+	Err   error
+}
+
+// Pool runs functions returning T and delivers their results in the order they finish.
+type Pool[T any] struct {
+	workers *pool.Pool
+	// own is set when the pool created workers and closes it along with itself.
+	own     bool
+	results chan Result[T]
+
+	mu sync.Mutex
+	// ready is signalled when a result is added to finished or the pool closes.
+	ready    *sync.Cond
+	finished []Result[T]
+	// submitted counts Submit calls; pending counts the ones still running.
+	submitted int
+	pending   int
+	closed    bool
+}
+
+// New creates a pool with its own numThreads workers.
+func New[T any](numThreads int) *Pool[T] {
+	workers := pool.New(numThreads)
+	workers.Run()
+	p := On[T](workers)
+	p.own = true
+	return p
+}
+
+// On creates a pool running its functions on workers, which must be running, alongside
+// whatever else is submitted to it.
+func On[T any](workers *pool.Pool) *Pool[T] {
+	p := &Pool[T]{workers: workers, results: make(chan Result[T])}
+	p.ready = sync.NewCond(&p.mu)
+	go p.forward()
+	return p
+}
+
+// Submit runs fn with the workers' context. Like pool.Pool's Submit, it blocks while
+// the workers have no room; results never hold it up, as they are buffered until read.
+func (p *Pool[T]) Submit(fn func(ctx context.Context) (T, error)) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		panic("typed: Submit on closed pool")
+	}
+	index := p.submitted
+	p.submitted++
+	p.pending++
+	p.mu.Unlock()
+
+	p.workers.SubmitWithResult(&task[T]{pool: p, index: index, fn: fn})
+}
+
+// Results returns the channel results are delivered on. It is closed once the pool is
+// closed and every result has been read.
+func (p *Pool[T]) Results() <-chan Result[T] {
+	return p.results
+}
+
+// Close ends submitting. Results is closed once the submitted functions have finished
+// and their results are read.
+func (p *Pool[T]) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.ready.Signal()
+	if p.own {
+		p.workers.Close()
+	}
+}
+
+// deliver queues a result for Results.
+func (p *Pool[T]) deliver(result Result[T]) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.finished = append(p.finished, result)
+	p.pending--
+	p.ready.Signal()
+}
+
+// forward sends finished results on the results channel until the pool is closed and
+// drained.
+func (p *Pool[T]) forward() {
+	for {
+		p.mu.Lock()
+		for len(p.finished) == 0 && !(p.closed && p.pending == 0) {
+			p.ready.Wait()
+		}
+		if len(p.finished) == 0 {
+			p.mu.Unlock()
+			close(p.results)
+			return
+		}
+		result := p.finished[0]
+		p.finished = p.finished[1:]
+		p.mu.Unlock()
+
+		p.results <- result
+	}
+}
+
+// task adapts a submitted function to pool.ResultTask, so the workers count its errors
+// and report its panics.
+type task[T any] struct {
+	pool  *Pool[T]
+	index int
+	fn    func(ctx context.Context) (T, error)
+}
+
+func (task *task[T]) RunResult(ctx context.Context) (any, error) {
+	defer func() {
+		// Deliver a result before the workers report the panic, so Results still closes
+		if value := recover(); value != nil {
+			task.pool.deliver(Result[T]{Index: task.index, Err: fmt.Errorf("task panicked: %v", value)})
+			panic(value)
+		}
+	}()
+	value, err := task.fn(ctx)
+	task.pool.deliver(Result[T]{Index: task.index, Value: value, Err: err})
+	return value, err
+}
@@ -0,0 +1,230 @@
+package quicdemo
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Options controls a client run. Zero values select the defaults.
+type Options struct {
+	// Requests is the number of echo requests to send (default 100).
+	Requests int
+	// Concurrency is how many requests are in flight at once (default 1).
+	Concurrency int
+	// Message is the line sent with every request (default "ping").
+	Message string
+	// Timeout bounds each request (default 5s).
+	Timeout time.Duration
+	// CAFile verifies the server certificate; Insecure skips verification instead.
+	CAFile   string
+	Insecure bool
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Requests <= 0 {
+		opts.Requests = 100
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Message == "" {
+		opts.Message = "ping"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+}
+
+// Result summarizes a client run.
+type Result struct {
+	Protocol string
+	// Handshake is the QUIC connection setup time; TCP connects are part of each latency.
+	Handshake time.Duration
+	Failed    int
+	Latencies []time.Duration
+	Elapsed   time.Duration
+}
+
+// Percentile returns the latency below which p percent of the successful requests fall.
+func (result *Result) Percentile(p float64) time.Duration {
+	if len(result.Latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(result.Latencies)
+	slices.Sort(sorted)
+	i := int(p / 100 * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+// Average returns the mean latency of the successful requests.
+func (result *Result) Average() time.Duration {
+	if len(result.Latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, latency := range result.Latencies {
+		total += latency
+	}
+	return total / time.Duration(len(result.Latencies))
+}
+
+func (result *Result) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %d ok, %d failed in %v", result.Protocol, len(result.Latencies), result.Failed, result.Elapsed.Round(time.Millisecond))
+	if result.Handshake > 0 {
+		fmt.Fprintf(&sb, ", handshake %v", result.Handshake.Round(time.Microsecond))
+	}
+	if len(result.Latencies) > 0 {
+		fmt.Fprintf(&sb, ", latency min/avg/p50/p99/max = %v/%v/%v/%v/%v",
+			result.Percentile(0).Round(time.Microsecond),
+			result.Average().Round(time.Microsecond),
+			result.Percentile(50).Round(time.Microsecond),
+			result.Percentile(99).Round(time.Microsecond),
+			result.Percentile(100).Round(time.Microsecond))
+	}
+	return sb.String()
+}
+
+// Run sends echo requests to a QUIC server, one stream per request over a single connection.
+func Run(ctx context.Context, addr string, opts Options) (*Result, error) {
+	opts.setDefaults()
+	tlsConfig, err := clientTLSConfig(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, addr, tlsConfig, &quic.Config{MaxIdleTimeout: idleTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.CloseWithError(0, "")
+	handshake := time.Since(start)
+
+	result := measure(ctx, opts, func(ctx context.Context) error {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			stream.SetDeadline(deadline)
+		}
+		return echo(stream, opts.Message)
+	})
+	result.Protocol = "quic"
+	result.Handshake = handshake
+	return result, nil
+}
+
+// RunTCP sends the same requests to a concurtcp server, one connection per request, for
+// comparison with Run.
+func RunTCP(ctx context.Context, addr string, opts Options) (*Result, error) {
+	opts.setDefaults()
+
+	result := measure(ctx, opts, func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		return echo(conn, opts.Message)
+	})
+	result.Protocol = "tcp"
+	return result, nil
+}
+
+// echo sends one line and checks the server's answer.
+func echo(rw io.ReadWriter, message string) error {
+	if _, err := rw.Write([]byte(message + "\n")); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(rw).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if want := "Received: " + message + "\n"; reply != want {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+// measure runs opts.Requests requests, opts.Concurrency at a time, timing each one.
+func measure(ctx context.Context, opts Options, request func(context.Context) error) *Result {
+	var mu sync.Mutex
+	result := &Result{}
+
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				reqCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+				sent := time.Now()
+				err := request(reqCtx)
+				latency := time.Since(sent)
+				cancel()
+
+				mu.Lock()
+				if err != nil {
+					result.Failed++
+				} else {
+					result.Latencies = append(result.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < opts.Requests && ctx.Err() == nil; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+	return result
+}
+
+func clientTLSConfig(addr string, opts Options) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		NextProtos:         []string{ALPN},
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: opts.Insecure,
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA file")
+		}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,169 @@
+// Package quicdemo is a QUIC echo server and client for comparing QUIC with the TCP
+// server. Each request is one bidirectional stream carrying a line; the server answers
+// "Received: <line>" and closes the stream, as concurtcp does per connection. Streams
+// are handled by tasks on the same worker pool the TCP server uses.
+package quicdemo
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/blueai2022/net_prg/pool"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// ALPN is the application protocol negotiated by client and server.
+	ALPN = "net_prg-echo"
+
+	maxIncomingStreams = 1000
+	idleTimeout        = 30 * time.Second
+)
+
+// Server accepts QUIC connections and echoes every stream.
+type Server struct {
+	listener *quic.Listener
+	workers  *pool.Pool
+}
+
+// Listen creates a server on a UDP address. With no certificate files, an ephemeral
+// self-signed certificate is used and clients must skip verification.
+func Listen(addr, certFile, keyFile string, workers *pool.Pool) (*Server, error) {
+	var cert tls.Certificate
+	var err error
+	if certFile != "" || keyFile != "" {
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	} else {
+		cert, err = selfSignedCertificate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{ALPN},
+		MinVersion:   tls.VersionTLS13,
+	}
+	quicConfig := &quic.Config{
+		MaxIncomingStreams: maxIncomingStreams,
+		MaxIdleTimeout:     idleTimeout,
+	}
+	listener, err := quic.ListenAddr(addr, tlsConfig, quicConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &Server{listener: listener, workers: workers}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (server *Server) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+// Serve accepts connections until ctx is done, handing each stream to the worker pool.
+func (server *Server) Serve(ctx context.Context) error {
+	defer server.listener.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := server.listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.acceptStreams(ctx, conn)
+		}()
+	}
+}
+
+// acceptStreams submits a task per stream until the connection closes.
+func (server *Server) acceptStreams(ctx context.Context, conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			var appErr *quic.ApplicationError
+			var idleErr *quic.IdleTimeoutError
+			if ctx.Err() == nil && !errors.As(err, &appErr) && !errors.As(err, &idleErr) {
+				log.Printf("Error accepting stream from %s: %v\n", conn.RemoteAddr(), err)
+			}
+			conn.CloseWithError(0, "")
+			return
+		}
+
+		// Create a new task for each stream and add it to the pool
+		server.workers.Submit(&StreamTask{stream: stream})
+	}
+}
+
+// Task implementation for handling a stream
+type StreamTask struct {
+	stream *quic.Stream
+}
+
+func (task *StreamTask) Run(ctx context.Context) error {
+	defer task.stream.Close()
+
+	// Read data from the client
+	data, err := bufio.NewReader(task.stream).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read from stream: %w", err)
+	}
+
+	// Process the data and generate a response
+	response := fmt.Sprintf("Received: %s", data)
+
+	// Send the response back to the client
+	if _, err := task.stream.Write([]byte(response)); err != nil {
+		return fmt.Errorf("failed to write to stream: %w", err)
+	}
+	return nil
+}
+
+// selfSignedCertificate creates a short-lived certificate for localhost.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "quicdemo"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,346 @@
+// Package revproxy balances TCP connections and HTTP requests across a set of backends.
+// Backends are picked round-robin or by fewest active connections, taken out of rotation
+// when a health check or a dial fails, and put back once a health check passes.
+package revproxy
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RoundRobin       = "round-robin"
+	LeastConnections = "least-connections"
+)
+
+// ErrNoBackend is returned when every backend is unhealthy.
+var ErrNoBackend = errors.New("no healthy backend")
+
+// Backend is one upstream server and its counters.
+type Backend struct {
+	Addr string
+
+	healthy  atomic.Bool
+	active   atomic.Int64
+	total    atomic.Int64
+	failures atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// BackendStats is a snapshot of a backend's counters.
+type BackendStats struct {
+	Healthy  bool  `json:"healthy"`
+	Active   int64 `json:"active"`
+	Total    int64 `json:"total"`
+	Failures int64 `json:"failures"`
+	// BytesIn counts bytes from clients to the backend, BytesOut the replies.
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// Stats returns the backend's counters.
+func (backend *Backend) Stats() BackendStats {
+	return BackendStats{
+		Healthy:  backend.healthy.Load(),
+		Active:   backend.active.Load(),
+		Total:    backend.total.Load(),
+		Failures: backend.failures.Load(),
+		BytesIn:  backend.bytesIn.Load(),
+		BytesOut: backend.bytesOut.Load(),
+	}
+}
+
+func (backend *Backend) acquire() {
+	backend.active.Add(1)
+	backend.total.Add(1)
+}
+
+func (backend *Backend) release() {
+	backend.active.Add(-1)
+}
+
+// markDown takes the backend out of rotation until a health check passes.
+func (backend *Backend) markDown(err error) {
+	backend.failures.Add(1)
+	if backend.healthy.Swap(false) {
+		log.Printf("Backend %s is down: %v\n", backend.Addr, err)
+	}
+}
+
+func (backend *Backend) markUp() {
+	if !backend.healthy.Swap(true) {
+		log.Printf("Backend %s is up\n", backend.Addr)
+	}
+}
+
+// Balancer picks backends for new connections. It is safe for concurrent use.
+type Balancer struct {
+	backends []*Backend
+	policy   string
+	next     atomic.Uint64
+}
+
+// NewBalancer creates a balancer over backend host:port addresses. Backends start healthy.
+func NewBalancer(addrs []string, policy string) (*Balancer, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("at least one backend is required")
+	}
+	if policy != RoundRobin && policy != LeastConnections {
+		return nil, fmt.Errorf("unknown balancing policy %q", policy)
+	}
+
+	balancer := &Balancer{policy: policy}
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid backend %s: %w", addr, err)
+		}
+		backend := &Backend{Addr: addr}
+		backend.healthy.Store(true)
+		balancer.backends = append(balancer.backends, backend)
+	}
+	return balancer, nil
+}
+
+// Backends returns every backend, healthy or not.
+func (balancer *Balancer) Backends() []*Backend {
+	return balancer.backends
+}
+
+// Pick returns the next healthy backend according to the policy.
+func (balancer *Balancer) Pick() (*Backend, error) {
+	return balancer.pick(nil)
+}
+
+// pick skips the backends in tried, so a failed dial moves on to another one.
+func (balancer *Balancer) pick(tried map[*Backend]bool) (*Backend, error) {
+	n := len(balancer.backends)
+	start := int(balancer.next.Add(1) % uint64(n))
+
+	var best *Backend
+	for i := 0; i < n; i++ {
+		backend := balancer.backends[(start+i)%n]
+		if !backend.healthy.Load() || tried[backend] {
+			continue
+		}
+		if balancer.policy == RoundRobin {
+			return backend, nil
+		}
+		// Ties go to the first in round-robin order so equal backends share the load
+		if best == nil || backend.active.Load() < best.active.Load() {
+			best = backend
+		}
+	}
+	if best == nil {
+		return nil, ErrNoBackend
+	}
+	return best, nil
+}
+
+// CheckFunc probes one backend address.
+type CheckFunc func(ctx context.Context, addr string) error
+
+// TCPCheck passes when the backend accepts a connection.
+func TCPCheck(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HTTPCheck returns a check that passes when GET path answers with a status below 500.
+func HTTPCheck(path string) CheckFunc {
+	return func(ctx context.Context, addr string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("health check returned %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// HealthCheck probes every backend each interval until ctx is done, updating which ones
+// are in rotation.
+func (balancer *Balancer) HealthCheck(ctx context.Context, interval, timeout time.Duration, check CheckFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, backend := range balancer.backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				checkCtx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				if err := check(checkCtx, backend.Addr); err != nil {
+					if ctx.Err() == nil {
+						backend.markDown(err)
+					}
+					return
+				}
+				backend.markUp()
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish exports the per-backend counters as the expvar variable name.
+func (balancer *Balancer) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		stats := make(map[string]BackendStats, len(balancer.backends))
+		for _, backend := range balancer.backends {
+			stats[backend.Addr] = backend.Stats()
+		}
+		return stats
+	}))
+}
+
+// ProxyTCP connects client to a healthy backend and copies data both ways until either
+// side closes or ctx is done. Client is always closed on return.
+func (balancer *Balancer) ProxyTCP(ctx context.Context, client net.Conn, dialTimeout time.Duration) error {
+	defer client.Close()
+
+	upstream, backend, err := balancer.dial(ctx, dialTimeout)
+	if err != nil {
+		return err
+	}
+	backend.acquire()
+	defer backend.release()
+
+	// Cut the connection short on shutdown
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	pipe(client, upstream, backend)
+	return nil
+}
+
+// dial connects to a healthy backend. Backends that refuse the connection are marked
+// down and the next one is tried.
+func (balancer *Balancer) dial(ctx context.Context, timeout time.Duration) (net.Conn, *Backend, error) {
+	tried := make(map[*Backend]bool)
+	for {
+		backend, err := balancer.pick(tried)
+		if err != nil {
+			return nil, nil, err
+		}
+		tried[backend] = true
+
+		dialer := net.Dialer{Timeout: timeout}
+		upstream, err := dialer.DialContext(ctx, "tcp", backend.Addr)
+		if err == nil {
+			return upstream, backend, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		backend.markDown(err)
+	}
+}
+
+// pipe copies both directions and closes both connections once both are done.
+func pipe(client, upstream net.Conn, backend *Backend) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(upstream, client)
+		backend.bytesIn.Add(n)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(client, upstream)
+		backend.bytesOut.Add(n)
+		closeWrite(client)
+	}()
+	wg.Wait()
+	client.Close()
+	upstream.Close()
+}
+
+// closeWrite half-closes conn so the other side sees EOF while replies can still arrive.
+func closeWrite(conn net.Conn) {
+	if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcpConn.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+type backendKey struct{}
+
+// HTTPHandler returns a handler that forwards each request to a healthy backend.
+// Requests that fail to reach a backend mark it down and get 502 Bad Gateway.
+func (balancer *Balancer) HTTPHandler() http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(req *httputil.ProxyRequest) {
+			backend := req.In.Context().Value(backendKey{}).(*Backend)
+			req.SetURL(&url.URL{Scheme: "http", Host: backend.Addr})
+			req.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			backend := resp.Request.Context().Value(backendKey{}).(*Backend)
+			if resp.ContentLength > 0 {
+				backend.bytesOut.Add(resp.ContentLength)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			backend := req.Context().Value(backendKey{}).(*Backend)
+			if req.Context().Err() == nil {
+				backend.markDown(err)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		backend, err := balancer.Pick()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		backend.acquire()
+		defer backend.release()
+		if req.ContentLength > 0 {
+			backend.bytesIn.Add(req.ContentLength)
+		}
+
+		ctx := context.WithValue(req.Context(), backendKey{}, backend)
+		proxy.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,68 @@
+package sniffer
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+// loopbackDupWindow is how close together two identical loopback packets must be to be
+// the outgoing and incoming copy of the same packet.
+const loopbackDupWindow = time.Millisecond
+
+type liveSource struct {
+	*pcapgo.EthernetHandle
+	// loopback sockets see every packet twice, once sent and once received
+	loopback bool
+	last     []byte
+	lastTime time.Time
+}
+
+func (source *liveSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (source *liveSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := source.EthernetHandle.ReadPacketData()
+		if err != nil || !source.loopback {
+			return data, ci, err
+		}
+		duplicate := bytes.Equal(data, source.last) && ci.Timestamp.Sub(source.lastTime) < loopbackDupWindow
+		source.last, source.lastTime = data, ci.Timestamp
+		if !duplicate {
+			return data, ci, nil
+		}
+		// Forget it so a genuine third copy is shown
+		source.last = nil
+	}
+}
+
+// OpenInterface captures packets on a network interface with an AF_PACKET socket, which
+// needs CAP_NET_RAW but not libpcap. Packets are cut to snaplen bytes.
+func OpenInterface(name string, snaplen int, promiscuous bool) (Source, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+	handle, err := pcapgo.NewEthernetHandle(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture on %s: %w", name, err)
+	}
+	if err := handle.SetCaptureLength(snaplen); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to set capture length: %w", err)
+	}
+	if promiscuous {
+		if err := handle.SetPromiscuous(true); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("failed to enable promiscuous mode on %s: %w", name, err)
+		}
+	}
+	return &liveSource{EthernetHandle: handle, loopback: iface.Flags&net.FlagLoopback != 0}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package sniffer
+
+import "errors"
+
+// OpenInterface is only implemented on Linux; elsewhere capture with tcpdump and use OpenFile.
+func OpenInterface(name string, snaplen int, promiscuous bool) (Source, error) {
+	return nil, errors.New("live capture is only supported on Linux")
+}
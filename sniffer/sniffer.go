@@ -0,0 +1,228 @@
+// Package sniffer captures packets on the ports this repo's servers use and decodes the
+// protocols they carry: newline-delimited text (concurtcp, SIP), 4-byte length-prefixed
+// frames (iperf data, gRPC-Web) and RTP. Decoding works on single packets without TCP
+// reassembly, so messages split across segments show up as partial.
+package sniffer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/pion/rtp"
+)
+
+const (
+	ProtoLine   = "line"
+	ProtoLength = "length"
+	ProtoRTP    = "rtp"
+	ProtoRaw    = "raw"
+
+	// maxDump bounds how much of a payload is shown as hex or text.
+	maxDump = 64
+)
+
+// DefaultPorts is the port spec for the repo's default ports: SIP, STUN/TURN, the
+// hole-punching rendezvous, iperf, QUIC echo and the usual RTP range.
+const DefaultPorts = "5060-5061=line,3478-3479=raw,5201=length,4242=raw,10000-20000=rtp"
+
+// portRange maps a range of ports to the protocol decoded on them.
+type portRange struct {
+	low, high uint16
+	proto     string
+}
+
+// Filter selects packets by TCP or UDP port.
+type Filter struct {
+	ranges []portRange
+}
+
+// ParseFilter parses a comma-separated list of port or low-high ranges, each optionally
+// followed by =protocol, e.g. "8080=line,5201=length,10000-20000=rtp". Ports without a
+// protocol are shown raw.
+func ParseFilter(spec string) (*Filter, error) {
+	filter := &Filter{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ports, proto, _ := strings.Cut(part, "=")
+		if proto == "" {
+			proto = ProtoRaw
+		}
+		switch proto {
+		case ProtoLine, ProtoLength, ProtoRTP, ProtoRaw:
+		default:
+			return nil, fmt.Errorf("unknown protocol %q in %q", proto, part)
+		}
+
+		lowStr, highStr, isRange := strings.Cut(ports, "-")
+		low, err := strconv.ParseUint(lowStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %q: %w", part, err)
+		}
+		high := low
+		if isRange {
+			if high, err = strconv.ParseUint(highStr, 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid port in %q: %w", part, err)
+			}
+		}
+		if low == 0 || high < low {
+			return nil, fmt.Errorf("invalid port range %q", ports)
+		}
+		filter.ranges = append(filter.ranges, portRange{low: uint16(low), high: uint16(high), proto: proto})
+	}
+	if len(filter.ranges) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	return filter, nil
+}
+
+// Match returns the protocol of the first range either port of the packet falls in.
+func (filter *Filter) Match(packet gopacket.Packet) (string, bool) {
+	src, dst, ok := ports(packet)
+	if !ok {
+		return "", false
+	}
+	for _, r := range filter.ranges {
+		if (dst >= r.low && dst <= r.high) || (src >= r.low && src <= r.high) {
+			return r.proto, true
+		}
+	}
+	return "", false
+}
+
+func ports(packet gopacket.Packet) (uint16, uint16, bool) {
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		return uint16(transport.SrcPort), uint16(transport.DstPort), true
+	case *layers.UDP:
+		return uint16(transport.SrcPort), uint16(transport.DstPort), true
+	default:
+		return 0, 0, false
+	}
+}
+
+// Describe returns a summary line for the packet followed by its payload decoded as proto.
+func Describe(packet gopacket.Packet, proto string) string {
+	var sb strings.Builder
+	sb.WriteString(packet.Metadata().Timestamp.Format("15:04:05.000000"))
+
+	src, dst := "?", "?"
+	if network := packet.NetworkLayer(); network != nil {
+		src, dst = network.NetworkFlow().Src().String(), network.NetworkFlow().Dst().String()
+	}
+	var payload []byte
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		fmt.Fprintf(&sb, " TCP %s > %s [%s] seq=%d len=%d\n",
+			joinHostPort(src, uint16(transport.SrcPort)), joinHostPort(dst, uint16(transport.DstPort)),
+			tcpFlags(transport), transport.Seq, len(transport.Payload))
+		payload = transport.Payload
+	case *layers.UDP:
+		fmt.Fprintf(&sb, " UDP %s > %s len=%d\n",
+			joinHostPort(src, uint16(transport.SrcPort)), joinHostPort(dst, uint16(transport.DstPort)), len(transport.Payload))
+		payload = transport.Payload
+	default:
+		fmt.Fprintf(&sb, " %s > %s\n", src, dst)
+	}
+
+	if len(payload) > 0 {
+		switch proto {
+		case ProtoLine:
+			describeLines(&sb, payload)
+		case ProtoLength:
+			describeFrames(&sb, payload)
+		case ProtoRTP:
+			describeRTP(&sb, payload)
+		default:
+			describeRaw(&sb, payload)
+		}
+	}
+	return sb.String()
+}
+
+func joinHostPort(host string, port uint16) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]:" + strconv.Itoa(int(port))
+	}
+	return host + ":" + strconv.Itoa(int(port))
+}
+
+func tcpFlags(tcp *layers.TCP) string {
+	var flags []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{tcp.SYN, "SYN"}, {tcp.FIN, "FIN"}, {tcp.RST, "RST"}, {tcp.PSH, "PSH"}, {tcp.ACK, "ACK"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return strings.Join(flags, ",")
+}
+
+// describeLines shows each newline-terminated line; a trailing fragment is marked partial.
+func describeLines(sb *strings.Builder, payload []byte) {
+	text := string(payload)
+	for text != "" {
+		line, rest, complete := strings.Cut(text, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if complete {
+			fmt.Fprintf(sb, "    line %q\n", truncate(line))
+		} else {
+			fmt.Fprintf(sb, "    partial line %q\n", truncate(line))
+		}
+		text = rest
+	}
+}
+
+// describeFrames walks 4-byte big-endian length-prefixed frames.
+func describeFrames(sb *strings.Builder, payload []byte) {
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			fmt.Fprintf(sb, "    partial frame header (%d bytes)\n", len(payload))
+			return
+		}
+		size := binary.BigEndian.Uint32(payload)
+		body := payload[4:]
+		if uint64(size) > uint64(len(body)) {
+			fmt.Fprintf(sb, "    frame len=%d, %d bytes in this packet\n", size, len(body))
+			return
+		}
+		fmt.Fprintf(sb, "    frame len=%d %s\n", size, hex.EncodeToString(body[:min(int(size), maxDump/2)]))
+		payload = body[size:]
+	}
+}
+
+func describeRTP(sb *strings.Builder, payload []byte) {
+	var packet rtp.Packet
+	if err := packet.Unmarshal(payload); err != nil {
+		fmt.Fprintf(sb, "    not RTP: %v\n", err)
+		describeRaw(sb, payload)
+		return
+	}
+	fmt.Fprintf(sb, "    RTP pt=%d seq=%d ts=%d ssrc=%#08x marker=%v payload=%d\n",
+		packet.PayloadType, packet.SequenceNumber, packet.Timestamp, packet.SSRC, packet.Marker, len(packet.Payload))
+}
+
+func describeRaw(sb *strings.Builder, payload []byte) {
+	dump := hex.Dump(payload[:min(len(payload), maxDump)])
+	for _, line := range strings.Split(strings.TrimSuffix(dump, "\n"), "\n") {
+		sb.WriteString("    " + line + "\n")
+	}
+}
+
+func truncate(s string) string {
+	if len(s) > maxDump {
+		return s[:maxDump] + "..."
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package sniffer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+// Source is a stream of captured packets, live or from a file.
+type Source interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	Close() error
+}
+
+type fileSource struct {
+	*pcapgo.Reader
+	file *os.File
+}
+
+func (source *fileSource) Close() error {
+	return source.file.Close()
+}
+
+// OpenFile reads packets from a pcap file.
+func OpenFile(path string) (Source, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read capture file %s: %w", path, err)
+	}
+	return &fileSource{Reader: reader, file: file}, nil
+}
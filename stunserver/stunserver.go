@@ -0,0 +1,232 @@
+// Package stunserver is a small STUN server (RFC 5389) answering binding requests with
+// XOR-MAPPED-ADDRESS, so NAT traversal can be exercised without public STUN servers.
+package stunserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+const (
+	defaultSoftware = "net_prg stunserver"
+	// nonceLifetime bounds how long a nonce is accepted before the client gets 438 Stale Nonce.
+	nonceLifetime = 10 * time.Minute
+	maxPacketSize = 1500
+)
+
+// Config configures a Server. With no Users, binding requests are answered without authentication.
+type Config struct {
+	// Realm is sent with long-term credential challenges.
+	Realm string
+	// Users maps usernames to passwords for long-term credential authentication.
+	Users map[string]string
+	// Software is sent in every response; empty uses a default.
+	Software string
+}
+
+// Server answers STUN binding requests on a packet connection.
+type Server struct {
+	conn     net.PacketConn
+	cfg      Config
+	software stun.Software
+	// nonceKey signs nonces so they can be checked without keeping state.
+	nonceKey []byte
+}
+
+// New creates a server on conn. Call Serve to start answering requests.
+func New(conn net.PacketConn, cfg Config) (*Server, error) {
+	if len(cfg.Users) > 0 && cfg.Realm == "" {
+		return nil, errors.New("a realm is required for long-term authentication")
+	}
+	if cfg.Software == "" {
+		cfg.Software = defaultSoftware
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce key: %w", err)
+	}
+	return &Server{conn: conn, cfg: cfg, software: stun.NewSoftware(cfg.Software), nonceKey: key}, nil
+}
+
+// Listen creates a server on a UDP socket bound to addr, e.g. ":3478" or "127.0.0.1:0".
+func Listen(addr string, cfg Config) (*Server, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	server, err := New(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+// Addr returns the address the server is listening on.
+func (server *Server) Addr() net.Addr {
+	return server.conn.LocalAddr()
+}
+
+// Serve answers requests until ctx is done or the connection is closed.
+func (server *Server) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { server.conn.Close() })
+	defer stop()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := server.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read request: %w", err)
+		}
+
+		response, err := server.handle(buf[:n], addr)
+		if err != nil {
+			log.Printf("Error handling STUN request from %s: %v\n", addr, err)
+			continue
+		}
+		if response == nil {
+			continue
+		}
+		if _, err := server.conn.WriteTo(response.Raw, addr); err != nil {
+			log.Printf("Error sending STUN response to %s: %v\n", addr, err)
+		}
+	}
+}
+
+// Close stops the server.
+func (server *Server) Close() error {
+	return server.conn.Close()
+}
+
+// handle builds the response to one packet, or returns nil if none should be sent.
+func (server *Server) handle(packet []byte, addr net.Addr) (*stun.Message, error) {
+	if !stun.IsMessage(packet) {
+		return nil, nil
+	}
+	request := &stun.Message{Raw: append([]byte(nil), packet...)}
+	if err := request.Decode(); err != nil {
+		return nil, fmt.Errorf("failed to decode request: %w", err)
+	}
+
+	// Indications and responses never get an answer
+	if request.Type.Class != stun.ClassRequest {
+		return nil, nil
+	}
+	if request.Contains(stun.AttrFingerprint) {
+		if err := stun.Fingerprint.Check(request); err != nil {
+			return nil, fmt.Errorf("bad fingerprint: %w", err)
+		}
+	}
+	if request.Type.Method != stun.MethodBinding {
+		return server.errorResponse(request, stun.CodeBadRequest)
+	}
+
+	var integrity stun.Setter
+	if len(server.cfg.Users) > 0 {
+		key, code := server.authenticate(request)
+		if code != 0 {
+			return server.errorResponse(request, code)
+		}
+		integrity = key
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unsupported address type %T", addr)
+	}
+	setters := []stun.Setter{
+		request,
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+		server.software,
+	}
+	if integrity != nil {
+		setters = append(setters, integrity)
+	}
+	setters = append(setters, stun.Fingerprint)
+	return stun.Build(setters...)
+}
+
+// authenticate checks long-term credentials, returning the integrity to sign the response
+// with, or the error code to answer with.
+func (server *Server) authenticate(request *stun.Message) (stun.MessageIntegrity, stun.ErrorCode) {
+	if !request.Contains(stun.AttrMessageIntegrity) {
+		return nil, stun.CodeUnauthorized
+	}
+
+	var username stun.Username
+	var realm stun.Realm
+	var nonce stun.Nonce
+	if username.GetFrom(request) != nil || realm.GetFrom(request) != nil || nonce.GetFrom(request) != nil {
+		return nil, stun.CodeBadRequest
+	}
+	if !server.validNonce(nonce.String()) {
+		return nil, stun.CodeStaleNonce
+	}
+
+	password, ok := server.cfg.Users[username.String()]
+	if !ok || realm.String() != server.cfg.Realm {
+		return nil, stun.CodeUnauthorized
+	}
+	key := stun.NewLongTermIntegrity(username.String(), server.cfg.Realm, password)
+	if err := key.Check(request); err != nil {
+		return nil, stun.CodeUnauthorized
+	}
+	return key, 0
+}
+
+func (server *Server) errorResponse(request *stun.Message, code stun.ErrorCode) (*stun.Message, error) {
+	setters := []stun.Setter{
+		request,
+		stun.NewType(request.Type.Method, stun.ClassErrorResponse),
+		code,
+		server.software,
+	}
+	// Challenges carry what the client needs to compute its long-term key
+	if code == stun.CodeUnauthorized || code == stun.CodeStaleNonce {
+		setters = append(setters, stun.NewRealm(server.cfg.Realm), stun.NewNonce(server.newNonce()))
+	}
+	setters = append(setters, stun.Fingerprint)
+	return stun.Build(setters...)
+}
+
+// newNonce returns a nonce carrying its issue time and a MAC over it.
+func (server *Server) newNonce() string {
+	var issued [8]byte
+	binary.BigEndian.PutUint64(issued[:], uint64(time.Now().Unix()))
+	return hex.EncodeToString(issued[:]) + hex.EncodeToString(server.nonceMAC(issued[:]))
+}
+
+func (server *Server) validNonce(nonce string) bool {
+	raw, err := hex.DecodeString(nonce)
+	if err != nil || len(raw) != 8+sha256.Size {
+		return false
+	}
+	issued, mac := raw[:8], raw[8:]
+	if !hmac.Equal(mac, server.nonceMAC(issued)) {
+		return false
+	}
+	age := time.Since(time.Unix(int64(binary.BigEndian.Uint64(issued)), 0))
+	return age >= 0 && age < nonceLifetime
+}
+
+func (server *Server) nonceMAC(issued []byte) []byte {
+	mac := hmac.New(sha256.New, server.nonceKey)
+	mac.Write(issued)
+	return mac.Sum(nil)
+}
@@ -1,35 +1,116 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"time"
+
+	"github.com/blueai2022/net_prg/config"
+	"github.com/blueai2022/net_prg/dnsclient"
+	"github.com/blueai2022/net_prg/framing"
+	"github.com/blueai2022/net_prg/mdns"
 )
 
+// clientConfig holds the tcpclient settings.
+type clientConfig struct {
+	Addr      string   `config:"addr" usage:"server host:port"`
+	Message   string   `config:"message" usage:"message sent to the server"`
+	Framing   string   `config:"framing" usage:"how messages are delimited, as the server does: newline, length or checked"`
+	Resolvers []string `config:"resolvers" usage:"comma-separated DNS servers used to resolve addr instead of the system resolver"`
+
+	Service         string        `config:"service" usage:"mDNS service to discover the server by instead of addr, e.g. _echo._tcp"`
+	Instance        string        `config:"instance" usage:"instance of service to connect to, empty takes the first to answer"`
+	DiscoverTimeout time.Duration `config:"discover-timeout" usage:"how long to wait for the service to answer"`
+
+	SSH           string `config:"ssh" usage:"[user@]host[:port] of a jump host to reach the server through"`
+	SSHKey        string `config:"ssh-key" usage:"private key file for the jump host, tried after the SSH agent"`
+	SSHKnownHosts string `config:"ssh-known-hosts" usage:"known_hosts file verifying the jump host"`
+}
+
+func (cfg *clientConfig) Validate() error {
+	if (cfg.Addr == "") == (cfg.Service == "") {
+		return errors.New("exactly one of addr and service is required")
+	}
+	if _, ok := framing.Framings[cfg.Framing]; !ok {
+		return fmt.Errorf("framing must be newline, length or checked, got %q", cfg.Framing)
+	}
+	if cfg.Instance != "" && cfg.Service == "" {
+		return errors.New("instance needs service")
+	}
+	if cfg.SSH != "" && len(cfg.Resolvers) > 0 {
+		return errors.New("resolvers cannot be used with ssh; the jump host resolves addr")
+	}
+	return nil
+}
+
 func main() {
-	if len(os.Args) == 1 {
-		log.Fatal("please provide host:port")
+	cfg := clientConfig{Message: "Hello, server", Framing: "newline", DiscoverTimeout: 3 * time.Second, SSHKnownHosts: "~/.ssh/known_hosts"}
+	if _, err := config.Load("tcpclient", &cfg, os.Args[1:]); err != nil {
+		log.Fatal("invalid configuration: ", err)
 	}
 
-	tcpAdr, err := net.ResolveTCPAddr("tcp4", os.Args[1])
-	if err != nil {
-		log.Fatal(" ", os.Args[1])
+	// Find the server on the LAN when given a service name
+	if cfg.Service != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DiscoverTimeout)
+		inst, err := mdns.Lookup(ctx, cfg.Service, cfg.Instance)
+		cancel()
+		if err != nil {
+			log.Fatal("cannot discover server: ", err)
+		}
+		cfg.Addr = inst.Addr()
+		log.Printf("Discovered %s at %s\n", inst.Name, cfg.Addr)
 	}
 
-	conn, err := net.DialTCP("tcp", nil, tcpAdr)
-	if err != nil {
-		log.Fatal(" ", tcpAdr.String())
+	// Connect through the jump host if one is configured
+	var jump *sshJump
+	if cfg.SSH != "" {
+		var err error
+		jump, err = dialSSH(cfg)
+		if err != nil {
+			log.Fatal("cannot reach server: ", err)
+		}
+		defer jump.Close()
 	}
 
-	_, err = conn.Write([]byte("Hello, server\n"))
+	conn, err := dial(cfg, jump)
 	if err != nil {
 		log.Fatal(" ", err)
 	}
+	defer conn.Close()
+
+	framer := framing.Framings[cfg.Framing](conn)
+	if err := framer.WriteFrame([]byte(cfg.Message)); err != nil {
+		log.Fatal(" ", err)
+	}
 
-	data, err := bufio.NewReader(conn).ReadString('\n')
+	data, err := framer.ReadFrame()
 	if err != nil {
 		log.Fatal(" ", err)
 	}
-	log.Println("> ", data)
+	log.Println("> ", string(data))
+}
+
+// dial connects to the server through the jump host if any, or else directly, resolving
+// its name with the configured resolvers if any.
+func dial(cfg clientConfig, jump *sshJump) (net.Conn, error) {
+	if jump != nil {
+		return jump.Dial(cfg.Addr)
+	}
+	if len(cfg.Resolvers) > 0 {
+		resolver, err := dnsclient.New(dnsclient.Config{Resolvers: cfg.Resolvers})
+		if err != nil {
+			return nil, err
+		}
+		return resolver.DialContext(context.Background(), "tcp4", cfg.Addr)
+	}
+
+	tcpAdr, err := net.ResolveTCPAddr("tcp4", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTCP("tcp", nil, tcpAdr)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshJump is a connection to a jump host that forwards dials to servers behind it.
+type sshJump struct {
+	client *ssh.Client
+	agent  net.Conn
+}
+
+// dialSSH connects to the jump host given as [user@]host[:port], authenticating with the
+// running SSH agent and the key file, whichever the server accepts.
+func dialSSH(cfg clientConfig) (*sshJump, error) {
+	username, host, ok := strings.Cut(cfg.SSH, "@")
+	if !ok {
+		host = username
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current user: %w", err)
+		}
+		username = current.Username
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	hostKeyCallback, err := knownhosts.New(expandHome(cfg.SSHKnownHosts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	jump := &sshJump{}
+	var methods []ssh.AuthMethod
+
+	// Try the agent first, then the key file
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		}
+		jump.agent = conn
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	if cfg.SSHKey != "" {
+		signer, err := loadSigner(expandHome(cfg.SSHKey))
+		if err != nil {
+			jump.Close()
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH agent running and no ssh-key given")
+	}
+
+	client, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            username,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		jump.Close()
+		return nil, fmt.Errorf("failed to connect to jump host %s: %w", host, err)
+	}
+	jump.client = client
+	return jump, nil
+}
+
+// Dial connects to addr from the jump host; the jump host resolves the name.
+func (jump *sshJump) Dial(addr string) (net.Conn, error) {
+	conn, err := jump.client.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s through jump host: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// Close disconnects from the jump host and the agent.
+func (jump *sshJump) Close() error {
+	if jump.agent != nil {
+		jump.agent.Close()
+	}
+	if jump.client != nil {
+		return jump.client.Close()
+	}
+	return nil
+}
+
+func loadSigner(path string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("SSH key %s is encrypted; add it to the SSH agent instead", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+	return signer, nil
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
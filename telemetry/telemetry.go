@@ -0,0 +1,167 @@
+// Package telemetry publishes metrics and events as JSON to an MQTT broker with QoS 1, for
+// sites that collect telemetry over MQTT. The client reconnects on its own; messages
+// published while it is reconnecting are queued and sent once the connection is back.
+//
+// Topics come from a template with {host}, {service} and {kind} placeholders, e.g.
+// "net_prg/{host}/{service}/{kind}" gives net_prg/edge-1/concurtcp/metrics.
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	DefaultTopic = "net_prg/{host}/{service}/{kind}"
+
+	qosAtLeastOnce = 1
+	connectTimeout = 10 * time.Second
+	// maxReconnectInterval caps the backoff between reconnect attempts.
+	maxReconnectInterval = time.Minute
+	disconnectQuiesce    = 250
+)
+
+// Config configures a Publisher. Zero values select the defaults.
+type Config struct {
+	// Broker is the broker URL, e.g. tcp://broker:1883 or ssl://broker:8883.
+	Broker   string
+	Username string
+	Password string
+	// ClientID identifies the session at the broker (default <service>-<host>).
+	ClientID string
+	// Service names the publishing binary in topics and messages.
+	Service string
+	// Topic is the topic template (default DefaultTopic).
+	Topic string
+}
+
+// Message is the envelope every payload is published in.
+type Message struct {
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Service string    `json:"service"`
+	Kind    string    `json:"kind"`
+	Data    any       `json:"data"`
+}
+
+// Publisher publishes telemetry to one broker. It is safe for concurrent use.
+type Publisher struct {
+	client  mqtt.Client
+	host    string
+	service string
+	topic   string
+}
+
+// Connect creates a publisher and waits for the first connection to the broker. Later
+// connection losses are retried in the background.
+func Connect(cfg Config) (*Publisher, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("an MQTT broker is required")
+	}
+	if cfg.Service == "" {
+		return nil, errors.New("a service name is required")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = DefaultTopic
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = cfg.Service + "-" + host
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		// Keep the session so QoS 1 messages in flight survive a reconnect
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(maxReconnectInterval).
+		SetConnectTimeout(connectTimeout).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT connection to %s lost: %v\n", cfg.Broker, err)
+		}).
+		SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+			log.Printf("Reconnecting to MQTT broker %s\n", cfg.Broker)
+		})
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", cfg.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %w", cfg.Broker, err)
+	}
+
+	return &Publisher{client: client, host: host, service: cfg.Service, topic: cfg.Topic}, nil
+}
+
+// Topic returns the topic messages of kind are published to.
+func (publisher *Publisher) Topic(kind string) string {
+	return strings.NewReplacer(
+		"{host}", publisher.host,
+		"{service}", publisher.service,
+		"{kind}", kind,
+	).Replace(publisher.topic)
+}
+
+// Publish sends data of kind wrapped in a Message. It does not wait for the broker's
+// acknowledgement; delivery failures are logged.
+func (publisher *Publisher) Publish(kind string, data any) error {
+	payload, err := json.Marshal(Message{
+		Time:    time.Now(),
+		Host:    publisher.host,
+		Service: publisher.service,
+		Kind:    kind,
+		Data:    data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s telemetry: %w", kind, err)
+	}
+
+	topic := publisher.Topic(kind)
+	token := publisher.client.Publish(topic, qosAtLeastOnce, false, payload)
+	go func() {
+		<-token.Done()
+		if err := token.Error(); err != nil {
+			log.Printf("Error publishing telemetry to %s: %v\n", topic, err)
+		}
+	}()
+	return nil
+}
+
+// PublishEvery publishes what collect returns as kind each interval until ctx is done.
+func (publisher *Publisher) PublishEvery(ctx context.Context, interval time.Duration, kind string, collect func() any) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := publisher.Publish(kind, collect()); err != nil {
+				log.Printf("Error publishing telemetry: %v\n", err)
+			}
+		}
+	}
+}
+
+// Close gives queued messages a moment to go out and disconnects.
+func (publisher *Publisher) Close() {
+	publisher.client.Disconnect(disconnectQuiesce)
+}
@@ -0,0 +1,38 @@
+package testharness
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/blueai2022/net_prg/certgen"
+)
+
+// Certs is a throwaway PKI written to a temporary directory, for exercising the TLS and
+// mTLS settings of the repo's commands.
+type Certs struct {
+	*certgen.PKI
+	Dir string
+}
+
+// NewCerts creates a CA with a server certificate for hosts and a client certificate.
+// Without hosts, the server certificate is valid for localhost and the loopback addresses.
+func NewCerts(t testing.TB, hosts ...string) *Certs {
+	t.Helper()
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	pki, err := certgen.New(nil, certgen.Leaf{CommonName: "localhost", Hosts: hosts}, certgen.Leaf{CommonName: "client"})
+	if err != nil {
+		t.Fatalf("failed to create certificates: %v", err)
+	}
+	dir := t.TempDir()
+	if err := pki.Write(dir); err != nil {
+		t.Fatalf("failed to write certificates: %v", err)
+	}
+	return &Certs{PKI: pki, Dir: dir}
+}
+
+// File returns the path of one of the certgen file names, e.g. certgen.CACertFile.
+func (certs *Certs) File(name string) string {
+	return filepath.Join(certs.Dir, name)
+}
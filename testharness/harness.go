@@ -0,0 +1,247 @@
+// Package testharness starts the repo's servers on ephemeral ports from inside go test
+// and records the traffic sent to them, so integration tests need no manual setup.
+// Everything a helper starts is stopped when the test ends.
+package testharness
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	// StartTimeout bounds how long a server may take to become ready.
+	StartTimeout = 10 * time.Second
+	// stopTimeout is how long a process gets to exit after SIGTERM before it is killed.
+	stopTimeout = 10 * time.Second
+	// exchangeTimeout bounds a request and its reply.
+	exchangeTimeout = 5 * time.Second
+)
+
+// RepoRoot returns the directory holding the repo's go.mod.
+func RepoRoot(t testing.TB) string {
+	t.Helper()
+	out, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		t.Fatalf("failed to find module root: %v", err)
+	}
+	gomod := strings.TrimSpace(string(out))
+	if gomod == "" || gomod == os.DevNull {
+		t.Fatal("failed to find module root: not inside a module")
+	}
+	return filepath.Dir(gomod)
+}
+
+// FreeAddr returns a loopback TCP address that was free a moment ago, for servers that
+// cannot listen on port 0 and report the port they got.
+func FreeAddr(t testing.TB) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// Build compiles a command of this repo into the test's temporary directory and returns
+// the binary's path. target is relative to the repo root: a package such as ./cmd/iperf
+// or a single file such as main.go. The build cache keeps repeated builds fast.
+func Build(t testing.TB, target string) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), strings.TrimSuffix(filepath.Base(target), ".go"))
+	cmd := exec.Command("go", "build", "-o", binary, target)
+	cmd.Dir = RepoRoot(t)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build %s: %v\n%s", target, err, out)
+	}
+	return binary
+}
+
+// Process is a server running as a child process.
+type Process struct {
+	// Addr is the address the server listens on, when the helper that started it knows it.
+	Addr string
+
+	cmd    *exec.Cmd
+	output *outputBuffer
+	done   chan struct{}
+	err    error
+}
+
+// Start runs binary with args and waits until its output contains ready. The process is
+// stopped when the test ends.
+func Start(t testing.TB, binary, ready string, args ...string) *Process {
+	t.Helper()
+	output := newOutputBuffer()
+	cmd := exec.Command(binary, args...)
+	// Keep config files in the working directory from leaking into the test
+	cmd.Dir = t.TempDir()
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start %s: %v", binary, err)
+	}
+
+	process := &Process{cmd: cmd, output: output, done: make(chan struct{})}
+	go func() {
+		process.err = cmd.Wait()
+		close(process.done)
+	}()
+	t.Cleanup(func() {
+		if err := process.Stop(); err != nil {
+			t.Logf("%s did not stop cleanly: %v\n%s", filepath.Base(binary), err, process.Output())
+		}
+	})
+
+	if !process.waitOutput(ready, StartTimeout) {
+		t.Fatalf("%s did not print %q within %v:\n%s", filepath.Base(binary), ready, StartTimeout, process.Output())
+	}
+	return process
+}
+
+// Output returns everything the process has written to stdout and stderr.
+func (process *Process) Output() string {
+	return process.output.String()
+}
+
+// WaitOutput fails the test unless the process prints substr within timeout.
+func (process *Process) WaitOutput(t testing.TB, substr string, timeout time.Duration) {
+	t.Helper()
+	if !process.waitOutput(substr, timeout) {
+		t.Fatalf("output did not contain %q within %v:\n%s", substr, timeout, process.Output())
+	}
+}
+
+func (process *Process) waitOutput(substr string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return process.output.waitFor(ctx, process.done, substr)
+}
+
+// Stop sends SIGTERM, waits for the process to exit and kills it if it takes too long.
+// It returns the process's exit error; exiting on the signal is not an error.
+func (process *Process) Stop() error {
+	select {
+	case <-process.done:
+	default:
+		process.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-process.done:
+		case <-time.After(stopTimeout):
+			process.cmd.Process.Kill()
+			<-process.done
+		}
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(process.err, &exitErr) && !exitErr.Exited() {
+		// Killed by a signal rather than exiting
+		return nil
+	}
+	return process.err
+}
+
+// Exchange sends request on a new TCP connection to addr, half-closes it and returns
+// the first line of the reply.
+func Exchange(t testing.TB, addr, request string) string {
+	t.Helper()
+	return ExchangeTLS(t, addr, nil, request)
+}
+
+// ExchangeTLS is Exchange over TLS with tlsConfig, or over plain TCP when it is nil.
+func ExchangeTLS(t testing.TB, addr string, tlsConfig *tls.Config, request string) string {
+	t.Helper()
+	dialer := &net.Dialer{Timeout: exchangeTimeout}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("failed to send to %s: %v", addr, err)
+	}
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && reply == "" {
+		t.Fatalf("failed to read reply from %s: %v", addr, err)
+	}
+	return reply
+}
+
+// AssertExchange fails the test unless addr replies to request with want.
+func AssertExchange(t testing.TB, addr, request, want string) {
+	t.Helper()
+	if got := Exchange(t, addr, request); got != want {
+		t.Fatalf("reply to %q: got %q, want %q", request, got, want)
+	}
+}
+
+// outputBuffer collects process output and wakes waiters when it grows.
+type outputBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	changed chan struct{}
+}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{changed: make(chan struct{})}
+}
+
+func (out *outputBuffer) Write(p []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.buf.Write(p)
+	close(out.changed)
+	out.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func (out *outputBuffer) String() string {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.buf.String()
+}
+
+// waitFor reports whether the output contains substr before ctx is done or the process
+// exits.
+func (out *outputBuffer) waitFor(ctx context.Context, exited <-chan struct{}, substr string) bool {
+	for {
+		out.mu.Lock()
+		found := strings.Contains(out.buf.String(), substr)
+		changed := out.changed
+		out.mu.Unlock()
+		if found {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-exited:
+			return strings.Contains(out.String(), substr)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
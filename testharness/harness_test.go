@@ -0,0 +1,88 @@
+package testharness
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/blueai2022/net_prg/certgen"
+	"github.com/blueai2022/net_prg/stunserver"
+	"github.com/pion/stun"
+)
+
+func TestConcurTCP(t *testing.T) {
+	server := StartConcurTCP(t, "-workers", "2")
+	AssertExchange(t, server.Addr, "hello\n", "Received: hello\n")
+}
+
+func TestTapRecordsTraffic(t *testing.T) {
+	server := StartConcurTCP(t)
+	tap := StartTap(t, server.Addr)
+	AssertExchange(t, tap.Addr, "hello\n", "Received: hello\n")
+
+	tap.WaitReceived(t, "Received: hello\n", exchangeTimeout)
+	if got := string(tap.Sent()); got != "hello\n" {
+		t.Errorf("sent %q, want %q", got, "hello\n")
+	}
+	if got := tap.Connections(); got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+}
+
+func TestTunnelTLS(t *testing.T) {
+	server := StartConcurTCP(t)
+	certs := NewCerts(t)
+	tunnel := StartTunnel(t, server.Addr,
+		"-listen-cert", certs.File(certgen.ServerCertFile),
+		"-listen-key", certs.File(certgen.ServerKeyFile))
+
+	reply := ExchangeTLS(t, tunnel.Addr, certs.ClientTLSConfig(), "hello\n")
+	if want := "Received: hello\n"; reply != want {
+		t.Errorf("got %q, want %q", reply, want)
+	}
+}
+
+func TestSTUNBinding(t *testing.T) {
+	server := StartSTUN(t, stunserver.Config{})
+	conn, err := net.Dial("udp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.Write(request.Raw); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	response := &stun.Message{Raw: buf[:n]}
+	if err := response.Decode(); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	var mapped stun.XORMappedAddress
+	if err := mapped.GetFrom(response); err != nil {
+		t.Fatalf("no mapped address: %v", err)
+	}
+	if got, want := mapped.String(), conn.LocalAddr().String(); got != want {
+		t.Errorf("mapped address %s, want %s", got, want)
+	}
+}
+
+func TestSyncServerDryRun(t *testing.T) {
+	fixtures := []SyncFixture{{
+		ChatID:  "chat-1",
+		Leader:  "leader-1",
+		History: []string{"hello", "How can I help?"},
+		Replies: []SyncReply{{Response: "decision: approved"}},
+	}}
+	server := StartSyncServer(t, fixtures, "ready")
+	if err := server.Stop(); err != nil {
+		t.Errorf("sync server failed: %v\n%s", err, server.Output())
+	}
+}
@@ -0,0 +1,125 @@
+package testharness
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blueai2022/net_prg/stunserver"
+	"github.com/blueai2022/net_prg/turnserver"
+)
+
+// Credentials StartTURN uses when the config has no users.
+const (
+	TURNRealm    = "net_prg.test"
+	TURNUsername = "test"
+	TURNPassword = "test"
+)
+
+// StartConcurTCP builds concurtcp and runs it on a free loopback port with any extra
+// flags, e.g. "-workers", "2". Its address is in the returned Process's Addr.
+func StartConcurTCP(t testing.TB, args ...string) *Process {
+	t.Helper()
+	binary := Build(t, "main.go")
+	addr := FreeAddr(t)
+	process := Start(t, binary, "server started", append([]string{"-addr", addr}, args...)...)
+	process.Addr = addr
+	return process
+}
+
+// SyncServerEnv names the environment variable holding the path of a sync server binary
+// for StartSyncServer. The sync server embeds the api package in the private
+// github.com/blueai2022/mc module, so it can't be built from this repo.
+const SyncServerEnv = "NET_PRG_SYNC_SERVER"
+
+// SyncFixture scripts one chat of the sync server's mock backend. It is written as the
+// api package's MockFixture.
+type SyncFixture struct {
+	ChatID  string      `json:"chat_id"`
+	Leader  string      `json:"leader,omitempty"`
+	History []string    `json:"history"`
+	Replies []SyncReply `json:"replies"`
+}
+
+// SyncReply is one scripted turn of a SyncFixture.
+type SyncReply struct {
+	Request  string `json:"request,omitempty"`
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StartSyncServer runs the sync server binary named by SyncServerEnv in dry-run mode, so
+// the chats of fixtures are answered by a mock backend instead of the chat services, with
+// extra flags such as its listen address, and waits until it prints ready. The test is
+// skipped when SyncServerEnv is not set.
+func StartSyncServer(t testing.TB, fixtures []SyncFixture, ready string, args ...string) *Process {
+	t.Helper()
+	binary := os.Getenv(SyncServerEnv)
+	if binary == "" {
+		t.Skipf("%s is not set to a sync server binary", SyncServerEnv)
+	}
+
+	data, err := json.Marshal(fixtures)
+	if err != nil {
+		t.Fatalf("failed to encode fixtures: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "fixtures.json")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatalf("failed to write fixtures: %v", err)
+	}
+	return Start(t, binary, ready, append([]string{"-dry-run", file}, args...)...)
+}
+
+// StartTunnel builds the tunnel command and runs it on a free loopback port, forwarding
+// to remote, with extra flags such as the TLS files of a Certs.
+func StartTunnel(t testing.TB, remote string, args ...string) *Process {
+	t.Helper()
+	binary := Build(t, "./cmd/tunnel")
+	addr := FreeAddr(t)
+	process := Start(t, binary, "Tunnel listening", append([]string{"-listen", addr, "-remote", remote}, args...)...)
+	process.Addr = addr
+	return process
+}
+
+// StartSTUN runs an in-process STUN server on a loopback UDP port.
+func StartSTUN(t testing.TB, cfg stunserver.Config) *stunserver.Server {
+	t.Helper()
+	server, err := stunserver.Listen("127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatalf("failed to start STUN server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("STUN server failed: %v", err)
+		}
+	})
+	return server
+}
+
+// StartTURN runs an in-process TURN server on a loopback UDP port, relaying on loopback.
+// Without users in cfg, it accepts TURNUsername and TURNPassword in TURNRealm.
+func StartTURN(t testing.TB, cfg turnserver.Config) *turnserver.Server {
+	t.Helper()
+	if len(cfg.Users) == 0 {
+		cfg.Realm = TURNRealm
+		cfg.Users = map[string]string{TURNUsername: TURNPassword}
+	}
+	if cfg.RelayIP == nil {
+		cfg.RelayIP = net.IPv4(127, 0, 0, 1)
+	}
+
+	server, err := turnserver.Listen("127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatalf("failed to start TURN server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
@@ -0,0 +1,184 @@
+package testharness
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tap is a TCP proxy in front of a server that records the bytes going each way, so
+// tests can assert on the traffic a client produced. Point the client at Addr.
+type Tap struct {
+	Addr string
+
+	target   string
+	listener net.Listener
+	closed   chan struct{}
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	sent        bytes.Buffer
+	received    bytes.Buffer
+	connections int
+	changed     chan struct{}
+}
+
+// StartTap listens on a loopback port and forwards every connection to target. It stops
+// when the test ends.
+func StartTap(t testing.TB, target string) *Tap {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start tap: %v", err)
+	}
+	tap := &Tap{
+		Addr:     listener.Addr().String(),
+		target:   target,
+		listener: listener,
+		closed:   make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+
+	tap.wg.Add(1)
+	go tap.accept()
+	t.Cleanup(func() {
+		close(tap.closed)
+		listener.Close()
+		tap.wg.Wait()
+	})
+	return tap
+}
+
+func (tap *Tap) accept() {
+	defer tap.wg.Done()
+	for {
+		client, err := tap.listener.Accept()
+		if err != nil {
+			return
+		}
+		tap.wg.Add(1)
+		go tap.forward(client)
+	}
+}
+
+// forward relays one connection, recording both directions.
+func (tap *Tap) forward(client net.Conn) {
+	defer tap.wg.Done()
+	defer client.Close()
+
+	server, err := net.DialTimeout("tcp", tap.target, exchangeTimeout)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	tap.record(func() { tap.connections++ })
+
+	// Stopping the tap ends the relay too
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-stop:
+		case <-tap.closed:
+			client.Close()
+			server.Close()
+		}
+	}()
+
+	var copies sync.WaitGroup
+	copies.Add(1)
+	go func() {
+		defer copies.Done()
+		io.Copy(server, tap.recorder(client, &tap.sent))
+		server.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(client, tap.recorder(server, &tap.received))
+	client.(*net.TCPConn).CloseWrite()
+	copies.Wait()
+}
+
+// recorder copies what r reads into buf.
+func (tap *Tap) recorder(r io.Reader, buf *bytes.Buffer) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		if n > 0 {
+			tap.record(func() { buf.Write(p[:n]) })
+		}
+		return n, err
+	})
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// record applies change under the lock and wakes waiters.
+func (tap *Tap) record(change func()) {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+	change()
+	close(tap.changed)
+	tap.changed = make(chan struct{})
+}
+
+// Sent returns everything clients sent through the tap.
+func (tap *Tap) Sent() []byte {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+	return bytes.Clone(tap.sent.Bytes())
+}
+
+// Received returns everything the server sent back through the tap.
+func (tap *Tap) Received() []byte {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+	return bytes.Clone(tap.received.Bytes())
+}
+
+// Connections returns the number of connections relayed.
+func (tap *Tap) Connections() int {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+	return tap.connections
+}
+
+// WaitSent fails the test unless clients send substr within timeout.
+func (tap *Tap) WaitSent(t testing.TB, substr string, timeout time.Duration) {
+	t.Helper()
+	if !tap.waitFor(&tap.sent, substr, timeout) {
+		t.Fatalf("clients did not send %q within %v; sent %q", substr, timeout, tap.Sent())
+	}
+}
+
+// WaitReceived fails the test unless the server sends substr within timeout.
+func (tap *Tap) WaitReceived(t testing.TB, substr string, timeout time.Duration) {
+	t.Helper()
+	if !tap.waitFor(&tap.received, substr, timeout) {
+		t.Fatalf("server did not send %q within %v; received %q", substr, timeout, tap.Received())
+	}
+}
+
+func (tap *Tap) waitFor(buf *bytes.Buffer, substr string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		tap.mu.Lock()
+		found := bytes.Contains(buf.Bytes(), []byte(substr))
+		changed := tap.changed
+		tap.mu.Unlock()
+		if found {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package traceroute discovers the hops to a host by sending UDP or ICMP echo probes with
+// increasing TTLs and reading the ICMP time exceeded replies. It needs a raw ICMP socket,
+// so run it as root or with CAP_NET_RAW. IPv4 only.
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	protocolICMP = 1
+	// defaultPort is the first destination port of UDP probes, as in classic traceroute.
+	defaultPort = 33434
+)
+
+// Method selects the probe type.
+type Method string
+
+const (
+	MethodUDP  Method = "udp"
+	MethodICMP Method = "icmp"
+)
+
+// Options controls a trace. Zero values select the defaults.
+type Options struct {
+	// Method is the probe type (default UDP).
+	Method Method
+	// MaxHops is the highest TTL probed (default 30).
+	MaxHops int
+	// Probes is the number of probes per hop (default 3).
+	Probes int
+	// Timeout is how long to wait for each probe's reply (default 1s).
+	Timeout time.Duration
+	// Port is the first UDP destination port (default 33434).
+	Port int
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Method == "" {
+		opts.Method = MethodUDP
+	}
+	if opts.MaxHops <= 0 {
+		opts.MaxHops = 30
+	}
+	if opts.Probes <= 0 {
+		opts.Probes = 3
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.Port <= 0 {
+		opts.Port = defaultPort
+	}
+}
+
+// Probe is the outcome of one probe. Addr is empty when the probe got no reply.
+type Probe struct {
+	Addr string        `json:"addr,omitempty"`
+	RTT  time.Duration `json:"rtt_ns,omitempty"`
+}
+
+// Hop holds the probes sent with one TTL.
+type Hop struct {
+	TTL     int     `json:"ttl"`
+	Probes  []Probe `json:"probes"`
+	Reached bool    `json:"reached,omitempty"`
+}
+
+// Result is a complete trace.
+type Result struct {
+	Target  string `json:"target"`
+	Addr    string `json:"addr"`
+	Method  Method `json:"method"`
+	Hops    []Hop  `json:"hops"`
+	Reached bool   `json:"reached"`
+}
+
+// HopFunc is called with every hop as soon as its probes are done.
+type HopFunc func(hop Hop)
+
+// Trace runs a traceroute to host. It stops at the destination, at opts.MaxHops,
+// or when ctx is done.
+func Trace(ctx context.Context, host string, opts Options, onHop HopFunc) (*Result, error) {
+	opts.setDefaults()
+	if opts.Method != MethodUDP && opts.Method != MethodICMP {
+		return nil, fmt.Errorf("unknown probe method %q", opts.Method)
+	}
+
+	ip, err := resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every reply, whether time exceeded, unreachable or echo reply, arrives as ICMP
+	icmpConn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raw ICMP socket (needs root or CAP_NET_RAW): %w", err)
+	}
+	defer icmpConn.Close()
+
+	tracer := &tracer{icmp: icmpConn, dst: ip, opts: opts, id: os.Getpid() & 0xffff}
+	if opts.Method == MethodUDP {
+		udpConn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+		}
+		defer udpConn.Close()
+		tracer.udp = ipv4.NewPacketConn(udpConn)
+	}
+
+	result := &Result{Target: host, Addr: ip.String(), Method: opts.Method}
+	seq := 0
+	for ttl := 1; ttl <= opts.MaxHops; ttl++ {
+		hop := Hop{TTL: ttl}
+		for i := 0; i < opts.Probes; i++ {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			probe, reached, err := tracer.probe(ttl, seq)
+			if err != nil {
+				return result, err
+			}
+			seq++
+			hop.Probes = append(hop.Probes, probe)
+			hop.Reached = hop.Reached || reached
+		}
+
+		result.Hops = append(result.Hops, hop)
+		if onHop != nil {
+			onHop(hop)
+		}
+		if hop.Reached {
+			result.Reached = true
+			break
+		}
+	}
+	return result, nil
+}
+
+func resolve(ctx context.Context, host string) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if ip := addr.IP.To4(); ip != nil {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no IPv4 address", host)
+}
+
+type tracer struct {
+	icmp *icmp.PacketConn
+	udp  *ipv4.PacketConn
+	dst  net.IP
+	opts Options
+	id   int
+}
+
+// probe sends one probe with the given TTL and waits for the reply that matches seq.
+func (tracer *tracer) probe(ttl, seq int) (Probe, bool, error) {
+	start := time.Now()
+	if err := tracer.send(ttl, seq); err != nil {
+		return Probe{}, false, err
+	}
+
+	if err := tracer.icmp.SetReadDeadline(start.Add(tracer.opts.Timeout)); err != nil {
+		return Probe{}, false, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := tracer.icmp.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return Probe{}, false, nil
+			}
+			return Probe{}, false, fmt.Errorf("failed to read ICMP reply: %w", err)
+		}
+
+		msg, err := icmp.ParseMessage(protocolICMP, buf[:n])
+		if err != nil {
+			continue
+		}
+		matched, reached := tracer.match(msg, seq)
+		if matched {
+			return Probe{Addr: peer.String(), RTT: time.Since(start)}, reached, nil
+		}
+	}
+}
+
+func (tracer *tracer) send(ttl, seq int) error {
+	if tracer.opts.Method == MethodUDP {
+		if err := tracer.udp.SetTTL(ttl); err != nil {
+			return fmt.Errorf("failed to set TTL: %w", err)
+		}
+		// The destination port identifies the probe in the quoted header of the reply
+		dst := &net.UDPAddr{IP: tracer.dst, Port: tracer.opts.Port + seq}
+		if _, err := tracer.udp.WriteTo(nil, nil, dst); err != nil {
+			return fmt.Errorf("failed to send UDP probe: %w", err)
+		}
+		return nil
+	}
+
+	if err := tracer.icmp.IPv4PacketConn().SetTTL(ttl); err != nil {
+		return fmt.Errorf("failed to set TTL: %w", err)
+	}
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: tracer.id, Seq: seq, Data: []byte("net_prg traceroute")},
+	}).Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("failed to encode echo request: %w", err)
+	}
+	if _, err := tracer.icmp.WriteTo(request, &net.IPAddr{IP: tracer.dst}); err != nil {
+		return fmt.Errorf("failed to send ICMP probe: %w", err)
+	}
+	return nil
+}
+
+// match reports whether msg answers the probe seq, and whether it came from the destination.
+func (tracer *tracer) match(msg *icmp.Message, seq int) (bool, bool) {
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if msg.Type != ipv4.ICMPTypeEchoReply || tracer.opts.Method != MethodICMP {
+			return false, false
+		}
+		return body.ID == tracer.id && body.Seq == seq&0xffff, true
+	case *icmp.TimeExceeded:
+		return tracer.matchQuoted(body.Data, seq), false
+	case *icmp.DstUnreach:
+		// Port unreachable from the destination ends a UDP trace
+		return tracer.matchQuoted(body.Data, seq), true
+	default:
+		return false, false
+	}
+}
+
+// matchQuoted checks the original IP header and first 8 bytes quoted in an ICMP error.
+func (tracer *tracer) matchQuoted(data []byte, seq int) bool {
+	if len(data) < ipv4.HeaderLen {
+		return false
+	}
+	headerLen := int(data[0]&0x0f) * 4
+	if len(data) < headerLen+8 || !net.IP(data[16:20]).Equal(tracer.dst) {
+		return false
+	}
+	quoted := data[headerLen:]
+
+	if tracer.opts.Method == MethodUDP {
+		return data[9] == 17 && int(binary.BigEndian.Uint16(quoted[2:4])) == tracer.opts.Port+seq
+	}
+	return data[9] == protocolICMP &&
+		int(binary.BigEndian.Uint16(quoted[4:6])) == tracer.id &&
+		int(binary.BigEndian.Uint16(quoted[6:8])) == seq&0xffff
+}
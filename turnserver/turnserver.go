@@ -0,0 +1,101 @@
+// Package turnserver runs a TURN relay (RFC 5766) built on pion/turn with static
+// long-term credentials, so relay fallback and relayed media can be tested locally.
+package turnserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/pion/turn/v2"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Realm is used with Users for long-term credential authentication.
+	Realm string
+	// Users maps usernames to passwords.
+	Users map[string]string
+	// RelayIP is the address advertised in allocations; use the host's public or LAN IP.
+	RelayIP net.IP
+	// MinPort and MaxPort bound the relay ports; zero lets the OS pick any port.
+	MinPort uint16
+	MaxPort uint16
+}
+
+// Server is a running TURN server. Allocations, permissions and channel bindings are
+// handled by pion/turn.
+type Server struct {
+	conn   net.PacketConn
+	server *turn.Server
+}
+
+// Listen starts a TURN server on a UDP socket bound to addr, e.g. ":3478" or "127.0.0.1:0".
+func Listen(addr string, cfg Config) (*Server, error) {
+	if cfg.Realm == "" || len(cfg.Users) == 0 {
+		return nil, errors.New("a realm and at least one user are required")
+	}
+	if cfg.RelayIP == nil {
+		return nil, errors.New("a relay IP is required")
+	}
+
+	// Precompute the long-term keys so passwords aren't kept around
+	keys := make(map[string][]byte, len(cfg.Users))
+	for username, password := range cfg.Users {
+		keys[username] = turn.GenerateAuthKey(username, cfg.Realm, password)
+	}
+
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	var relay turn.RelayAddressGenerator = &turn.RelayAddressGeneratorStatic{
+		RelayAddress: cfg.RelayIP,
+		Address:      "0.0.0.0",
+	}
+	if cfg.MinPort != 0 || cfg.MaxPort != 0 {
+		relay = &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: cfg.RelayIP,
+			Address:      "0.0.0.0",
+			MinPort:      cfg.MinPort,
+			MaxPort:      cfg.MaxPort,
+		}
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: cfg.Realm,
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			key, ok := keys[username]
+			if !ok {
+				log.Printf("TURN authentication failed for user %q from %s\n", username, srcAddr)
+			}
+			return key, ok
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            conn,
+			RelayAddressGenerator: relay,
+		}},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start TURN server: %w", err)
+	}
+	return &Server{conn: conn, server: server}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (server *Server) Addr() net.Addr {
+	return server.conn.LocalAddr()
+}
+
+// AllocationCount returns the number of active allocations.
+func (server *Server) AllocationCount() int {
+	return server.server.AllocationCount()
+}
+
+// Close stops the server and releases every allocation.
+func (server *Server) Close() error {
+	return server.server.Close()
+}
@@ -0,0 +1,145 @@
+// Package wschat is a WebSocket chat and pub/sub server. Clients join named rooms, get the
+// room's recent history replayed on join, and receive every message published to the
+// rooms they are in.
+//
+// Clients and server exchange JSON events:
+//
+//	{"type": "join", "room": "lobby"}
+//	{"type": "message", "room": "lobby", "text": "hello"}
+//	{"type": "leave", "room": "lobby"}
+//
+// The server sends joined, left and message events to room members, with history
+// replay marked by "history": true, and error events for bad requests.
+package wschat
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EventJoin    = "join"
+	EventLeave   = "leave"
+	EventMessage = "message"
+	EventJoined  = "joined"
+	EventLeft    = "left"
+	EventError   = "error"
+)
+
+// Event is one message between a client and the server.
+type Event struct {
+	Type    string    `json:"type"`
+	Room    string    `json:"room,omitempty"`
+	From    string    `json:"from,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Time    time.Time `json:"time,omitzero"`
+	History bool      `json:"history,omitempty"`
+}
+
+// room is a set of members and the last messages sent to it.
+type room struct {
+	members map[*client]bool
+	history []Event
+}
+
+// Hub tracks rooms and delivers events to their members. It is safe for concurrent use.
+type Hub struct {
+	historySize int
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// NewHub creates a hub that replays up to historySize messages to clients joining a room.
+func NewHub(historySize int) *Hub {
+	return &Hub{historySize: historySize, rooms: make(map[string]*room)}
+}
+
+// Rooms returns the number of members in each room.
+func (hub *Hub) Rooms() map[string]int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	counts := make(map[string]int, len(hub.rooms))
+	for name, r := range hub.rooms {
+		counts[name] = len(r.members)
+	}
+	return counts
+}
+
+// join adds c to a room, replays its history to c and announces c to the other members.
+func (hub *Hub) join(c *client, name string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	r, ok := hub.rooms[name]
+	if !ok {
+		r = &room{members: make(map[*client]bool)}
+		hub.rooms[name] = r
+	}
+	if r.members[c] {
+		return
+	}
+
+	for _, event := range r.history {
+		event.History = true
+		c.deliver(event)
+	}
+	r.members[c] = true
+	hub.broadcast(r, Event{Type: EventJoined, Room: name, From: c.name, Time: time.Now()})
+}
+
+// leave removes c from a room and announces it. Empty rooms are dropped with their history.
+func (hub *Hub) leave(c *client, name string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.leaveLocked(c, name)
+}
+
+func (hub *Hub) leaveLocked(c *client, name string) {
+	r, ok := hub.rooms[name]
+	if !ok || !r.members[c] {
+		return
+	}
+	delete(r.members, c)
+	if len(r.members) == 0 {
+		delete(hub.rooms, name)
+		return
+	}
+	hub.broadcast(r, Event{Type: EventLeft, Room: name, From: c.name, Time: time.Now()})
+}
+
+// leaveAll removes c from every room, when it disconnects.
+func (hub *Hub) leaveAll(c *client) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for name := range hub.rooms {
+		hub.leaveLocked(c, name)
+	}
+}
+
+// publish sends a message from c to a room it is a member of.
+func (hub *Hub) publish(c *client, name, text string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	r, ok := hub.rooms[name]
+	if !ok || !r.members[c] {
+		return false
+	}
+	event := Event{Type: EventMessage, Room: name, From: c.name, Text: text, Time: time.Now()}
+	if hub.historySize > 0 {
+		r.history = append(r.history, event)
+		if len(r.history) > hub.historySize {
+			r.history = r.history[len(r.history)-hub.historySize:]
+		}
+	}
+	hub.broadcast(r, event)
+	return true
+}
+
+func (hub *Hub) broadcast(r *room, event Event) {
+	for member := range r.members {
+		member.deliver(event)
+	}
+}
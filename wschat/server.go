@@ -0,0 +1,190 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/blueai2022/net_prg/pool"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sendBuffer is how many events may queue for a client before it is dropped as too slow.
+	sendBuffer    = 64
+	maxEventBytes = 64 * 1024
+	writeTimeout  = 10 * time.Second
+	pongTimeout   = 60 * time.Second
+	pingInterval  = pongTimeout * 9 / 10
+)
+
+// Server upgrades HTTP requests to WebSocket sessions and runs each session on a worker
+// pool, so the pool size bounds the number of connected clients.
+type Server struct {
+	hub      *Hub
+	workers  *pool.Pool
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	nextID int
+}
+
+// NewServer creates a server for hub. The workers pool must already be running.
+func NewServer(hub *Hub, workers *pool.Pool) *Server {
+	return &Server{
+		hub:     hub,
+		workers: workers,
+		upgrader: websocket.Upgrader{
+			// Browsers on any origin may connect; chat carries no credentials
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+}
+
+// ServeHTTP upgrades the request. The client name comes from the name query parameter.
+func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, err := server.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// Upgrade has already answered with an HTTP error
+		log.Printf("Error upgrading connection from %s: %v\n", req.RemoteAddr, err)
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		server.mu.Lock()
+		server.nextID++
+		name = fmt.Sprintf("guest-%d", server.nextID)
+		server.mu.Unlock()
+	}
+
+	c := &client{conn: conn, name: name, send: make(chan Event, sendBuffer)}
+	server.workers.Submit(&SessionTask{hub: server.hub, client: c})
+}
+
+// client is one connected WebSocket.
+type client struct {
+	conn *websocket.Conn
+	name string
+	send chan Event
+
+	closeOnce sync.Once
+}
+
+// deliver queues an event without blocking; a client whose queue is full is disconnected.
+// The hub lock is held, so this must not wait on the client.
+func (c *client) deliver(event Event) {
+	select {
+	case c.send <- event:
+	default:
+		log.Printf("Error delivering to %s: client too slow, disconnecting\n", c.name)
+		c.close()
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		c.conn.Close()
+	})
+}
+
+// Task implementation for serving a WebSocket session
+type SessionTask struct {
+	hub    *Hub
+	client *client
+}
+
+func (task *SessionTask) Run(ctx context.Context) error {
+	c := task.client
+	done := make(chan struct{})
+	go func() {
+		task.writeLoop()
+		close(done)
+	}()
+
+	task.readLoop()
+
+	// Leave the rooms first so nothing new is queued, then stop the writer
+	task.hub.leaveAll(c)
+	close(c.send)
+	<-done
+	c.close()
+	return nil
+}
+
+// readLoop handles client requests until the connection fails or closes.
+func (task *SessionTask) readLoop() {
+	c := task.client
+	c.conn.SetReadLimit(maxEventBytes)
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		var event Event
+		if err := c.conn.ReadJSON(&event); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Error reading from %s: %v\n", c.name, err)
+			}
+			return
+		}
+		if event.Room == "" {
+			c.deliver(Event{Type: EventError, Text: "room is required"})
+			continue
+		}
+
+		switch event.Type {
+		case EventJoin:
+			task.hub.join(c, event.Room)
+		case EventLeave:
+			task.hub.leave(c, event.Room)
+		case EventMessage:
+			if !task.hub.publish(c, event.Room, event.Text) {
+				c.deliver(Event{Type: EventError, Room: event.Room, Text: "join the room before sending to it"})
+			}
+		default:
+			c.deliver(Event{Type: EventError, Text: fmt.Sprintf("unknown event type %q", event.Type)})
+		}
+	}
+}
+
+// writeLoop sends queued events and keepalive pings until the queue is closed.
+func (task *SessionTask) writeLoop() {
+	c := task.client
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-c.send:
+			if !ok {
+				c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteJSON(event); err != nil {
+				// Unblock readLoop, which then closes the queue
+				c.close()
+				drain(c.send)
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				c.close()
+				drain(c.send)
+				return
+			}
+		}
+	}
+}
+
+// drain discards events until the queue is closed.
+func drain(events <-chan Event) {
+	for range events {
+	}
+}